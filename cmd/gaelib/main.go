@@ -0,0 +1,218 @@
+// Command gaelib scaffolds App Engine projects wired to the library and
+// generates new handlers, forms & entities in them.
+//
+// Usage:
+//    gaelib new [-app ID] DIR
+//    gaelib handler [-dir DIR] NAME
+//    gaelib form [-dir DIR] NAME
+//    gaelib entity [-dir DIR] NAME
+//
+// The generated code uses the v2 packages. The routes of the new handlers
+// and forms are printed to add them to server/routes.go.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Data of the templates of the files
+type names struct {
+	// Application ID of the project
+	App string
+
+	// Name of the generated item: exported Go name, lowercase Go name,
+	// file name and URL path
+	Name, Var, File, Path string
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "new":
+		err = newProject(args)
+	case "handler", "form", "entity":
+		err = generate(cmd, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gaelib: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage:
+  gaelib new [-app ID] DIR      scaffold a new project in DIR
+  gaelib handler [-dir DIR] NAME  generate a page handler
+  gaelib form [-dir DIR] NAME     generate a form with its handlers
+  gaelib entity [-dir DIR] NAME   generate a datastore entity
+`)
+}
+
+func newProject(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	appID := fs.String("app", "", "application ID, the name of the directory by default")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("new needs the directory of the project")
+	}
+
+	dir := fs.Arg(0)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists", dir)
+	}
+
+	// The example form of the project is generated like the other ones
+	n := itemNames("contact")
+	n.App = *appID
+	if n.App == "" {
+		n.App = strings.ToLower(filepath.Base(dir))
+	}
+	for _, f := range projectFiles {
+		if err := writeFile(filepath.Join(dir, f.path), f.tmpl, n); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Project created in %s. Run it with: goapp serve %s\n", dir, dir)
+	return nil
+}
+
+func generate(kind string, args []string) error {
+	fs := flag.NewFlagSet(kind, flag.ExitOnError)
+	dir := fs.String("dir", ".", "root directory of the project")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s needs the name to generate", kind)
+	}
+	if _, err := os.Stat(filepath.Join(*dir, "app.yaml")); err != nil {
+		return fmt.Errorf("%s is not the root of a project: %s", *dir, err)
+	}
+
+	n := itemNames(fs.Arg(0))
+	if n.Name == "" {
+		return fmt.Errorf("invalid name: %s", fs.Arg(0))
+	}
+
+	for _, f := range generators[kind] {
+		path, err := execString(f.path, n)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(*dir, path), f.tmpl, n); err != nil {
+			return err
+		}
+	}
+
+	if routes := generatorRoutes[kind]; routes != "" {
+		r, err := execString(routes, n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Add the routes to server/routes.go:\n%s", r)
+	}
+	return nil
+}
+
+// Splits the name in words (by the separators and the case changes)
+// to build the Go, file & path names
+func itemNames(name string) *names {
+	words := []string{}
+	word := []rune{}
+	for i, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = nil
+			}
+			continue
+		}
+		if unicode.IsUpper(r) && len(word) > 0 && i > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+		word = append(word, unicode.ToLower(r))
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	if len(words) == 0 || unicode.IsDigit([]rune(words[0])[0]) {
+		return &names{}
+	}
+
+	n := &names{
+		File: strings.Join(words, "_"),
+		Path: strings.Join(words, "-"),
+	}
+	for i, w := range words {
+		title := strings.ToUpper(w[:1]) + w[1:]
+		n.Name += title
+		if i == 0 {
+			n.Var += w
+		} else {
+			n.Var += title
+		}
+	}
+	return n
+}
+
+// Writes the file with the template, formatting the Go code. The
+// existing files are never overwritten.
+func writeFile(path, tmpl string, n *names) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	content, err := execString(tmpl, n)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(path, ".go") {
+		src, err := format.Source([]byte(content))
+		if err != nil {
+			return fmt.Errorf("format %s failed: %s", path, err)
+		}
+		content = string(src)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create dir failed: %s", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("write file failed: %s", err)
+	}
+	fmt.Println("created", path)
+	return nil
+}
+
+// Executes the template with [[ ]] delimiters, to keep the {{ }} of the
+// HTML templates as they are
+func execString(tmpl string, n *names) (string, error) {
+	t, err := template.New("file").Delims("[[", "]]").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parse template failed: %s", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := t.Execute(buf, n); err != nil {
+		return "", fmt.Errorf("exec template failed: %s", err)
+	}
+	return buf.String(), nil
+}
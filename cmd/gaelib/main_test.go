@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Names passed to r.Template in the generated handlers
+var templateNamesRe = regexp.MustCompile(`r\.Template\(\[\]string\{([^}]*)\}`)
+
+func TestScaffold(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gaelib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "example")
+	if err := newProject([]string{dir}); err != nil {
+		t.Fatalf("new project failed: %s", err)
+	}
+	if err := generate("handler", []string{"-dir", dir, "AboutUs"}); err != nil {
+		t.Fatalf("generate handler failed: %s", err)
+	}
+	if err := generate("form", []string{"-dir", dir, "signup"}); err != nil {
+		t.Fatalf("generate form failed: %s", err)
+	}
+	if err := generate("entity", []string{"-dir", dir, "order-line"}); err != nil {
+		t.Fatalf("generate entity failed: %s", err)
+	}
+	if err := generate("handler", []string{"-dir", dir, "about_us"}); err == nil {
+		t.Errorf("existing handler overwritten")
+	}
+
+	for _, name := range []string{
+		"server/handlers/about_us.go",
+		"templates/pages/about_us.html",
+		"server/handlers/signup.go",
+		"templates/pages/signup.html",
+		"server/model/order_line.go",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("generated file missing: %s", err)
+		}
+	}
+
+	// Each template used by the handlers should exist
+	handlers, err := filepath.Glob(filepath.Join(dir, "server/handlers/*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, handler := range handlers {
+		src, err := ioutil.ReadFile(handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range templateNamesRe.FindAllStringSubmatch(string(src), -1) {
+			for _, name := range strings.Split(m[1], ",") {
+				name = strings.Trim(strings.TrimSpace(name), `"`)
+				if _, err := os.Stat(filepath.Join(dir, "templates", name+".html")); err != nil {
+					t.Errorf("%s: template %s not found: %s", filepath.Base(handler), name, err)
+				}
+			}
+		}
+	}
+}
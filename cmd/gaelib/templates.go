@@ -0,0 +1,298 @@
+package main
+
+// File generated from a template; the path is a template too
+type file struct {
+	path, tmpl string
+}
+
+// Files of the new projects
+var projectFiles = []*file{
+	{"app.yaml", appYaml},
+	{"queue.yaml", queueYaml},
+	{"cron.yaml", cronYaml},
+	{"server/routes.go", routesGo},
+	{"server/handlers/errors.go", errorsGo},
+	{"server/handlers/home.go", homeGo},
+	{"server/handlers/contact.go", formGo},
+	{"templates/base.html", baseHtml},
+	{"templates/pages/home.html", homeHtml},
+	{"templates/pages/contact.html", formHtml},
+	{"templates/errors/404.html", notFoundHtml},
+	{"templates/errors/500.html", internalErrorHtml},
+	{"static/css/app.css", appCss},
+}
+
+// Files of each generator
+var generators = map[string][]*file{
+	"handler": {
+		{"server/handlers/[[.File]].go", handlerGo},
+		{"templates/pages/[[.File]].html", handlerHtml},
+	},
+	"form": {
+		{"server/handlers/[[.File]].go", formGo},
+		{"templates/pages/[[.File]].html", formHtml},
+	},
+	"entity": {
+		{"server/model/[[.File]].go", entityGo},
+	},
+}
+
+// Routes printed after running each generator
+var generatorRoutes = map[string]string{
+	"handler": `    "GET::/[[.Path]]": handlers.[[.Name]],
+`,
+	"form": `    "GET::/[[.Path]]":    handlers.[[.Name]],
+    "POST::/_/[[.Path]]": handlers.Send[[.Name]],
+`,
+}
+
+const appYaml = `application: [[.App]]
+version: 1
+runtime: go
+api_version: go1
+
+handlers:
+- url: /static
+  static_dir: static
+
+- url: /tasks/.*
+  script: _go_app
+  login: admin
+
+- url: /.*
+  script: _go_app
+`
+
+const queueYaml = `queue:
+- name: admin-mails
+  rate: 1/s
+
+- name: mails
+  rate: 5/s
+`
+
+const cronYaml = `cron:
+- description: digest of the errors
+  url: /tasks/error-digest
+  schedule: every 1 hours
+`
+
+const routesGo = `package server
+
+import (
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/mail"
+
+	"server/handlers"
+)
+
+func init() {
+	app.SetAPIPrefix("/_/")
+
+	app.Router(map[string]app.Handler{
+		"ERROR::404": handlers.NotFound,
+		"ERROR::500": handlers.InternalError,
+
+		"POST::/tasks/error-mail": mail.ErrorMailHandler,
+
+		"GET::/": handlers.Home,
+
+		"GET::/contact":    handlers.Contact,
+		"POST::/_/contact": handlers.SendContact,
+	})
+
+	app.Cron("/tasks/error-digest", app.ErrorDigestHandler)
+}
+`
+
+const errorsGo = `package handlers
+
+import (
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+func NotFound(r *app.Request) error {
+	r.W.WriteHeader(404)
+	return r.Template([]string{"base", "errors/404"}, nil)
+}
+
+func InternalError(r *app.Request) error {
+	r.W.WriteHeader(500)
+	return r.Template([]string{"base", "errors/500"}, nil)
+}
+`
+
+const homeGo = `package handlers
+
+import (
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+func Home(r *app.Request) error {
+	return r.Template([]string{"base", "pages/home"}, nil)
+}
+`
+
+const handlerGo = `package handlers
+
+import (
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+func [[.Name]](r *app.Request) error {
+	data := map[string]interface{}{}
+	return r.Template([]string{"base", "pages/[[.File]]"}, data)
+}
+`
+
+const formGo = `package handlers
+
+import (
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+// Data posted by the form, checked with the valid tags
+type [[.Var]]Form struct {
+	Name    string ` + "`" + `json:"name" valid:"required,max=100"` + "`" + `
+	Email   string ` + "`" + `json:"email" valid:"required,email,max=200"` + "`" + `
+	Message string ` + "`" + `json:"message" valid:"required,max=5000"` + "`" + `
+}
+
+func [[.Name]](r *app.Request) error {
+	return r.Template([]string{"base", "pages/[[.File]]"}, nil)
+}
+
+func Send[[.Name]](r *app.Request) error {
+	f := new([[.Var]]Form)
+	if err := r.LoadJsonData(f); err != nil {
+		return err
+	}
+
+	// TODO: store or send the data of the form
+	r.C.Infof("[[.Path]] form sent by %s", f.Email)
+
+	return r.EmitJson(map[string]bool{"ok": true})
+}
+`
+
+const entityGo = `package model
+
+import (
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/db"
+)
+
+const Kind[[.Name]] = "[[.Name]]"
+
+type [[.Name]] struct {
+	ID      int64     ` + "`" + `datastore:"-" json:"id"` + "`" + `
+	Created time.Time ` + "`" + `json:"created"` + "`" + `
+	Updated time.Time ` + "`" + `json:"updated"` + "`" + `
+}
+
+// Returns datastore.ErrNoSuchEntity if it doesn't exist
+func Get[[.Name]](c appengine.Context, id int64) (*[[.Name]], error) {
+	e := new([[.Name]])
+	if err := db.GetByID(c, Kind[[.Name]], id, e); err != nil {
+		return nil, err
+	}
+	e.ID = id
+	return e, nil
+}
+
+// Stores the entity, allocating its ID the first time
+func Put[[.Name]](c appengine.Context, e *[[.Name]]) error {
+	now := time.Now()
+	if e.Created.IsZero() {
+		e.Created = now
+	}
+	e.Updated = now
+
+	key, err := db.Put(c, Kind[[.Name]], e.ID, e)
+	if err != nil {
+		return err
+	}
+	e.ID = key.IntID()
+	return nil
+}
+
+func Delete[[.Name]](c appengine.Context, id int64) error {
+	return db.DeleteByID(c, Kind[[.Name]], id)
+}
+`
+
+const baseHtml = `{{define "base"}}<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{template "title" .}}</title>
+  <link rel="stylesheet" href="/static/css/app.css">
+</head>
+<body>
+  <header><a href="/">[[.App]]</a></header>
+  <main>{{template "content" .}}</main>
+</body>
+</html>{{end}}
+`
+
+const homeHtml = `{{define "title"}}Home{{end}}
+
+{{define "content"}}
+<h1>It works!</h1>
+<p>Edit server/routes.go and the templates to start.</p>
+<p><a href="/contact">Example form</a></p>
+{{end}}
+`
+
+const handlerHtml = `{{define "title"}}[[.Name]]{{end}}
+
+{{define "content"}}
+<h1>[[.Name]]</h1>
+{{end}}
+`
+
+// The XSRF token is sent in a header, so the form is posted with the
+// post script of the app
+const formHtml = `{{define "title"}}[[.Name]]{{end}}
+
+{{define "content"}}
+<h1>[[.Name]]</h1>
+<form action="/_/[[.Path]]" data-post data-result="[[.Path]]-result"
+    data-ok="Sent, thanks!" data-error="Please check the fields and try again.">
+  <p><label>Name<br><input name="name" required maxlength="100"></label></p>
+  <p><label>Email<br><input type="email" name="email" required maxlength="200"></label></p>
+  <p><label>Message<br><textarea name="message" required maxlength="5000"></textarea></label></p>
+  <p><button type="submit">Send</button></p>
+</form>
+<p id="[[.Path]]-result"></p>
+{{post_script}}
+{{end}}
+`
+
+const notFoundHtml = `{{define "title"}}Not found{{end}}
+
+{{define "content"}}
+<h1>Page not found</h1>
+<p><a href="/">Go to the home page</a></p>
+{{end}}
+`
+
+const internalErrorHtml = `{{define "title"}}Error{{end}}
+
+{{define "content"}}
+<h1>Something went wrong</h1>
+<p>We have been notified. Please try again in a few minutes.</p>
+{{end}}
+`
+
+const appCss = `body {
+  font-family: sans-serif;
+  margin: 0 auto;
+  max-width: 960px;
+  padding: 0 1em;
+}
+`
@@ -6,6 +6,7 @@ import (
 	"conf"
 	"github.com/ernestokarim/gaelib/v0/errors"
 	"github.com/ernestokarim/gaelib/v0/mail"
+	"github.com/ernestokarim/gaelib/v2/app/config"
 
 	"appengine"
 )
@@ -31,9 +32,10 @@ func NotAllowed() error {
 func sendErrorByEmail(c appengine.Context, errorStr string) {
 	appid := appengine.AppID(c)
 
-	// Try to send an email to the admin if the app is in production
+	// Try to send an email to the admin if the app is in production. The
+	// AdminEmails setting replaces the conf list when it's stored.
 	if !appengine.IsDevAppServer() {
-		for _, admin := range conf.ADMIN_EMAILS {
+		for _, admin := range config.List(c, "AdminEmails", conf.ADMIN_EMAILS) {
 			// Build the template data
 			data := map[string]interface{}{
 				"Error":    errorStr,
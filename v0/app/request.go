@@ -26,6 +26,13 @@ type Request struct {
 	C   appengine.Context
 }
 
+// Registers a converter of the schema decoder used by LoadData for
+// the type of value. Call it at init().
+// Example: app.RegisterConverter(time.Time{}, parseTime)
+func RegisterConverter(value interface{}, converter schema.Converter) {
+	schemaDecoder.RegisterConverter(value, converter)
+}
+
 // Load the request data using gorilla schema into a struct
 func (r *Request) LoadData(data interface{}) error {
 	if err := r.Req.ParseForm(); err != nil {
@@ -154,7 +161,9 @@ func (r *Request) processError(err error) {
 		}
 	}
 
-	http.Error(r.W, "", e.Code)
+	// Only the safe message is served, the full error with its
+	// stack has been logged
+	http.Error(r.W, e.SafeMessage(), e.Code)
 }
 
 func SetErrorHandler(code int, f Handler) {
@@ -9,10 +9,50 @@ type Error struct {
 	CallStack   string
 	OriginalErr error
 	Code        int
+
+	// Message safe to show to the user. The original error and the
+	// context are only logged.
+	Message string
+
+	// Context added by Wrap
+	Context string
 }
 
 func (err *Error) Error() string {
-	return fmt.Sprintf("[status code %d] %s\n\n%s", err.Code, err.OriginalErr, err.CallStack)
+	return fmt.Sprintf("[status code %d] %s\n\n%s", err.Code, err.message(), err.CallStack)
+}
+
+// Message of the error and all the wrapped ones, without the stack
+func (err *Error) message() string {
+	msg := fmt.Sprintf("%s", err.OriginalErr)
+	if e, ok := err.OriginalErr.(*Error); ok {
+		msg = e.message()
+	}
+	if err.Context != "" {
+		msg = err.Context + ": " + msg
+	}
+	return msg
+}
+
+// Returns the original error to be compatible with the standard errors
+// package chains (errors.Unwrap, errors.As, ...)
+func (err *Error) Unwrap() error {
+	return err.OriginalErr
+}
+
+// Two errors are equal if they have the same code and the target doesn't
+// have an original error. It allows: errors.Is(err, errors.Code(404))
+func (err *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.OriginalErr == nil && t.Code == err.Code
+}
+
+// Returns the message that can be shown to the user
+func (err *Error) SafeMessage() string {
+	return err.Message
 }
 
 func New(original error) error {
@@ -37,3 +77,39 @@ func Code(code int) error {
 		CallStack: fmt.Sprintf("%s", debug.Stack()),
 	}
 }
+
+// Error with a HTTP code and a message safe to show to the user.
+// Example: return errors.CodeMsg(404, "not found: %s", id)
+func CodeMsg(code int, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	return &Error{
+		OriginalErr: fmt.Errorf("%s", msg),
+		Code:        code,
+		Message:     msg,
+		CallStack:   fmt.Sprintf("%s", debug.Stack()),
+	}
+}
+
+// Adds context to the error, keeping the code, the message and the
+// stack of the original one if it was created by this package.
+// Example: return errors.Wrap(err, "load user failed")
+func Wrap(err error, context string) error {
+	if err == nil {
+		return nil
+	}
+
+	e, ok := err.(*Error)
+	if !ok {
+		return &Error{
+			OriginalErr: err,
+			Code:        500,
+			Context:     context,
+			CallStack:   fmt.Sprintf("%s", debug.Stack()),
+		}
+	}
+
+	wrapped := *e
+	wrapped.OriginalErr = e
+	wrapped.Context = context
+	return &wrapped
+}
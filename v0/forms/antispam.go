@@ -0,0 +1,121 @@
+package forms
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"appengine"
+	"appengine/urlfetch"
+
+	"github.com/ernestokarim/gaelib/v0/app"
+	"github.com/ernestokarim/gaelib/v0/errors"
+)
+
+// Secret key of reCAPTCHA, used by Parse to verify the responses
+// of the RecaptchaField
+var RecaptchaSecret string
+
+const recaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+
+// Input posted by the reCAPTCHA widget
+const recaptchaResponse = "g-recaptcha-response"
+
+// Input hidden from the users that the spam bots fill. Parse rejects
+// the submissions with a value without telling the reason.
+type HoneypotField struct {
+	Id string
+}
+
+func (f *HoneypotField) Build() string {
+	return render("honeypot", map[string]string{
+		"type":         "text",
+		"id":           f.Id,
+		"name":         f.Id,
+		"tabindex":     "-1",
+		"autocomplete": "off",
+	})
+}
+
+// Widget of reCAPTCHA. Parse verifies its response with Google when the
+// rest of the fields are valid, showing the Message if it fails. The Id
+// of the control is not used, the widget posts its own input.
+type RecaptchaField struct {
+	Control *Control
+
+	// Public key of the site in reCAPTCHA
+	SiteKey string
+
+	// Message shown when the verification fails
+	Message string
+}
+
+func (f *RecaptchaField) Build() string {
+	widget := render("recaptcha", map[string]string{
+		"class":        "g-recaptcha",
+		"data-sitekey": f.SiteKey,
+	})
+	return fmt.Sprintf(f.Control.Build(), widget)
+}
+
+// Verifies the responses of the reCAPTCHA fields of the form, filling
+// the error of the ones that fail
+func (f *Form) checkRecaptchas(r *app.Request) (bool, error) {
+	valid := true
+	for _, name := range f.FieldNames {
+		rf, ok := f.Fields[name].(*RecaptchaField)
+		if !ok {
+			continue
+		}
+
+		ok, err := verifyRecaptcha(r.C, r.Req.Form.Get(recaptchaResponse), r.Req.RemoteAddr)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			rf.Control.Error = f.translate(rf.Message)
+			valid = false
+		}
+	}
+	return valid, nil
+}
+
+// Asks Google if the response of the reCAPTCHA widget is valid
+func verifyRecaptcha(c appengine.Context, token, remoteIP string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+
+	client := &http.Client{
+		Transport: &urlfetch.Transport{
+			Context:  c,
+			Deadline: time.Duration(10) * time.Second,
+		},
+	}
+
+	params := url.Values{
+		"secret":   {RecaptchaSecret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	}
+	resp, err := client.PostForm(recaptchaVerifyURL, params)
+	if err != nil {
+		return false, errors.Wrap(err, "recaptcha request failed")
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, errors.Wrap(err, "decode recaptcha response failed")
+	}
+	if !result.Success {
+		c.Warningf("[forms] recaptcha verification failed: %v", result.ErrorCodes)
+	}
+
+	return result.Success, nil
+}
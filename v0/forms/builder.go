@@ -0,0 +1,115 @@
+package forms
+
+// Fluent construction of the forms. Each method adds a field and
+// returns the form to chain the next one.
+// Example:
+//    f := forms.NewForm("login").
+//      Email("email", "Email", forms.NotEmpty("Write your email")).
+//      Password("pwd", "Password", forms.NotEmpty("Write your password")).
+//      Submit("Enter")
+func NewForm(id string) *Form {
+	f := New("")
+	f.Id = id
+	return f
+}
+
+// Changes the markup of the form
+func (f *Form) WithLayout(layout Layout) *Form {
+	f.Layout = layout
+	return f
+}
+
+// Sets the URL where the form is posted; the current one by default
+func (f *Form) PostTo(action string) *Form {
+	f.Action = action
+	return f
+}
+
+func (f *Form) Text(id, label string, validators ...*Validator) *Form {
+	return f.input(id, label, "text", validators)
+}
+
+// Password input; the value is not sent back to the client
+func (f *Form) Password(id, label string, validators ...*Validator) *Form {
+	f.input(id, label, "password", validators)
+	f.GetControl(id).ResetValue = true
+	return f
+}
+
+func (f *Form) Email(id, label string, validators ...*Validator) *Form {
+	return f.input(id, label, "email", validators)
+}
+
+func (f *Form) TextArea(id, label string, rows int, validators ...*Validator) *Form {
+	f.AddField(id, &TextAreaField{Control: newControl(id, label, validators), Rows: rows})
+	return f
+}
+
+func (f *Form) Select(id, label string, labels, values []string, validators ...*Validator) *Form {
+	f.AddField(id, &SelectField{
+		Control: newControl(id, label, validators),
+		Labels:  labels,
+		Values:  values,
+	})
+	return f
+}
+
+func (f *Form) Date(id, label string, validators ...*Validator) *Form {
+	f.AddField(id, &DateField{Control: newControl(id, label, validators)})
+	return f
+}
+
+func (f *Form) Time(id, label string, validators ...*Validator) *Form {
+	f.AddField(id, &TimeField{Control: newControl(id, label, validators)})
+	return f
+}
+
+func (f *Form) Number(id, label string, validators ...*Validator) *Form {
+	f.AddField(id, &NumberField{Control: newControl(id, label, validators)})
+	return f
+}
+
+// File input accepting the content types (image/*, .pdf, etc.)
+func (f *Form) File(id, label, accept string, validators ...*Validator) *Form {
+	f.AddField(id, &FileField{Control: newControl(id, label, validators), Accept: accept})
+	return f
+}
+
+func (f *Form) Hidden(name, value string) *Form {
+	f.AddField(name, &HiddenField{Name: name, Value: value})
+	return f
+}
+
+// Sets the help text of the field added with the id
+func (f *Form) Help(id, help string) *Form {
+	ctrl := f.GetControl(id)
+	if ctrl == nil {
+		panic("control not found: " + id)
+	}
+	ctrl.Help = help
+	return f
+}
+
+func (f *Form) Submit(label string) *Form {
+	f.AddField("submit", &SubmitField{Label: label})
+	return f
+}
+
+// Adds a cancel link to the submit button
+func (f *Form) Cancel(url, label string) *Form {
+	submit, ok := f.Fields["submit"].(*SubmitField)
+	if !ok {
+		panic("add the submit button before the cancel link")
+	}
+	submit.CancelUrl, submit.CancelLabel = url, label
+	return f
+}
+
+func (f *Form) input(id, label, inputType string, validators []*Validator) *Form {
+	f.AddField(id, &InputField{Control: newControl(id, label, validators), Type: inputType})
+	return f
+}
+
+func newControl(id, label string, validators []*Validator) *Control {
+	return &Control{Id: id, Name: label, Validations: validators}
+}
@@ -0,0 +1,161 @@
+package forms
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ernestokarim/gaelib/v0/app"
+)
+
+// Layouts of the date & time inputs
+const (
+	dateLayout = "2006-01-02"
+	timeLayout = "15:04"
+)
+
+func init() {
+	// Bind the date & time fields to the time.Time fields of the
+	// destination struct
+	app.RegisterConverter(time.Time{}, func(value string) reflect.Value {
+		for _, layout := range []string{dateLayout, timeLayout, time.RFC3339} {
+			if t, err := time.Parse(layout, value); err == nil {
+				if layout == timeLayout {
+					t = t.AddDate(1970, 0, 0)
+				}
+				return reflect.ValueOf(t)
+			}
+		}
+		return reflect.Value{}
+	})
+}
+
+// Picker of a day. The value is bound to a time.Time at midnight (UTC).
+// Its min & max attributes are taken from the MinDate and MaxDate
+// validators of the control.
+type DateField struct {
+	Control *Control
+	Class   []string
+}
+
+func (f *DateField) Build() string {
+	return buildTypedInput(f.Control, "date", f.Class, "")
+}
+
+// Picker of a time of the day. The value is bound to a time.Time of
+// the 1 Jan 1970 (UTC).
+type TimeField struct {
+	Control *Control
+	Class   []string
+}
+
+func (f *TimeField) Build() string {
+	return buildTypedInput(f.Control, "time", f.Class, "")
+}
+
+func DateFormat(message string) *Validator {
+	return &Validator{
+		Name: "date",
+		Func: func(v string) string {
+			if _, err := time.Parse(dateLayout, v); v != "" && err != nil {
+				return message
+			}
+
+			return ""
+		},
+	}
+}
+
+func TimeFormat(message string) *Validator {
+	return &Validator{
+		Name: "time",
+		Func: func(v string) string {
+			if _, err := time.Parse(timeLayout, v); v != "" && err != nil {
+				return message
+			}
+
+			return ""
+		},
+	}
+}
+
+func MinDate(min time.Time, message string) *Validator {
+	return minString(min.Format(dateLayout), message)
+}
+
+func MaxDate(max time.Time, message string) *Validator {
+	return maxString(max.Format(dateLayout), message)
+}
+
+// The bound is formatted as 15:04
+func MinTime(min, message string) *Validator {
+	return minString(min, message)
+}
+
+// The bound is formatted as 15:04
+func MaxTime(max, message string) *Validator {
+	return maxString(max, message)
+}
+
+// The layouts of the dates & times can be compared as strings
+func minString(min, message string) *Validator {
+	return &Validator{
+		Name: "min",
+		Args: []interface{}{min},
+		Func: func(v string) string {
+			if v != "" && v < min {
+				return message
+			}
+
+			return ""
+		},
+	}
+}
+
+func maxString(max, message string) *Validator {
+	return &Validator{
+		Name: "max",
+		Args: []interface{}{max},
+		Func: func(v string) string {
+			if v != "" && v > max {
+				return message
+			}
+
+			return ""
+		},
+	}
+}
+
+// Builds an input of the type, with the min, max & step attributes of
+// the validators of the control
+func buildTypedInput(c *Control, kind string, class []string, placeholder string) string {
+	// Tag attributes
+	attrs := map[string]string{
+		"type":  kind,
+		"id":    c.Id,
+		"name":  c.Id,
+		"value": c.Value,
+	}
+
+	for _, val := range c.Validations {
+		switch val.Name {
+		case "min", "max", "step":
+			attrs[val.Name] = fmt.Sprintf("%v", val.Args[0])
+		}
+	}
+
+	if placeholder != "" {
+		attrs["placeholder"] = placeholder
+	}
+
+	// The CSS classes
+	if class != nil {
+		attrs["class"] = strings.Join(class, " ")
+	}
+
+	// Build the control HTML
+	ctrl := render("input", attrs)
+
+	return fmt.Sprintf(c.Build(), ctrl)
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"text/template"
 )
 
 type Control struct {
@@ -15,35 +14,16 @@ type Control struct {
 	// If there's an error for this field, reset the value.
 	// Useful for passwords, for example.
 	ResetValue bool
+
+	// Layout of the form, set when building it
+	layout Layout
 }
 
 func (c *Control) Build() string {
-	err := ""
-	if c.Error != "" {
-		err = " error"
-	}
-
-	// Fields without name are built without label
-	if c.Name == "" {
-		return fmt.Sprintf(`
-			<div class="control-group%s">
-				%%s
-				<p class="help-block">%s</p>
-				<p class="help-block">%s</p>
-			</div>
-		`, err, c.Error, c.Help)
-	}
-
-	return fmt.Sprintf(`
-		<div class="control-group%s">
-			<label class="control-label" for="%s">%s</label>
-			<div class="controls">
-				%%s
-				<p class="help-block">%s</p>
-				<p class="help-block">%s</p>
-			</div>
-		</div>
-	`, err, c.Id, c.Name, c.Error, c.Help)
+	if c.layout == nil {
+		return Bootstrap2.Control(c)
+	}
+	return c.layout.Control(c)
 }
 
 // --------------------------------------------------------
@@ -65,7 +45,7 @@ func (f *InputField) Build() string {
 
 	// Add the value if we don't need to reset it every time
 	if !f.Control.ResetValue {
-		attrs["value"] = template.HTMLEscapeString(f.Control.Value)
+		attrs["value"] = f.Control.Value
 	}
 
 	// Add the disabled flag
@@ -89,11 +69,7 @@ func (f *InputField) Build() string {
 	}
 
 	// Build the control HTML
-	ctrl := "<input"
-	for k, v := range attrs {
-		ctrl += fmt.Sprintf(" %s=\"%s\"", k, v)
-	}
-	ctrl += ">"
+	ctrl := render("input", attrs)
 
 	return fmt.Sprintf(f.Control.Build(), ctrl)
 }
@@ -103,23 +79,16 @@ func (f *InputField) Build() string {
 type SubmitField struct {
 	Label                  string
 	CancelUrl, CancelLabel string
+
+	// Layout of the form, set when building it
+	layout Layout
 }
 
 func (f *SubmitField) Build() string {
-	// Build the cancel button if present
-	cancel := ""
-	if f.CancelLabel != "" && f.CancelUrl != "" {
-		cancel = fmt.Sprintf(`&nbsp;&nbsp;&nbsp;<a href="%s" class="btn">%s</a>`,
-			f.CancelUrl, f.CancelLabel)
-	}
-
-	// Build the control
-	return fmt.Sprintf(`
-		<div class="form-actions">
-			<button type="submit" class="btn btn-primary">%s</button>
-			%s
-		</div>
-	`, f.Label, cancel)
+	if f.layout == nil {
+		return Bootstrap2.Submit(f)
+	}
+	return f.layout.Submit(f)
 }
 
 // --------------------------------------------------------
@@ -142,18 +111,13 @@ func (f *SelectField) Build() string {
 		attrs["class"] = strings.Join(f.Class, " ")
 	}
 
-	ctrl := "<select"
-	for k, v := range attrs {
-		ctrl += fmt.Sprintf(" %s=\"%s\"", k, v)
-	}
-	ctrl += ">"
-
 	// Assert the same length precondition, because the error is not
 	// very descriptive
 	if len(f.Labels) != len(f.Values) {
 		panic("labels and values should have the same size")
 	}
 
+	options := []map[string]interface{}{}
 	for i, label := range f.Labels {
 		// Option tag attributes
 		attrs := map[string]string{}
@@ -171,16 +135,17 @@ func (f *SelectField) Build() string {
 			attrs["value"] = f.Values[i]
 		}
 
-		// Build the HTML of the option tag
-		ctrl += "<option"
-		for k, v := range attrs {
-			ctrl += fmt.Sprintf(" %s=\"%s\"", k, v)
-		}
-		ctrl += ">" + label + "</option>"
+		options = append(options, map[string]interface{}{
+			"Attrs": attrs,
+			"Label": label,
+		})
 	}
 
-	// Finish the control build
-	ctrl += "</select>"
+	// Build the control HTML
+	ctrl := render("select", map[string]interface{}{
+		"Attrs":   attrs,
+		"Options": options,
+	})
 
 	return fmt.Sprintf(f.Control.Build(), ctrl)
 }
@@ -209,11 +174,10 @@ func (f *TextAreaField) Build() string {
 	}
 
 	// Build the control HTML
-	ctrl := "<textarea"
-	for k, v := range attrs {
-		ctrl += fmt.Sprintf(" %s=\"%s\"", k, v)
-	}
-	ctrl += ">" + template.HTMLEscapeString(f.Control.Value) + "</textarea>"
+	ctrl := render("textarea", map[string]interface{}{
+		"Attrs": attrs,
+		"Value": f.Control.Value,
+	})
 
 	return fmt.Sprintf(f.Control.Build(), ctrl)
 }
@@ -225,6 +189,42 @@ type HiddenField struct {
 }
 
 func (f *HiddenField) Build() string {
-	return fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, f.Name,
-		template.HTMLEscapeString(f.Value))
+	return render("input", map[string]string{
+		"type":  "hidden",
+		"name":  f.Name,
+		"value": f.Value,
+	})
+}
+
+// --------------------------------------------------------
+
+type FileField struct {
+	Control *Control
+	Class   []string
+
+	// Content types accepted by the input (image/*, .pdf, etc.)
+	Accept string
+}
+
+func (f *FileField) Build() string {
+	// Tag attributes
+	attrs := map[string]string{
+		"type": "file",
+		"id":   f.Control.Id,
+		"name": f.Control.Id,
+	}
+
+	if f.Accept != "" {
+		attrs["accept"] = f.Accept
+	}
+
+	// The CSS classes
+	if f.Class != nil {
+		attrs["class"] = strings.Join(f.Class, " ")
+	}
+
+	// Build the control HTML
+	ctrl := render("input", attrs)
+
+	return fmt.Sprintf(f.Control.Build(), ctrl)
 }
@@ -1,19 +1,29 @@
 package forms
 
 import (
-	"fmt"
+	"html/template"
 	"net/url"
 	"strings"
 
 	"github.com/ernestokarim/gaelib/v0/app"
+	"github.com/ernestokarim/gaelib/v0/errors"
 )
 
+// Returned by Parse when some of the fields didn't pass the validations
+var ErrInvalid = errors.Code(400)
+
 type Field interface {
 	Build() string
 }
 
+// Receives the flash messages of the forms after a submit
+type Flasher interface {
+	AddFlash(kind, message string)
+}
+
 type Form struct {
-	Name           string
+	// Id attribute of the form tag and legend of the fieldset
+	Id, Name       string
 	Method, Action string
 	FieldNames     []string
 	Fields         map[string]Field
@@ -21,6 +31,17 @@ type Form struct {
 	// True if we want to show a message at the top of the form
 	// each time a validation error occurs
 	ShowError bool
+
+	// Flash messages pushed by ParseFlash after a submit
+	SuccessFlash, ErrorFlash string
+
+	// Translates the labels, help texts and validation messages, that
+	// are used as keys of the translations. Nil to use them as they are.
+	// Example: f.Translator = i18n.Translator(r)
+	Translator func(key string) string
+
+	// Markup of the form, Bootstrap2 by default
+	Layout Layout
 }
 
 func New(action string) *Form {
@@ -42,7 +63,7 @@ func (f *Form) Build() string {
 	out := ""
 	withError := false
 	for _, name := range f.FieldNames {
-		out += f.Fields[name].Build()
+		out += f.buildField(name)
 
 		ctrl := f.GetControl(name)
 		if ctrl != nil && ctrl.Error != "" {
@@ -50,63 +71,199 @@ func (f *Form) Build() string {
 		}
 	}
 
+	alert := ""
 	if withError && f.ShowError {
-		out = `
-			<div class="alert alert-error">
-				Hay errores en el formulario, revísalos y guarda de nuevo
-			</div><br>
-		` + out
+		alert = f.translate("Hay errores en el formulario, revísalos y guarda de nuevo")
 	}
 
-	// Set the form legend if needed
-	legend := ""
-	if f.Name != "" {
-		legend = "<legend>" + f.Name + "</legend>"
+	// Forms with files should be sent as multipart
+	multipart := false
+	for _, name := range f.FieldNames {
+		if _, ok := f.Fields[name].(*FileField); ok {
+			multipart = true
+		}
 	}
 
-	return fmt.Sprintf(`
-		<form action="%s" method="%s" class="form-horizontal">
-			<fieldset>%s%s</fieldset>
-		</form>
-	`, f.Action, f.Method, legend, out)
+	return f.layout().Form(&FormMarkup{
+		Id:        f.Id,
+		Action:    f.Action,
+		Method:    f.Method,
+		Legend:    f.translate(f.Name),
+		Alert:     alert,
+		Multipart: multipart,
+		Fields:    template.HTML(out),
+	})
+}
+
+// Returns the HTML of the whole form
+func (f *Form) Render() string {
+	return f.Build()
+}
+
+// Returns the HTML of one field, to place it manually in the page.
+// The form tag should be written by the template in that case.
+func (f *Form) RenderField(name string) string {
+	if _, ok := f.Fields[name]; !ok {
+		panic("field not found: " + name)
+	}
+	return f.buildField(name)
+}
+
+// Builds the field with the layout & translations of the form
+func (f *Form) buildField(name string) string {
+	field := f.Fields[name]
+	if submit, ok := field.(*SubmitField); ok {
+		submit.layout = f.layout()
+		label, cancel := submit.Label, submit.CancelLabel
+		submit.Label, submit.CancelLabel = f.translate(label), f.translate(cancel)
+		defer func() { submit.Label, submit.CancelLabel = label, cancel }()
+	}
+
+	ctrl := getControl(field)
+	if ctrl == nil {
+		return field.Build()
+	}
+
+	ctrl.layout = f.layout()
+	if f.Translator != nil {
+		label, help := ctrl.Name, ctrl.Help
+		ctrl.Name, ctrl.Help = f.translate(label), f.translate(help)
+		defer func() { ctrl.Name, ctrl.Help = label, help }()
+	}
+	return field.Build()
+}
+
+func (f *Form) layout() Layout {
+	if f.Layout == nil {
+		return Bootstrap2
+	}
+	return f.Layout
 }
 
 func (f *Form) Validate(r *app.Request, data interface{}) (bool, error) {
+	if err := f.Parse(r, data); err != nil {
+		if err == ErrInvalid {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Parse the posted values, running the validators of each field. If one
+// of them fails the Error of the control is filled with its message and
+// ErrInvalid is returned, so the form can be built again to show them.
+// Otherwise the validated values are decoded into dest.
+func (f *Form) Parse(r *app.Request, dest interface{}) error {
 	if err := r.Req.ParseForm(); err != nil {
-		return false, app.Error(err)
+		return errors.New(err)
+	}
+	if strings.HasPrefix(r.Req.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.Req.ParseMultipartForm(10 << 20); err != nil {
+			return errors.New(err)
+		}
 	}
 
 	failed := false
 	for _, name := range f.FieldNames {
-		field := f.Fields[name]
-		if control := getControl(field); control != nil {
-			// Extract the control value and assign it
-			value := normalizeValue(control.Id, r.Req.Form)
-			control.Value = value
-
-			// Run each validation for this field
-			for _, val := range control.Validations {
-				if err := val.Func(value); err != "" {
-					failed = true
-
-					control.Error = err
-					if control.ResetValue {
-						control.Value = ""
-					}
-
-					break
+		// The humans don't see the honeypots, don't tell the bots why
+		// the form failed
+		if hp, ok := f.Fields[name].(*HoneypotField); ok {
+			if normalizeValue(hp.Id, r.Req.Form) != "" {
+				r.C.Warningf("[forms] honeypot %s filled from %s", hp.Id, r.Req.RemoteAddr)
+				failed = true
+			}
+			continue
+		}
+
+		control := getControl(f.Fields[name])
+		if control == nil {
+			continue
+		}
+
+		// Extract the control value and assign it. The value of the
+		// files is their name.
+		value := normalizeValue(control.Id, r.Req.Form)
+		if _, ok := f.Fields[name].(*FileField); ok {
+			value = ""
+			if _, header, err := r.Req.FormFile(control.Id); err == nil {
+				value = header.Filename
+			}
+		}
+		if _, ok := f.Fields[name].(*RecaptchaField); ok {
+			value = normalizeValue(recaptchaResponse, r.Req.Form)
+		}
+		if num, ok := f.Fields[name].(*NumberField); ok && value != "" {
+			value = num.normalize(value)
+			r.Req.Form[control.Id] = []string{value}
+		}
+		control.Value = value
+		control.Error = ""
+
+		// Run each validation for this field
+		for _, val := range control.Validations {
+			if err := val.Func(value); err != "" {
+				failed = true
+
+				control.Error = f.translate(err)
+				if control.ResetValue {
+					control.Value = ""
 				}
+
+				break
 			}
 		}
 	}
 
+	// The responses can be verified only once, after the rest of the checks
 	if !failed {
-		if err := r.LoadData(data); err != nil {
-			return true, err
+		ok, err := f.checkRecaptchas(r)
+		if err != nil {
+			return err
 		}
+		failed = !ok
+	}
+	if failed {
+		return ErrInvalid
+	}
+
+	if err := r.LoadData(dest); err != nil {
+		return err
 	}
 
-	return !failed, nil
+	return nil
+}
+
+// Shows the errors of a validation made elsewhere (like the
+// ValidationErrors of the v2 app package), keyed by the field names.
+// It returns true if some of them belong to the form.
+func (f *Form) SetErrors(errs map[string]string) bool {
+	found := false
+	for name, msg := range errs {
+		control := f.GetControl(name)
+		if control == nil {
+			continue
+		}
+		control.Error = f.translate(msg)
+		if control.ResetValue {
+			control.Value = ""
+		}
+		found = true
+	}
+	return found
+}
+
+// Like Parse, but it pushes the success or error flash message of
+// the form depending on the result of the validations.
+func (f *Form) ParseFlash(r *app.Request, fl Flasher, dest interface{}) error {
+	err := f.Parse(r, dest)
+	if err == nil && f.SuccessFlash != "" {
+		fl.AddFlash("success", f.translate(f.SuccessFlash))
+	} else if err == ErrInvalid && f.ErrorFlash != "" {
+		fl.AddFlash("error", f.translate(f.ErrorFlash))
+	}
+	return err
 }
 
 func (f *Form) GetControl(name string) *Control {
@@ -137,6 +294,32 @@ func getControl(f Field) *Control {
 		return textarea.Control
 	}
 
+	// Control for files
+	file, ok := f.(*FileField)
+	if ok {
+		return file.Control
+	}
+
+	// Control for dates, times & numbers
+	date, ok := f.(*DateField)
+	if ok {
+		return date.Control
+	}
+	tf, ok := f.(*TimeField)
+	if ok {
+		return tf.Control
+	}
+	num, ok := f.(*NumberField)
+	if ok {
+		return num.Control
+	}
+
+	// Control of the reCAPTCHA widget
+	rf, ok := f.(*RecaptchaField)
+	if ok {
+		return rf.Control
+	}
+
 	// Not a control
 	return nil
 }
@@ -155,3 +338,12 @@ func normalizeValue(id string, v url.Values) string {
 	// No value found
 	return ""
 }
+
+// Returns the translation of the key, or the key itself without
+// a Translator or if it's empty
+func (f *Form) translate(key string) string {
+	if f.Translator == nil || key == "" {
+		return key
+	}
+	return f.Translator(key)
+}
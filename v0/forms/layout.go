@@ -0,0 +1,75 @@
+package forms
+
+import (
+	"html/template"
+	"strings"
+)
+
+// Data of the form passed to the layouts
+type FormMarkup struct {
+	Id, Action, Method string
+	Legend, Alert      string
+	Multipart          bool
+
+	// HTML of the fields, already rendered
+	Fields template.HTML
+}
+
+// Builds the markup around the controls, the buttons and the form
+// itself. Use one of the predefined ones or implement it to change
+// the structure of the HTML.
+type Layout interface {
+	// Returns the wrapper of the control, with a %s where the input
+	// should be placed (like Control.Build)
+	Control(c *Control) string
+
+	Submit(f *SubmitField) string
+	Form(m *FormMarkup) string
+}
+
+var (
+	// Horizontal forms of Bootstrap 2, the default layout
+	Bootstrap2 Layout = &templateLayout{"bs2-"}
+
+	// Horizontal forms of Bootstrap 3. Add the form-control class
+	// to the inputs.
+	Bootstrap3 Layout = &templateLayout{"bs3-"}
+
+	// Labels and inputs inside paragraphs, without any framework
+	Plain Layout = &templateLayout{"plain-"}
+)
+
+// Layout that executes the templates with the prefix
+type templateLayout struct {
+	prefix string
+}
+
+func (l *templateLayout) Control(c *Control) string {
+	control := render(l.prefix+"control", map[string]interface{}{
+		"Id":      c.Id,
+		"Name":    c.Name,
+		"Error":   c.Error,
+		"Help":    c.Help,
+		"Control": template.HTML(controlSentinel),
+	})
+
+	// The caller formats the result with the HTML of the control
+	control = strings.Replace(control, "%", "%%", -1)
+	return strings.Replace(control, controlSentinel, "%s", 1)
+}
+
+func (l *templateLayout) Submit(f *SubmitField) string {
+	data := map[string]string{"Label": f.Label}
+
+	// Build the cancel button if present
+	if f.CancelLabel != "" && f.CancelUrl != "" {
+		data["CancelUrl"] = f.CancelUrl
+		data["CancelLabel"] = f.CancelLabel
+	}
+
+	return render(l.prefix+"submit", data)
+}
+
+func (l *templateLayout) Form(m *FormMarkup) string {
+	return render(l.prefix+"form", m)
+}
@@ -0,0 +1,96 @@
+package forms
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Numeric input. The value is bound to the numeric field of the
+// destination struct.
+type NumberField struct {
+	Control     *Control
+	Class       []string
+	PlaceHolder string
+
+	// Decimal separator of the locale, "." by default. The other
+	// one is accepted as the thousands separator.
+	DecimalSeparator string
+}
+
+func (f *NumberField) Build() string {
+	return buildTypedInput(f.Control, "number", f.Class, f.PlaceHolder)
+}
+
+// Returns the value with "." as decimal separator and without the
+// thousands one, as the validators & the decoder expect it
+func (f *NumberField) normalize(value string) string {
+	if f.DecimalSeparator == "" || f.DecimalSeparator == "." {
+		return strings.Replace(value, ",", "", -1)
+	}
+	value = strings.Replace(value, ".", "", -1)
+	return strings.Replace(value, f.DecimalSeparator, ".", -1)
+}
+
+func Number(message string) *Validator {
+	return &Validator{
+		Name: "number",
+		Func: func(v string) string {
+			if _, err := strconv.ParseFloat(v, 64); v != "" && err != nil {
+				return message
+			}
+
+			return ""
+		},
+	}
+}
+
+func MinNumber(min float64, message string) *Validator {
+	return &Validator{
+		Name: "min",
+		Args: []interface{}{min},
+		Func: func(v string) string {
+			if n, err := strconv.ParseFloat(v, 64); v != "" && (err != nil || n < min) {
+				return message
+			}
+
+			return ""
+		},
+	}
+}
+
+func MaxNumber(max float64, message string) *Validator {
+	return &Validator{
+		Name: "max",
+		Args: []interface{}{max},
+		Func: func(v string) string {
+			if n, err := strconv.ParseFloat(v, 64); v != "" && (err != nil || n > max) {
+				return message
+			}
+
+			return ""
+		},
+	}
+}
+
+// The number should be a multiple of step (1 for integers)
+func Step(step float64, message string) *Validator {
+	return &Validator{
+		Name: "step",
+		Args: []interface{}{step},
+		Func: func(v string) string {
+			if v == "" {
+				return ""
+			}
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return message
+			}
+			if q := n / step; math.Abs(q-math.Floor(q+0.5)) > 1e-9 {
+				return message
+			}
+
+			return ""
+		},
+	}
+}
@@ -0,0 +1,148 @@
+package forms
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Markup of the forms. The templates escape the labels, help texts,
+// errors and attribute values, so the forms are safe even if some
+// of them come from user data.
+var templates = template.Must(template.New("forms").Funcs(template.FuncMap{
+	"attrs": attrs,
+}).Parse(`
+{{define "input"}}<input{{attrs .}}>{{end}}
+
+{{define "textarea"}}<textarea{{attrs .Attrs}}>{{.Value}}</textarea>{{end}}
+
+{{define "honeypot"}}<div style="position: absolute; left: -10000px;" aria-hidden="true"><input{{attrs .}}></div>{{end}}
+
+{{define "recaptcha"}}<div{{attrs .}}></div><script src="https://www.google.com/recaptcha/api.js" async defer></script>{{end}}
+
+{{define "select"}}<select{{attrs .Attrs}}>{{range .Options}}<option{{attrs .Attrs}}>{{.Label}}</option>{{end}}</select>{{end}}
+
+{{define "bs2-control"}}
+		<div class="control-group{{if .Error}} error{{end}}">
+			{{if .Name}}<label class="control-label" for="{{.Id}}">{{.Name}}</label>
+			<div class="controls">{{end}}
+				{{.Control}}
+				<p class="help-block">{{.Error}}</p>
+				<p class="help-block">{{.Help}}</p>
+			{{if .Name}}</div>{{end}}
+		</div>
+{{end}}
+
+{{define "bs2-submit"}}
+		<div class="form-actions">
+			<button type="submit" class="btn btn-primary">{{.Label}}</button>
+			{{if .CancelUrl}}&nbsp;&nbsp;&nbsp;<a href="{{.CancelUrl}}" class="btn">{{.CancelLabel}}</a>{{end}}
+		</div>
+{{end}}
+
+{{define "bs2-form"}}
+		<form{{if .Id}} id="{{.Id}}"{{end}} action="{{.Action}}" method="{{.Method}}" class="form-horizontal"{{if .Multipart}} enctype="multipart/form-data"{{end}}>
+			<fieldset>{{if .Legend}}<legend>{{.Legend}}</legend>{{end}}{{if .Alert}}
+			<div class="alert alert-error">
+				{{.Alert}}
+			</div><br>
+		{{end}}{{.Fields}}</fieldset>
+		</form>
+{{end}}
+
+{{define "bs3-control"}}
+		<div class="form-group{{if .Error}} has-error{{end}}">
+			{{if .Name}}<label class="col-sm-2 control-label" for="{{.Id}}">{{.Name}}</label>{{end}}
+			<div class="{{if not .Name}}col-sm-offset-2 {{end}}col-sm-10">
+				{{.Control}}
+				{{if .Error}}<p class="help-block">{{.Error}}</p>{{end}}
+				{{if .Help}}<p class="help-block">{{.Help}}</p>{{end}}
+			</div>
+		</div>
+{{end}}
+
+{{define "bs3-submit"}}
+		<div class="form-group">
+			<div class="col-sm-offset-2 col-sm-10">
+				<button type="submit" class="btn btn-primary">{{.Label}}</button>
+				{{if .CancelUrl}}<a href="{{.CancelUrl}}" class="btn btn-default">{{.CancelLabel}}</a>{{end}}
+			</div>
+		</div>
+{{end}}
+
+{{define "bs3-form"}}
+		<form{{if .Id}} id="{{.Id}}"{{end}} action="{{.Action}}" method="{{.Method}}" class="form-horizontal" role="form"{{if .Multipart}} enctype="multipart/form-data"{{end}}>
+			{{if .Legend}}<legend>{{.Legend}}</legend>{{end}}
+			{{if .Alert}}<div class="alert alert-danger">{{.Alert}}</div>{{end}}
+			{{.Fields}}
+		</form>
+{{end}}
+
+{{define "plain-control"}}
+		<p{{if .Error}} class="error"{{end}}>
+			{{if .Name}}<label for="{{.Id}}">{{.Name}}</label><br>{{end}}
+			{{.Control}}
+			{{if .Error}}<br><strong>{{.Error}}</strong>{{end}}
+			{{if .Help}}<br><small>{{.Help}}</small>{{end}}
+		</p>
+{{end}}
+
+{{define "plain-submit"}}
+		<p>
+			<button type="submit">{{.Label}}</button>
+			{{if .CancelUrl}}<a href="{{.CancelUrl}}">{{.CancelLabel}}</a>{{end}}
+		</p>
+{{end}}
+
+{{define "plain-form"}}
+		<form{{if .Id}} id="{{.Id}}"{{end}} action="{{.Action}}" method="{{.Method}}"{{if .Multipart}} enctype="multipart/form-data"{{end}}>
+			<fieldset>{{if .Legend}}<legend>{{.Legend}}</legend>{{end}}
+			{{if .Alert}}<p class="error">{{.Alert}}</p>{{end}}
+			{{.Fields}}</fieldset>
+		</form>
+{{end}}
+`))
+
+// Placeholder of the control inside the format returned by Control.Build
+const controlSentinel = "__forms_control__"
+
+// Valid names of the attributes
+var attrNameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_:.-]*$`)
+
+// Returns the attributes of a tag sorted by name, with their values
+// escaped. Invalid names and event handlers are dropped, as well as
+// the javascript: URLs.
+func attrs(m map[string]string) template.HTMLAttr {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := ""
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if !attrNameRe.MatchString(name) || strings.HasPrefix(lower, "on") {
+			continue
+		}
+		value := m[name]
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), "javascript:") {
+			value = "#"
+		}
+		out += fmt.Sprintf(` %s="%s"`, name, template.HTMLEscapeString(value))
+	}
+	return template.HTMLAttr(out)
+}
+
+// Executes the template, panicking if it fails like the rest of the
+// programming errors of the forms
+func render(name string, data interface{}) string {
+	buf := bytes.NewBuffer(nil)
+	if err := templates.ExecuteTemplate(buf, name, data); err != nil {
+		panic(fmt.Sprintf("render %s failed: %s", name, err))
+	}
+	return buf.String()
+}
@@ -2,6 +2,8 @@ package forms
 
 import (
 	"strings"
+
+	"github.com/ernestokarim/gaelib/v2/validators"
 )
 
 type Validator struct {
@@ -14,7 +16,7 @@ func NotEmpty(message string) *Validator {
 	return &Validator{
 		Name: "not-empty",
 		Func: func(v string) string {
-			if v == "" {
+			if !validators.Required(v) {
 				return message
 			}
 
@@ -28,7 +30,7 @@ func LargerThan(n int, message string) *Validator {
 		Name: "larger-than",
 		Args: []interface{}{n},
 		Func: func(v string) string {
-			if len(v) < n {
+			if !validators.MinBytes(v, n) {
 				return message
 			}
 
@@ -75,10 +77,8 @@ func SelectValue(f *Form, id, message string) *Validator {
 		Func: func(v string) string {
 			sel, ok := f.Fields[id].(*SelectField)
 			if ok {
-				for _, value := range sel.Values {
-					if v == value {
-						return ""
-					}
+				if validators.OneOf(v, sel.Values) {
+					return ""
 				}
 
 				return message
@@ -26,6 +26,18 @@ type Request struct {
 	C   appengine.Context
 	N   *goon.Goon
 	Session *sessions.Session
+
+	xsrfToken string
+}
+
+// Name of the hidden field with the XSRF token of the forms posted
+// without JS
+const XsrfField = "xsrf_token"
+
+// Returns the XSRF token of the response, to include it in the XsrfField
+// hidden field of the forms posted without JS
+func (r *Request) XsrfToken() string {
+	return r.xsrfToken
 }
 
 // Load the request data using gorilla schema into a struct
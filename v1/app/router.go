@@ -113,12 +113,13 @@ func appstatsWrapper(h Handler) http.Handler {
 		rw := newResponseWriter(w)
 		r := &Request{Req: req, W: rw, C: c, N: goon.FromContext(c)}
 
-		session, token, err := getSession(req, rw)
+		session, token, encoded, err := getSession(req, rw)
 		if err != nil {
 			r.processError(fmt.Errorf("build session failed: %s", err))
 			return
 		}
 		r.Session = session
+		r.xsrfToken = encoded
 
 		// Check XSRF token
 		if req.Method != "GET" {
@@ -156,8 +157,9 @@ func appstatsWrapper(h Handler) http.Handler {
 	return appstats.NewHandler(f)
 }
 
-// Return the session, the old XSRF token and an error if needed
-func getSession(req *http.Request, w http.ResponseWriter) (*sessions.Session, []uint8, error) {
+// Return the session, the old XSRF token, the encoded new one and an
+// error if needed
+func getSession(req *http.Request, w http.ResponseWriter) (*sessions.Session, []uint8, string, error) {
 	session, _ := dStore.Get(req, conf.SESSION_NAME)
 	session.Options = &sessions.Options{
 		Path: "/",
@@ -173,14 +175,14 @@ func getSession(req *http.Request, w http.ResponseWriter) (*sessions.Session, []
 
 	encoded, err := securecookie.EncodeMulti("XSRF-TOKEN", token, xsrfCodecs...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("encode token failed: %s", err)
+		return nil, nil, "", fmt.Errorf("encode token failed: %s", err)
 	}
 	http.SetCookie(w, &http.Cookie{
 		Name: "XSRF-TOKEN",
 		Value: encoded,
 		Path: "/",
 	})
-	return session, oldtoken, nil
+	return session, oldtoken, encoded, nil
 }
 
 // Returns true if the XSRF token was correct and an error if needed
@@ -203,8 +205,12 @@ func checkXsrfToken(req *http.Request, token []uint8) (bool, error) {
 		return false, nil
 	}
 
-	// Inconsistencies between the script & the cookies
+	// Inconsistencies between the script & the cookies. The forms posted
+	// without JS send the token in a hidden field instead.
 	header := req.Header.Get("X-Xsrf-Token")
+	if header == "" {
+		header = req.PostFormValue(XsrfField)
+	}
 	if header != cookie {
 		c.Errorf("[xsrf] inconsistency between the header & cookie: %s != %s",
 			header, cookie)
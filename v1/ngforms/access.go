@@ -0,0 +1,54 @@
+package ngforms
+
+// Roles allowed to see and edit a field. Empty lists allow everybody.
+// Fields not visible are omitted, and the not editable ones are rendered
+// disabled; both are ignored when validating the submitted data.
+// The roles of the current user are set in FormData.Roles.
+type Access struct {
+	VisibleTo, EditableBy []string
+}
+
+func (a *Access) access() *Access {
+	return a
+}
+
+type accessible interface {
+	access() *Access
+}
+
+func (a *Access) visible(roles []string) bool {
+	return len(a.VisibleTo) == 0 || hasRole(a.VisibleTo, roles)
+}
+
+func (a *Access) editable(roles []string) bool {
+	return a.visible(roles) && (len(a.EditableBy) == 0 || hasRole(a.EditableBy, roles))
+}
+
+func hasRole(allowed, roles []string) bool {
+	for _, a := range allowed {
+		for _, r := range roles {
+			if a == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the access rules of the field, or nil if it doesn't have them
+func getAccess(f Field) *Access {
+	if a, ok := f.(accessible); ok {
+		return a.access()
+	}
+	return nil
+}
+
+// Returns the access rules of the field with the ID
+func findAccess(form Form, id string) *Access {
+	for _, field := range form.Fields() {
+		if getId(field) == id {
+			return getAccess(field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package ngforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+// Key of the Google Geocoding API used by Geocode
+var GeocodingKey string
+
+// Value of an AddressField. Use it in the form struct with the
+// same name of the field:
+//    Home *ngforms.Address `json:"home"`
+type Address struct {
+	Street     string `json:"street"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postalCode"`
+	Country    string `json:"country"`
+
+	// Filled by Geocode
+	Location appengine.GeoPoint `json:"-"`
+}
+
+func (a *Address) String() string {
+	parts := []string{}
+	for _, p := range []string{a.Street, a.PostalCode + " " + a.City, a.Region, a.Country} {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Composite field with the street, city, region, postal code and
+// country of an address. Only the "required" validator is allowed; it
+// applies to all the parts except the region.
+type AddressField struct {
+	Id, Name string
+	Help     string
+	Class    []string
+
+	// Labels of the parts, used as placeholders
+	StreetLabel, CityLabel, RegionLabel, PostalCodeLabel, CountryLabel string
+
+	Hooks
+	Access
+}
+
+func (f *AddressField) Build(form Form) string {
+	checkValidators(form, "address", f.Id, "required")
+
+	d := getFormData(form)
+	fid := fmt.Sprintf("%s%s", d.Name, f.Id)
+	controlAttrs, control := BuildControl(form, f.Id, f.Name, f.Help)
+
+	input := func(part, label string, required bool) string {
+		attrs := map[string]string{
+			"type":        "text",
+			"id":          fid + part,
+			"name":        fid + part,
+			"placeholder": label,
+			"class":       strings.Join(f.Class, " "),
+			"ng-model":    fmt.Sprintf("%s.%s.%s", d.ObjName, f.Id, part),
+		}
+		if required {
+			update(attrs, controlAttrs)
+		}
+
+		f.beforeRender(form, f.Id, attrs)
+		return renderTag("input", attrs)
+	}
+
+	labels := []string{d.translate(f.CountryLabel)}
+	values := []string{""}
+	for _, c := range Countries {
+		labels = append(labels, c.Name)
+		values = append(values, c.Code)
+	}
+	attrs := map[string]string{
+		"id":       fid + "country",
+		"name":     fid + "country",
+		"class":    strings.Join(f.Class, " "),
+		"ng-model": fmt.Sprintf("%s.%s.country", d.ObjName, f.Id),
+	}
+	update(attrs, controlAttrs)
+	f.beforeRender(form, f.Id, attrs)
+	sel := renderSelect(attrs, labels, values, postedValues(form, attrs["name"]))
+
+	// The nested form groups the errors of all the parts
+	ctrl := renderNgForm(fid, "<br>",
+		input("street", d.translate(f.StreetLabel), true),
+		input("postalCode", d.translate(f.PostalCodeLabel), true)+" "+
+			input("city", d.translate(f.CityLabel), true),
+		input("region", d.translate(f.RegionLabel), false),
+		sel)
+
+	return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
+}
+
+// Returns the value used by the validators of an address: empty if
+// some of the required parts is missing.
+func addressValue(id string, m map[string]interface{}) string {
+	parts, ok := m[id].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	values := []string{}
+	for _, part := range []string{"street", "postalCode", "city", "country"} {
+		v, _ := extractValue(part, parts)
+		if v == "" {
+			return ""
+		}
+		values = append(values, v)
+	}
+	return strings.Join(values, ",")
+}
+
+// Returns true if the country of the address is empty or one of the
+// codes of Countries, the options of its select
+func validCountry(id string, m map[string]interface{}) bool {
+	parts, ok := m[id].(map[string]interface{})
+	if !ok {
+		return true
+	}
+	country, _ := extractValue("country", parts)
+	if country == "" {
+		return true
+	}
+	for _, c := range Countries {
+		if c.Code == country {
+			return true
+		}
+	}
+	return false
+}
+
+// Fills the location of the address using the Google Geocoding API.
+// Call it after validating the form, before storing the address.
+func Geocode(c appengine.Context, a *Address) error {
+	client := &http.Client{
+		Transport: &urlfetch.Transport{
+			Context:  c,
+			Deadline: time.Duration(10) * time.Second,
+		},
+	}
+
+	params := url.Values{
+		"address": {a.String()},
+		"key":     {GeocodingKey},
+	}
+	u := "https://maps.googleapis.com/maps/api/geocode/json?" + params.Encode()
+	resp, err := client.Get(u)
+	if err != nil {
+		return fmt.Errorf("geocoding request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status  string `json:"status"`
+		Results []struct {
+			Geometry struct {
+				Location struct {
+					Lat float64 `json:"lat"`
+					Lng float64 `json:"lng"`
+				} `json:"location"`
+			} `json:"geometry"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode geocoding response failed: %s", err)
+	}
+	if result.Status != "OK" || len(result.Results) == 0 {
+		return fmt.Errorf("cannot geocode the address: %s", result.Status)
+	}
+
+	loc := result.Results[0].Geometry.Location
+	a.Location = appengine.GeoPoint{Lat: loc.Lat, Lng: loc.Lng}
+
+	return nil
+}
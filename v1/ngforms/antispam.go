@@ -0,0 +1,172 @@
+package ngforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+// Secret key of reCAPTCHA, used by Validate to verify the responses
+// of the RecaptchaField
+var RecaptchaSecret string
+
+const recaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+
+// Input posted by the reCAPTCHA widget in the forms sent without JS
+const recaptchaResponse = "g-recaptcha-response"
+
+// Error key of the RecaptchaField when the verification fails
+const recaptchaError = "recaptcha"
+
+// Input hidden from the users that the spam bots fill. Validate rejects
+// the submissions with a value without telling the reason. It doesn't
+// need validations.
+type HoneypotField struct {
+	Id string
+}
+
+func (f *HoneypotField) Build(form Form) string {
+	d := getFormData(form)
+	return render("honeypot", map[string]string{
+		"type":         "text",
+		"id":           fmt.Sprintf("%s%s", d.Name, f.Id),
+		"name":         fmt.Sprintf("%s%s", d.Name, f.Id),
+		"ng-model":     fmt.Sprintf("%s.%s", d.ObjName, f.Id),
+		"tabindex":     "-1",
+		"autocomplete": "off",
+	})
+}
+
+// Widget of reCAPTCHA. Validate verifies its response with Google when
+// the rest of the fields are valid, showing the Message if it fails. It
+// doesn't need validations. It needs the "ngformsRecaptcha" directive
+// of DirectiveJS to send the response with the Angular submits.
+type RecaptchaField struct {
+	Id, Name string
+
+	// Public key of the site in reCAPTCHA
+	SiteKey string
+
+	// Message shown when the verification fails
+	Message string
+}
+
+func (f *RecaptchaField) Build(form Form) string {
+	d := getFormData(form)
+	fid := fmt.Sprintf("%s%s", d.Name, f.Id)
+
+	// The widget calls the global functions of the directive when the
+	// user solves it or the response expires
+	widget := render("recaptcha", map[string]string{
+		"id":                    fid,
+		"class":                 "g-recaptcha",
+		"data-sitekey":          f.SiteKey,
+		"data-callback":         "ngformsRecaptcha" + fid,
+		"data-expired-callback": "ngformsRecaptchaExpired" + fid,
+		"ngforms-recaptcha":     fmt.Sprintf("%s.%s", d.ObjName, f.Id),
+	})
+
+	serverError := ""
+	if FieldError(form, f.Id) == recaptchaError {
+		serverError = d.translate(f.Message)
+	}
+	return render("control", map[string]interface{}{
+		"FormName":    d.Name,
+		"Fid":         fid,
+		"Label":       d.translate(f.Name),
+		"Errs":        "false",
+		"ServerError": serverError,
+		"Control":     template.HTML(widget),
+	})
+}
+
+// Returns false if some of the honeypots of the form was filled
+func checkHoneypots(r *http.Request, d *FormData, fields FieldList, m map[string]interface{}) bool {
+	for _, field := range fields {
+		hp, ok := field.(*HoneypotField)
+		if !ok {
+			continue
+		}
+
+		value, _ := extractValue(hp.Id, m)
+		if IsFallbackPost(r) {
+			value = r.PostForm.Get(d.Name + hp.Id)
+		}
+		if value != "" {
+			c := appengine.NewContext(r)
+			c.Warningf("[ngforms] honeypot %s filled from %s", hp.Id, r.RemoteAddr)
+			return false
+		}
+	}
+	return true
+}
+
+// Verifies the responses of the reCAPTCHA fields of the form, marking
+// the error of the ones that fail
+func checkRecaptchas(r *http.Request, f Form, fields FieldList, m map[string]interface{}) (bool, error) {
+	valid := true
+	for _, field := range fields {
+		rf, ok := field.(*RecaptchaField)
+		if !ok {
+			continue
+		}
+
+		token, _ := extractValue(rf.Id, m)
+		if IsFallbackPost(r) {
+			token = r.PostForm.Get(recaptchaResponse)
+		}
+		ok, err := verifyRecaptcha(appengine.NewContext(r), token, r.RemoteAddr)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			f.SetValue(fieldErrorKey(rf.Id), recaptchaError)
+			valid = false
+		}
+	}
+	return valid, nil
+}
+
+// Asks Google if the response of the reCAPTCHA widget is valid
+func verifyRecaptcha(c appengine.Context, token, remoteIP string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+
+	client := &http.Client{
+		Transport: &urlfetch.Transport{
+			Context:  c,
+			Deadline: time.Duration(10) * time.Second,
+		},
+	}
+
+	params := url.Values{
+		"secret":   {RecaptchaSecret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	}
+	resp, err := client.PostForm(recaptchaVerifyURL, params)
+	if err != nil {
+		return false, fmt.Errorf("recaptcha request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("decode recaptcha response failed: %s", err)
+	}
+	if !result.Success {
+		c.Warningf("[ngforms] recaptcha verification failed: %v", result.ErrorCodes)
+	}
+
+	return result.Success, nil
+}
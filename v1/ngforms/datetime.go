@@ -0,0 +1,176 @@
+package ngforms
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Layouts of the date & time inputs
+const (
+	dateLayout = "2006-01-02"
+	timeLayout = "15:04"
+)
+
+// Picker of a day. The validators receive the value as 2006-01-02 and
+// the form struct receives a time.Time at midnight of the Location:
+//    Birthday time.Time `json:"birthday"`
+type DateField struct {
+	Id, Name string
+	Help     string
+	Class    []string
+
+	// Zone of the bound time, the Location of the form by default
+	Location *time.Location
+
+	Hooks
+	Access
+}
+
+func (f *DateField) Build(form Form) string {
+	checkValidators(form, "date", f.Id, "required", "date", "min", "max")
+	return buildTypedInput(form, f.Id, f.Name, f.Help, "date", f.Class, &f.Hooks)
+}
+
+// Converts the value of the body to a RFC 3339 time the JSON decoder
+// understands
+func (f *DateField) normalize(value string, loc *time.Location) interface{} {
+	if f.Location != nil {
+		loc = f.Location
+	}
+	t, err := time.ParseInLocation(dateLayout, value, loc)
+	if err != nil {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+// Picker of a time of the day. The validators receive the value as 15:04
+// and the form struct receives a time.Time of the 1 Jan 1970 (UTC):
+//    OpensAt time.Time `json:"opensAt"`
+type TimeField struct {
+	Id, Name string
+	Help     string
+	Class    []string
+
+	Hooks
+	Access
+}
+
+func (f *TimeField) Build(form Form) string {
+	checkValidators(form, "time", f.Id, "required", "time", "min", "max")
+	return buildTypedInput(form, f.Id, f.Name, f.Help, "time", f.Class, &f.Hooks)
+}
+
+func (f *TimeField) normalize(value string) interface{} {
+	t, err := time.Parse(timeLayout, value)
+	if err != nil {
+		return nil
+	}
+	return t.AddDate(1970, 0, 0).Format(time.RFC3339)
+}
+
+// The value should be a valid date
+func DateFormat(msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{},
+		Message: msg,
+		Error:   "date",
+		Func: func(v string) bool {
+			_, err := time.Parse(dateLayout, v)
+			return v == "" || err == nil
+		},
+	}
+}
+
+// The value should be a valid time
+func TimeFormat(msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{},
+		Message: msg,
+		Error:   "time",
+		Func: func(v string) bool {
+			_, err := time.Parse(timeLayout, v)
+			return v == "" || err == nil
+		},
+	}
+}
+
+// The day should not be before min
+func MinDate(min time.Time, msg string) *Validator {
+	bound := min.Format(dateLayout)
+	return &Validator{
+		Attrs:   map[string]string{"min": bound},
+		Message: msg,
+		Error:   "min",
+		Func:    func(v string) bool { return v == "" || v >= bound },
+	}
+}
+
+// The day should not be after max
+func MaxDate(max time.Time, msg string) *Validator {
+	bound := max.Format(dateLayout)
+	return &Validator{
+		Attrs:   map[string]string{"max": bound},
+		Message: msg,
+		Error:   "max",
+		Func:    func(v string) bool { return v == "" || v <= bound },
+	}
+}
+
+// The time should not be before min (formatted as 15:04)
+func MinTime(min, msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{"min": min},
+		Message: msg,
+		Error:   "min",
+		Func:    func(v string) bool { return v == "" || v >= min },
+	}
+}
+
+// The time should not be after max (formatted as 15:04)
+func MaxTime(max, msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{"max": max},
+		Message: msg,
+		Error:   "max",
+		Func:    func(v string) bool { return v == "" || v <= max },
+	}
+}
+
+// Returns the value used by the validators of a date or time. Angular
+// sends the models of these inputs as full JSON dates; the local
+// part with the layout is extracted from them in the zone of the user.
+func dateTimeValue(id, layout string, m map[string]interface{}, loc *time.Location) string {
+	value := normalizeValue(id, m)
+	if value == "" {
+		return ""
+	}
+	if _, err := time.Parse(layout, value); err == nil {
+		return value
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(loc).Format(layout)
+	}
+	return value
+}
+
+// Renders an input of the type with the usual attributes of the form
+func buildTypedInput(form Form, id, name, help, kind string, class []string, h *Hooks) string {
+	d := getFormData(form)
+	attrs := map[string]string{
+		"type":     kind,
+		"id":       fmt.Sprintf("%s%s", d.Name, id),
+		"name":     fmt.Sprintf("%s%s", d.Name, id),
+		"class":    strings.Join(class, " "),
+		"ng-model": fmt.Sprintf("%s.%s", d.ObjName, id),
+	}
+
+	controlAttrs, control := BuildControl(form, id, name, help)
+	update(attrs, controlAttrs)
+
+	h.beforeRender(form, id, attrs)
+	ctrl := renderTag("input", attrs)
+
+	return h.afterRender(form, id, fmt.Sprintf(control, ctrl))
+}
@@ -0,0 +1,110 @@
+package ngforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"appengine/datastore"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v1/app"
+	"github.com/gorilla/mux"
+)
+
+const KindDraft = "NgFormsDraft"
+
+// Time a draft is kept before being discarded
+var DraftTTL = 7 * 24 * time.Hour
+
+// Max size of the data of a draft
+var DraftMaxSize int64 = 256 << 10
+
+type Draft struct {
+	Data    []byte `datastore:",noindex"`
+	Updated time.Time
+}
+
+// Handler for the drafts endpoint. It should be registered with a form
+// variable in the path, matching the FormData.DraftUrl prefix:
+//    "::/_/drafts/{form}": ngforms.DraftHandler,
+//
+// GET returns the stored draft (an empty object if there's none), POST
+// saves the body as the new draft and DELETE discards it.
+func DraftHandler(r *app.Request) error {
+	u := user.Current(r.C)
+	if u == nil {
+		return app.Forbidden()
+	}
+	form := mux.Vars(r.Req)["form"]
+	if form == "" {
+		return app.NotFound()
+	}
+	key := datastore.NewKey(r.C, KindDraft, u.ID+"/"+form, 0, nil)
+
+	switch r.Req.Method {
+	case "GET":
+		draft := new(Draft)
+		if err := datastore.Get(r.C, key, draft); err != nil {
+			if err != datastore.ErrNoSuchEntity {
+				return fmt.Errorf("get draft failed: %s", err)
+			}
+		}
+		if len(draft.Data) == 0 || time.Since(draft.Updated) > DraftTTL {
+			draft.Data = []byte("{}")
+		}
+		r.W.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(r.W, ")]}',")
+		if _, err := r.W.Write(draft.Data); err != nil {
+			return fmt.Errorf("write draft failed: %s", err)
+		}
+
+	case "POST":
+		data, err := ioutil.ReadAll(io.LimitReader(r.Req.Body, DraftMaxSize+1))
+		if err != nil {
+			return fmt.Errorf("read draft body failed: %s", err)
+		}
+		var values map[string]interface{}
+		if int64(len(data)) > DraftMaxSize || json.Unmarshal(data, &values) != nil {
+			return app.HttpError(400)
+		}
+		draft := &Draft{Data: data, Updated: time.Now()}
+		if _, err := datastore.Put(r.C, key, draft); err != nil {
+			return fmt.Errorf("put draft failed: %s", err)
+		}
+
+	case "DELETE":
+		if err := datastore.Delete(r.C, key); err != nil && err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("delete draft failed: %s", err)
+		}
+
+	default:
+		return app.NotAllowed()
+	}
+
+	return nil
+}
+
+// Handler to remove the expired drafts, prepared to be called from a cron.
+func CleanDraftsHandler(r *app.Request) error {
+	for {
+		// The datastore deletes 500 entities in each batch at most
+		q := datastore.NewQuery(KindDraft).
+			Filter("Updated <", time.Now().Add(-DraftTTL)).
+			KeysOnly().
+			Limit(500)
+		keys, err := q.GetAll(r.C, nil)
+		if err != nil {
+			return fmt.Errorf("query expired drafts failed: %s", err)
+		}
+		if err := datastore.DeleteMulti(r.C, keys); err != nil {
+			return fmt.Errorf("delete expired drafts failed: %s", err)
+		}
+
+		if len(keys) < 500 {
+			return nil
+		}
+	}
+}
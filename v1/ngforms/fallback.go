@@ -0,0 +1,147 @@
+package ngforms
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ernestokarim/gaelib/v1/app"
+)
+
+// Key of the form values with the failed validator of the field
+func fieldErrorKey(id string) string {
+	return "$error:" + id
+}
+
+// Key of the form values with the raw values posted without JS, by the
+// name of their inputs
+func postedKey(name string) string {
+	return "$posted:" + name
+}
+
+// Returns the error key of the validator that failed in the field after
+// Validate ("required", "email", etc.), or an empty string.
+func FieldError(f Form, id string) string {
+	return f.Value(fieldErrorKey(id))
+}
+
+// Returns true if the form was posted without JS (see FormData.Action).
+// Render the page with the form again when Validate fails, to show the
+// errors of the fields.
+func IsFallbackPost(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	return strings.HasPrefix(ct, "application/x-www-form-urlencoded") ||
+		strings.HasPrefix(ct, "multipart/form-data")
+}
+
+// Converts the values of a regular POST to the structure of the JSON
+// body sent by Angular, keeping the raw ones to render them again.
+func formValues(r *http.Request, d *FormData, f Form, fields FieldList) (map[string]interface{}, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("parse form failed: %s", err)
+	}
+
+	m := map[string]interface{}{}
+	for _, field := range fields {
+		id := getId(field)
+		if id == "" {
+			continue
+		}
+		fid := d.Name + id
+
+		switch ff := field.(type) {
+		case *AddressField:
+			parts := map[string]interface{}{}
+			for _, part := range []string{"street", "postalCode", "city", "region", "country"} {
+				parts[part] = r.PostForm.Get(fid + part)
+			}
+			m[id] = parts
+
+		case *TimeRangeField:
+			m[id] = map[string]interface{}{
+				"start": r.PostForm.Get(fid + "start"),
+				"end":   r.PostForm.Get(fid + "end"),
+			}
+
+		case *CheckboxField:
+			if len(ff.Values) == 0 {
+				m[id] = r.PostForm.Get(fid) != ""
+				continue
+			}
+			checked := map[string]interface{}{}
+			for _, v := range r.PostForm[fid] {
+				checked[v] = true
+			}
+			m[id] = checked
+
+		case *SelectField:
+			if !ff.Multiple {
+				m[id] = r.PostForm.Get(fid)
+				continue
+			}
+			selected := []interface{}{}
+			for _, v := range r.PostForm[fid] {
+				selected = append(selected, v)
+			}
+			m[id] = selected
+
+		case *FileField:
+			// The files are uploaded separately
+
+		default:
+			if values, ok := r.PostForm[fid]; ok {
+				m[id] = values[0]
+			}
+		}
+	}
+
+	for name, values := range r.PostForm {
+		if name != app.XsrfField {
+			f.SetValue(postedKey(name), strings.Join(values, "\x00"))
+		}
+	}
+
+	return m, nil
+}
+
+// Returns the first value posted without JS in the input
+func postedValue(form Form, name string) string {
+	return strings.Split(form.Value(postedKey(name)), "\x00")[0]
+}
+
+// Returns all the values posted without JS in the input
+func postedValues(form Form, name string) []string {
+	raw := form.Value(postedKey(name))
+	if raw == "" {
+		return nil
+	}
+	return strings.Split(raw, "\x00")
+}
+
+// Fills the value of the input, or checks it, with the values posted
+// without JS, so the user doesn't lose them when the form has errors.
+func fillPosted(form Form, attrs map[string]string) {
+	name := attrs["name"]
+	switch attrs["type"] {
+	// Textareas & selects are filled when they're rendered
+	case "", "password", "file":
+		return
+
+	case "checkbox", "radio":
+		value, ok := attrs["value"]
+		if !ok {
+			// Value sent by the browsers for the checkboxes without one
+			value = "on"
+		}
+		for _, v := range postedValues(form, name) {
+			if v == value {
+				attrs["checked"] = ""
+			}
+		}
+
+	default:
+		if value := postedValue(form, name); value != "" {
+			attrs["value"] = value
+		}
+	}
+}
@@ -2,11 +2,11 @@ package ngforms
 
 import (
 	"fmt"
+	"html/template"
 	"strings"
 )
 
 func BuildControl(form Form, id, name, help string) (map[string]string, string) {
-	var errs, messages string
 	attrs := map[string]string{}
 
 	validationMap := form.Validations()
@@ -17,33 +17,34 @@ func BuildControl(form Form, id, name, help string) (map[string]string, string)
 
 	d := getFormData(form)
 	fid := fmt.Sprintf("%s%s", d.Name, id)
-	messages = fmt.Sprintf(`
-        <p class="help-block error" ng-show="%s.val && %s.%s.$invalid">
-	`, d.Name, d.Name, fid)
+	errs := []string{}
+	messages := []map[string]string{}
+	serverError := ""
 	for _, val := range validations {
 		update(attrs, val.Attrs)
-		errs += fmt.Sprintf("%s.%s.$error.%s || ", d.Name, fid, val.Error)
-		messages += fmt.Sprintf(`
-			<span ng-show="%s.%s.$error.%s">%s</span>
-		`, d.Name, fid, val.Error, val.Message)
-	}
-	messages += `</p>`
-	errs = errs[:len(errs)-4]
-
-	if name == "" {
-		return attrs, fmt.Sprintf(`
-	        <div class="control-group" ng-class="%s.val && (%s) && 'error'">
-	          %%s%s
-	        </div>
-		`, d.Name, errs, messages)
+		if val.Error == FieldError(form, id) {
+			serverError = d.translate(val.Message)
+		}
+		errs = append(errs, fmt.Sprintf("%s.%s.$error.%s", d.Name, fid, val.Error))
+		messages = append(messages, map[string]string{
+			"Error":   val.Error,
+			"Message": d.translate(val.Message),
+		})
 	}
 
-	return attrs, fmt.Sprintf(`
-      <div class="control-group" ng-class="%s.val && (%s) && 'error'">
-        <label class="control-label" for="%s">%s</label>
-        <div class="controls">%%s%s</div>
-      </div>
-	`, d.Name, errs, fid, name, messages)
+	control := render("control", map[string]interface{}{
+		"FormName":    d.Name,
+		"Fid":         fid,
+		"Label":       d.translate(name),
+		"Errs":        strings.Join(errs, " || "),
+		"Messages":    messages,
+		"ServerError": serverError,
+		"Control":     template.HTML(controlSentinel),
+	})
+
+	// The caller formats the result with the HTML of the control
+	control = strings.Replace(control, "%", "%%", -1)
+	return attrs, strings.Replace(control, controlSentinel, "%s", 1)
 }
 
 // ==================================================================
@@ -55,7 +56,13 @@ type InputField struct {
 	Class       []string
 	PlaceHolder string
 
+	// Format of the value while the user types it
+	Mask *Mask
+
 	Attrs map[string]string
+
+	Hooks
+	Access
 }
 
 func (f *InputField) Build(form Form) string {
@@ -72,18 +79,18 @@ func (f *InputField) Build(form Form) string {
 		"class":       strings.Join(f.Class, " "),
 		"ng-model":    fmt.Sprintf("%s.%s", d.ObjName, f.Id),
 	}
+	if f.Mask != nil {
+		attrs["ui-mask"] = f.Mask.Pattern
+	}
 	update(attrs, f.Attrs)
 
 	controlAttrs, control := BuildControl(form, f.Id, f.Name, f.Help)
 	update(attrs, controlAttrs)
 
-	ctrl := "<input"
-	for k, v := range attrs {
-		ctrl += fmt.Sprintf(` %s="%s"`, k, v)
-	}
-	ctrl += ">"
+	f.beforeRender(form, f.Id, attrs)
+	ctrl := renderTag("input", attrs)
 
-	return fmt.Sprintf(control, ctrl)
+	return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
 }
 
 // ==================================================================
@@ -95,20 +102,18 @@ type SubmitField struct {
 }
 
 func (f *SubmitField) Build(form Form) string {
-	cancel := ""
+	d := getFormData(form)
+	data := map[string]string{
+		"TrySubmit": d.TrySubmit,
+		"Name":      d.Name,
+		"Label":     d.translate(f.Label),
+	}
 	if f.CancelLabel != "" && f.CancelUrl != "" {
-		cancel = fmt.Sprintf(`&nbsp;&nbsp;&nbsp;<a href="%s" class="btn">%s</a>`,
-			f.CancelUrl, f.CancelLabel)
+		data["CancelUrl"] = f.CancelUrl
+		data["CancelLabel"] = d.translate(f.CancelLabel)
 	}
 
-	d := getFormData(form)
-	return fmt.Sprintf(`
-		<div class="form-actions">
-			<button ng-click="%s(); %s.val = true;" class="btn btn-primary"
-				ng-disabled="%s.val && !%s.$valid">%s</button>
-			%s
-		</div>
-	`, d.TrySubmit, d.Name, d.Name, d.Name, f.Label, cancel)
+	return render("submit", data)
 }
 
 // ==================================================================
@@ -119,6 +124,9 @@ type TextAreaField struct {
 	Class       []string
 	Rows        int
 	PlaceHolder string
+
+	Hooks
+	Access
 }
 
 func (f *TextAreaField) Build(form Form) string {
@@ -135,81 +143,193 @@ func (f *TextAreaField) Build(form Form) string {
 	controlAttrs, control := BuildControl(form, f.Id, f.Name, f.Help)
 	update(attrs, controlAttrs)
 
-	ctrl := "<textarea"
-	for k, v := range attrs {
-		ctrl += fmt.Sprintf(` %s="%s"`, k, v)
-	}
-	ctrl += "></textarea>"
+	f.beforeRender(form, f.Id, attrs)
+	ctrl := renderTextArea(attrs, postedValue(form, attrs["name"]))
 
-	return fmt.Sprintf(control, ctrl)
+	return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
 }
 
-/*
 // ==================================================================
 
 type SelectField struct {
-	Control        *Control
+	Id, Name       string
+	Help           string
 	Class          []string
 	Labels, Values []string
+
+	// Allow the selection of several options. The model will be
+	// an array with the selected values.
+	Multiple bool
+
+	Hooks
+	Access
 }
 
-func (f *SelectField) Build() string {
-	// The select tag attributes
+func (f *SelectField) Build(form Form) string {
+	checkValidators(form, "select", f.Id, "required", "minselected")
+	return f.build(form)
+}
+
+func (f *SelectField) build(form Form) string {
+	d := getFormData(form)
 	attrs := map[string]string{
-		"id":       f.Control.Id,
-		"name":     f.Control.Id,
-		"ng-model": "data." + f.Control.Id,
+		"id":       fmt.Sprintf("%s%s", d.Name, f.Id),
+		"name":     fmt.Sprintf("%s%s", d.Name, f.Id),
+		"class":    strings.Join(f.Class, " "),
+		"ng-model": fmt.Sprintf("%s.%s", d.ObjName, f.Id),
+	}
+	if f.Multiple {
+		attrs["multiple"] = ""
 	}
 
-	// The CSS classes
-	if f.Class != nil {
-		attrs["class"] = strings.Join(f.Class, " ")
+	controlAttrs, control := BuildControl(form, f.Id, f.Name, f.Help)
+	update(attrs, controlAttrs)
+
+	// Assert the same length precondition, because the error is not
+	// very descriptive.
+	if len(f.Labels) != len(f.Values) {
+		panic("labels and values should have the same size: " + f.Id)
 	}
 
-	// Add the validators
-	errors := fmt.Sprintf(`<p class="help-block error" ng-show="val && f.%s.$invalid">`,
-		f.Control.Id)
-	for _, v := range f.Control.Validations {
-		// Fail early if it's not a valid one
-		if v.Error != "required" && v.Error != "select" {
-			panic("validator not allowed in select " + f.Control.Id + ": " + v.Error)
+	f.beforeRender(form, f.Id, attrs)
+	ctrl := renderSelect(attrs, f.Labels, f.Values, postedValues(form, attrs["name"]))
+
+	return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
+}
+
+// ==================================================================
+
+type CheckboxField struct {
+	Id, Name string
+	Help     string
+	Class    []string
+
+	// Text shown next to a single checkbox
+	Label string
+
+	// If present, a group of checkboxes is built instead of a single one.
+	// The model will be an object with the checked values as keys.
+	Labels, Values []string
+
+	Hooks
+	Access
+}
+
+func (f *CheckboxField) Build(form Form) string {
+	checkValidators(form, "checkbox", f.Id, "required", "minselected")
+
+	d := getFormData(form)
+	fid := fmt.Sprintf("%s%s", d.Name, f.Id)
+	controlAttrs, control := BuildControl(form, f.Id, f.Name, f.Help)
+
+	// Single checkbox bound to a boolean
+	if len(f.Values) == 0 {
+		attrs := map[string]string{
+			"type":     "checkbox",
+			"id":       fid,
+			"name":     fid,
+			"class":    strings.Join(f.Class, " "),
+			"ng-model": fmt.Sprintf("%s.%s", d.ObjName, f.Id),
 		}
+		update(attrs, controlAttrs)
+
+		f.beforeRender(form, f.Id, attrs)
+		ctrl := renderLabeled("checkbox", attrs, d.translate(f.Label))
+
+		return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
+	}
 
-		// Add the attributes and errors
-		for k, v := range v.Attrs {
-			attrs[k] = v
+	if len(f.Labels) != len(f.Values) {
+		panic("labels and values should have the same size: " + f.Id)
+	}
+
+	// A group is required when at least one of the boxes is checked; emulate
+	// it requiring all of them while none is checked.
+	if _, ok := controlAttrs["required"]; ok {
+		delete(controlAttrs, "required")
+		conds := []string{}
+		for _, value := range f.Values {
+			conds = append(conds, fmt.Sprintf("!%s.%s['%s']", d.ObjName, f.Id, value))
 		}
-		errors += fmt.Sprintf(`<span ng-show="f.%s.$error.%s">%s</span>`, f.Control.Id,
-			v.Error, v.Message)
-		f.Control.errors = append(f.Control.errors, v.Error)
+		controlAttrs["ng-required"] = strings.Join(conds, " && ")
 	}
-	errors += "</p>"
 
-	// Build the tag
-	ctrl := "<select"
-	for k, v := range attrs {
-		ctrl += fmt.Sprintf(" %s=\"%s\"", k, v)
+	ctrl := ""
+	for i, label := range f.Labels {
+		attrs := map[string]string{
+			"type":     "checkbox",
+			"name":     fid,
+			"value":    f.Values[i],
+			"class":    strings.Join(f.Class, " "),
+			"ng-model": fmt.Sprintf("%s.%s['%s']", d.ObjName, f.Id, f.Values[i]),
+		}
+		update(attrs, controlAttrs)
+
+		f.beforeRender(form, f.Id, attrs)
+		ctrl += renderLabeled("checkbox", attrs, label)
 	}
-	ctrl += ">"
 
-	// Assert the same length precondition, because the error is not
-	// very descriptive. Then add the option tags to the select field.
+	return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
+}
+
+// ==================================================================
+
+type RadioGroupField struct {
+	Id, Name       string
+	Help           string
+	Class          []string
+	Labels, Values []string
+
+	Hooks
+	Access
+}
+
+func (f *RadioGroupField) Build(form Form) string {
+	checkValidators(form, "radio", f.Id, "required")
+
 	if len(f.Labels) != len(f.Values) {
-		panic("labels and values should have the same size")
+		panic("labels and values should have the same size: " + f.Id)
 	}
+
+	d := getFormData(form)
+	controlAttrs, control := BuildControl(form, f.Id, f.Name, f.Help)
+
+	ctrl := ""
 	for i, label := range f.Labels {
-		ctrl += fmt.Sprintf(`<option value="%s">%s</option>`, f.Values[i], label)
-	}
+		attrs := map[string]string{
+			"type":     "radio",
+			"name":     fmt.Sprintf("%s%s", d.Name, f.Id),
+			"value":    f.Values[i],
+			"class":    strings.Join(f.Class, " "),
+			"ng-model": fmt.Sprintf("%s.%s", d.ObjName, f.Id),
+		}
+		update(attrs, controlAttrs)
 
-	// Finish the control build
-	ctrl += "</select>"
+		f.beforeRender(form, f.Id, attrs)
+		ctrl += renderLabeled("radio", attrs, label)
+	}
 
-	return fmt.Sprintf(f.Control.Build(), ctrl, errors)
+	return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
 }
 
-func (f *SelectField) Validate(value string) bool {
-	return f.Control.Validate(value)
-}*/
+// ==================================================================
+
+// Panics if the field has validators not included in the allowed list
+// or registered for its input type with RegisterValidator
+func checkValidators(form Form, inputType, id string, allowed ...string) {
+	for _, val := range form.Validations()[id] {
+		found := customAllowed(inputType, val.Error)
+		for _, a := range allowed {
+			if val.Error == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			panic("validator not allowed in " + id + ": " + val.Error)
+		}
+	}
+}
 
 // ==================================================================
 
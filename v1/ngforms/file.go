@@ -0,0 +1,68 @@
+package ngforms
+
+import (
+	"fmt"
+	"strings"
+)
+
+// File input. Angular doesn't bind the files to the models; the
+// "fileModel" directive (see DirectiveJS) uploads the selected file to
+// Upload as the "file" field of a multipart form, and stores the "value"
+// of the JSON response (the key or URL of the file) in the model.
+// That's the value received by the validators.
+type FileField struct {
+	Id, Name string
+	Help     string
+	Class    []string
+
+	// Content types accepted by the input (image/*, .pdf, etc.)
+	Accept string
+
+	// Path of the handler that receives the uploaded file
+	Upload string
+
+	Hooks
+	Access
+}
+
+func (f *FileField) Build(form Form) string {
+	checkValidators(form, f.Id, "required")
+
+	d := getFormData(form)
+	fid := fmt.Sprintf("%s%s", d.Name, f.Id)
+	model := fmt.Sprintf("%s.%s", d.ObjName, f.Id)
+
+	// The model and the validations live in a hidden input, updated by
+	// the directive when the upload finishes
+	hidden := map[string]string{
+		"type":     "hidden",
+		"name":     fid,
+		"ng-model": model,
+	}
+	controlAttrs, control := BuildControl(form, f.Id, f.Name, f.Help)
+	update(hidden, controlAttrs)
+
+	attrs := map[string]string{
+		"type":        "file",
+		"id":          fid,
+		"class":       strings.Join(f.Class, " "),
+		"file-model":  model,
+		"file-upload": f.Upload,
+	}
+	if f.Accept != "" {
+		attrs["accept"] = f.Accept
+	}
+
+	f.beforeRender(form, f.Id, attrs)
+	ctrl := "<input"
+	for k, v := range hidden {
+		ctrl += fmt.Sprintf(` %s="%s"`, k, v)
+	}
+	ctrl += "><input"
+	for k, v := range attrs {
+		ctrl += fmt.Sprintf(` %s="%s"`, k, v)
+	}
+	ctrl += ">"
+
+	return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
+}
@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ernestokarim/gaelib/v1/app"
+	"github.com/ernestokarim/gaelib/v2/validators"
 )
 
 type Field interface {
@@ -31,6 +38,52 @@ type FormData struct {
 	// Name of the client side object that will be scoped
 	// with the values of the form
 	ObjName string
+
+	// URL of the drafts endpoint for this form (see DraftHandler).
+	// If present, the client will save the partial data periodically
+	// and load it again when the form is opened, with the "draft"
+	// directive of DirectiveJS.
+	DraftUrl string
+
+	// Seconds between each draft save. 30 by default.
+	DraftInterval int
+
+	// Roles of the current user, checked against the Access
+	// rules of the fields
+	Roles []string
+
+	// Maximum number of submissions per minute of each user (or IP)
+	// and the message shown when it's exceeded
+	Throttle        int
+	ThrottleMessage string
+
+	// Submissions with the same values of the same user inside the
+	// window are rejected with the message (double clicks, etc.). The
+	// handler records them calling Submitted after processing them.
+	DuplicateWindow  time.Duration
+	DuplicateMessage string
+
+	// URL where the form is posted as a regular POST when Angular fails
+	// to load or JS is disabled. The fields are rendered with the posted
+	// values and the errors of the server validation. It needs the
+	// "ngformsFallback" directive of DirectiveJS, that stops the regular
+	// submit when Angular is loaded.
+	Action string
+
+	// XSRF token of the fallback POST, sent in a hidden field.
+	// Example: XsrfToken: r.XsrfToken(),
+	XsrfToken string
+
+	// Translates the labels and validation messages, that are used as
+	// keys of the translations. Nil to use them as they are.
+	// Example: Translator: i18n.Translator(r),
+	Translator func(key string) string
+
+	// Zone of the user, where the dates & times Angular sends as JSON
+	// instants are read. The date fields without their own Location
+	// are bound to it too. UTC by default.
+	// Example: Location, _ = time.LoadLocation(user.TimeZone)
+	Location *time.Location
 }
 
 type Form interface {
@@ -82,16 +135,27 @@ func (f *BaseForm) Validations() ValidationMap {
 
 // Build the form returning the generated HTML
 func Build(f Form) string {
+	d := getFormData(f)
 	results := []string{}
 	for _, field := range f.Fields() {
+		if a := getAccess(field); a != nil && !a.visible(d.Roles) {
+			continue
+		}
 		results = append(results, field.Build(f))
 	}
 
-	d := getFormData(f)
-	return fmt.Sprintf(`
-      <form class="form-horizontal" name="%s" novalidate ng-init="%s.val = false;"
-        ng-submit="%s.$valid && %s()"><fieldset>%s</fieldset></form>
-    `, d.Name, d.Name, d.Name, d.Submit, strings.Join(results, ""))
+	return render("form", map[string]interface{}{
+		"Name":          d.Name,
+		"Submit":        d.Submit,
+		"ObjName":       d.ObjName,
+		"DraftUrl":      d.DraftUrl,
+		"DraftInterval": d.DraftInterval,
+		"Action":        d.Action,
+		"XsrfField":     app.XsrfField,
+		"XsrfToken":     d.XsrfToken,
+		"FormError":     FormError(f),
+		"Fields":        template.HTML(strings.Join(results, "")),
+	})
 }
 
 func getFormData(f Form) *FormData {
@@ -111,12 +175,20 @@ func getFormData(f Form) *FormData {
 	if d.ObjName == "" {
 		d.ObjName = "data"
 	}
+	if d.DraftInterval == 0 {
+		d.DraftInterval = 30
+	}
+	if d.Location == nil {
+		d.Location = time.UTC
+	}
 	return d
 }
 
 // Validate the form.
 // Returns a boolean indicating if the data was valid according
 // to the validations defined on f. It returns an error too.
+// The JSON bodies of Angular and the regular POSTs of the fallback
+// Action are accepted.
 func Validate(r *http.Request, f Form) (bool, error) {
 	// Copy the body to a buffer so we can use it twice
 	buf := new(bytes.Buffer)
@@ -126,31 +198,177 @@ func Validate(r *http.Request, f Form) (bool, error) {
 	nbuf := ioutil.NopCloser(bytes.NewBuffer(buf.Bytes()))
 	r.Body = nbuf
 
+	d := getFormData(f)
+	if !checkThrottle(r, d) {
+		f.SetValue(formErrorKey, d.translate(d.ThrottleMessage))
+		return false, nil
+	}
+
+	fields := f.Fields()
+	body := buf.Bytes()
 	m := make(map[string]interface{})
-	if err := json.NewDecoder(buf).Decode(&m); err != nil {
+	normalized := false
+	if IsFallbackPost(r) {
+		var err error
+		if m, err = formValues(r, d, f, fields); err != nil {
+			return false, err
+		}
+		body = []byte(r.PostForm.Encode())
+		normalized = true
+	} else if err := json.NewDecoder(buf).Decode(&m); err != nil {
 		return false, fmt.Errorf("decode body json failed: %s", err)
 	}
 
-	fields := f.Fields()
+	if !checkHoneypots(r, d, fields, m) {
+		return false, nil
+	}
+
 	validations := f.Validations()
+	roles := d.Roles
+	valid := true
 	for _, field := range fields {
 		id := getId(field)
 		if id == "" {
 			continue
 		}
 
-		// Skip fields without validation constrainst
-		if _, ok := validations[id]; !ok {
+		// Ignore the values the user can't change
+		if a := getAccess(field); a != nil && !a.editable(roles) {
+			delete(m, id)
+			delete(m, "str"+id)
+			normalized = true
 			continue
 		}
 
+		// Convert the masked values to their canonical form
+		if mask := getMask(field); mask != nil {
+			if value, ok := extractValue(id, m); ok {
+				m[id] = mask.Normalize(value)
+				normalized = true
+			}
+		}
+
+		// Reject the values that are not among the options of the field
+		values := extractValues(id, m)
+		if options, ok := fieldOptions(field); ok && !allowedValues(values, options) {
+			f.SetValue(fieldErrorKey(id), optionError)
+			valid = false
+			continue
+		}
+		if _, ok := field.(*AddressField); ok && !validCountry(id, m) {
+			f.SetValue(fieldErrorKey(id), optionError)
+			valid = false
+			continue
+		}
+		if ks, ok := field.(*KeySelectField); ok {
+			if exist, err := ks.exist(values); err != nil {
+				return false, err
+			} else if !exist {
+				f.SetValue(fieldErrorKey(id), optionError)
+				valid = false
+				continue
+			}
+		}
+
 		value := normalizeValue(id, m)
+		if _, ok := field.(*AddressField); ok {
+			value = addressValue(id, m)
+		}
+		if _, ok := field.(*TimeRangeField); ok {
+			value = timeRangeValue(id, m)
+		}
+		if _, ok := field.(*DateField); ok {
+			value = dateTimeValue(id, dateLayout, m, d.Location)
+		}
+		if _, ok := field.(*TimeField); ok {
+			value = dateTimeValue(id, timeLayout, m, d.Location)
+		}
+		if num, ok := field.(*NumberField); ok {
+			value = num.value(id, m)
+		}
+		f.SetValue(id, value)
+
+		// Check all the fields to show their errors in the fallback
+		// page, not only the first one. The fields without validation
+		// constraints are bound anyway.
+		failed := false
+		_, multiple := m[id].([]interface{})
+		if _, ok := m[id].(map[string]interface{}); ok {
+			multiple = true
+		}
 		for _, val := range validations[id] {
-			if !val.Func(value) {
-				return false, nil
+			ok := false
+			if multiple && val.ValuesFunc != nil {
+				ok = val.ValuesFunc(values)
+			} else {
+				ok = val.Func(value)
+			}
+			if !ok {
+				f.SetValue(fieldErrorKey(id), val.Error)
+				failed = true
+				break
 			}
 		}
-		f.SetValue(id, value)
+		if failed {
+			valid = false
+			continue
+		}
+
+		// Bind the money fields to their minor units
+		if money, ok := field.(*MoneyField); ok {
+			v, err := money.normalize(value)
+			if err != nil {
+				f.SetValue(fieldErrorKey(id), moneyError)
+				valid = false
+				continue
+			}
+			m[id] = v
+			normalized = true
+		}
+		if tr, ok := field.(*TimeRangeField); ok {
+			m[id] = tr.normalize(value)
+			normalized = true
+		}
+
+		// Bind the dates, times & numbers to their Go types
+		if value != "" {
+			switch nf := field.(type) {
+			case *DateField:
+				m[id] = nf.normalize(value, d.Location)
+				normalized = true
+			case *TimeField:
+				m[id] = nf.normalize(value)
+				normalized = true
+			case *NumberField:
+				m[id] = nf.normalize(value)
+				normalized = true
+			}
+		}
+	}
+
+	if !valid {
+		return false, nil
+	}
+
+	// The responses can be verified only once, after the rest of the checks
+	if ok, err := checkRecaptchas(r, f, fields, m); err != nil {
+		return false, err
+	} else if !ok {
+		return false, nil
+	}
+
+	if !checkDuplicate(r, f, d, body) {
+		f.SetValue(formErrorKey, d.translate(d.DuplicateMessage))
+		return false, nil
+	}
+
+	// Decode the normalized values if some of them changed
+	if normalized {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return false, fmt.Errorf("encode normalized json failed: %s", err)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(data))
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(f); err != nil {
@@ -160,6 +378,27 @@ func Validate(r *http.Request, f Form) (bool, error) {
 	return true, nil
 }
 
+// Receives the flash messages of the forms after a submit
+type Flasher interface {
+	AddFlash(kind, message string)
+}
+
+// Like Validate, but it pushes the success or error flash message
+// depending on the result of the validations.
+func ValidateFlash(r *http.Request, f Form, fl Flasher, success, failure string) (bool, error) {
+	ok, err := Validate(r, f)
+	if err != nil {
+		return false, err
+	}
+
+	if ok && success != "" {
+		fl.AddFlash("success", success)
+	} else if !ok && failure != "" {
+		fl.AddFlash("error", failure)
+	}
+	return ok, nil
+}
+
 // Extract the value or its str counterpart to validate it
 func normalizeValue(id string, m map[string]interface{}) string {
 	if v, ok := extractValue(id, m); ok {
@@ -172,14 +411,105 @@ func normalizeValue(id string, m map[string]interface{}) string {
 	return ""
 }
 
-// Extract a value from the body data, trimming it
+// Error key of the fields with a value not among their options
+const optionError = "option"
+
+// Error key of the money fields whose amount can't be parsed, the same
+// of the Money validator
+const moneyError = "money"
+
+// Returns the declared values of the selects, radio & checkbox groups
+func fieldOptions(field Field) ([]string, bool) {
+	switch f := field.(type) {
+	case *SelectField:
+		return f.Values, true
+	case *RadioGroupField:
+		return f.Values, true
+	case *CheckboxField:
+		return f.Values, len(f.Values) > 0
+	}
+	return nil, false
+}
+
+// Returns true if all the values are in the options
+func allowedValues(values, options []string) bool {
+	for _, v := range values {
+		if !validators.OneOf(v, options) {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract all the values from the body data without joining them: the
+// items of the arrays, the checked keys of the checkbox groups or
+// the single value of the rest.
+func extractValues(id string, m map[string]interface{}) []string {
+	values := []string{}
+	switch v := m[id].(type) {
+	case []interface{}:
+		for _, item := range v {
+			if str, ok := item.(string); ok && str != "" {
+				values = append(values, str)
+			}
+		}
+
+	case map[string]interface{}:
+		for k, checked := range v {
+			if b, ok := checked.(bool); ok && b {
+				values = append(values, k)
+			}
+		}
+		sort.Strings(values)
+
+	case string:
+		if str := strings.TrimSpace(v); str != "" {
+			values = append(values, str)
+		}
+	}
+	return values
+}
+
+// Extract a value from the body data, trimming it. Booleans, numbers,
+// arrays and checkbox groups are converted to their string counterparts;
+// multiple values are joined with commas.
 func extractValue(id string, m map[string]interface{}) (string, bool) {
 	value, ok := m[id]
-	if ok {
-		str, ok := value.(string)
-		if ok {
-			return strings.TrimSpace(str), true
+	if !ok {
+		return "", false
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v), true
+
+	case bool:
+		if v {
+			return "true", true
+		}
+		return "", true
+
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			if str, ok := item.(string); ok && str != "" {
+				values = append(values, str)
+			}
 		}
+		return strings.Join(values, ","), true
+
+	case map[string]interface{}:
+		values := []string{}
+		for k, checked := range v {
+			if b, ok := checked.(bool); ok && b {
+				values = append(values, k)
+			}
+		}
+		sort.Strings(values)
+		return strings.Join(values, ","), true
 	}
 
 	return "", false
@@ -196,5 +526,69 @@ func getId(f Field) string {
 		return ta.Id
 	}
 
+	sel, ok := f.(*SelectField)
+	if ok {
+		return sel.Id
+	}
+
+	cb, ok := f.(*CheckboxField)
+	if ok {
+		return cb.Id
+	}
+
+	radio, ok := f.(*RadioGroupField)
+	if ok {
+		return radio.Id
+	}
+
+	address, ok := f.(*AddressField)
+	if ok {
+		return address.Id
+	}
+
+	money, ok := f.(*MoneyField)
+	if ok {
+		return money.Id
+	}
+
+	tr, ok := f.(*TimeRangeField)
+	if ok {
+		return tr.Id
+	}
+
+	date, ok := f.(*DateField)
+	if ok {
+		return date.Id
+	}
+
+	tf, ok := f.(*TimeField)
+	if ok {
+		return tf.Id
+	}
+
+	num, ok := f.(*NumberField)
+	if ok {
+		return num.Id
+	}
+
+	ks, ok := f.(*KeySelectField)
+	if ok {
+		return ks.Id
+	}
+
+	file, ok := f.(*FileField)
+	if ok {
+		return file.Id
+	}
+
 	return ""
 }
+
+// Returns the translation of the key, or the key itself without
+// a Translator or if it's empty
+func (d *FormData) translate(key string) string {
+	if d.Translator == nil || key == "" {
+		return key
+	}
+	return d.Translator(key)
+}
@@ -0,0 +1,286 @@
+package ngforms
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ernestokarim/gaelib/v1/app"
+)
+
+func TestExtractValues(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected []string
+	}{
+		{"  a ", []string{"a"}},
+		{"", []string{}},
+		{[]interface{}{"a,b", "", "c"}, []string{"a,b", "c"}},
+		{map[string]interface{}{"y": true, "x,z": true, "n": false}, []string{"x,z", "y"}},
+		{true, []string{}},
+	}
+	for _, test := range tests {
+		got := extractValues("f", map[string]interface{}{"f": test.value})
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("value %#v: got %#v, expected %#v", test.value, got, test.expected)
+		}
+	}
+}
+
+func TestAllowedValues(t *testing.T) {
+	options := []string{"red", "green,blue"}
+	tests := []struct {
+		values   []string
+		expected bool
+	}{
+		{nil, true},
+		{[]string{"red"}, true},
+		{[]string{"green,blue", "red"}, true},
+		{[]string{"green"}, false},
+		{[]string{"red", "<script>"}, false},
+	}
+	for _, test := range tests {
+		if got := allowedValues(test.values, options); got != test.expected {
+			t.Errorf("values %v: got %v, expected %v", test.values, got, test.expected)
+		}
+	}
+}
+
+func TestMinSelected(t *testing.T) {
+	val := MinSelected(2, "select two")
+	tests := []struct {
+		values   []string
+		expected bool
+	}{
+		{nil, false},
+		{[]string{"a,b"}, false},
+		{[]string{"a,b", "c"}, true},
+	}
+	for _, test := range tests {
+		if got := val.ValuesFunc(test.values); got != test.expected {
+			t.Errorf("values %v: got %v, expected %v", test.values, got, test.expected)
+		}
+	}
+	if val.Func("a") {
+		t.Errorf("a single value passed a minimum of two")
+	}
+}
+
+type typedForm struct {
+	BaseForm
+
+	Price    *Money    `json:"price"`
+	Birthday time.Time `json:"birthday"`
+
+	validations ValidationMap
+	location    *time.Location
+}
+
+func (f *typedForm) Data() *FormData {
+	return &FormData{Location: f.location}
+}
+
+func (f *typedForm) Fields() FieldList {
+	return FieldList{
+		&MoneyField{Id: "price", Currency: "EUR"},
+		&DateField{Id: "birthday"},
+	}
+}
+
+func (f *typedForm) Validations() ValidationMap {
+	return f.validations
+}
+
+func TestValidateBindsUnvalidatedFields(t *testing.T) {
+	tests := []struct {
+		body        string
+		validations ValidationMap
+		valid       bool
+		amount      int64
+		priceError  string
+	}{
+		{`{"price": "12.50", "birthday": "2015-03-04"}`, ValidationMap{}, true, 1250, ""},
+		{`{"price": "12.50", "birthday": "2015-03-04"}`, ValidationMap{
+			"price": {Required("required")},
+		}, true, 1250, ""},
+		{`{"price": "12 euros", "birthday": "2015-03-04"}`, ValidationMap{}, false, 0, "money"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", "/", bytes.NewBufferString(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		f := &typedForm{validations: test.validations}
+		valid, err := Validate(req, f)
+		if err != nil {
+			t.Errorf("body %s: unexpected error: %s", test.body, err)
+			continue
+		}
+		if valid != test.valid {
+			t.Errorf("body %s: got valid %v, expected %v", test.body, valid, test.valid)
+			continue
+		}
+		if got := FieldError(f, "price"); got != test.priceError {
+			t.Errorf("body %s: got price error %q, expected %q", test.body, got, test.priceError)
+		}
+		if !valid {
+			continue
+		}
+		if f.Price == nil || f.Price.Amount != test.amount || f.Price.Currency != "EUR" {
+			t.Errorf("body %s: got price %+v, expected %d EUR", test.body, f.Price, test.amount)
+		}
+		if expected := time.Date(2015, 3, 4, 0, 0, 0, 0, time.UTC); !f.Birthday.Equal(expected) {
+			t.Errorf("body %s: got birthday %s, expected %s", test.body, f.Birthday, expected)
+		}
+	}
+}
+
+func TestValidateReadsDatesInTheUserLocation(t *testing.T) {
+	madrid, err := time.LoadLocation("Europe/Madrid")
+	if err != nil {
+		t.Skip("no zoneinfo database")
+	}
+	tests := []struct {
+		body     string
+		location *time.Location
+		expected time.Time
+	}{
+		{`{"price": "1", "birthday": "2015-03-03T23:00:00.000Z"}`, nil, time.Date(2015, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{`{"price": "1", "birthday": "2015-03-03T23:00:00.000Z"}`, madrid, time.Date(2015, 3, 4, 0, 0, 0, 0, madrid)},
+		{`{"price": "1", "birthday": "2015-03-04"}`, madrid, time.Date(2015, 3, 4, 0, 0, 0, 0, madrid)},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", "/", bytes.NewBufferString(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		f := &typedForm{validations: ValidationMap{}, location: test.location}
+		if valid, err := Validate(req, f); err != nil || !valid {
+			t.Errorf("body %s: got valid %v, error %v", test.body, valid, err)
+			continue
+		}
+		if !f.Birthday.Equal(test.expected) {
+			t.Errorf("body %s: got birthday %s, expected %s", test.body, f.Birthday, test.expected)
+		}
+	}
+}
+
+type fallbackForm struct {
+	BaseForm
+
+	Price    *Money    `json:"price"`
+	Birthday time.Time `json:"birthday"`
+	Quantity float64   `json:"quantity"`
+	Name     string    `json:"name"`
+}
+
+func (f *fallbackForm) Fields() FieldList {
+	return FieldList{
+		&MoneyField{Id: "price", Currency: "EUR", DecimalSeparator: ","},
+		&DateField{Id: "birthday"},
+		&NumberField{Id: "quantity"},
+		&InputField{Id: "name", Type: "text"},
+	}
+}
+
+func (f *fallbackForm) Validations() ValidationMap {
+	return ValidationMap{
+		"name": {Required("required")},
+	}
+}
+
+func TestValidateFallbackPost(t *testing.T) {
+	values := url.Values{
+		"fprice":      {"1.234,50"},
+		"fbirthday":   {"2015-03-04"},
+		"fquantity":   {"3"},
+		"fname":       {"Anna"},
+		app.XsrfField: {"token"},
+	}
+	req, err := http.NewRequest("POST", "/", bytes.NewBufferString(values.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	f := new(fallbackForm)
+	valid, err := Validate(req, f)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !valid {
+		t.Fatalf("fallback post not valid")
+	}
+	if f.Price == nil || f.Price.Amount != 123450 {
+		t.Errorf("got price %+v, expected 123450", f.Price)
+	}
+	if expected := time.Date(2015, 3, 4, 0, 0, 0, 0, time.UTC); !f.Birthday.Equal(expected) {
+		t.Errorf("got birthday %s, expected %s", f.Birthday, expected)
+	}
+	if f.Quantity != 3 {
+		t.Errorf("got quantity %v, expected 3", f.Quantity)
+	}
+	if f.Name != "Anna" {
+		t.Errorf("got name %q, expected Anna", f.Name)
+	}
+	if got := postedValue(f, "fprice"); got != "1.234,50" {
+		t.Errorf("got posted price %q, expected the raw value", got)
+	}
+}
+
+type addressForm struct {
+	BaseForm
+
+	Home *Address `json:"home"`
+}
+
+func (f *addressForm) Fields() FieldList {
+	return FieldList{
+		&AddressField{Id: "home"},
+	}
+}
+
+func (f *addressForm) Validations() ValidationMap {
+	return ValidationMap{}
+}
+
+func TestValidateAddressCountry(t *testing.T) {
+	tests := []struct {
+		country string
+		valid   bool
+	}{
+		{"ES", true},
+		{"", true},
+		{"XX", false},
+		{"es", false},
+	}
+	for _, test := range tests {
+		body := `{"home": {"street": "Gran Vía 1", "postalCode": "28013", "city": "Madrid", "country": "` +
+			test.country + `"}}`
+		req, err := http.NewRequest("POST", "/", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		f := new(addressForm)
+		valid, err := Validate(req, f)
+		if err != nil {
+			t.Errorf("country %q: unexpected error: %s", test.country, err)
+			continue
+		}
+		if valid != test.valid {
+			t.Errorf("country %q: got valid %v, expected %v", test.country, valid, test.valid)
+		}
+		if !valid && FieldError(f, "home") != optionError {
+			t.Errorf("country %q: got error %q, expected %q", test.country, FieldError(f, "home"), optionError)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package ngforms
+
+// Functions called while rendering a field. BeforeRender receives the
+// attributes of each tag of the field before generating its HTML, and
+// AfterRender the final HTML, returning the one that will be used.
+// Example:
+//    &ngforms.InputField{
+//      Id: "email",
+//      Hooks: ngforms.Hooks{
+//        BeforeRender: func(attrs map[string]string) {
+//          attrs["data-track"] = "email"
+//        },
+//      },
+//    }
+type Hooks struct {
+	BeforeRender func(attrs map[string]string)
+	AfterRender  func(html string) string
+}
+
+// Forms can implement this interface to receive the rendering
+// hooks of all their fields. They're called before the ones of
+// the field itself.
+type RenderHooks interface {
+	BeforeRender(id string, attrs map[string]string)
+	AfterRender(id, html string) string
+}
+
+func (h *Hooks) beforeRender(form Form, id string, attrs map[string]string) {
+	if a := findAccess(form, id); a != nil && !a.editable(getFormData(form).Roles) {
+		attrs["disabled"] = ""
+	}
+	fillPosted(form, attrs)
+
+	if fh, ok := form.(RenderHooks); ok {
+		fh.BeforeRender(id, attrs)
+	}
+	if h.BeforeRender != nil {
+		h.BeforeRender(attrs)
+	}
+}
+
+func (h *Hooks) afterRender(form Form, id, html string) string {
+	if fh, ok := form.(RenderHooks); ok {
+		html = fh.AfterRender(id, html)
+	}
+	if h.AfterRender != nil {
+		html = h.AfterRender(html)
+	}
+	return html
+}
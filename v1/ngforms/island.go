@@ -0,0 +1,383 @@
+package ngforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Description of a field in the JSON configuration of a form
+type fieldConfig struct {
+	Type        string            `json:"type"`
+	Id          string            `json:"id,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Help        string            `json:"help,omitempty"`
+	InputType   string            `json:"inputType,omitempty"`
+	PlaceHolder string            `json:"placeholder,omitempty"`
+	Class       string            `json:"class,omitempty"`
+	Rows        int               `json:"rows,omitempty"`
+	Labels      []string          `json:"labels,omitempty"`
+	Values      []string          `json:"values,omitempty"`
+	Multiple    bool              `json:"multiple,omitempty"`
+	Attrs       map[string]string `json:"attrs,omitempty"`
+	Validations []*validationConf `json:"validations,omitempty"`
+
+	// Fields without a simple description are sent prerendered
+	Html string `json:"html,omitempty"`
+
+	// Submit buttons
+	Label       string `json:"label,omitempty"`
+	CancelUrl   string `json:"cancelUrl,omitempty"`
+	CancelLabel string `json:"cancelLabel,omitempty"`
+}
+
+type validationConf struct {
+	Error   string            `json:"error"`
+	Message string            `json:"message"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+type formConfig struct {
+	Name      string         `json:"name"`
+	Submit    string         `json:"submit"`
+	TrySubmit string         `json:"trySubmit"`
+	ObjName   string         `json:"objName"`
+	Fields    []*fieldConfig `json:"fields"`
+}
+
+// Build the form as a minimal HTML shell plus a JSON configuration
+// island, rendered in the client by the "ngformsIsland" directive
+// (see DirectiveJS). Pages with many forms are much smaller this way,
+// and the client can render them again if needed.
+func BuildIsland(f Form) string {
+	d := getFormData(f)
+	conf := &formConfig{
+		Name:      d.Name,
+		Submit:    d.Submit,
+		TrySubmit: d.TrySubmit,
+		ObjName:   d.ObjName,
+	}
+
+	validations := f.Validations()
+	for _, field := range f.Fields() {
+		if a := getAccess(field); a != nil && !a.visible(d.Roles) {
+			continue
+		}
+
+		fc := describeField(f, field)
+		for _, val := range validations[fc.Id] {
+			fc.Validations = append(fc.Validations, &validationConf{
+				Error:   val.Error,
+				Message: val.Message,
+				Attrs:   val.Attrs,
+			})
+		}
+		conf.Fields = append(conf.Fields, fc)
+	}
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		panic(fmt.Sprintf("encode form config failed: %s", err))
+	}
+
+	// Avoid closing the script tag from the JSON contents
+	island := strings.Replace(string(data), "</", `<\/`, -1)
+	return render("island", map[string]interface{}{
+		"Name":   d.Name,
+		"Config": template.JS(island),
+	})
+}
+
+func describeField(form Form, field Field) *fieldConfig {
+	html := &fieldConfig{Type: "html", Id: getId(field)}
+	if a := getAccess(field); a != nil && !a.editable(getFormData(form).Roles) {
+		html.Html = field.Build(form)
+		return html
+	}
+
+	switch f := field.(type) {
+	case *InputField:
+		fc := &fieldConfig{Type: "input", Id: f.Id, Name: f.Name, Help: f.Help,
+			InputType: f.Type, PlaceHolder: f.PlaceHolder,
+			Class: strings.Join(f.Class, " "), Attrs: f.Attrs}
+		if f.Mask != nil {
+			fc.Attrs = map[string]string{"ui-mask": f.Mask.Pattern}
+			update(fc.Attrs, f.Attrs)
+		}
+		if f.Hooks.BeforeRender == nil && f.Hooks.AfterRender == nil {
+			return fc
+		}
+
+	case *TextAreaField:
+		if f.Hooks.BeforeRender == nil && f.Hooks.AfterRender == nil {
+			return &fieldConfig{Type: "textarea", Id: f.Id, Name: f.Name, Help: f.Help,
+				PlaceHolder: f.PlaceHolder, Class: strings.Join(f.Class, " "), Rows: f.Rows}
+		}
+
+	case *SelectField:
+		if f.Hooks.BeforeRender == nil && f.Hooks.AfterRender == nil {
+			return &fieldConfig{Type: "select", Id: f.Id, Name: f.Name, Help: f.Help,
+				Class: strings.Join(f.Class, " "), Labels: f.Labels, Values: f.Values,
+				Multiple: f.Multiple}
+		}
+
+	case *SubmitField:
+		return &fieldConfig{Type: "submit", Label: f.Label, CancelUrl: f.CancelUrl,
+			CancelLabel: f.CancelLabel}
+	}
+
+	// Hooks, composites and custom fields can't be described, send them
+	// already rendered
+	html.Html = field.Build(form)
+	return html
+}
+
+// Serves the script of the ngformsIsland directive. Include it after
+// Angular and add the "ngforms" module to the dependencies of the app.
+// Example: "GET::/scripts/ngforms.js": ngforms.DirectiveHandler,
+func DirectiveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	fmt.Fprint(w, DirectiveJS)
+}
+
+// Returns the directive script ready to be inlined in a template
+func DirectiveScript() template.HTML {
+	return template.HTML("<script>" + DirectiveJS + "</script>")
+}
+
+// Angular directives that render the forms built with BuildIsland, stop
+// the regular submit of the forms with a fallback Action, bind the
+// responses of the reCAPTCHA fields, upload the files of the FileFields,
+// save the drafts and move through the steps of the wizards
+const DirectiveJS = `
+angular.module('ngforms', []).directive('ngformsIsland', ['$compile', function($compile) {
+  function esc(s) {
+    return String(s || '').replace(/&/g, '&amp;').replace(/"/g, '&quot;')
+      .replace(/</g, '&lt;').replace(/>/g, '&gt;').replace(/{{/g, '{\u200b{');
+  }
+  function attrs(m) {
+    var out = '';
+    angular.forEach(m, function(v, k) { out += ' ' + k + '="' + esc(v) + '"'; });
+    return out;
+  }
+  function control(c, f, html) {
+    var fid = c.name + f.id, errs = [], msgs = '';
+    var a = {};
+    angular.forEach(f.validations, function(v) {
+      angular.extend(a, v.attrs);
+      errs.push(c.name + '.' + fid + '.$error.' + v.error);
+      msgs += '<span ng-show="' + c.name + '.' + fid + '.$error.' + v.error + '">' +
+        esc(v.message) + '</span>';
+    });
+    html = html.replace('%attrs%', attrs(a));
+    var cls = c.name + '.val && (' + (errs.join(' || ') || 'false') + ') && \'error\'';
+    var out = '<div class="control-group" ng-class="' + cls + '">';
+    if (f.name) {
+      out += '<label class="control-label" for="' + fid + '">' + esc(f.name) + '</label>';
+    }
+    out += '<div class="controls">' + html + '<p class="help-block error" ng-show="' +
+      c.name + '.val && ' + c.name + '.' + fid + '.$invalid">' + msgs + '</p></div></div>';
+    return out;
+  }
+  function field(c, f) {
+    var fid = c.name + f.id;
+    var base = {id: fid, name: fid, 'class': f['class'], 'ng-model': c.objName + '.' + f.id};
+    switch (f.type) {
+    case 'input':
+      return control(c, f, '<input' + attrs(angular.extend(base, {type: f.inputType,
+        placeholder: f.placeholder}, f.attrs)) + '%attrs%>');
+    case 'textarea':
+      return control(c, f, '<textarea' + attrs(angular.extend(base, {rows: f.rows,
+        placeholder: f.placeholder})) + '%attrs%></textarea>');
+    case 'select':
+      var opts = '';
+      angular.forEach(f.labels, function(l, i) {
+        opts += '<option value="' + esc(f.values[i]) + '">' + esc(l) + '</option>';
+      });
+      if (f.multiple) { base.multiple = ''; }
+      return control(c, f, '<select' + attrs(base) + '%attrs%>' + opts + '</select>');
+    case 'submit':
+      var cancel = f.cancelLabel && f.cancelUrl ? '&nbsp;&nbsp;&nbsp;<a href="' +
+        esc(/^\s*javascript:/i.test(f.cancelUrl) ? '#' : f.cancelUrl) + '" class="btn">' + esc(f.cancelLabel) + '</a>' : '';
+      return '<div class="form-actions"><button ng-click="' + c.trySubmit + '(); ' +
+        c.name + '.val = true;" class="btn btn-primary" ng-disabled="' + c.name +
+        '.val && !' + c.name + '.$valid">' + esc(f.label) + '</button>' + cancel + '</div>';
+    }
+    return f.html;
+  }
+  return {
+    restrict: 'A',
+    link: function(scope, elm, attr) {
+      var c = angular.fromJson(document.getElementById(attr.ngformsIsland).innerHTML);
+      var html = '';
+      angular.forEach(c.fields, function(f) { html += field(c, f); });
+      elm.html('<form class="form-horizontal" name="' + c.name + '" novalidate ng-init="' +
+        c.name + '.val = false;" ng-submit="' + c.name + '.$valid && ' + c.submit +
+        '()"><fieldset>' + html + '</fieldset></form>');
+      $compile(elm.contents())(scope);
+    }
+  };
+}]).directive('ngformsFallback', function() {
+  // The forms with an action are submitted by Angular when it's loaded
+  return {
+    restrict: 'A',
+    link: function(scope, elm) {
+      elm.on('submit', function(e) { e.preventDefault(); });
+    }
+  };
+}).directive('ngformsRecaptcha', ['$window', '$parse', function($window, $parse) {
+  // Binds the response of the reCAPTCHA widget to the model
+  return {
+    restrict: 'A',
+    link: function(scope, elm, attrs) {
+      var model = $parse(attrs.ngformsRecaptcha);
+      function set(value) {
+        scope.$apply(function() { model.assign(scope, value); });
+      }
+      $window[attrs.callback] = set;
+      $window[attrs.expiredCallback] = function() { set(''); };
+      scope.$on('$destroy', function() {
+        delete $window[attrs.callback];
+        delete $window[attrs.expiredCallback];
+      });
+    }
+  };
+}]).directive('ngformsWizard', ['$window', '$parse', '$http', function($window, $parse, $http) {
+  // Navigation of the steps of a FormWizard. The partial data, without the
+  // omitted fields, is kept in the session storage or the server session
+  // until the form is submitted.
+  return {
+    restrict: 'A',
+    link: function(scope, elm, attrs) {
+      var name = attrs.ngformsWizard, key = 'ngforms-wizard:' + name;
+      var model = $parse(attrs.wizardModel);
+      var omit = angular.fromJson(attrs.wizardOmit || '[]');
+      var url = attrs.wizardSession;
+      var storage = !url && $window.sessionStorage;
+      var wizard = scope[name + 'Wizard'] = {step: 0};
+
+      function restore(saved) {
+        if (saved && saved.data) {
+          wizard.step = saved.step || 0;
+          model.assign(scope, angular.extend(model(scope) || {}, saved.data));
+        }
+      }
+      function state() {
+        var data = angular.copy(model(scope) || {});
+        angular.forEach(omit, function(id) { delete data[id]; });
+        return {step: wizard.step, data: data};
+      }
+      function save() {
+        if (url) {
+          $http.post(url, state());
+        } else if (storage) {
+          storage.setItem(key, angular.toJson(state()));
+        }
+      }
+
+      if (url) {
+        // The server copy is only updated when the step changes
+        $http.get(url).success(restore);
+      } else if (storage) {
+        restore(angular.fromJson(storage.getItem(key) || 'null'));
+        scope.$watch(attrs.wizardModel, save, true);
+      }
+
+      wizard.next = function(valid) {
+        scope[name].val = true;
+        if (valid) {
+          wizard.step++;
+          scope[name].val = false;
+          save();
+        }
+      };
+      wizard.prev = function() {
+        wizard.step--;
+        scope[name].val = false;
+        save();
+      };
+
+      var form = elm[0];
+      while (form && form.nodeName != 'FORM') {
+        form = form.parentNode;
+      }
+      angular.element(form).on('submit', function() {
+        if (!scope[name].$valid) {
+          return;
+        }
+        if (url) {
+          $http['delete'](url);
+        } else if (storage) {
+          storage.removeItem(key);
+        }
+      });
+    }
+  };
+}]).directive('draft', ['$http', '$parse', '$timeout', function($http, $parse, $timeout) {
+  // Loads the draft of the form when it's opened and saves the model
+  // periodically if it changes. The draft is discarded after the submit.
+  return {
+    restrict: 'A',
+    link: function(scope, elm, attrs) {
+      var url = attrs.draft, model = $parse(attrs.draftModel);
+      var interval = (parseInt(attrs.draftInterval, 10) || 30) * 1000;
+      var saved, timer;
+
+      function save() {
+        var data = angular.toJson(model(scope) || {});
+        if (data != saved) {
+          saved = data;
+          $http.post(url, data);
+        }
+        timer = $timeout(save, interval);
+      }
+
+      $http.get(url).success(function(data) {
+        if (data && !angular.equals(data, {})) {
+          model.assign(scope, angular.extend(model(scope) || {}, data));
+        }
+        saved = angular.toJson(model(scope) || {});
+        timer = $timeout(save, interval);
+      });
+
+      elm.on('submit', function() {
+        if (scope[attrs.name] && scope[attrs.name].$valid) {
+          $timeout.cancel(timer);
+          $http['delete'](url);
+        }
+      });
+      scope.$on('$destroy', function() { $timeout.cancel(timer); });
+    }
+  };
+}]).directive('fileModel', ['$parse', '$http', function($parse, $http) {
+  // Uploads the selected file and stores the value of the response in the
+  // model; it's cleared while the upload is running or if it fails
+  return {
+    restrict: 'A',
+    link: function(scope, elm, attrs) {
+      var model = $parse(attrs.fileModel);
+      elm.on('change', function() {
+        var file = elm[0].files[0];
+        scope.$apply(function() { model.assign(scope, ''); });
+        if (!file) {
+          return;
+        }
+
+        var data = new FormData();
+        data.append('file', file);
+        $http.post(attrs.fileUpload, data, {
+          transformRequest: angular.identity,
+          headers: {'Content-Type': undefined}
+        }).success(function(result) {
+          if (elm[0].files[0] === file) {
+            model.assign(scope, result.value);
+          }
+        });
+      });
+    }
+  };
+}]);
+`
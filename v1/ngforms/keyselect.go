@@ -0,0 +1,182 @@
+package ngforms
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+// Time the options of the key selects are cached
+var KeySelectExpiration = 10 * time.Minute
+
+// Select whose values are the web-safe keys of the entities of a kind.
+// The submitted value can be decoded directly into a *datastore.Key
+// field of the form struct; Validate checks it's the key of an existing
+// entity of the kind. Call Prepare before building the form to handle
+// the errors loading the options.
+type KeySelectField struct {
+	Id, Name string
+	Help     string
+	Class    []string
+
+	// Context used to load the options
+	C appengine.Context
+
+	// Kind of the entities and property used as the label of
+	// each option
+	Kind, LabelProperty string
+
+	// Maximum number of options, 1000 by default
+	Limit int
+
+	Hooks
+	Access
+
+	opts *keyOptions
+}
+
+type keyOptions struct {
+	Labels, Values []string
+}
+
+func (f *KeySelectField) Build(form Form) string {
+	checkValidators(form, "keyselect", f.Id, "required", "key")
+
+	// Without Prepare the error can only be logged, the select is
+	// rendered without options
+	if err := f.load(); err != nil {
+		f.C.Errorf("[ngforms] %s", err)
+		f.opts = new(keyOptions)
+	}
+
+	sel := &SelectField{
+		Id:     f.Id,
+		Name:   f.Name,
+		Help:   f.Help,
+		Class:  f.Class,
+		Labels: f.opts.Labels,
+		Values: f.opts.Values,
+		Hooks:  f.Hooks,
+		Access: f.Access,
+	}
+	return sel.build(form)
+}
+
+// Loads the options from the cache or running the query, only once
+func (f *KeySelectField) load() error {
+	if f.opts != nil {
+		return nil
+	}
+
+	limit := f.Limit
+	if limit == 0 {
+		limit = 1000
+	}
+
+	opts := new(keyOptions)
+	cacheKey := fmt.Sprintf("ngforms-keys:%s:%s:%d", f.Kind, f.LabelProperty, limit)
+	st := store.New(f.C)
+	if item, err := st.Get(cacheKey); err == nil {
+		if err := gob.NewDecoder(bytes.NewReader(item.Value)).Decode(opts); err == nil {
+			f.opts = opts
+			return nil
+		}
+		opts = new(keyOptions)
+	}
+
+	q := datastore.NewQuery(f.Kind).Project(f.LabelProperty).Order(f.LabelProperty).Limit(limit)
+	var entities []datastore.PropertyList
+	keys, err := q.GetAll(f.C, &entities)
+	if err != nil {
+		return fmt.Errorf("query select keys failed: %s", err)
+	}
+
+	for i, key := range keys {
+		label := ""
+		for _, p := range entities[i] {
+			if p.Name == f.LabelProperty {
+				label = fmt.Sprintf("%v", p.Value)
+			}
+		}
+		opts.Labels = append(opts.Labels, label)
+		opts.Values = append(opts.Values, key.Encode())
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(opts); err != nil {
+		return fmt.Errorf("encode select keys failed: %s", err)
+	}
+	if err := st.Set(cacheKey, buf.Bytes(), KeySelectExpiration); err != nil {
+		f.C.Warningf("[ngforms] cache select keys failed: %s", err)
+	}
+
+	f.opts = opts
+	return nil
+}
+
+// Loads the data of the fields that need the datastore (the options of
+// the KeySelectFields), returning the errors. Call it before Build.
+func Prepare(f Form) error {
+	for _, field := range f.Fields() {
+		if ks, ok := field.(*KeySelectField); ok {
+			if err := ks.load(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if all the values are keys of existing entities
+func (f *KeySelectField) exist(values []string) (bool, error) {
+	for _, v := range values {
+		if ok, err := keyExists(f.C, f.Kind, v); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Returns true if the value is empty or the web-safe key of an existing
+// entity of the kind
+func keyExists(c appengine.Context, kind, v string) (bool, error) {
+	if v == "" {
+		return true, nil
+	}
+
+	key, err := datastore.DecodeKey(v)
+	if err != nil || key.Kind() != kind {
+		return false, nil
+	}
+
+	q := datastore.NewQuery(kind).Filter("__key__ =", key).KeysOnly()
+	n, err := q.Count(c)
+	if err != nil {
+		return false, fmt.Errorf("check key failed: %s", err)
+	}
+	return n == 1, nil
+}
+
+// The value should be the web-safe key of an existing entity of the
+// kind. The KeySelectFields check it without the validator.
+func KeyOfKind(c appengine.Context, kind, msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{},
+		Message: msg,
+		Error:   "key",
+		Func: func(v string) bool {
+			ok, err := keyExists(c, kind, v)
+			if err != nil {
+				c.Errorf("[ngforms] %s", err)
+				return false
+			}
+			return ok
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package ngforms
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Input mask of a field. The client shows the formatted value using
+// the "ui-mask" directive (AngularUI) and the server normalizes it
+// to the canonical value before validating and storing it.
+type Mask struct {
+	// Pattern of the ui-mask directive: 9 for digits, A for letters
+	// and * for both
+	Pattern string
+
+	// Converts the display value to the canonical one
+	Normalize func(string) string
+}
+
+var (
+	PhoneMask = &Mask{
+		Pattern:   "999 999 999",
+		Normalize: digits,
+	}
+
+	CreditCardMask = &Mask{
+		Pattern:   "9999 9999 9999 9999",
+		Normalize: digits,
+	}
+
+	// Shows a dd/mm/yyyy date and stores it as yyyy-mm-dd
+	DateMask = &Mask{
+		Pattern: "99/99/9999",
+		Normalize: func(v string) string {
+			d := digits(v)
+			if len(d) != 8 {
+				return v
+			}
+			return d[4:] + "-" + d[2:4] + "-" + d[:2]
+		},
+	}
+)
+
+// Removes all the non digit characters of the value
+func digits(v string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, v)
+}
+
+func getMask(f Field) *Mask {
+	input, ok := f.(*InputField)
+	if ok {
+		return input.Mask
+	}
+
+	return nil
+}
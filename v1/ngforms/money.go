@@ -0,0 +1,154 @@
+package ngforms
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Currencies without minor units; the rest use two decimals
+var zeroDecimalCurrencies = map[string]bool{
+	"JPY": true, "KRW": true, "CLP": true, "ISK": true, "VND": true,
+}
+
+// Value of a MoneyField. Use it in the form struct with the
+// same name of the field:
+//    Price *ngforms.Money `json:"price"`
+type Money struct {
+	// Amount in minor units (cents)
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Input of an amount of money, shown with the currency symbol.
+// The value is stored in minor units to avoid rounding errors.
+type MoneyField struct {
+	Id, Name    string
+	Help        string
+	Class       []string
+	PlaceHolder string
+
+	// ISO 4217 code and symbol of the currency
+	Currency, Symbol string
+
+	// Decimal separator of the locale, "." by default. The other one
+	// is accepted as the thousands separator.
+	DecimalSeparator string
+
+	Hooks
+	Access
+}
+
+func (f *MoneyField) Build(form Form) string {
+	checkValidators(form, "money", f.Id, "required", "money")
+
+	d := getFormData(form)
+	attrs := map[string]string{
+		"type":        "text",
+		"id":          fmt.Sprintf("%s%s", d.Name, f.Id),
+		"name":        fmt.Sprintf("%s%s", d.Name, f.Id),
+		"placeholder": f.PlaceHolder,
+		"class":       strings.Join(f.Class, " "),
+		"ng-model":    fmt.Sprintf("%s.%s", d.ObjName, f.Id),
+	}
+
+	controlAttrs, control := BuildControl(form, f.Id, f.Name, f.Help)
+	update(attrs, controlAttrs)
+
+	f.beforeRender(form, f.Id, attrs)
+	ctrl := render("prepend", map[string]interface{}{
+		"Symbol": f.Symbol,
+		"Input":  template.HTML(renderTag("input", attrs)),
+	})
+
+	return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
+}
+
+func (f *MoneyField) separator() string {
+	if f.DecimalSeparator == "" {
+		return "."
+	}
+	return f.DecimalSeparator
+}
+
+func (f *MoneyField) decimals() int {
+	if zeroDecimalCurrencies[strings.ToUpper(f.Currency)] {
+		return 0
+	}
+	return 2
+}
+
+// Validates the amount of a money field with its decimal separator.
+func MoneyFormat(f *MoneyField, msg string) *Validator {
+	pattern := moneyPattern(f.separator(), f.decimals())
+	re := regexp.MustCompile(pattern)
+
+	return &Validator{
+		Attrs:   map[string]string{"pattern": pattern},
+		Message: msg,
+		Error:   moneyError,
+		Func:    func(v string) bool { return v == "" || re.MatchString(v) },
+	}
+}
+
+func moneyPattern(sep string, decimals int) string {
+	thousands := ","
+	if sep == "," {
+		thousands = "."
+	}
+	p := fmt.Sprintf(`^-?(\d{1,3}(\%s\d{3})*|\d+)`, thousands)
+	if decimals > 0 {
+		p += fmt.Sprintf(`(\%s\d{1,%d})?`, sep, decimals)
+	}
+	return p + "$"
+}
+
+// Converts the amount typed by the user to minor units
+func ParseMoney(value, sep string, decimals int) (int64, error) {
+	thousands := ","
+	if sep == "," {
+		thousands = "."
+	}
+	value = strings.Replace(strings.TrimSpace(value), thousands, "", -1)
+
+	parts := strings.Split(value, sep)
+	if len(parts) > 2 || (len(parts) == 2 && len(parts[1]) > decimals) {
+		return 0, fmt.Errorf("bad money format: %s", value)
+	}
+
+	units, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse money units failed: %s", err)
+	}
+	for i := 0; i < decimals; i++ {
+		units *= 10
+	}
+
+	if len(parts) == 2 {
+		minor := parts[1] + strings.Repeat("0", decimals-len(parts[1]))
+		cents, err := strconv.ParseInt(minor, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse money decimals failed: %s", err)
+		}
+		if strings.HasPrefix(parts[0], "-") {
+			cents = -cents
+		}
+		units += cents
+	}
+
+	return units, nil
+}
+
+// Converts the money value of the body to the Money struct
+func (f *MoneyField) normalize(value string) (interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+	amount, err := ParseMoney(value, f.separator(), f.decimals())
+	if err != nil {
+		return nil, err
+	}
+	return &Money{Amount: amount, Currency: f.Currency}, nil
+}
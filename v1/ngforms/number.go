@@ -0,0 +1,125 @@
+package ngforms
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Numeric input. The value is bound to the numeric field of the form
+// struct with the same name:
+//    Quantity int `json:"quantity"`
+type NumberField struct {
+	Id, Name    string
+	Help        string
+	Class       []string
+	PlaceHolder string
+
+	// Decimal separator of the locale, "." by default, accepted when
+	// the client sends the number as a string.
+	DecimalSeparator string
+
+	Hooks
+	Access
+}
+
+func (f *NumberField) Build(form Form) string {
+	checkValidators(form, "number", f.Id, "required", "number", "min", "max", "step")
+
+	h := f.Hooks
+	before := h.BeforeRender
+	h.BeforeRender = func(attrs map[string]string) {
+		if f.PlaceHolder != "" {
+			attrs["placeholder"] = f.PlaceHolder
+		}
+		if before != nil {
+			before(attrs)
+		}
+	}
+	return buildTypedInput(form, f.Id, f.Name, f.Help, "number", f.Class, &h)
+}
+
+// Returns the value used by the validators, with "." as the decimal
+// separator and without the thousands one
+func (f *NumberField) value(id string, m map[string]interface{}) string {
+	value := normalizeValue(id, m)
+	if f.DecimalSeparator == "" || f.DecimalSeparator == "." {
+		return strings.Replace(value, ",", "", -1)
+	}
+	value = strings.Replace(value, ".", "", -1)
+	return strings.Replace(value, f.DecimalSeparator, ".", -1)
+}
+
+// Converts the value of the body to a JSON number
+func (f *NumberField) normalize(value string) interface{} {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	return n
+}
+
+// The value should be a number
+func Number(msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{},
+		Message: msg,
+		Error:   "number",
+		Func: func(v string) bool {
+			_, err := strconv.ParseFloat(v, 64)
+			return v == "" || err == nil
+		},
+	}
+}
+
+// The number should not be lower than min
+func MinNumber(min float64, msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{"min": formatNumber(min)},
+		Message: msg,
+		Error:   "min",
+		Func: func(v string) bool {
+			n, err := strconv.ParseFloat(v, 64)
+			return v == "" || (err == nil && n >= min)
+		},
+	}
+}
+
+// The number should not be greater than max
+func MaxNumber(max float64, msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{"max": formatNumber(max)},
+		Message: msg,
+		Error:   "max",
+		Func: func(v string) bool {
+			n, err := strconv.ParseFloat(v, 64)
+			return v == "" || (err == nil && n <= max)
+		},
+	}
+}
+
+// The number should be a multiple of step (1 for integers). It needs
+// a "step" directive in the client.
+func Step(step float64, msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{"step": formatNumber(step)},
+		Message: msg,
+		Error:   "step",
+		Func: func(v string) bool {
+			n, err := strconv.ParseFloat(v, 64)
+			if v == "" {
+				return true
+			}
+			if err != nil {
+				return false
+			}
+			q := n / step
+			return math.Abs(q-math.Floor(q+0.5)) < 1e-9
+		},
+	}
+}
+
+func formatNumber(n float64) string {
+	return fmt.Sprintf("%v", n)
+}
@@ -0,0 +1,179 @@
+package ngforms
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Markup of the fields. The templates escape the labels, help texts,
+// messages and attribute values, so the forms are safe even if some
+// of them come from user data.
+var templates = template.Must(template.New("ngforms").Funcs(template.FuncMap{
+	"attrs": attrs,
+	"text":  text,
+}).Parse(`
+{{define "input"}}<input{{attrs .}}>{{end}}
+
+{{define "textarea"}}<textarea{{attrs .Attrs}}>{{text .Value}}</textarea>{{end}}
+
+{{define "select"}}<select{{attrs .Attrs}}>{{range .Options}}<option value="{{.Value}}"{{if .Selected}} selected{{end}}>{{text .Label}}</option>{{end}}</select>{{end}}
+
+{{define "labeled"}}<label class="{{.Class}}"><input{{attrs .Attrs}}>{{text .Label}}</label>{{end}}
+
+{{define "prepend"}}<div class="input-prepend"><span class="add-on">{{text .Symbol}}</span>{{.Input}}</div>{{end}}
+
+{{define "ngform"}}<ng-form name="{{.Name}}">{{range $i, $part := .Parts}}{{if $i}}{{$.Sep}}{{end}}{{$part}}{{end}}</ng-form>{{end}}
+
+{{define "control"}}
+      <div class="control-group{{if .ServerError}} error{{end}}" ng-class="{{.FormName}}.val && ({{.Errs}}) && 'error'">
+        {{if .Label}}<label class="control-label" for="{{.Fid}}">{{text .Label}}</label>
+        <div class="controls">{{end}}{{.Control}}
+        <p class="help-block error" ng-show="{{.FormName}}.val && {{.FormName}}.{{.Fid}}.$invalid">
+          {{range .Messages}}<span ng-show="{{$.FormName}}.{{$.Fid}}.$error.{{.Error}}">{{text .Message}}</span>
+          {{end}}</p>{{if .ServerError}}
+        <p class="help-block error">{{text .ServerError}}</p>{{end}}{{if .Label}}</div>{{end}}
+      </div>
+{{end}}
+
+{{define "submit"}}
+		<div class="form-actions">
+			<button ng-click="{{.TrySubmit}}(); {{.Name}}.val = true;" class="btn btn-primary"
+				ng-disabled="{{.Name}}.val && !{{.Name}}.$valid">{{text .Label}}</button>
+			{{if .CancelUrl}}&nbsp;&nbsp;&nbsp;<a href="{{.CancelUrl}}" class="btn">{{text .CancelLabel}}</a>{{end}}
+		</div>
+{{end}}
+
+{{define "honeypot"}}<div style="position: absolute; left: -10000px;" aria-hidden="true"><input{{attrs .}}></div>{{end}}
+
+{{define "recaptcha"}}<div{{attrs .}}></div><script src="https://www.google.com/recaptcha/api.js" async defer></script>{{end}}
+
+{{define "wizard"}}<div ngforms-wizard="{{.Name}}" wizard-model="{{.ObjName}}" wizard-omit="{{.Omit}}"{{if .SessionUrl}} wizard-session="{{.SessionUrl}}"{{end}}>
+        <ul class="nav nav-pills">{{range .Steps}}
+          <li ng-class="{{$.Name}}Wizard.step == {{.Index}} && 'active'"><a>{{text .Name}}</a></li>{{end}}
+        </ul>{{range .Steps}}
+        <div ng-show="{{$.Name}}Wizard.step == {{.Index}}">{{.Fields}}{{if or .Index (not .Last)}}
+          <div class="form-actions">{{if .Index}}
+            <button type="button" class="btn" ng-click="{{$.Name}}Wizard.prev()">{{text $.PrevLabel}}</button>{{end}}{{if not .Last}}
+            <button type="button" class="btn btn-primary" ng-click="{{$.Name}}Wizard.next({{.Valid}})">{{text $.NextLabel}}</button>{{end}}
+          </div>{{end}}
+        </div>{{end}}
+      </div>{{end}}
+
+{{define "island"}}<div ngforms-island="{{.Name}}-config"></div><script type="application/json" id="{{.Name}}-config">{{.Config}}</script>{{end}}
+
+{{define "form"}}
+      <form class="form-horizontal" name="{{.Name}}" novalidate ng-init="{{.Name}}.val = false;"
+        ng-submit="{{.Name}}.$valid && {{.Submit}}()"{{if .DraftUrl}} draft="{{.DraftUrl}}" draft-model="{{.ObjName}}" draft-interval="{{.DraftInterval}}"{{end}}{{if .Action}} action="{{.Action}}" method="POST" ngforms-fallback{{end}}><fieldset>{{if .Action}}
+        <input type="hidden" name="{{.XsrfField}}" value="{{.XsrfToken}}">{{if .FormError}}
+        <div class="alert alert-error">{{text .FormError}}</div>{{end}}{{end}}{{.Fields}}</fieldset></form>
+{{end}}
+`))
+
+// Valid names of the attributes
+var attrNameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_:.-]*$`)
+
+// Returns the attributes of a tag sorted by name, with their values
+// escaped. Invalid names and event handlers are dropped, as well as
+// the javascript: URLs.
+func attrs(m map[string]string) template.HTMLAttr {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := ""
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if !attrNameRe.MatchString(name) || strings.HasPrefix(lower, "on") {
+			continue
+		}
+		value := m[name]
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), "javascript:") {
+			value = "#"
+		}
+		out += fmt.Sprintf(` %s="%s"`, name, template.HTMLEscapeString(unbind(value)))
+	}
+	return template.HTMLAttr(out)
+}
+
+// Placeholder of the control inside the wrapper returned by BuildControl
+const controlSentinel = "__ngforms_control__"
+
+// Escapes the text of a tag, breaking the Angular expressions
+func text(s string) template.HTML {
+	return template.HTML(template.HTMLEscapeString(unbind(s)))
+}
+
+// Inserts a zero width space between the braces, so Angular doesn't
+// evaluate the expressions of the user data when compiling the form
+func unbind(s string) string {
+	return strings.Replace(s, "{{", "{\u200b{", -1)
+}
+
+// Executes the template, panicking if it fails like the rest of the
+// programming errors of the forms
+func render(name string, data interface{}) string {
+	buf := bytes.NewBuffer(nil)
+	if err := templates.ExecuteTemplate(buf, name, data); err != nil {
+		panic(fmt.Sprintf("render %s failed: %s", name, err))
+	}
+	return buf.String()
+}
+
+// Renders an input with the attributes
+func renderTag(tag string, attrs map[string]string) string {
+	return render(tag, attrs)
+}
+
+// Renders a textarea with the attributes and its content
+func renderTextArea(attrs map[string]string, value string) string {
+	return render("textarea", map[string]interface{}{"Attrs": attrs, "Value": value})
+}
+
+type option struct {
+	Value, Label string
+	Selected     bool
+}
+
+// Renders a select with the options, marking the selected values; labels
+// and values should have the same length
+func renderSelect(attrs map[string]string, labels, values, selected []string) string {
+	opts := make([]option, len(labels))
+	for i, label := range labels {
+		opts[i] = option{Value: values[i], Label: label}
+		for _, s := range selected {
+			if s == values[i] {
+				opts[i].Selected = true
+			}
+		}
+	}
+	return render("select", map[string]interface{}{"Attrs": attrs, "Options": opts})
+}
+
+// Renders a checkbox or radio input inside its label
+func renderLabeled(class string, attrs map[string]string, label string) string {
+	return render("labeled", map[string]interface{}{
+		"Class": class,
+		"Attrs": attrs,
+		"Label": label,
+	})
+}
+
+// Renders the parts of a composite field inside a nested form with the
+// name, separated by sep (already escaped HTML)
+func renderNgForm(name, sep string, parts ...string) string {
+	html := make([]template.HTML, len(parts))
+	for i, p := range parts {
+		html[i] = template.HTML(p)
+	}
+	return render("ngform", map[string]interface{}{
+		"Name":  name,
+		"Sep":   template.HTML(sep),
+		"Parts": html,
+	})
+}
@@ -0,0 +1,96 @@
+package ngforms
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+const (
+	// Key of the form values with the error of the submission
+	formErrorKey = "$form"
+
+	// Key of the form values with the store key of the submission
+	duplicateKey = "$duplicate"
+)
+
+// Returns the error message of the whole form after a failed Validate
+// (too many submissions, duplicated one, etc.), or an empty string.
+func FormError(f Form) string {
+	return f.Value(formErrorKey)
+}
+
+// Identifies the author of the submission: the user or its IP
+func submitter(c appengine.Context, r *http.Request) string {
+	if u := user.Current(c); u != nil {
+		return u.ID
+	}
+	return r.RemoteAddr
+}
+
+// Returns false if the submitter has sent the form too many
+// times in the last minute. Store errors let the form pass.
+func checkThrottle(r *http.Request, d *FormData) bool {
+	if d.Throttle == 0 {
+		return true
+	}
+
+	c := appengine.NewContext(r)
+	minute := time.Now().Unix() / 60
+	key := fmt.Sprintf("ngforms-throttle:%s:%s:%d", d.Name, submitter(c, r), minute)
+	n, err := store.New(c).Increment(key, 1, 0)
+	if err != nil {
+		c.Warningf("[ngforms] increment throttle counter failed: %s", err)
+		return true
+	}
+
+	return n <= uint64(d.Throttle)
+}
+
+// Returns false if the same submitter sent exactly the same values
+// inside the duplicates window. The submission is recorded later by
+// Submitted, so a failed one can be retried. Store errors let the
+// form pass.
+func checkDuplicate(r *http.Request, f Form, d *FormData, body []byte) bool {
+	if d.DuplicateWindow == 0 {
+		return true
+	}
+
+	c := appengine.NewContext(r)
+	hash := sha1.Sum(append([]byte(d.Name+":"+submitter(c, r)+":"), body...))
+	key := fmt.Sprintf("ngforms-dup:%x", hash)
+	f.SetValue(duplicateKey, key)
+
+	if _, err := store.New(c).Get(key); err == nil {
+		return false
+	} else if err != store.ErrNotFound {
+		c.Warningf("[ngforms] get duplicate check failed: %s", err)
+	}
+	return true
+}
+
+// Records the submission of the form after it has been processed
+// successfully, rejecting the same values inside its DuplicateWindow.
+// Example:
+//    if err := ngforms.Submitted(r.Req, f); err != nil {
+//      return err
+//    }
+func Submitted(r *http.Request, f Form) error {
+	d := getFormData(f)
+	key := f.Value(duplicateKey)
+	if d.DuplicateWindow == 0 || key == "" {
+		return nil
+	}
+
+	st := store.New(appengine.NewContext(r))
+	if err := st.Set(key, []byte{1}, d.DuplicateWindow); err != nil {
+		return fmt.Errorf("set duplicate check failed: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package ngforms
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Layout of the datetime-local inputs
+const timeRangeLayout = "2006-01-02T15:04"
+
+// Value of a TimeRangeField. Use it in the form struct with the
+// same name of the field:
+//    Meeting *ngforms.TimeRange `json:"meeting"`
+type TimeRange struct {
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Pair of start & end pickers rendered together. The validators receive
+// both values separated by a comma; use TimeRangeOrder and MaxDuration
+// to check the range.
+type TimeRangeField struct {
+	Id, Name string
+	Help     string
+	Class    []string
+
+	// Labels of the pickers, used as placeholders
+	StartLabel, EndLabel string
+
+	Hooks
+	Access
+}
+
+func (f *TimeRangeField) Build(form Form) string {
+	checkValidators(form, "timerange", f.Id, "required", "timerange", "maxduration")
+
+	d := getFormData(form)
+	fid := fmt.Sprintf("%s%s", d.Name, f.Id)
+	controlAttrs, control := BuildControl(form, f.Id, f.Name, f.Help)
+
+	input := func(part, label string) string {
+		attrs := map[string]string{
+			"type":        "datetime-local",
+			"id":          fid + part,
+			"name":        fid + part,
+			"placeholder": label,
+			"class":       strings.Join(f.Class, " "),
+			"ng-model":    fmt.Sprintf("%s.%s.%s", d.ObjName, f.Id, part),
+		}
+		update(attrs, controlAttrs)
+
+		f.beforeRender(form, f.Id, attrs)
+		return renderTag("input", attrs)
+	}
+
+	// The nested form groups the errors of both pickers
+	ctrl := renderNgForm(fid, " &ndash; ",
+		input("start", d.translate(f.StartLabel)), input("end", d.translate(f.EndLabel)))
+
+	return f.afterRender(form, f.Id, fmt.Sprintf(control, ctrl))
+}
+
+// End should follow the start. It needs a "timeRange" directive
+// in the client.
+func TimeRangeOrder(msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{"time-range": ""},
+		Message: msg,
+		Error:   "timerange",
+		Func: func(v string) bool {
+			start, end, ok := parseTimeRange(v)
+			return v == "" || (ok && end.After(start))
+		},
+	}
+}
+
+// The range should not be longer than d. It needs a "maxDuration"
+// directive in the client.
+func MaxDuration(d time.Duration, msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{"max-duration": fmt.Sprintf("%d", int64(d.Seconds()))},
+		Message: msg,
+		Error:   "maxduration",
+		Func: func(v string) bool {
+			start, end, ok := parseTimeRange(v)
+			return v == "" || (ok && end.Sub(start) <= d)
+		},
+	}
+}
+
+// Returns the value used by the validators of a range: empty if some
+// of the pickers is missing.
+func timeRangeValue(id string, m map[string]interface{}) string {
+	parts, ok := m[id].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	start, _ := extractValue("start", parts)
+	end, _ := extractValue("end", parts)
+	if start == "" || end == "" {
+		return ""
+	}
+	return start + "," + end
+}
+
+func parseTimeRange(v string) (time.Time, time.Time, bool) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	start, err := parseTimeRangeValue(parts[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	end, err := parseTimeRangeValue(parts[1])
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return start, end, true
+}
+
+// Browsers send the datetime-local values with or without seconds
+func parseTimeRangeValue(v string) (time.Time, error) {
+	if t, err := time.Parse(timeRangeLayout, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(timeRangeLayout+":05", v)
+}
+
+// Converts the value of the body to the TimeRange struct
+func (f *TimeRangeField) normalize(value string) interface{} {
+	start, end, ok := parseTimeRange(value)
+	if !ok {
+		return nil
+	}
+	return &TimeRange{Start: start, End: end, Duration: end.Sub(start)}
+}
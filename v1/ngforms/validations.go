@@ -3,17 +3,27 @@ package ngforms
 import (
 	"fmt"
 	"regexp"
+	"sync"
+
+	"github.com/ernestokarim/gaelib/v2/validators"
 )
 
 // A validator func it's one that receive a value as a param
 // and returns true if the input it's correct.
 type ValidatorFunc func(string) bool
 
+// Receives all the values of a multiple select or checkbox group
+type ValuesFunc func([]string) bool
+
 type Validator struct {
 	Attrs   map[string]string
 	Message string
 	Error   string
 	Func    ValidatorFunc
+
+	// Replaces Func in the fields with several values, that can
+	// contain commas themselves
+	ValuesFunc ValuesFunc
 }
 
 func Required(msg string) *Validator {
@@ -21,7 +31,7 @@ func Required(msg string) *Validator {
 		Attrs:   map[string]string{"required": ""},
 		Message: msg,
 		Error:   "required",
-		Func:    func(v string) bool { return v != "" },
+		Func:    validators.Required,
 	}
 }
 
@@ -30,7 +40,7 @@ func LargerThan(value int, msg string) *Validator {
 		Attrs:   map[string]string{"ng-minlength": fmt.Sprintf("%d", value)},
 		Message: msg,
 		Error:   "minlength",
-		Func:    func(v string) bool { return len(v) >= value },
+		Func:    func(v string) bool { return validators.MinBytes(v, value) },
 	}
 }
 
@@ -39,7 +49,7 @@ func ShorterThan(value int, msg string) *Validator {
 		Attrs:   map[string]string{"ng-maxlength": fmt.Sprintf("%d", value)},
 		Message: msg,
 		Error:   "maxlength",
-		Func:    func(v string) bool { return len(v) <= value },
+		Func:    func(v string) bool { return validators.MaxBytes(v, value) },
 	}
 }
 
@@ -75,6 +85,25 @@ func Match(f Form, field, msg string) *Validator {
 	}
 }
 
+// Minimum number of options selected in a multiple select or checkboxes
+// checked in a group. It needs a "minSelected" directive in the client.
+func MinSelected(value int, msg string) *Validator {
+	return &Validator{
+		Attrs:   map[string]string{"min-selected": fmt.Sprintf("%d", value)},
+		Message: msg,
+		Error:   "minselected",
+		Func: func(v string) bool {
+			if v == "" {
+				return value <= 0
+			}
+			return 1 >= value
+		},
+		ValuesFunc: func(v []string) bool {
+			return len(v) >= value
+		},
+	}
+}
+
 /*
 
 func Select(f *Form, field, msg string) *Validator {
@@ -99,3 +128,73 @@ func Select(f *Form, field, msg string) *Validator {
 	}
 }
 */
+
+// ==================================================================
+
+// Builds the directive attributes of a custom validator from the
+// argument passed to Custom.
+type AttrBuilder func(arg string) map[string]string
+
+var (
+	customMutex sync.RWMutex
+
+	// Attributes builders of the custom validators by name
+	customBuilders = map[string]AttrBuilder{}
+
+	// Custom validators allowed by input type
+	customTypes = map[string]map[string]bool{}
+)
+
+// Registers a custom validator, accepted in the fields of inputType
+// ("*" for all of them). The name is the error key of the directive
+// in the client. Call it at init().
+// Example:
+//    ngforms.RegisterValidator("*", "uniqueemail", func(arg string) map[string]string {
+//      return map[string]string{"unique-email": arg}
+//    })
+func RegisterValidator(inputType, name string, attrs AttrBuilder) {
+	customMutex.Lock()
+	defer customMutex.Unlock()
+
+	customBuilders[name] = attrs
+
+	if customTypes[inputType] == nil {
+		customTypes[inputType] = map[string]bool{}
+	}
+	customTypes[inputType][name] = true
+}
+
+// Validator previously registered with RegisterValidator. The argument
+// is passed to its attributes builder and fn checks the value in the
+// server.
+// Example: ngforms.Custom("uniqueemail", "/accounts/check", "Email already used", isFree),
+func Custom(name, arg, msg string, fn ValidatorFunc) *Validator {
+	customMutex.RLock()
+	builder, ok := customBuilders[name]
+	customMutex.RUnlock()
+	if !ok {
+		panic("validator not registered: " + name)
+	}
+
+	attrs := map[string]string{}
+	if builder != nil {
+		update(attrs, builder(arg))
+	}
+	if fn == nil {
+		fn = func(v string) bool { return true }
+	}
+
+	return &Validator{
+		Attrs:   attrs,
+		Message: msg,
+		Error:   name,
+		Func:    fn,
+	}
+}
+
+// Returns true if the custom validator can be used in the input type
+func customAllowed(inputType, name string) bool {
+	customMutex.RLock()
+	defer customMutex.RUnlock()
+	return customTypes[inputType][name] || customTypes["*"][name]
+}
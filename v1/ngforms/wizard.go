@@ -0,0 +1,201 @@
+package ngforms
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ernestokarim/gaelib/v1/app"
+	"github.com/gorilla/mux"
+)
+
+// Form split in steps shown one at a time. The client checks the fields
+// of each step before going to the next one and keeps the partial data
+// in the scope (and the session storage of the browser, or the server
+// session with SessionUrl, to survive the reloads); the full form is
+// submitted and validated only in the last step. The password inputs
+// and the Sensitive fields are never stored. Without JS all the steps
+// are shown, as a regular form.
+// It needs the "ngformsWizard" directive of DirectiveJS.
+// Example:
+//    w := &ngforms.FormWizard{
+//      Form: f,
+//      Steps: []*ngforms.WizardStep{
+//        {Name: "Account", Fields: []string{"email", "password"}},
+//        {Name: "Address", Fields: []string{"address"}},
+//      },
+//    }
+//    data["Form"] = template.HTML(ngforms.BuildWizard(w))
+type FormWizard struct {
+	Form  Form
+	Steps []*WizardStep
+
+	// Labels of the navigation buttons, translated with the
+	// Translator of the form
+	NextLabel, PrevLabel string
+
+	// IDs of the fields kept only in the scope, besides the passwords
+	Sensitive []string
+
+	// URL of a WizardHandler to keep the partial data in the server
+	// session instead of the browser
+	SessionUrl string
+}
+
+type WizardStep struct {
+	// Title of the step
+	Name string
+
+	// IDs of the fields of the step. The fields without ID (the submit
+	// buttons, for example) are rendered in the last step.
+	Fields []string
+}
+
+// Build the wizard returning the generated HTML. Validate the submitted
+// data with the Form of the wizard, like the rest of the forms.
+func BuildWizard(w *FormWizard) string {
+	if len(w.Steps) == 0 {
+		panic("wizard without steps")
+	}
+
+	f := w.Form
+	d := getFormData(f)
+
+	// Assign each field to its step
+	byId := map[string]int{}
+	for i, step := range w.Steps {
+		for _, id := range step.Fields {
+			byId[id] = i
+		}
+	}
+
+	omit := append([]string{}, w.Sensitive...)
+	last := len(w.Steps) - 1
+	fields := make([][]string, len(w.Steps))
+	checks := make([][]string, len(w.Steps))
+	for _, field := range f.Fields() {
+		if input, ok := field.(*InputField); ok && input.Type == "password" {
+			omit = append(omit, input.Id)
+		}
+
+		a := getAccess(field)
+		if a != nil && !a.visible(d.Roles) {
+			continue
+		}
+
+		id := getId(field)
+		i := last
+		if id != "" {
+			var ok bool
+			if i, ok = byId[id]; !ok {
+				panic("field without step in the wizard: " + id)
+			}
+
+			// The fields the user can't change don't block the steps
+			if a == nil || a.editable(d.Roles) {
+				checks[i] = append(checks[i], fmt.Sprintf("!%s.%s%s.$invalid", d.Name, d.Name, id))
+			}
+		}
+		fields[i] = append(fields[i], field.Build(f))
+	}
+
+	steps := []map[string]interface{}{}
+	for i, step := range w.Steps {
+		valid := "true"
+		if len(checks[i]) > 0 {
+			valid = strings.Join(checks[i], " && ")
+		}
+		steps = append(steps, map[string]interface{}{
+			"Index":  i,
+			"Name":   d.translate(step.Name),
+			"Last":   i == last,
+			"Valid":  valid,
+			"Fields": template.HTML(strings.Join(fields[i], "")),
+		})
+	}
+
+	next, prev := w.NextLabel, w.PrevLabel
+	if next == "" {
+		next = "Next"
+	}
+	if prev == "" {
+		prev = "Previous"
+	}
+	omitted, err := json.Marshal(omit)
+	if err != nil {
+		panic(err)
+	}
+	wizard := render("wizard", map[string]interface{}{
+		"Name":       d.Name,
+		"ObjName":    d.ObjName,
+		"Steps":      steps,
+		"NextLabel":  d.translate(next),
+		"PrevLabel":  d.translate(prev),
+		"Omit":       string(omitted),
+		"SessionUrl": w.SessionUrl,
+	})
+
+	return render("form", map[string]interface{}{
+		"Name":          d.Name,
+		"Submit":        d.Submit,
+		"ObjName":       d.ObjName,
+		"DraftUrl":      d.DraftUrl,
+		"DraftInterval": d.DraftInterval,
+		"Action":        d.Action,
+		"XsrfField":     app.XsrfField,
+		"XsrfToken":     d.XsrfToken,
+		"FormError":     FormError(f),
+		"Fields":        template.HTML(wizard),
+	})
+}
+
+// Max size of the partial data of a wizard kept in the session
+var WizardMaxSize int64 = 64 << 10
+
+// Handler for the FormWizard.SessionUrl endpoint, that keeps the partial
+// data in the session of the user. It should be registered with a form
+// variable in the path:
+//    "::/_/wizards/{form}": ngforms.WizardHandler,
+//
+// GET returns the stored data (an empty object if there's none), POST
+// saves the body and DELETE discards it.
+func WizardHandler(r *app.Request) error {
+	form := mux.Vars(r.Req)["form"]
+	if form == "" {
+		return app.NotFound()
+	}
+	key := "ngforms-wizard:" + form
+
+	switch r.Req.Method {
+	case "GET":
+		data, _ := r.Session.Values[key].(string)
+		if data == "" {
+			data = "{}"
+		}
+		r.W.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(r.W, ")]}',")
+		fmt.Fprint(r.W, data)
+
+	case "POST":
+		data, err := ioutil.ReadAll(io.LimitReader(r.Req.Body, WizardMaxSize+1))
+		if err != nil {
+			return fmt.Errorf("read wizard body failed: %s", err)
+		}
+		var state map[string]interface{}
+		if int64(len(data)) > WizardMaxSize || json.Unmarshal(data, &state) != nil {
+			return app.HttpError(400)
+		}
+		r.Session.Values[key] = string(data)
+
+	case "DELETE":
+		delete(r.Session.Values, key)
+
+	default:
+		return app.NotAllowed()
+	}
+
+	return nil
+}
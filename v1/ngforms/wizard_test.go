@@ -0,0 +1,68 @@
+package ngforms
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type wizardForm struct {
+	BaseForm
+}
+
+func (f *wizardForm) Fields() FieldList {
+	return FieldList{
+		&InputField{Id: "email", Type: "email"},
+		&InputField{Id: "password", Type: "password"},
+		&InputField{Id: "card", Type: "text"},
+	}
+}
+
+func (f *wizardForm) Validations() ValidationMap {
+	return ValidationMap{
+		"email":    {Required("required")},
+		"password": {Required("required")},
+		"card":     {},
+	}
+}
+
+var wizardOmitRe = regexp.MustCompile(`wizard-omit="([^"]*)"`)
+
+func TestBuildWizardOmitsSensitiveFields(t *testing.T) {
+	tests := []struct {
+		sensitive  []string
+		sessionUrl string
+		expected   string
+	}{
+		{nil, "", `["password"]`},
+		{[]string{"card"}, "", `["card","password"]`},
+		{[]string{"card"}, "/_/wizards/signup", `["card","password"]`},
+	}
+	for _, test := range tests {
+		w := &FormWizard{
+			Form: new(wizardForm),
+			Steps: []*WizardStep{
+				{Name: "Account", Fields: []string{"email", "password"}},
+				{Name: "Payment", Fields: []string{"card"}},
+			},
+			Sensitive:  test.sensitive,
+			SessionUrl: test.sessionUrl,
+		}
+		out := BuildWizard(w)
+
+		m := wizardOmitRe.FindStringSubmatch(out)
+		if m == nil {
+			t.Errorf("sensitive %v: omitted fields not rendered", test.sensitive)
+			continue
+		}
+		if got := html.UnescapeString(m[1]); got != test.expected {
+			t.Errorf("sensitive %v: got omitted %s, expected %s", test.sensitive, got, test.expected)
+		}
+
+		session := `wizard-session="` + test.sessionUrl + `"`
+		if got := strings.Contains(out, session); got != (test.sessionUrl != "") {
+			t.Errorf("session url %q: rendered %v", test.sessionUrl, got)
+		}
+	}
+}
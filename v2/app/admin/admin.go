@@ -0,0 +1,237 @@
+// Dashboard for the admins of the application with the recent errors,
+// the task queues, the cache, the registered routes and the mail
+// previews.
+package admin
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+
+	"appengine/datastore"
+	"appengine/memcache"
+	"appengine/taskqueue"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/tasks"
+	"github.com/ernestokarim/gaelib/v2/auth"
+	"github.com/ernestokarim/gaelib/v2/cache"
+	"github.com/ernestokarim/gaelib/v2/mail"
+)
+
+var (
+	// Queues whose pending tasks are shown in the dashboard
+	Queues = []string{"default", "mails", "admin-mails"}
+
+	// Rows of the errors & failed tasks tables
+	ListLimit = 50
+)
+
+type dashboard struct {
+	Prefix   string
+	Nonce    string
+	Script   template.HTML
+	Errors   []*errorRow
+	Queues   []*queueRow
+	Failures []*failureRow
+	Memcache *memcache.Statistics
+
+	// Problems loading parts of the dashboard
+	Warnings []string
+}
+
+type errorRow struct {
+	ID string
+	*app.ErrorReport
+}
+
+type queueRow struct {
+	Name string
+	taskqueue.QueueStatistics
+}
+
+type failureRow struct {
+	ID int64
+	*tasks.Failure
+}
+
+// ID of the error report or failed task of the actions
+type action struct {
+	ID string `json:"id" valid:"required"`
+}
+
+var dashboardTemplate = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Admin</title></head>
+<body>
+  <h1>Admin</h1>
+  {{range .Warnings}}<p><strong>Warning:</strong> {{.}}</p>{{end}}
+  <p id="admin-result"></p>
+
+  <h2>Recent errors</h2>
+  <table>
+    <tr><th>Last</th><th>Count</th><th>Pending</th><th>Message</th><th></th></tr>
+    {{range .Errors}}
+    <tr>
+      <td>{{.Last.Format "2006-01-02 15:04:05"}}</td>
+      <td>{{.Count}}</td>
+      <td>{{.Pending}}</td>
+      <td><pre>{{.Message}}</pre></td>
+      <td><button data-action="{{$.Prefix}}/errors/resend" data-id="{{.ID}}">Resend mail</button></td>
+    </tr>
+    {{end}}
+  </table>
+
+  <h2>Task queues</h2>
+  <table>
+    <tr><th>Queue</th><th>Pending</th><th>In flight</th><th>Executed last minute</th><th>Oldest ETA</th></tr>
+    {{range .Queues}}
+    <tr>
+      <td>{{.Name}}</td>
+      <td>{{.Tasks}}</td>
+      <td>{{.InFlight}}</td>
+      <td>{{.Executed1Minute}}</td>
+      <td>{{if not .OldestETA.IsZero}}{{.OldestETA.Format "2006-01-02 15:04:05"}}{{end}}</td>
+    </tr>
+    {{end}}
+  </table>
+
+  <h2>Failed tasks</h2>
+  <table>
+    <tr><th>Date</th><th>Task</th><th>Error</th><th></th></tr>
+    {{range .Failures}}
+    <tr>
+      <td>{{.Created.Format "2006-01-02 15:04:05"}}</td>
+      <td>{{.Name}}</td>
+      <td><pre>{{.Error}}</pre></td>
+      <td>
+        {{if .Transient}}
+        Retried by the queue ({{.Retries}} failures)
+        {{else}}
+        <button data-action="{{$.Prefix}}/tasks/retry" data-id="{{.ID}}">Retry</button>
+        {{end}}
+      </td>
+    </tr>
+    {{end}}
+  </table>
+
+  <h2>Cache</h2>
+  {{with .Memcache}}
+  <p>{{.Items}} items, {{.Bytes}} bytes; {{.Hits}} hits, {{.Misses}} misses; oldest item {{.Oldest}}s</p>
+  {{end}}
+  <button data-action="{{.Prefix}}/cache/flush" data-id="all">Flush the cache</button>
+
+  <p><a href="{{.Prefix}}/routes">Registered routes (JSON)</a></p>
+  <p><a href="{{.Prefix}}/mail-previews">Mail previews</a></p>
+
+  {{.Script}}
+  <script nonce="{{.Nonce}}">
+  Array.prototype.forEach.call(document.querySelectorAll('button[data-action]'), function(button) {
+    button.addEventListener('click', function() {
+      var data = {id: button.getAttribute('data-id')};
+      gaelib.post(button.getAttribute('data-action'), data, function(status, text) {
+        document.getElementById('admin-result').textContent = status == 200 ?
+          'Done' : 'Failed: ' + text;
+      });
+    });
+  });
+  </script>
+</body>
+</html>
+`))
+
+// Registers the dashboard in the prefix and the routes of its actions
+// under it. Only the admins of the application can use them.
+// Example: admin.Mount(app.NewMux(), "/admin")
+func Mount(m *app.Mux, prefix string) {
+	m.Get(prefix, auth.RequireAdmin(dashboardHandler(prefix)))
+	m.Post(prefix+"/errors/resend", auth.RequireAdmin(resendErrorHandler))
+	m.Post(prefix+"/tasks/retry", auth.RequireAdmin(retryTaskHandler))
+	m.Post(prefix+"/cache/flush", auth.RequireAdmin(flushCacheHandler))
+	m.Get(prefix+"/routes", auth.RequireAdmin(app.RoutesHandler))
+	m.Get(prefix+"/mail-previews", auth.RequireAdmin(mail.PreviewHandler))
+}
+
+func dashboardHandler(prefix string) app.Handler {
+	return func(r *app.Request) error {
+		data := &dashboard{Prefix: prefix, Nonce: r.CSPNonce(), Script: r.PostScript()}
+
+		reports := []*app.ErrorReport{}
+		q := datastore.NewQuery(app.KindErrorReport).Order("-Last").Limit(ListLimit)
+		keys, err := q.GetAll(r.C, &reports)
+		if err != nil {
+			return fmt.Errorf("query error reports failed: %s", err)
+		}
+		for i, key := range keys {
+			data.Errors = append(data.Errors, &errorRow{ID: key.StringID(), ErrorReport: reports[i]})
+		}
+
+		// A queue missing in queue.yaml fails the request of all of them,
+		// so they're loaded one by one
+		for _, name := range Queues {
+			stats, err := taskqueue.QueueStats(r.C, []string{name}, 0)
+			if err != nil {
+				r.C.Warningf("[admin] get queue %s stats failed: %s", name, err)
+				data.Warnings = append(data.Warnings, fmt.Sprintf("cannot load the queue %s: %s", name, err))
+				continue
+			}
+			data.Queues = append(data.Queues, &queueRow{Name: name, QueueStatistics: stats[0]})
+		}
+
+		fkeys, failures, err := tasks.Failures(r.C, ListLimit)
+		if err != nil {
+			return err
+		}
+		for i, key := range fkeys {
+			data.Failures = append(data.Failures, &failureRow{ID: key.IntID(), Failure: failures[i]})
+		}
+
+		// The stats are not essential, the page is shown without them
+		data.Memcache, err = memcache.Stats(r.C)
+		if err != nil {
+			r.C.Warningf("[admin] get memcache stats failed: %s", err)
+		}
+
+		r.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(r.W, data); err != nil {
+			return fmt.Errorf("exec admin template failed: %s", err)
+		}
+		return nil
+	}
+}
+
+func resendErrorHandler(r *app.Request) error {
+	a := new(action)
+	if err := r.LoadJsonData(a); err != nil {
+		return err
+	}
+	if err := app.ResendErrorReport(r.C, a.ID); err != nil {
+		return err
+	}
+	return r.EmitJson(map[string]bool{"ok": true})
+}
+
+func retryTaskHandler(r *app.Request) error {
+	a := new(action)
+	if err := r.LoadJsonData(a); err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(a.ID, 10, 64)
+	if err != nil {
+		return app.BadRequest("id", "the id should be a number")
+	}
+	if err := tasks.Retry(r.C, id); err != nil {
+		return err
+	}
+	return r.EmitJson(map[string]bool{"ok": true})
+}
+
+// Discards the cached values, keeping the sessions, rate limits and the
+// rest of the data of the store
+func flushCacheHandler(r *app.Request) error {
+	if err := cache.Flush(r.C); err != nil {
+		return err
+	}
+	r.C.Infof("[admin] cache flushed by %s", auth.Current(r).Email)
+	return r.EmitJson(map[string]bool{"ok": true})
+}
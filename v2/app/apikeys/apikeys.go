@@ -0,0 +1,251 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/cache"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+const KindAPIKey = "APIKey"
+
+// Prefix of the generated secrets, to recognize them in the logs & repos
+var SecretPrefix = "gk_"
+
+// Time the resolved keys are cached; a revoked key can be used during
+// this time in other instances
+var CacheTTL = time.Minute
+
+const requestValue = "apikeys.key"
+
+// Key stored with the hash of its secret as its ID. The secret itself is
+// only returned once by Generate.
+type Key struct {
+	ID      string `datastore:"-"`
+	Name    string
+	Owner   string
+	Hint    string `datastore:",noindex"`
+	Scopes  []string
+	Created time.Time
+	Expires time.Time
+	Revoked bool
+}
+
+// Returns true if the key has the scope
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *Key) valid() bool {
+	return !k.Revoked && (k.Expires.IsZero() || time.Now().Before(k.Expires))
+}
+
+func init() {
+	// The browsers can't send these headers from other sites without a
+	// CORS preflight, so a valid key doesn't need the XSRF token. The
+	// requests with the session cookie too are checked anyway.
+	app.ExemptXsrf(func(req *http.Request) bool {
+		secret := secretFromHeaders(req)
+		if secret == "" || app.HasSessionCookie(req) {
+			return false
+		}
+
+		c := platform.Current.NewContext(req)
+		key, err := Lookup(c, secret)
+		if err != nil {
+			c.Errorf("[apikeys] cannot check the key of the xsrf exemption: %s", err)
+			return false
+		}
+		return key != nil
+	})
+}
+
+// Creates a new key of the owner with the scopes, returning the secret
+// to give to the client. Use a zero expiration for keys that don't expire.
+// Example:
+//    secret, key, err := apikeys.Generate(c, "Billing sync", email, []string{"invoices"}, time.Time{})
+func Generate(c appengine.Context, name, owner string, scopes []string, expires time.Time) (string, *Key, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, fmt.Errorf("generate random secret failed: %s", err)
+	}
+	secret := SecretPrefix + base64.URLEncoding.EncodeToString(random)
+	secret = strings.TrimRight(secret, "=")
+
+	key := &Key{
+		ID:      hashSecret(secret),
+		Name:    name,
+		Owner:   owner,
+		Hint:    secret[:len(SecretPrefix)+4] + "...",
+		Scopes:  scopes,
+		Created: time.Now(),
+		Expires: expires,
+	}
+	if _, err := datastore.Put(c, datastore.NewKey(c, KindAPIKey, key.ID, 0, nil), key); err != nil {
+		return "", nil, fmt.Errorf("put key failed: %s", err)
+	}
+
+	return secret, key, nil
+}
+
+// Revokes the key with the ID. The key is kept to list it as revoked.
+func Revoke(c appengine.Context, id string) error {
+	dkey := datastore.NewKey(c, KindAPIKey, id, 0, nil)
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		key := new(Key)
+		if err := datastore.Get(c, dkey, key); err != nil {
+			return err
+		}
+		key.Revoked = true
+		_, err := datastore.Put(c, dkey, key)
+		return err
+	}, nil)
+	if err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return err
+		}
+		return fmt.Errorf("revoke key failed: %s", err)
+	}
+
+	if err := cache.Delete(c, cacheKey(id)); err != nil {
+		c.Warningf("[apikeys] delete cached key failed: %s", err)
+	}
+	return nil
+}
+
+// Returns the keys of the owner, the revoked ones too
+func List(c appengine.Context, owner string) ([]*Key, error) {
+	keys := []*Key{}
+	q := datastore.NewQuery(KindAPIKey).Filter("Owner =", owner)
+	dkeys, err := q.GetAll(c, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("query keys failed: %s", err)
+	}
+	for i, dkey := range dkeys {
+		keys[i].ID = dkey.StringID()
+	}
+
+	return keys, nil
+}
+
+// Returns the valid key of the secret, or nil if it doesn't exist, it's
+// revoked or it has expired
+func Lookup(c appengine.Context, secret string) (*Key, error) {
+	id := hashSecret(secret)
+
+	key := new(Key)
+	err := cache.Get(c, cacheKey(id), key)
+	if err == cache.ErrMiss {
+		if err := datastore.Get(c, datastore.NewKey(c, KindAPIKey, id, 0, nil), key); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("get key failed: %s", err)
+		}
+		key.ID = id
+		if err := cache.Set(c, cacheKey(id), key, CacheTTL); err != nil {
+			c.Warningf("[apikeys] cache key failed: %s", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("get cached key failed: %s", err)
+	}
+
+	if !key.valid() {
+		return nil, nil
+	}
+	return key, nil
+}
+
+// Decorates the handler to resolve the key of the request, if there's
+// one, so it can be checked with Current. Invalid keys receive a 401 error.
+// Example: "::/api/items": apikeys.Authenticate(api.Items),
+func Authenticate(h app.Handler) app.Handler {
+	return app.Wrap(h, func(r *app.Request) error {
+		secret := secretFromHeaders(r.Req)
+		if secret == "" {
+			return h(r)
+		}
+
+		key, err := Lookup(r.C, secret)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			r.C.Errorf("[apikeys] invalid key")
+			return app.Unauthorized()
+		}
+		r.SetValue(requestValue, key)
+
+		return h(r)
+	})
+}
+
+// Decorates the handler to allow only the requests with a valid key that
+// has all the scopes. Requests without a key receive a 401 error and the
+// ones without the scopes a 403 one.
+// Example: "POST::/api/invoices": apikeys.RequireScopes([]string{"invoices"}, api.Invoices),
+func RequireScopes(scopes []string, h app.Handler) app.Handler {
+	return Authenticate(app.Wrap(h, func(r *app.Request) error {
+		key := Current(r)
+		if key == nil {
+			return app.Unauthorized()
+		}
+		for _, scope := range scopes {
+			if !key.HasScope(scope) {
+				r.C.Errorf("[apikeys] key %s doesn't have the scope: %s", key.Hint, scope)
+				return app.Forbidden()
+			}
+		}
+		return h(r)
+	}))
+}
+
+// Returns the key resolved by Authenticate, or nil if the request
+// didn't have one
+func Current(r *app.Request) *Key {
+	key, _ := r.Value(requestValue).(*Key)
+	return key
+}
+
+// Returns true if the request has a valid key with the scope
+func HasScope(r *app.Request, scope string) bool {
+	key := Current(r)
+	return key != nil && key.HasScope(scope)
+}
+
+// Returns the secret of the Authorization: Bearer or X-Api-Key headers
+func secretFromHeaders(req *http.Request) string {
+	if secret := req.Header.Get("X-Api-Key"); secret != "" {
+		return secret
+	}
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func cacheKey(id string) string {
+	return "apikeys:" + id
+}
@@ -0,0 +1,260 @@
+// Helpers to test the handlers through the same path of the router. Run
+// them from the tests of the projects with goapp test.
+package apptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"appengine"
+	"appengine/aetest"
+	"appengine_internal"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/gorilla/mux"
+)
+
+// Result of running a handler
+type Response struct {
+	*httptest.ResponseRecorder
+
+	// Request passed to the handler, to check its session or templates
+	Request *app.Request
+
+	// RPCs sent to the datastore by the handler
+	DatastoreCalls int
+}
+
+// Context that counts the datastore RPCs, so the handlers are checked
+// against app.DatastoreBudget
+type countingContext struct {
+	appengine.Context
+
+	mutex *sync.Mutex
+	calls int
+}
+
+func (c *countingContext) Call(service, method string, in, out appengine_internal.ProtoMessage, opts *appengine_internal.CallOptions) error {
+	if service == "datastore_v3" {
+		c.mutex.Lock()
+		c.calls++
+		c.mutex.Unlock()
+	}
+	return c.Context.Call(service, method, in, out, opts)
+}
+
+func (c *countingContext) DatastoreCalls() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls
+}
+
+// Returns a new test context, failing the test if it can't be started.
+// Close it at the end of the test.
+// Example:
+//    c := apptest.NewContext(t)
+//    defer c.Close()
+func NewContext(t testing.TB) aetest.Context {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatalf("start test context failed: %s", err)
+	}
+	return c
+}
+
+// Builds a request with the body
+func NewRequest(method, path string, body io.Reader) *http.Request {
+	req, err := http.NewRequest(method, path, body)
+	if err != nil {
+		panic(err)
+	}
+	return req
+}
+
+// Builds a request with the data encoded as a form in the body (or in the
+// query string of the GET requests)
+func NewFormRequest(method, path string, data map[string]string) *http.Request {
+	values := url.Values{}
+	for k, v := range data {
+		values.Set(k, v)
+	}
+	encoded := values.Encode()
+
+	if method == "GET" {
+		if encoded != "" {
+			path += "?" + encoded
+		}
+		return NewRequest(method, path, nil)
+	}
+	req := NewRequest(method, path, strings.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// Builds a request with the data encoded as JSON in the body
+func NewJsonRequest(method, path string, data interface{}) *http.Request {
+	body, err := app.MarshalJson(data)
+	if err != nil {
+		panic(err)
+	}
+	req := NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	return req
+}
+
+// Runs the handler with the request like the router does, matching
+// the path against pattern to fill the route vars (an empty pattern
+// matches the path itself). The requests that aren't GET need WithXsrf
+// to pass the XSRF check.
+// Example:
+//    req := apptest.NewRequest("GET", "/items/3", nil)
+//    resp := apptest.Serve(c, "/items/{id}", items.Show, req)
+//    resp.AssertStatus(t, 200)
+func Serve(c appengine.Context, pattern string, h app.Handler, req *http.Request) *Response {
+	if pattern == "" {
+		pattern = req.URL.Path
+	}
+
+	resp := &Response{ResponseRecorder: httptest.NewRecorder()}
+	counter := &countingContext{Context: c, mutex: &sync.Mutex{}}
+	router := mux.NewRouter()
+	router.HandleFunc(pattern, func(w http.ResponseWriter, req *http.Request) {
+		resp.Request = app.NewRequest(counter, w, req)
+		resp.Request.Run(h)
+	})
+	router.ServeHTTP(resp.ResponseRecorder, req)
+
+	if resp.Request == nil {
+		panic(fmt.Sprintf("the path %s doesn't match the pattern %s", req.URL.Path, pattern))
+	}
+	resp.DatastoreCalls = counter.DatastoreCalls()
+	return resp
+}
+
+// Adds the session and XSRF cookies of a previous response to the
+// request, and the header with the token, like the scripts of the pages
+// do. It returns the request.
+// Example:
+//    req := apptest.WithXsrf(c, apptest.NewJsonRequest("POST", "/_/items", item))
+func WithXsrf(c appengine.Context, req *http.Request) *http.Request {
+	page := Serve(c, "", func(r *app.Request) error { return nil }, NewRequest("GET", "/", nil))
+	cookies := (&http.Response{Header: page.Header()}).Cookies()
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+		if cookie.Name == "XSRF-TOKEN" {
+			req.Header.Set("X-Xsrf-Token", cookie.Value)
+		}
+	}
+	return req
+}
+
+// Checks the status code of the response, and that the handler is
+// within its datastore budget if app.StrictDatastoreBudget is enabled
+func (resp *Response) AssertStatus(t testing.TB, code int) {
+	if resp.Code != code {
+		t.Errorf("status code %d, expected %d; body: %s", resp.Code, code, resp.Body.String())
+	}
+	if err := resp.Request.DatastoreBudgetError(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Checks that the handler didn't send more than max RPCs to the datastore.
+// Set app.StrictDatastoreBudget to fail in AssertStatus all the handlers
+// over app.DatastoreBudget instead.
+func (resp *Response) AssertDatastoreCalls(t testing.TB, max int) {
+	if resp.DatastoreCalls > max {
+		t.Errorf("%d datastore calls, expected %d at most", resp.DatastoreCalls, max)
+	}
+}
+
+// Checks that the JSON body (without the XSSI prefix) is equal to the
+// encoding of expected
+func (resp *Response) AssertJson(t testing.TB, expected interface{}) {
+	want, err := app.MarshalJson(expected)
+	if err != nil {
+		t.Fatalf("encode expected json failed: %s", err)
+	}
+
+	var got, wanted interface{}
+	if err := json.Unmarshal(resp.JsonBody(), &got); err != nil {
+		t.Errorf("decode json body failed: %s; body: %s", err, resp.Body.String())
+		return
+	}
+	if err := json.Unmarshal(want, &wanted); err != nil {
+		t.Fatalf("decode expected json failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("json body %s, expected %s", resp.JsonBody(), want)
+	}
+}
+
+// Decodes the JSON body into dest, failing the test if it's not valid
+func (resp *Response) DecodeJson(t testing.TB, dest interface{}) {
+	if err := app.UnmarshalJson(resp.JsonBody(), dest); err != nil {
+		t.Fatalf("decode json body failed: %s; body: %s", err, resp.Body.String())
+	}
+}
+
+// Returns the body without the XSSI protection prefix of EmitJson
+func (resp *Response) JsonBody() []byte {
+	return bytes.TrimPrefix(resp.Body.Bytes(), []byte(")]}',\n"))
+}
+
+// Checks that the response redirects to the location
+func (resp *Response) AssertRedirect(t testing.TB, location string) {
+	if resp.Code != http.StatusFound && resp.Code != http.StatusMovedPermanently {
+		t.Errorf("status code %d, expected a redirect to %s", resp.Code, location)
+		return
+	}
+	if got := resp.Header().Get("Location"); got != location {
+		t.Errorf("redirect to %s, expected %s", got, location)
+	}
+}
+
+// Checks that the template was rendered by the handler
+func (resp *Response) AssertTemplate(t testing.TB, name string) {
+	for _, rendered := range resp.Request.Templates() {
+		if rendered == name {
+			return
+		}
+	}
+	t.Errorf("template %s not rendered, the rendered ones are: %v", name, resp.Request.Templates())
+}
+
+// Replaces the error handlers of the codes (403, 404 and 500 if there's
+// none) with plain ones that don't need the templates of the project. It
+// returns a function that restores the originals.
+// Example: defer apptest.StubErrorHandlers()()
+func StubErrorHandlers(codes ...int) func() {
+	if len(codes) == 0 {
+		codes = []int{403, 404, 500}
+	}
+
+	originals := map[int]app.Handler{}
+	for _, code := range codes {
+		code := code
+		originals[code] = app.GetErrorHandler(code)
+		app.SetErrorHandler(code, func(r *app.Request) error {
+			r.W.WriteHeader(code)
+			fmt.Fprintf(r.W, "error %d", code)
+			return nil
+		})
+	}
+
+	return func() {
+		for code, h := range originals {
+			app.SetErrorHandler(code, h)
+		}
+	}
+}
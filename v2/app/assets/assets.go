@@ -0,0 +1,115 @@
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+var (
+	// Folder of the assets in the application files. They should not be
+	// in a static dir of app.yaml, Handler serves them.
+	Dir = "static"
+
+	// Path where Handler is registered
+	Prefix = "/static/"
+)
+
+// Length of the hash in the fingerprinted names
+const hashLength = 10
+
+var (
+	hashesMutex sync.Mutex
+	hashes      = map[string]string{}
+)
+
+func init() {
+	app.RegisterTemplateFuncs(template.FuncMap{
+		// Fingerprinted URL of the asset
+		// Example: <link rel="stylesheet" href="{{asset "css/app.css"}}">
+		"asset": URL,
+	})
+}
+
+// Returns the URL of the asset with the hash of its content in the
+// name, so it can be cached forever.
+// Example: assets.URL("css/app.css") -> "/static/css/app.3f2a1b9c0d.css"
+func URL(name string) (string, error) {
+	hash, err := hash(name)
+	if err != nil {
+		return "", err
+	}
+
+	ext := path.Ext(name)
+	return Prefix + strings.TrimSuffix(name, ext) + "." + hash + ext, nil
+}
+
+// Returns the hash of the asset. The files don't change in a deploy,
+// so it's computed only once outside the dev server.
+func hash(name string) (string, error) {
+	hashesMutex.Lock()
+	defer hashesMutex.Unlock()
+
+	if h, ok := hashes[name]; ok && !platform.Current.IsDevelopment() {
+		return h, nil
+	}
+
+	content, err := read(name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	hashes[name] = hex.EncodeToString(sum[:])[:hashLength]
+
+	return hashes[name], nil
+}
+
+func read(name string) ([]byte, error) {
+	name = path.Clean("/" + name)
+	content, err := ioutil.ReadFile(filepath.Join(Dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, fmt.Errorf("read asset failed: %s", err)
+	}
+	return content, nil
+}
+
+var fingerprint = regexp.MustCompile(fmt.Sprintf(`\.[0-9a-f]{%d}(\.[^./]+)$`, hashLength))
+
+// Serves the assets. The fingerprinted URLs are cached forever; the
+// ones with an old or without a hash are not cached.
+// Example: http.HandleFunc("/static/", assets.Handler)
+func Handler(w http.ResponseWriter, req *http.Request) {
+	requested := strings.TrimPrefix(req.URL.Path, Prefix)
+	name := fingerprint.ReplaceAllString(requested, "$1")
+
+	content, err := read(name)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	sum := sha256.Sum256(content)
+	current := hex.EncodeToString(sum[:])[:hashLength]
+	if name != requested && requested == strings.TrimSuffix(name, path.Ext(name))+"."+current+path.Ext(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000")
+		w.Header().Set("Expires", time.Now().AddDate(1, 0, 0).UTC().Format(http.TimeFormat))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", `"`+current+`"`)
+
+	http.ServeContent(w, req, name, time.Time{}, bytes.NewReader(content))
+}
@@ -0,0 +1,82 @@
+package assets
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+// Registers an asset built concatenating the files, minified if minify
+// is true. It's rebuilt in the dev server each time the instance starts
+// and written to the assets folder, so it's deployed with the rest of
+// the application files. Call it at init().
+// Example: assets.Bundle("js/app.js", true, "js/lib/angular.js", "js/app.js", "js/controllers.js")
+func Bundle(name string, minify bool, files ...string) {
+	if !platform.Current.IsDevelopment() {
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	for _, f := range files {
+		content, err := read(f)
+		if err != nil {
+			panic(fmt.Sprintf("build bundle %s failed: %s", name, err))
+		}
+		buf.Write(content)
+
+		// Avoid joining the last statement with the next file
+		if path.Ext(name) == ".js" {
+			buf.WriteString(";")
+		}
+		buf.WriteString("\n")
+	}
+
+	content := buf.Bytes()
+	if minify {
+		switch path.Ext(name) {
+		case ".css":
+			content = minifyCSS(content)
+		case ".js":
+			content = minifyJS(content)
+		}
+	}
+
+	filename := filepath.Join(Dir, filepath.FromSlash(path.Clean("/"+name)))
+	if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+		panic(fmt.Sprintf("write bundle %s failed: %s", name, err))
+	}
+}
+
+var (
+	cssComments   = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssWhitespace = regexp.MustCompile(`\s+`)
+	cssSymbols    = regexp.MustCompile(`\s*([{};,>])\s*`)
+)
+
+// Removes the comments & whitespace of the stylesheet
+func minifyCSS(content []byte) []byte {
+	content = cssComments.ReplaceAll(content, nil)
+	content = cssWhitespace.ReplaceAll(content, []byte(" "))
+	content = cssSymbols.ReplaceAll(content, []byte("$1"))
+	return bytes.TrimSpace(content)
+}
+
+// Removes the indentation, the blank lines and the line comments of the
+// script. It doesn't rename anything; use a real minifier for that.
+func minifyJS(content []byte) []byte {
+	out := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return []byte(strings.Join(out, "\n"))
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"html/template"
+	"net"
+	"strings"
+)
+
+// Look of the site & mails served in a domain
+type Brand struct {
+	// Name of the site and host where it's served. A host like
+	// *.example.com matches all its subdomains.
+	Name, Host string
+
+	// Logo URL and CSS colors
+	Logo                         string
+	PrimaryColor, SecondaryColor string
+
+	// Text of the footer of the pages & mails
+	Footer string
+
+	// Sender of the mails sent from requests of this domain
+	MailFrom, MailFromName string
+
+	// Other values for the templates
+	Extra map[string]string
+}
+
+var (
+	brands       = map[string]*Brand{}
+	defaultBrand = &Brand{}
+)
+
+func init() {
+	// Global version used by the templates without a request. The mails
+	// replace it with their own brand.
+	RegisterTemplateFuncs(template.FuncMap{
+		"brand": func() *Brand { return defaultBrand },
+	})
+	RegisterRequestTemplateFuncs(func(r *Request) template.FuncMap {
+		return template.FuncMap{
+			"brand": r.Brand,
+		}
+	})
+}
+
+// Registers the brand of its host, so one deployment can serve several
+// branded domains. The brands are resolved by the host of the request,
+// there's no other notion of tenant. Call it at init().
+// Example:
+//    app.RegisterBrand(&app.Brand{
+//      Name:     "Acme Store",
+//      Host:     "store.acme.com",
+//      Logo:     "/static/img/acme.png",
+//      MailFrom: "hello@acme.com",
+//    })
+func RegisterBrand(brand *Brand) {
+	brands[strings.ToLower(brand.Host)] = brand
+}
+
+// Sets the brand of the hosts without a registered one. Call it at init().
+func SetDefaultBrand(brand *Brand) {
+	defaultBrand = brand
+}
+
+// Returns the brand used when there's no request
+func DefaultBrand() *Brand {
+	return defaultBrand
+}
+
+// Returns the brand of the host, or the default one if there's not
+// a registered brand for it
+func BrandOf(host string) *Brand {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if brand, ok := brands[host]; ok {
+		return brand
+	}
+	for i := strings.Index(host, "."); i != -1; i = strings.Index(host, ".") {
+		host = host[i+1:]
+		if brand, ok := brands["*."+host]; ok {
+			return brand
+		}
+	}
+	return defaultBrand
+}
+
+// Returns the brand of the host of the request. It's available in the
+// templates with {{brand}}.
+// Example: <img src="{{brand.Logo}}" alt="{{brand.Name}}">
+func (r *Request) Brand() *Brand {
+	return BrandOf(r.Req.Host)
+}
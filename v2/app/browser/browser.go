@@ -0,0 +1,153 @@
+// Detection of the browsers too old to run the Angular pages, showing
+// them an unsupported browser page instead of a broken one.
+package browser
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+var (
+	// Minimum major version supported of each browser. The browsers not
+	// listed here and the unknown user agents (bots, etc.) are allowed.
+	MinVersions = map[string]int{
+		"MSIE":    10,
+		"Firefox": 24,
+		"Chrome":  30,
+		"Safari":  6,
+		"Opera":   15,
+		"Android": 4,
+	}
+
+	// Templates of the unsupported browser page, rendered with Page as
+	// data. Without them a plain builtin page is used.
+	Templates []string
+
+	// Query param of the link that continues with the unsupported browser
+	OverrideParam = "unsupported-browser"
+
+	// Time the choice of continuing is remembered
+	OverrideTTL = 30 * 24 * time.Hour
+)
+
+const overrideCookie = "unsupported-browser"
+
+// Data of the unsupported browser page
+type Page struct {
+	Browser  string
+	Version  int
+	Continue string
+}
+
+// Patterns of the user agents, in order: some browsers include the
+// tokens of the others (Chrome says Safari, Opera says Chrome, etc.)
+var patterns = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"Opera", regexp.MustCompile(`OPR/(\d+)`)},
+	{"Opera", regexp.MustCompile(`Opera.*Version/(\d+)`)},
+	{"Opera", regexp.MustCompile(`Opera[/ ](\d+)`)},
+	{"MSIE", regexp.MustCompile(`MSIE (\d+)`)},
+	{"MSIE", regexp.MustCompile(`Trident/.*rv:(\d+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+	{"Android", regexp.MustCompile(`Android (\d+).*Version/[\d.]+.*Safari`)},
+	{"Safari", regexp.MustCompile(`Version/(\d+).*Safari`)},
+}
+
+// Returns the name & major version of the browser of the user agent,
+// or an empty name if it's not recognized
+func Detect(ua string) (string, int) {
+	for _, p := range patterns {
+		m := p.re.FindStringSubmatch(ua)
+		if m == nil {
+			continue
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		return p.name, version
+	}
+	return "", 0
+}
+
+// Returns true if the browser of the user agent is not older than
+// the MinVersions
+func Supported(ua string) bool {
+	name, version := Detect(ua)
+	min, ok := MinVersions[name]
+	return !ok || version >= min
+}
+
+var builtinPage = template.Must(template.New("browser").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsupported browser</title></head>
+<body>
+<h1>Your browser is not supported</h1>
+<p>This site doesn't work in {{.Browser}} {{.Version}}. Please update it or
+use a recent version of Chrome, Firefox, Safari or Internet Explorer.</p>
+<p><a href="{{.Continue}}">Continue anyway</a></p>
+</body>
+</html>
+`))
+
+// Decorates the handler to show the unsupported browser page to the old
+// browsers. The users can continue anyway from the page, their choice is
+// remembered in a cookie. The JSON requests always pass.
+// Example: "::/app": browser.Require(pages.App),
+func Require(h app.Handler) app.Handler {
+	return app.Wrap(h, func(r *app.Request) error {
+		if r.WantsJson() {
+			return h(r)
+		}
+		if _, err := r.Req.Cookie(overrideCookie); err == nil {
+			return h(r)
+		}
+
+		ua := r.Req.UserAgent()
+		if Supported(ua) {
+			return h(r)
+		}
+
+		// Remember the choice and return to the page without the param
+		query := r.Req.URL.Query()
+		if query.Get(OverrideParam) != "" {
+			http.SetCookie(r.W, &http.Cookie{
+				Name:     overrideCookie,
+				Value:    "1",
+				Path:     "/",
+				Expires:  time.Now().Add(OverrideTTL),
+				HttpOnly: true,
+			})
+			query.Del(OverrideParam)
+			u := *r.Req.URL
+			u.RawQuery = query.Encode()
+			return r.Redirect(u.RequestURI())
+		}
+
+		name, version := Detect(ua)
+		query.Set(OverrideParam, "1")
+		page := &Page{
+			Browser:  name,
+			Version:  version,
+			Continue: (&url.URL{Path: r.Req.URL.Path, RawQuery: query.Encode()}).RequestURI(),
+		}
+		if Templates != nil {
+			return r.Template(Templates, page)
+		}
+		r.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := builtinPage.Execute(r.W, page); err != nil {
+			return fmt.Errorf("exec unsupported browser page failed: %s", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/mjibson/appstats"
+)
+
+var (
+	// Maximum datastore RPCs of a request before logging a warning, to
+	// catch the N+1 queries. Zero disables the check. The calls are only
+	// counted when appstats records the request or in the contexts
+	// of the apptest package.
+	DatastoreBudget = 0
+
+	// Logs an error and records it in the requests over the budget,
+	// instead of a warning. The response of the handler is sent as is;
+	// enable it in the tests to fail them with apptest.
+	StrictDatastoreBudget = false
+)
+
+// Contexts that count their datastore calls themselves
+type datastoreCounter interface {
+	DatastoreCalls() int
+}
+
+// Decorates the handler to use a custom datastore budget for the route.
+// Example: "::/reports": app.DatastoreCallBudget(200, reports.Build),
+func DatastoreCallBudget(n int, h Handler) Handler {
+	return Wrap(h, func(r *Request) error {
+		r.datastoreBudget = n
+		return h(r)
+	})
+}
+
+// Returns the error of the request over its strict datastore budget,
+// or nil if it's within the budget
+func (r *Request) DatastoreBudgetError() error {
+	return r.budgetErr
+}
+
+// Returns the number of datastore RPCs of the request and true if
+// they can be counted
+func (r *Request) DatastoreCalls() (int, bool) {
+	switch c := r.C.(type) {
+	case *appstats.Context:
+		calls := 0
+		for _, stat := range c.Stats.RPCStats {
+			if stat.Service == "datastore_v3" {
+				calls++
+			}
+		}
+		return calls, true
+
+	case datastoreCounter:
+		return c.DatastoreCalls(), true
+	}
+	return 0, false
+}
+
+// Logs a warning if the request exceeded its datastore budget, or
+// returns an error if the budget is strict
+func (r *Request) checkDatastoreBudget() error {
+	budget := DatastoreBudget
+	if r.datastoreBudget > 0 {
+		budget = r.datastoreBudget
+	}
+	if budget == 0 {
+		return nil
+	}
+
+	calls, ok := r.DatastoreCalls()
+	if !ok || calls <= budget {
+		return nil
+	}
+	if StrictDatastoreBudget {
+		return fmt.Errorf("%s made %d datastore calls, the budget is %d", r.URL(), calls, budget)
+	}
+	r.C.Warningf("[budget] %s made %d datastore calls, the budget is %d", r.URL(), calls, budget)
+	return nil
+}
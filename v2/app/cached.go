@@ -0,0 +1,112 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/cache"
+)
+
+// Replaces the CSP nonce of the request in the cached bodies
+const noncePlaceholder = "__gaelib_csp_nonce__"
+
+// Headers emitted again in each request, that are not cached
+var uncachedHeaders = []string{"Set-Cookie", "X-Request-Id", "X-Served-Version",
+	"Content-Security-Policy", "Date"}
+
+// Language of the request used in the keys of the cached responses
+var cacheLanguage = func(r *Request) string {
+	return r.Req.Header.Get("Accept-Language")
+}
+
+// Sets the function that returns the language of the request for
+// Cached. The i18n package sets it to its own detection.
+func SetCacheLanguage(f func(r *Request) string) {
+	cacheLanguage = f
+}
+
+type cachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Caches the successful responses of the GET handler by path, query &
+// language, serving the next requests directly from the cache. Don't
+// use it with pages that depend on the user or the session.
+// Example: "GET::/about": app.Cached(time.Hour, pages.About),
+func Cached(ttl time.Duration, h Handler) Handler {
+	return Wrap(h, func(r *Request) error {
+		rw, ok := r.W.(*responseWriter)
+		if r.Req.Method != "GET" || !ok {
+			return h(r)
+		}
+
+		key := cache.Key(r.C, "page:"+r.Req.URL.Path, r.Req.URL.RawQuery+"|"+cacheLanguage(r))
+		cached := new(cachedResponse)
+		if err := cache.Get(r.C, key, cached); err == nil {
+			rw.Header().Set("X-Cache", "HIT")
+			return replayResponse(r, rw, cached)
+		} else if err != cache.ErrMiss {
+			r.C.Warningf("[cache] cannot read cached response: %s", err)
+		}
+
+		// Responses that set their own cookies or are streamed are not cached
+		cookies := len(rw.Header()["Set-Cookie"])
+		if err := h(r); err != nil {
+			return err
+		}
+		if (rw.status != 0 && rw.status != http.StatusOK) || len(rw.Header()["Set-Cookie"]) != cookies || rw.streaming {
+			return nil
+		}
+
+		if err := cache.Set(r.C, key, captureResponse(r, rw), ttl); err != nil {
+			r.C.Warningf("[cache] cannot cache the response of %s: %s", r.Req.URL.Path, err)
+		}
+		rw.Header().Set("X-Cache", "MISS")
+		return nil
+	})
+}
+
+// Copies the response written by the handler, without the headers
+// of the request and the CSP nonce.
+func captureResponse(r *Request, rw *responseWriter) *cachedResponse {
+	header := http.Header{}
+	for name, values := range rw.Header() {
+		header[name] = append([]string(nil), values...)
+	}
+	for _, name := range uncachedHeaders {
+		header.Del(name)
+	}
+
+	body := append([]byte(nil), rw.buf.Bytes()...)
+	if r.cspNonce != "" {
+		body = bytes.Replace(body, []byte(r.cspNonce), []byte(noncePlaceholder), -1)
+	}
+
+	return &cachedResponse{Status: rw.status, Header: header, Body: body}
+}
+
+// Writes a response captured in another request
+func replayResponse(r *Request, rw *responseWriter, resp *cachedResponse) error {
+	// The cached slices are shared by the concurrent responses
+	for name, values := range resp.Header {
+		rw.Header()[name] = append([]string(nil), values...)
+	}
+	if resp.Status != 0 {
+		rw.WriteHeader(resp.Status)
+	}
+
+	body := bytes.Replace(resp.Body, []byte(noncePlaceholder), []byte(r.CSPNonce()), -1)
+	_, err := rw.Write(body)
+	return err
+}
+
+// Removes the cached responses of the path, with all the queries
+// and languages.
+func PurgeCached(c appengine.Context, path string) error {
+	return cache.Invalidate(c, "page:"+path)
+}
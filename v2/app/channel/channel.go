@@ -0,0 +1,245 @@
+package channel
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"appengine"
+	gaechannel "appengine/channel"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/auth"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+var (
+	// Lifetime of the tokens & the groups of their clients
+	TokenDuration = time.Duration(2) * time.Hour
+
+	// Called when a client connects or disconnects. Set them at init().
+	OnConnect, OnDisconnect func(c appengine.Context, clientID string)
+)
+
+// Number of retries of the concurrent updates of the groups
+const updateRetries = 10
+
+// Opens a channel for the logged user (see auth.Identity), joining its
+// client to the groups. It returns the token for the JS API and the
+// client ID; each call opens a new client, one per tab.
+// Example:
+//    token, _, err := channel.Open(r, "room:"+room.ID)
+func Open(r *app.Request, groups ...string) (string, string, error) {
+	email, err := auth.Identity.Email(r)
+	if err != nil {
+		return "", "", fmt.Errorf("get channel user failed: %s", err)
+	}
+	if email == "" {
+		return "", "", app.Unauthorized()
+	}
+
+	// The emails are too long for the client IDs
+	hash := sha256.Sum256([]byte(email))
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", "", fmt.Errorf("generate client id failed: %s", err)
+	}
+	clientID := hex.EncodeToString(hash[:12]) + "-" + hex.EncodeToString(suffix)
+
+	token, err := gaechannel.Create(r.C, clientID)
+	if err != nil {
+		return "", "", fmt.Errorf("create channel failed: %s", err)
+	}
+
+	for _, group := range append([]string{userGroup(email)}, groups...) {
+		if err := Join(r.C, group, clientID); err != nil {
+			return "", "", err
+		}
+	}
+	return token, clientID, nil
+}
+
+// Adds the client to the group
+func Join(c appengine.Context, group, clientID string) error {
+	if err := update(c, groupKey(group), func(ids []string) []string {
+		return addID(ids, clientID)
+	}); err != nil {
+		return err
+	}
+
+	// Remember the groups of the client to leave them when it disconnects
+	return update(c, clientKey(clientID), func(groups []string) []string {
+		return addID(groups, group)
+	})
+}
+
+// Removes the client from the group
+func Leave(c appengine.Context, group, clientID string) error {
+	return update(c, groupKey(group), func(ids []string) []string {
+		return removeID(ids, clientID)
+	})
+}
+
+// Returns the clients of the group
+func Clients(c appengine.Context, group string) ([]string, error) {
+	ids := []string{}
+	item, err := store.New(c).Get(groupKey(group))
+	if err != nil {
+		if err == store.ErrNotFound {
+			return ids, nil
+		}
+		return nil, fmt.Errorf("get channel group failed: %s", err)
+	}
+	if err := json.Unmarshal(item.Value, &ids); err != nil {
+		return nil, fmt.Errorf("decode channel group failed: %s", err)
+	}
+	return ids, nil
+}
+
+// Sends the value encoded as JSON to all the clients of the group
+func Broadcast(c appengine.Context, group string, v interface{}) error {
+	ids, err := Clients(c, group)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := gaechannel.SendJSON(c, id, v); err != nil {
+			c.Warningf("[channel] send to %s failed: %s", id, err)
+		}
+	}
+	return nil
+}
+
+// Sends the value encoded as JSON to all the clients of the user
+func SendToUser(c appengine.Context, email string, v interface{}) error {
+	return Broadcast(c, userGroup(email), v)
+}
+
+// Receives the presence notifications of the clients, enabled with
+// the channel_presence inbound service. Register it directly in
+// net/http, App Engine doesn't send the XSRF token of the app router.
+// Example:
+//    http.HandleFunc("/_ah/channel/connected/", channel.PresenceHandler)
+//    http.HandleFunc("/_ah/channel/disconnected/", channel.PresenceHandler)
+func PresenceHandler(w http.ResponseWriter, req *http.Request) {
+	c := platform.Current.NewContext(req)
+	clientID := req.FormValue("from")
+
+	switch req.URL.Path {
+	case "/_ah/channel/connected/":
+		if OnConnect != nil {
+			OnConnect(c, clientID)
+		}
+
+	case "/_ah/channel/disconnected/":
+		if err := disconnect(c, clientID); err != nil {
+			c.Errorf("[channel] disconnect %s failed: %s", clientID, err)
+		}
+		if OnDisconnect != nil {
+			OnDisconnect(c, clientID)
+		}
+
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// Removes the client from all its groups
+func disconnect(c appengine.Context, clientID string) error {
+	st := store.New(c)
+	item, err := st.Get(clientKey(clientID))
+	if err != nil {
+		if err == store.ErrNotFound {
+			return nil
+		}
+		return fmt.Errorf("get channel client failed: %s", err)
+	}
+	groups := []string{}
+	if err := json.Unmarshal(item.Value, &groups); err != nil {
+		return fmt.Errorf("decode channel client failed: %s", err)
+	}
+
+	for _, group := range groups {
+		if err := Leave(c, group, clientID); err != nil {
+			return err
+		}
+	}
+	return st.Delete(clientKey(clientID))
+}
+
+// Updates the list of IDs stored in the key, retrying the concurrent
+// modifications.
+func update(c appengine.Context, key string, fn func(ids []string) []string) error {
+	st := store.New(c)
+	for i := 0; i < updateRetries; i++ {
+		item, err := st.Get(key)
+		if err == store.ErrNotFound {
+			value, err := json.Marshal(fn([]string{}))
+			if err != nil {
+				return fmt.Errorf("encode channel ids failed: %s", err)
+			}
+			if err := st.Add(key, value, TokenDuration); err == store.ErrNotStored {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("add channel ids failed: %s", err)
+			}
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("get channel ids failed: %s", err)
+		}
+
+		ids := []string{}
+		if err := json.Unmarshal(item.Value, &ids); err != nil {
+			return fmt.Errorf("decode channel ids failed: %s", err)
+		}
+		if item.Value, err = json.Marshal(fn(ids)); err != nil {
+			return fmt.Errorf("encode channel ids failed: %s", err)
+		}
+		item.TTL = TokenDuration
+		if err := st.CompareAndSwap(item); err == store.ErrConflict || err == store.ErrNotStored {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("update channel ids failed: %s", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("update channel ids failed: too many conflicts in %s", key)
+}
+
+func addID(ids []string, id string) []string {
+	for _, i := range ids {
+		if i == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+func removeID(ids []string, id string) []string {
+	result := []string{}
+	for _, i := range ids {
+		if i != id {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+func userGroup(email string) string {
+	return "user:" + email
+}
+
+func groupKey(group string) string {
+	return "channel-group:" + group
+}
+
+func clientKey(clientID string) string {
+	return "channel-client:" + clientID
+}
@@ -0,0 +1,71 @@
+package channel
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"appengine"
+	"appengine/xmpp"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+// Sends the chat message to the XMPP addresses that are available
+func SendXMPP(c appengine.Context, body string, jids ...string) error {
+	available := []string{}
+	for _, jid := range jids {
+		ok, err := IsAvailable(c, jid)
+		if err != nil {
+			return err
+		}
+		if ok {
+			available = append(available, jid)
+		}
+	}
+	if len(available) == 0 {
+		return nil
+	}
+
+	m := &xmpp.Message{To: available, Body: body}
+	if err := m.Send(c); err != nil {
+		return fmt.Errorf("send xmpp message failed: %s", err)
+	}
+	return nil
+}
+
+// Returns true if the address was available in the last presence
+// notification. Unknown addresses are considered available.
+func IsAvailable(c appengine.Context, jid string) (bool, error) {
+	item, err := store.New(c).Get(presenceKey(jid))
+	if err != nil {
+		if err == store.ErrNotFound {
+			return true, nil
+		}
+		return false, fmt.Errorf("get xmpp presence failed: %s", err)
+	}
+	return string(item.Value) == "available", nil
+}
+
+// Receives the presence notifications of the XMPP addresses, enabled
+// with the xmpp_presence inbound service. Register it directly in
+// net/http like PresenceHandler.
+// Example: http.HandleFunc("/_ah/xmpp/presence/", channel.XMPPPresenceHandler)
+func XMPPPresenceHandler(w http.ResponseWriter, req *http.Request) {
+	c := platform.Current.NewContext(req)
+	status := strings.Trim(strings.TrimPrefix(req.URL.Path, "/_ah/xmpp/presence/"), "/")
+	if status != "available" && status != "unavailable" {
+		return
+	}
+
+	// Remove the resource of the address (user@example.com/phone)
+	jid := strings.SplitN(req.FormValue("from"), "/", 2)[0]
+	if err := store.New(c).Set(presenceKey(jid), []byte(status), 0); err != nil {
+		c.Errorf("[channel] save xmpp presence of %s failed: %s", jid, err)
+	}
+}
+
+func presenceKey(jid string) string {
+	return "xmpp-presence:" + strings.ToLower(jid)
+}
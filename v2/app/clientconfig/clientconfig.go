@@ -0,0 +1,180 @@
+// Configuration of the client app assembled in one response: feature
+// flags, translations, API base paths and the XSRF token, so the
+// Angular bootstrap doesn't need several round trips.
+package clientconfig
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/config"
+	"github.com/ernestokarim/gaelib/v2/app/i18n"
+)
+
+// Configuration emitted to the client
+type Config struct {
+	Flags     map[string]bool        `json:"flags"`
+	Language  string                 `json:"language"`
+	Messages  map[string]string      `json:"messages"`
+	APIs      map[string]string      `json:"apis"`
+	Values    map[string]interface{} `json:"values"`
+	XsrfToken string                 `json:"xsrfToken,omitempty"`
+}
+
+var (
+	mutex    = &sync.RWMutex{}
+	flags    = map[string]func(r *app.Request) bool{}
+	messages []string
+	apis     = map[string]string{}
+	values   = map[string]func(r *app.Request) (interface{}, error){}
+)
+
+func init() {
+	app.RegisterRequestTemplateFuncs(func(r *app.Request) template.FuncMap {
+		return template.FuncMap{
+			"app_config": func() (template.JS, error) { return inline(r) },
+		}
+	})
+}
+
+// Registers a feature flag evaluated in each request. Call it at init().
+// Example:
+//    clientconfig.Flag("newEditor", func(r *app.Request) bool {
+//      return r.Country() == "AR"
+//    })
+func Flag(name string, fn func(r *app.Request) bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	flags[name] = fn
+}
+
+// Registers a feature flag that can be toggled at runtime from the
+// settings admin page (see the config package). Call it at init().
+// Example:
+//    if err := clientconfig.ConfigFlag("newEditor", false); err != nil {
+//      panic(err)
+//    }
+func ConfigFlag(name string, def bool) error {
+	if name == "" {
+		return fmt.Errorf("config flag without name")
+	}
+
+	setting := flagSetting(name)
+	config.Register(setting, config.TypeBool, strconv.FormatBool(def), "Feature flag of the client app")
+	Flag(name, func(r *app.Request) bool {
+		return config.Bool(r.C, setting, def)
+	})
+	return nil
+}
+
+// Name of the setting of the config flag
+// Example: "newEditor" -> "FlagNewEditor"
+func flagSetting(name string) string {
+	return "Flag" + strings.ToUpper(name[:1]) + name[1:]
+}
+
+// Adds the keys to the translations sent to the client, in its language.
+// Call it at init().
+// Example: clientconfig.Messages("Save", "Cancel", "Loading...")
+func Messages(keys ...string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	messages = append(messages, keys...)
+}
+
+// Registers the base path of an API used by the client. Call it at init().
+// Example: clientconfig.APIBase("orders", "/_/orders")
+func APIBase(name, path string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	apis[name] = path
+}
+
+// Registers a custom value computed in each request. Call it at init().
+func Value(name string, fn func(r *app.Request) (interface{}, error)) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	values[name] = fn
+}
+
+// Builds the configuration of the request, without the XSRF token
+func Build(r *app.Request) (*Config, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	lang := i18n.Language(r)
+	cfg := &Config{
+		Flags:    map[string]bool{},
+		Language: lang,
+		Messages: map[string]string{},
+		APIs:     map[string]string{},
+		Values:   map[string]interface{}{},
+	}
+	for name, fn := range flags {
+		cfg.Flags[name] = fn(r)
+	}
+	for _, key := range messages {
+		cfg.Messages[key] = i18n.Translate(lang, key)
+	}
+	for name, path := range apis {
+		cfg.APIs[name] = path
+	}
+	for name, fn := range values {
+		v, err := fn(r)
+		if err != nil {
+			return nil, fmt.Errorf("client config value %s failed: %s", name, err)
+		}
+		cfg.Values[name] = v
+	}
+	return cfg, nil
+}
+
+// Emits the configuration of the request with an ETag, so the client
+// revalidates it with a 304 most of the times. The token changes in each
+// request and it's not part of the ETag: the clients should keep reading
+// the XSRF-TOKEN cookie after the first request, like Angular does.
+// Example: "GET::/app-config.json": clientconfig.Handler,
+func Handler(r *app.Request) error {
+	cfg, err := Build(r)
+	if err != nil {
+		return err
+	}
+	data, err := app.MarshalJson(cfg)
+	if err != nil {
+		return fmt.Errorf("encode client config failed: %s", err)
+	}
+
+	r.W.Header().Set("Cache-Control", "private, no-cache")
+	etag := fmt.Sprintf("%x", sha1.Sum(data))
+	return r.ServeWithETag(etag, func() error {
+		cfg.XsrfToken = r.XsrfToken()
+		return r.EmitJson(cfg)
+	})
+}
+
+// Returns the configuration as a JS object to inline it in the page
+// Example: <script nonce="{{csp_nonce}}">window.APP_CONFIG = {{app_config}};</script>
+func inline(r *app.Request) (template.JS, error) {
+	cfg, err := Build(r)
+	if err != nil {
+		return "", err
+	}
+	cfg.XsrfToken = r.XsrfToken()
+
+	data, err := app.MarshalJson(cfg)
+	if err != nil {
+		return "", fmt.Errorf("encode client config failed: %s", err)
+	}
+
+	// Avoid closing the script tag from the JSON contents
+	return template.JS(strings.Replace(string(data), "</", `<\/`, -1)), nil
+}
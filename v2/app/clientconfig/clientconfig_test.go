@@ -0,0 +1,54 @@
+package clientconfig
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+func TestAppConfigTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clientconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := `{{define "base"}}{{if .}}{{app_config}}{{end}}{{end}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "base.html"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The template is parsed without the request
+	err = app.ExecTemplate(&app.TemplateConfig{
+		Names: []string{"base"},
+		W:     bytes.NewBuffer(nil),
+		Dir:   dir,
+	})
+	if err != nil {
+		t.Errorf("exec template with app_config failed: %s", err)
+	}
+}
+
+func TestConfigFlagWithoutName(t *testing.T) {
+	if err := ConfigFlag("", true); err == nil {
+		t.Errorf("expected an error registering a flag without name")
+	}
+}
+
+func TestFlagSetting(t *testing.T) {
+	tests := []struct {
+		name, expected string
+	}{
+		{"newEditor", "FlagNewEditor"},
+		{"x", "FlagX"},
+	}
+	for _, test := range tests {
+		if got := flagSetting(test.name); got != test.expected {
+			t.Errorf("flag %s: got %s, expected %s", test.name, got, test.expected)
+		}
+	}
+}
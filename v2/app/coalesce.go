@@ -0,0 +1,106 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"appengine/user"
+)
+
+// User of the request used in the keys of Coalesced
+var coalesceUser = func(r *Request) string {
+	if u := user.Current(r.C); u != nil {
+		return u.Email
+	}
+	return ""
+}
+
+// Sets the function that returns the user of the request for Coalesced.
+// The auth package sets it to its identity provider.
+func SetCoalesceUser(f func(r *Request) string) {
+	coalesceUser = f
+}
+
+type flight struct {
+	wg       sync.WaitGroup
+	response *cachedResponse
+	err      error
+
+	// The response was streamed and can't be replayed
+	streamed bool
+}
+
+var (
+	flightsMutex sync.Mutex
+	flights      = map[string]*flight{}
+)
+
+// Shares the response of the GET handler between the identical requests
+// (same URL & user) that arrive to the instance while it's running, so
+// the expensive pages are computed once in the traffic spikes. The
+// streamed responses are not shared.
+// Example: "GET::/ranking": app.Coalesced(pages.Ranking),
+func Coalesced(h Handler) Handler {
+	return Wrap(h, func(r *Request) error {
+		rw, ok := r.W.(*responseWriter)
+		if r.Req.Method != "GET" || !ok {
+			return h(r)
+		}
+
+		// Encode sorts the params
+		key := r.Req.URL.Path + "?" + r.Req.URL.Query().Encode() + "|" + url.QueryEscape(coalesceUser(r))
+
+		flightsMutex.Lock()
+		f, running := flights[key]
+		if !running {
+			f = new(flight)
+			f.wg.Add(1)
+			flights[key] = f
+		}
+		flightsMutex.Unlock()
+
+		if running {
+			f.wg.Wait()
+			if f.streamed {
+				return h(r)
+			}
+			if f.err != nil {
+				return f.err
+			}
+			if f.response == nil {
+				return fmt.Errorf("coalesced handler returned no response")
+			}
+			rw.Header().Set("X-Coalesced", "true")
+			return replayResponse(r, rw, f.response)
+		}
+
+		defer func() {
+			flightsMutex.Lock()
+			delete(flights, key)
+			flightsMutex.Unlock()
+			f.wg.Done()
+		}()
+
+		// The waiters fail with an error instead of a nil response, the
+		// leader panics again so the router reports it
+		defer func() {
+			if rec := recover(); rec != nil {
+				f.err = fmt.Errorf("coalesced handler panicked: %v", rec)
+				panic(rec)
+			}
+		}()
+
+		f.err = h(r)
+		if f.err == nil {
+			// The body of the streamed responses is not buffered, the
+			// waiters run the handler themselves like in Cached
+			if rw.streaming {
+				f.streamed = true
+			} else {
+				f.response = captureResponse(r, rw)
+			}
+		}
+		return f.err
+	})
+}
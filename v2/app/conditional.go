@@ -0,0 +1,80 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Emits the ETag header and checks the If-None-Match one of the request.
+// If the client already has the content a 304 is returned; otherwise fn
+// is called to render it.
+// Example:
+//    return r.ServeWithETag(fmt.Sprintf(`"%d"`, post.Revision), func() error {
+//      return r.EmitJson(post)
+//    })
+func (r *Request) ServeWithETag(etag string, fn func() error) error {
+	if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+		etag = `"` + etag + `"`
+	}
+	r.W.Header().Set("ETag", etag)
+	r.allowRevalidation()
+
+	if match := r.Req.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				r.notModified()
+				return nil
+			}
+		}
+	}
+
+	return fn()
+}
+
+// Emits the Last-Modified header and returns true (after emitting a 304)
+// if the client has a copy newer than t, so the handler can return
+// directly without rendering anything.
+// Example:
+//    if r.NotModifiedSince(post.Updated) {
+//      return nil
+//    }
+func (r *Request) NotModifiedSince(t time.Time) bool {
+	t = t.UTC().Truncate(time.Second)
+	r.W.Header().Set("Last-Modified", t.Format(http.TimeFormat))
+	r.allowRevalidation()
+
+	// If-None-Match takes precedence when present
+	if r.Req.Header.Get("If-None-Match") != "" {
+		return false
+	}
+
+	since, err := http.ParseTime(r.Req.Header.Get("If-Modified-Since"))
+	if err != nil || t.After(since) {
+		return false
+	}
+
+	r.notModified()
+	return true
+}
+
+// Replaces the default anti-cache headers (no-store & the expired date),
+// so the browsers keep the content and revalidate it with the validators.
+// A Cache-Control set by the handler is kept.
+func (r *Request) allowRevalidation() {
+	h := r.W.Header()
+	if h.Get("Cache-Control") == defaultHeaders.Get("Cache-Control") {
+		h.Set("Cache-Control", "private, no-cache")
+	}
+	if h.Get("Expires") == defaultHeaders.Get("Expires") {
+		h.Del("Expires")
+	}
+}
+
+func (r *Request) notModified() {
+	h := r.W.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	r.W.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeWithETag(t *testing.T) {
+	tests := []struct {
+		match, cacheControl string
+
+		status               int
+		rendered             bool
+		expectedCacheControl string
+	}{
+		{"", "", 200, true, "private, no-cache"},
+		{`"other"`, "", 200, true, "private, no-cache"},
+		{`"v1"`, "", 304, false, "private, no-cache"},
+		{`W/"v1"`, "", 304, false, "private, no-cache"},
+		{"", "public, max-age=60", 200, true, "public, max-age=60"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "/posts/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.match != "" {
+			req.Header.Set("If-None-Match", test.match)
+		}
+		w := httptest.NewRecorder()
+		for name, values := range defaultHeaders {
+			w.Header()[name] = append([]string(nil), values...)
+		}
+		if test.cacheControl != "" {
+			w.Header().Set("Cache-Control", test.cacheControl)
+		}
+
+		r := &Request{Req: req, W: w}
+		rendered := false
+		err = r.ServeWithETag("v1", func() error {
+			rendered = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("match %q: unexpected error: %s", test.match, err)
+		}
+
+		if rendered != test.rendered {
+			t.Errorf("match %q: rendered %v, expected %v", test.match, rendered, test.rendered)
+		}
+		if w.Code != test.status {
+			t.Errorf("match %q: got status %d, expected %d", test.match, w.Code, test.status)
+		}
+		if got := w.Header().Get("ETag"); got != `"v1"` {
+			t.Errorf("match %q: got etag %s", test.match, got)
+		}
+		if got := w.Header().Get("Cache-Control"); got != test.expectedCacheControl {
+			t.Errorf("match %q: got cache control %q, expected %q", test.match, got, test.expectedCacheControl)
+		}
+		if got := w.Header().Get("Expires"); got != "" {
+			t.Errorf("match %q: got expires %q", test.match, got)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// Settings stored in the datastore that can be changed at runtime from
+// the admin page, without redeploying. The secrets of the sessions & the
+// XSRF tokens are generated and stored by the app package.
+package config
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/cache"
+)
+
+const KindSetting = "Settings"
+
+// Time the settings are cached
+var CacheTTL = 5 * time.Minute
+
+const cacheKey = "config:settings"
+
+// Kinds of settings, to validate & show them in the admin page
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeBool   = "bool"
+	TypeSecret = "secret"
+	TypeList   = "list"
+)
+
+// Setting stored with its name as the ID
+type Setting struct {
+	Value    string `datastore:",noindex"`
+	Modified time.Time
+	Author   string
+}
+
+// Registered setting, with its default value
+type Definition struct {
+	Name, Type, Default, Help string
+}
+
+var (
+	definitionsMutex = &sync.RWMutex{}
+	definitions      = map[string]*Definition{}
+)
+
+func init() {
+	Register("AdminEmails", TypeList, "", "Comma separated emails that receive the admin mails")
+}
+
+// Returns the emails of the AdminEmails setting, that receive the error
+// mails and the rest of the mails of mail.SendToAdmins
+func AdminEmails(c appengine.Context) []string {
+	return List(c, "AdminEmails", nil)
+}
+
+// Registers a setting so it's listed in the admin page, with the value
+// used until it's changed there. Call it at init().
+// Example: config.Register("AdminEmails", config.TypeList, "", "Receivers of the error mails")
+func Register(name, typ, def, help string) {
+	definitionsMutex.Lock()
+	defer definitionsMutex.Unlock()
+
+	definitions[name] = &Definition{Name: name, Type: typ, Default: def, Help: help}
+}
+
+// Returns the value of the setting, the registered default or def if
+// it's not stored. The errors are logged and return the default too.
+func String(c appengine.Context, name, def string) string {
+	settings, err := load(c)
+	if err != nil {
+		c.Errorf("[config] load settings failed: %s", err)
+	} else if value, ok := settings[name]; ok {
+		return value
+	}
+
+	definitionsMutex.RLock()
+	defer definitionsMutex.RUnlock()
+	if d, ok := definitions[name]; ok && d.Default != "" {
+		return d.Default
+	}
+	return def
+}
+
+// Returns the integer value of the setting, or def if it's not stored
+// or it's not a number
+func Int(c appengine.Context, name string, def int64) int64 {
+	value := String(c, name, "")
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		c.Warningf("[config] setting %s is not an integer: %s", name, value)
+		return def
+	}
+	return n
+}
+
+// Returns the boolean value of the setting, or def if it's not stored
+func Bool(c appengine.Context, name string, def bool) bool {
+	value := String(c, name, "")
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		c.Warningf("[config] setting %s is not a boolean: %s", name, value)
+		return def
+	}
+	return b
+}
+
+// Returns the value of a secret setting (API keys, passwords). It's the
+// same as String, but the admin page never shows its value.
+func Secret(c appengine.Context, name, def string) string {
+	return String(c, name, def)
+}
+
+// Returns the comma separated values of the setting, or def if it's
+// not stored
+// Example: hosts := config.List(c, "AllowedHosts", nil)
+func List(c appengine.Context, name string, def []string) []string {
+	value := String(c, name, "")
+	if value == "" {
+		return def
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Stores the value of the setting. author is the email of the admin
+// that changes it, for the audit.
+func Set(c appengine.Context, name, value, author string) error {
+	if err := validate(name, value); err != nil {
+		return err
+	}
+
+	setting := &Setting{Value: value, Modified: time.Now(), Author: author}
+	if _, err := datastore.Put(c, datastore.NewKey(c, KindSetting, name, 0, nil), setting); err != nil {
+		return fmt.Errorf("put setting failed: %s", err)
+	}
+	if err := cache.Delete(c, cacheKey); err != nil {
+		c.Warningf("[config] delete cached settings failed: %s", err)
+	}
+	return nil
+}
+
+// Removes the stored value of the setting, returning to the default one
+func Reset(c appengine.Context, name string) error {
+	if err := datastore.Delete(c, datastore.NewKey(c, KindSetting, name, 0, nil)); err != nil {
+		return fmt.Errorf("delete setting failed: %s", err)
+	}
+	if err := cache.Delete(c, cacheKey); err != nil {
+		c.Warningf("[config] delete cached settings failed: %s", err)
+	}
+	return nil
+}
+
+// Checks the value against the type of the registered setting
+func validate(name, value string) error {
+	definitionsMutex.RLock()
+	d, ok := definitions[name]
+	definitionsMutex.RUnlock()
+	if !ok || value == "" {
+		return nil
+	}
+
+	switch d.Type {
+	case TypeInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return app.BadRequest(name, "the value should be an integer")
+		}
+	case TypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return app.BadRequest(name, "the value should be true or false")
+		}
+	}
+	return nil
+}
+
+// Returns all the stored settings, from the cache if possible
+func load(c appengine.Context) (map[string]string, error) {
+	settings := map[string]string{}
+	err := cache.Once(c, cacheKey, CacheTTL, &settings, func() (interface{}, error) {
+		stored := []*Setting{}
+		keys, err := datastore.NewQuery(KindSetting).GetAll(c, &stored)
+		if err != nil {
+			return nil, fmt.Errorf("query settings failed: %s", err)
+		}
+
+		values := map[string]string{}
+		for i, key := range keys {
+			values[key.StringID()] = stored[i].Value
+		}
+		return values, nil
+	})
+	return settings, err
+}
+
+type adminRow struct {
+	*Definition
+	Value  string
+	Stored bool
+}
+
+var adminTemplate = template.Must(template.New("config").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Settings</title></head>
+<body>
+  <h1>Settings</h1>
+  <table>
+    <tr><th>Name</th><th>Value</th><th>Help</th><th></th></tr>
+    {{range .Rows}}
+    <tr>
+      <td>{{.Name}}</td>
+      <td>
+        {{if eq .Type "secret"}}
+        <input name="value" form="setting-{{.Name}}" type="password" placeholder="{{if .Stored}}(stored){{else}}(default){{end}}">
+        {{else if eq .Type "bool"}}
+        <select name="value" form="setting-{{.Name}}">
+          <option value="true"{{if eq .Value "true"}} selected{{end}}>true</option>
+          <option value="false"{{if ne .Value "true"}} selected{{end}}>false</option>
+        </select>
+        {{else}}
+        <input name="value" form="setting-{{.Name}}" value="{{.Value}}">
+        {{end}}
+        {{if not .Stored}}<small>(default)</small>{{end}}
+        <small>(save it empty to return to the default)</small>
+      </td>
+      <td>{{.Help}}</td>
+      <td>
+        <form id="setting-{{.Name}}" action="" data-post data-result="config-result"
+            data-ok="{{.Name}} saved" data-error="{{.Name}} not saved">
+          <input type="hidden" name="name" value="{{.Name}}">
+          <button type="submit">Save</button>
+        </form>
+      </td>
+    </tr>
+    {{end}}
+  </table>
+  <p id="config-result"></p>
+  {{.Script}}
+</body>
+</html>
+`))
+
+type settingChange struct {
+	Name  string `json:"name" valid:"required"`
+	Value string `json:"value"`
+}
+
+// Admin page to edit the registered settings. The secrets are never shown.
+// Example: "::/admin/settings": config.AdminHandler,
+func AdminHandler(r *app.Request) error {
+	if !user.IsAdmin(r.C) {
+		return app.Forbidden()
+	}
+
+	if r.IsPOST() {
+		change := new(settingChange)
+		if err := r.LoadJsonData(change); err != nil {
+			return err
+		}
+		// Empty values return to the default one
+		if change.Value == "" {
+			if err := Reset(r.C, change.Name); err != nil {
+				return err
+			}
+		} else if err := Set(r.C, change.Name, change.Value, user.Current(r.C).Email); err != nil {
+			return err
+		}
+		r.C.Infof("[config] setting %s changed by %s", change.Name, user.Current(r.C).Email)
+		return r.EmitJson(map[string]bool{"ok": true})
+	}
+
+	settings, err := load(r.C)
+	if err != nil {
+		return err
+	}
+
+	definitionsMutex.RLock()
+	rows := []*adminRow{}
+	for _, d := range definitions {
+		value, stored := settings[d.Name]
+		if !stored {
+			value = d.Default
+		}
+		if d.Type == TypeSecret {
+			value = ""
+		}
+		rows = append(rows, &adminRow{Definition: d, Value: value, Stored: stored})
+	}
+	definitionsMutex.RUnlock()
+	sort.Sort(byName(rows))
+
+	data := map[string]interface{}{
+		"Rows":   rows,
+		"Script": r.PostScript(),
+	}
+	if err := adminTemplate.Execute(r.W, data); err != nil {
+		return fmt.Errorf("exec config template failed: %s", err)
+	}
+	return nil
+}
+
+type byName []*adminRow
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
@@ -0,0 +1,398 @@
+// Consent of the users: versioned terms they have to accept again when
+// they change, and the cookie categories they allow.
+package consent
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/auth"
+	"github.com/ernestokarim/gaelib/v2/cache"
+)
+
+const (
+	KindTerms      = "TermsVersion"
+	KindAcceptance = "TermsAcceptance"
+)
+
+var (
+	// Route of TermsHandler, where the users are sent to accept the terms
+	TermsPath = "/terms"
+
+	// Route of CookiesHandler, where the banner posts the preferences
+	CookiesPath = "/_/consent/cookies"
+
+	// Templates of the terms page, rendered with Page as data. They can
+	// post the acceptance with a form of {{post_script}}. Without them a
+	// plain builtin page is used.
+	Templates []string
+
+	// Optional cookie categories the users can allow. The necessary
+	// cookies are always allowed.
+	CookieCategories = []string{"analytics", "marketing"}
+
+	// Time the cookie preferences are kept
+	CookieTTL = 365 * 24 * time.Hour
+
+	// Time the current terms are cached
+	CacheTTL = 10 * time.Minute
+)
+
+const (
+	cookieName = "cookie-consent"
+	cacheKey   = "consent:terms"
+)
+
+// Version of the terms, stored with the version number as its ID
+type Terms struct {
+	Version int64  `datastore:"-"`
+	Body    string `datastore:",noindex"`
+	Created time.Time
+}
+
+// Acceptance of a version of the terms, stored with the lowercase email
+// and the version (email:version) as its ID
+type Acceptance struct {
+	Email    string
+	Version  int64
+	Accepted time.Time
+	IP       string
+}
+
+// Data of the terms page
+type Page struct {
+	Terms       *Terms
+	Next, Nonce string
+}
+
+// Preferences posted by the cookie banner
+type cookiePrefs struct {
+	Allowed []string `json:"allowed"`
+}
+
+// Acceptance posted by the terms page
+type acceptForm struct {
+	Version string `json:"version" valid:"required"`
+}
+
+func init() {
+	app.RegisterRequestTemplateFuncs(func(r *app.Request) template.FuncMap {
+		return template.FuncMap{
+			"cookie_banner":  func() (template.HTML, error) { return cookieBanner(r) },
+			"cookie_allowed": func(category string) bool { return CookieAllowed(r, category) },
+		}
+	})
+}
+
+// Stores a new version of the terms, that the users will have to accept
+// before continuing in the handlers decorated with Require
+func Publish(c appengine.Context, body string) (*Terms, error) {
+	current, err := Current(c)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := &Terms{Body: body, Created: time.Now()}
+	if current != nil {
+		terms.Version = current.Version + 1
+	} else {
+		terms.Version = 1
+	}
+	key := datastore.NewKey(c, KindTerms, "", terms.Version, nil)
+	if _, err := datastore.Put(c, key, terms); err != nil {
+		return nil, fmt.Errorf("put terms failed: %s", err)
+	}
+	if err := cache.Delete(c, cacheKey); err != nil {
+		c.Warningf("[consent] delete cached terms failed: %s", err)
+	}
+	return terms, nil
+}
+
+// Returns the last version of the terms, or nil if there's none
+func Current(c appengine.Context) (*Terms, error) {
+	terms := new(Terms)
+	err := cache.Once(c, cacheKey, CacheTTL, terms, func() (interface{}, error) {
+		found := []*Terms{}
+		keys, err := datastore.NewQuery(KindTerms).Order("-Created").Limit(1).GetAll(c, &found)
+		if err != nil {
+			return nil, fmt.Errorf("query terms failed: %s", err)
+		}
+		if len(keys) == 0 {
+			return &Terms{}, nil
+		}
+		found[0].Version = keys[0].IntID()
+		return found[0], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if terms.Version == 0 {
+		return nil, nil
+	}
+	return terms, nil
+}
+
+// Returns true if the user has accepted the version of the terms
+func HasAccepted(c appengine.Context, email string, version int64) (bool, error) {
+	err := datastore.Get(c, acceptanceKey(c, email, version), new(Acceptance))
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("get terms acceptance failed: %s", err)
+	}
+	return true, nil
+}
+
+// Records the acceptance of the version of the terms by the logged user,
+// with the IP of the request
+func Accept(r *app.Request, email string, version int64) error {
+	a := &Acceptance{
+		Email:    strings.ToLower(email),
+		Version:  version,
+		Accepted: time.Now(),
+		IP:       r.Req.RemoteAddr,
+	}
+	if _, err := datastore.Put(r.C, acceptanceKey(r.C, email, version), a); err != nil {
+		return fmt.Errorf("put terms acceptance failed: %s", err)
+	}
+	return nil
+}
+
+// Returns the versions of the terms accepted by the user, newest first
+func Acceptances(c appengine.Context, email string) ([]*Acceptance, error) {
+	accepted := []*Acceptance{}
+	q := datastore.NewQuery(KindAcceptance).Filter("Email =", strings.ToLower(email)).Order("-Version")
+	if _, err := q.GetAll(c, &accepted); err != nil {
+		return nil, fmt.Errorf("query terms acceptances failed: %s", err)
+	}
+	return accepted, nil
+}
+
+func acceptanceKey(c appengine.Context, email string, version int64) *datastore.Key {
+	id := fmt.Sprintf("%s:%d", strings.ToLower(email), version)
+	return datastore.NewKey(c, KindAcceptance, id, 0, nil)
+}
+
+// Decorates the handler to send the logged users that haven't accepted
+// the current terms to TermsPath, returning to the page after it. The
+// JSON requests receive a 403 error instead. Anonymous users pass.
+// Example: "::/dashboard": consent.Require(pages.Dashboard),
+func Require(h app.Handler) app.Handler {
+	return app.Wrap(h, func(r *app.Request) error {
+		if user.IsAdmin(r.C) {
+			return h(r)
+		}
+
+		email, err := auth.Identity.Email(r)
+		if err != nil {
+			return fmt.Errorf("get consent user failed: %s", err)
+		}
+		if email == "" {
+			return h(r)
+		}
+
+		terms, err := Current(r.C)
+		if err != nil {
+			return err
+		}
+		if terms == nil {
+			return h(r)
+		}
+		accepted, err := HasAccepted(r.C, email, terms.Version)
+		if err != nil {
+			return err
+		}
+		if accepted {
+			return h(r)
+		}
+
+		if r.WantsJson() {
+			return &app.AppError{Code: 403, Message: "the terms should be accepted"}
+		}
+		return r.Redirect(TermsPath + "?" + url.Values{"next": []string{r.Path()}}.Encode())
+	})
+}
+
+var builtinPage = template.Must(template.New("terms").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Terms of service</title></head>
+<body>
+<h1>Terms of service</h1>
+{{with .Terms}}
+<div style="white-space: pre-wrap">{{.Body}}</div>
+<form action="" data-post data-next="{{$.Next}}" data-result="terms-result"
+    data-error="The terms could not be accepted, please reload the page.">
+  <input type="hidden" name="version" value="{{.Version}}">
+  <button type="submit">I accept</button>
+</form>
+<p id="terms-result"></p>
+{{$.Script}}
+{{end}}
+</body>
+</html>
+`))
+
+// Shows the current terms and records their acceptance when they're
+// posted back, returning to the next param. The JSON posts of the forms
+// of {{post_script}} receive a 409 error if the terms changed while the
+// page was open. Register it in TermsPath.
+// Example: "::/terms": consent.TermsHandler,
+func TermsHandler(r *app.Request) error {
+	terms, err := Current(r.C)
+	if err != nil {
+		return err
+	}
+	if terms == nil {
+		return app.NotFound()
+	}
+
+	next := r.Req.FormValue("next")
+	// Only local paths, to avoid open redirects
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+
+	if r.IsPOST() {
+		email, err := auth.Identity.Email(r)
+		if err != nil {
+			return fmt.Errorf("get consent user failed: %s", err)
+		}
+		if email == "" {
+			return auth.Identity.Login(r)
+		}
+
+		version := r.Req.FormValue("version")
+		if r.WantsJson() {
+			form := new(acceptForm)
+			if err := r.LoadJsonData(form); err != nil {
+				return err
+			}
+			version = form.Version
+		}
+
+		// The terms may change while the page is open
+		if version != fmt.Sprintf("%d", terms.Version) {
+			if r.WantsJson() {
+				return &app.AppError{Code: 409, Message: "the terms have changed"}
+			}
+			return r.Redirect(TermsPath + "?" + url.Values{"next": []string{next}}.Encode())
+		}
+		if err := Accept(r, email, terms.Version); err != nil {
+			return err
+		}
+		if r.WantsJson() {
+			return r.EmitJson(map[string]bool{"ok": true})
+		}
+		return r.Redirect(next)
+	}
+
+	page := &Page{Terms: terms, Next: next, Nonce: r.CSPNonce()}
+	if Templates != nil {
+		return r.Template(Templates, page)
+	}
+
+	data := struct {
+		*Page
+		Script template.HTML
+	}{page, r.PostScript()}
+	r.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := builtinPage.Execute(r.W, data); err != nil {
+		return fmt.Errorf("exec terms page failed: %s", err)
+	}
+	return nil
+}
+
+// Returns true if the user allowed the cookies of the category
+// Example: {{if cookie_allowed "analytics"}}...{{end}}
+func CookieAllowed(r *app.Request, category string) bool {
+	for _, allowed := range strings.Split(app.GetSecureCookie(r, cookieName), ",") {
+		if allowed == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Stores the cookie categories allowed by the user in the banner.
+// Register it in CookiesPath.
+// Example: "POST::/_/consent/cookies": consent.CookiesHandler,
+func CookiesHandler(r *app.Request) error {
+	prefs := new(cookiePrefs)
+	if err := r.LoadJsonData(prefs); err != nil {
+		return err
+	}
+
+	// The necessary cookies mark that the user already chose
+	allowed := []string{"necessary"}
+	for _, category := range prefs.Allowed {
+		for _, known := range CookieCategories {
+			if category == known {
+				allowed = append(allowed, category)
+			}
+		}
+	}
+	if err := app.SetSecureCookie(r, cookieName, strings.Join(allowed, ","), CookieTTL); err != nil {
+		return err
+	}
+
+	return r.EmitJson(map[string]bool{"ok": true})
+}
+
+var bannerTemplate = template.Must(template.New("banner").Parse(`<div id="cookie-banner">
+  <p>We use cookies to run the site and, if you allow them, to measure and improve it.</p>
+  {{range .Categories}}
+  <label><input type="checkbox" name="cookie-category" value="{{.}}"> {{.}}</label>
+  {{end}}
+  <button id="cookie-save">Save preferences</button>
+  <button id="cookie-all">Allow all</button>
+</div>
+{{.Script}}
+<script nonce="{{.Nonce}}">
+(function() {
+  function save(all) {
+    var allowed = [];
+    Array.prototype.forEach.call(document.querySelectorAll('input[name="cookie-category"]'), function(input) {
+      if (all || input.checked) {
+        allowed.push(input.value);
+      }
+    });
+    gaelib.post({{.Path}}, {allowed: allowed}, function(status) {
+      if (status == 200) {
+        document.getElementById('cookie-banner').style.display = 'none';
+      }
+    });
+  }
+  document.getElementById('cookie-save').addEventListener('click', function() { save(false); });
+  document.getElementById('cookie-all').addEventListener('click', function() { save(true); });
+})();
+</script>
+`))
+
+// Returns the cookie banner if the user hasn't chosen the preferences yet
+// Example: {{cookie_banner}}
+func cookieBanner(r *app.Request) (template.HTML, error) {
+	if app.GetSecureCookie(r, cookieName) != "" {
+		return "", nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	data := map[string]interface{}{
+		"Categories": CookieCategories,
+		"Path":       CookiesPath,
+		"Nonce":      r.CSPNonce(),
+		"Script":     r.PostScript(),
+	}
+	if err := bannerTemplate.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("exec cookie banner failed: %s", err)
+	}
+	return template.HTML(buf.String()), nil
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+	"github.com/gorilla/securecookie"
+)
+
+const KindCookieKeys = "SecureCookieKeys"
+
+var (
+	cookieMutex = &sync.RWMutex{}
+	cookieCodec *securecookie.SecureCookie
+)
+
+type cookieKeys struct {
+	HashKey  []byte `datastore:",noindex"`
+	BlockKey []byte `datastore:",noindex"`
+}
+
+type cookieValue struct {
+	Value   string
+	Expires int64
+}
+
+func newCookieCodec(hashKey, blockKey []byte) *securecookie.SecureCookie {
+	// The expiration is checked with the value of each cookie
+	return securecookie.New(hashKey, blockKey).MaxAge(0)
+}
+
+func deriveKey(secret, purpose string) []byte {
+	sum := sha256.Sum256([]byte(purpose + ":" + secret))
+	return sum[:]
+}
+
+// Sets the keys used to sign (HMAC) and encrypt (AES, 16, 24 or 32 bytes)
+// the secure cookies. A nil blockKey only signs them. By default they're
+// signed with a key derived from the XSRF secret of the application.
+// Call it at init().
+func SetCookieKeys(hashKey, blockKey []byte) {
+	cookieMutex.Lock()
+	defer cookieMutex.Unlock()
+
+	cookieCodec = newCookieCodec(hashKey, blockKey)
+}
+
+// Loads the secure cookies keys from the datastore, generating and
+// storing random ones the first time. Call it in the warmup request.
+func LoadCookieKeys(c appengine.Context) error {
+	keys := new(cookieKeys)
+	key := datastore.NewKey(c, KindCookieKeys, "default", 0, nil)
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		if err := datastore.Get(c, key, keys); err == nil {
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		keys.HashKey = securecookie.GenerateRandomKey(32)
+		keys.BlockKey = securecookie.GenerateRandomKey(32)
+		_, err := datastore.Put(c, key, keys)
+		return err
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("load cookie keys failed: %s", err)
+	}
+
+	SetCookieKeys(keys.HashKey, keys.BlockKey)
+	return nil
+}
+
+// Emits a cookie signed (and encrypted if configured) that can't be
+// tampered with by the client. It expires after ttl.
+func SetSecureCookie(r *Request, name, value string, ttl time.Duration) error {
+	cookieMutex.RLock()
+	codec := cookieCodec
+	cookieMutex.RUnlock()
+
+	v := &cookieValue{Value: value, Expires: time.Now().Add(ttl).Unix()}
+	encoded, err := codec.Encode(name, v)
+	if err != nil {
+		return fmt.Errorf("encode cookie failed: %s", err)
+	}
+
+	http.SetCookie(r.W, &http.Cookie{
+		Name:     name,
+		Value:    encoded,
+		Path:     "/",
+		Expires:  time.Now().Add(ttl),
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   !platform.Current.IsDevelopment(),
+	})
+	return nil
+}
+
+// Returns the value of a secure cookie, or an empty string if it's not
+// present, has expired or has been tampered with.
+func GetSecureCookie(r *Request, name string) string {
+	cookie, err := r.Req.Cookie(name)
+	if err != nil {
+		return ""
+	}
+
+	cookieMutex.RLock()
+	codec := cookieCodec
+	cookieMutex.RUnlock()
+
+	v := new(cookieValue)
+	if err := codec.Decode(name, cookie.Value, v); err != nil {
+		r.C.Warningf("[cookies] invalid cookie %s: %s", name, err)
+		return ""
+	}
+	if time.Now().Unix() > v.Expires {
+		return ""
+	}
+	return v.Value
+}
+
+// Removes a cookie from the client
+func DeleteCookie(r *Request, name string) {
+	http.SetCookie(r.W, &http.Cookie{
+		Name:   name,
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
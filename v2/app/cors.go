@@ -0,0 +1,129 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type CORSOptions struct {
+	// Origins allowed to call the endpoints (https://app.example.com),
+	// or "*" for any of them
+	Origins []string
+
+	// Methods & headers allowed in the requests. GET, POST & the
+	// Content-Type and X-Xsrf-Token headers by default.
+	Methods, Headers []string
+
+	// Response headers readable by the client
+	ExposeHeaders []string
+
+	// Time the browsers cache the preflight responses
+	MaxAge time.Duration
+
+	// Allow the cookies in the requests. It can't be used with "*".
+	Credentials bool
+}
+
+type corsRule struct {
+	prefix string
+	opts   *CORSOptions
+}
+
+var corsRules = []*corsRule{}
+
+// Enables CORS in the paths with the prefix ("/" for all of them). The
+// preflight requests are answered automatically. The requests of the
+// origins listed explicitly don't need the XSRF token, they can't read
+// its cookie. Call it at init().
+// Example:
+//    app.CORS("/api/", app.CORSOptions{
+//      Origins:     []string{"https://admin.example.com"},
+//      Methods:     []string{"GET", "POST", "DELETE"},
+//      Credentials: true,
+//    })
+func CORS(prefix string, opts CORSOptions) {
+	if opts.Credentials && opts.allows("*") {
+		panic("cors credentials can't be used with any origin: " + prefix)
+	}
+	if len(opts.Methods) == 0 {
+		opts.Methods = []string{"GET", "POST"}
+	}
+	if len(opts.Headers) == 0 {
+		opts.Headers = []string{"Content-Type", "X-Xsrf-Token"}
+	}
+
+	corsRules = append(corsRules, &corsRule{prefix: prefix, opts: &opts})
+
+	// The longest prefix has precedence
+	sort.Sort(byPrefixLength(corsRules))
+}
+
+type byPrefixLength []*corsRule
+
+func (s byPrefixLength) Len() int           { return len(s) }
+func (s byPrefixLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byPrefixLength) Less(i, j int) bool { return len(s[i].prefix) > len(s[j].prefix) }
+
+func (o *CORSOptions) allows(origin string) bool {
+	for _, allowed := range o.Origins {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Emits the CORS headers of the request. It returns true if the request
+// was a preflight one, already answered, and true in trusted if the
+// origin is one of the listed explicitly.
+func handleCORS(w http.ResponseWriter, req *http.Request) (preflight, trusted bool) {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return false, false
+	}
+
+	var opts *CORSOptions
+	for _, rule := range corsRules {
+		if strings.HasPrefix(req.URL.Path, rule.prefix) {
+			opts = rule.opts
+			break
+		}
+	}
+	if opts == nil {
+		return false, false
+	}
+
+	trusted = opts.allows(origin)
+	if !trusted && !opts.allows("*") {
+		return false, false
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if trusted {
+		h.Set("Access-Control-Allow-Origin", origin)
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+	}
+	if opts.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != "" {
+		h.Set("Access-Control-Allow-Methods", strings.Join(opts.Methods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(opts.Headers, ", "))
+		if opts.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", fmt.Sprintf("%d", int64(opts.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return true, trusted
+	}
+
+	if len(opts.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposeHeaders, ", "))
+	}
+	return false, trusted
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleCORS(t *testing.T) {
+	saved := corsRules
+	corsRules = []*corsRule{}
+	defer func() { corsRules = saved }()
+
+	CORS("/", CORSOptions{Origins: []string{"*"}})
+	CORS("/api/", CORSOptions{
+		Origins:       []string{"https://admin.example.com"},
+		Methods:       []string{"GET", "DELETE"},
+		ExposeHeaders: []string{"X-Total"},
+		MaxAge:        time.Hour,
+		Credentials:   true,
+	})
+
+	tests := []struct {
+		method, path, origin, requestMethod string
+
+		preflight, trusted bool
+		headers            map[string]string
+	}{
+		{"GET", "/api/items", "", "", false, false, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"GET", "/api/items", "https://admin.example.com", "", false, true, map[string]string{
+			"Access-Control-Allow-Origin":      "https://admin.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Expose-Headers":    "X-Total",
+			"Vary":                             "Origin",
+		}},
+		{"GET", "/api/items", "https://other.com", "", false, false, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"OPTIONS", "/api/items", "https://admin.example.com", "DELETE", true, true, map[string]string{
+			"Access-Control-Allow-Methods": "GET, DELETE",
+			"Access-Control-Allow-Headers": "Content-Type, X-Xsrf-Token",
+			"Access-Control-Max-Age":       "3600",
+		}},
+		{"OPTIONS", "/api/items", "https://admin.example.com", "", false, true, map[string]string{
+			"Access-Control-Allow-Methods": "",
+		}},
+		{"GET", "/public", "https://other.com", "", false, false, map[string]string{
+			"Access-Control-Allow-Origin":      "*",
+			"Access-Control-Allow-Credentials": "",
+		}},
+		{"OPTIONS", "/public", "https://other.com", "POST", true, false, map[string]string{
+			"Access-Control-Allow-Methods": "GET, POST",
+			"Access-Control-Max-Age":       "",
+		}},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if test.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", test.requestMethod)
+		}
+
+		w := httptest.NewRecorder()
+		preflight, trusted := handleCORS(w, req)
+		if preflight != test.preflight || trusted != test.trusted {
+			t.Errorf("%s %s from %q: got preflight %v & trusted %v, expected %v & %v", test.method,
+				test.path, test.origin, preflight, trusted, test.preflight, test.trusted)
+		}
+		if preflight && w.Code != http.StatusNoContent {
+			t.Errorf("%s %s from %q: got status %d in the preflight", test.method, test.path,
+				test.origin, w.Code)
+		}
+		for name, expected := range test.headers {
+			if got := w.Header().Get(name); got != expected {
+				t.Errorf("%s %s from %q: got %s %q, expected %q", test.method, test.path,
+					test.origin, name, got, expected)
+			}
+		}
+	}
+}
+
+func TestCORSCredentialsWithAnyOrigin(t *testing.T) {
+	saved := corsRules
+	defer func() {
+		corsRules = saved
+		if recover() == nil {
+			t.Errorf("expected a panic with credentials and any origin")
+		}
+	}()
+
+	CORS("/", CORSOptions{Origins: []string{"*"}, Credentials: true})
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+// Number of consecutive failures of a cron job before
+// emailing the admins
+var CronFailuresThreshold uint64 = 3
+
+// Registers the handler of a cron job. Requests without the cron header
+// are rejected. Call it at init().
+// Example: app.Cron("/tasks/cleanup", tasks.Cleanup)
+func Cron(path string, h Handler) {
+	recordRoute("cron", newRouteGroup(), true, "GET", path, h)
+	rootRouter().Handle(path, appstatsWrapper(func(r *Request) error {
+		// App Engine removes this header from the external requests
+		if r.Req.Header.Get("X-AppEngine-Cron") != "true" {
+			r.C.Errorf("[cron] request without cron header: %s", path)
+			return Forbidden()
+		}
+
+		r.C.Infof("[cron] %s started", path)
+		start := time.Now()
+		err := h(r)
+		r.C.Infof("[cron] %s finished in %s", path, time.Since(start))
+
+		key := "cron-failures:" + path
+		st := store.New(r.C)
+		if err == nil {
+			if err := st.Delete(key); err != nil {
+				r.C.Warningf("[cron] reset failures failed: %s", err)
+			}
+			return nil
+		}
+
+		failures, cerr := st.Increment(key, 1, 0)
+		if cerr != nil {
+			r.C.Warningf("[cron] count failures failed: %s", cerr)
+		} else if failures == CronFailuresThreshold {
+			sendErrorByEmail(r.C, fmt.Sprintf("cron job %s failed %d consecutive times: %s",
+				path, failures, err))
+		}
+
+		return fmt.Errorf("cron job %s failed: %s", path, err)
+	})).Methods("GET")
+}
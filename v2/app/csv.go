@@ -0,0 +1,295 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts accepted in the time columns of LoadCSV
+var CSVTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// Streams a CSV file to the client as an attachment with the filename.
+// rows should call yield with each row, in the order of the header. The
+// cells that spreadsheets would run as formulas are prefixed with '.
+// Example:
+//    return r.EmitCSV("orders.csv", []string{"ID", "Total"}, func(yield func([]string)) error {
+//      for _, o := range orders {
+//        yield([]string{strconv.FormatInt(o.ID, 10), o.Total})
+//      }
+//      return nil
+//    })
+func (r *Request) EmitCSV(filename string, header []string, rows func(yield func([]string)) error) error {
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	r.W.Header().Set("Content-Disposition", disposition)
+
+	return r.Stream("text/csv; charset=utf-8", func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(escapeCSVRow(header)); err != nil {
+			return fmt.Errorf("write csv header failed: %s", err)
+		}
+
+		// Keep the first error, the rows can't stop the producer
+		var writeErr error
+		yield := func(row []string) {
+			if writeErr == nil {
+				writeErr = cw.Write(escapeCSVRow(row))
+			}
+		}
+		if err := rows(yield); err != nil {
+			return err
+		}
+		if writeErr != nil {
+			return fmt.Errorf("write csv row failed: %s", writeErr)
+		}
+
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("flush csv failed: %s", err)
+		}
+		return nil
+	})
+}
+
+// Decodes an uploaded CSV (the first file of a multipart form, or the
+// body of the request) into dest, a pointer to a slice of structs or
+// pointers to structs. The first line has the names of the columns,
+// matched without case with the csv tags of the fields (or their names);
+// the unknown columns are ignored. The items are checked with their
+// valid tags after decoding them (see Validate).
+// Example:
+//    type importedUser struct {
+//      Email string `csv:"E-mail" valid:"required,email"`
+//      Age   int    `csv:"Age"`
+//    }
+//    users := []*importedUser{}
+//    if err := r.LoadCSV(&users); err != nil {
+//      return err
+//    }
+func (r *Request) LoadCSV(dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		panic("csv destination should be a pointer to a slice")
+	}
+	slice := v.Elem()
+	itemType := slice.Type().Elem()
+	structType := itemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		panic("csv destination items should be structs")
+	}
+
+	content, err := r.csvContent()
+	if err != nil {
+		return err
+	}
+
+	cr := csv.NewReader(bytes.NewReader(content))
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return BadRequest("", "the csv file is empty")
+	} else if err != nil {
+		return BadRequest("", fmt.Sprintf("invalid csv: %s", err))
+	}
+
+	// Field of each column, -1 for the unknown ones
+	fields := map[string]int{}
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if name := csvName(field); field.PkgPath == "" && name != "-" {
+			fields[strings.ToLower(name)] = i
+		}
+	}
+	columns := make([]int, len(header))
+	for i, name := range header {
+		// Excel adds a BOM at the start of the UTF-8 files
+		name = strings.TrimPrefix(name, "\ufeff")
+		header[i] = name
+
+		columns[i] = -1
+		if f, ok := fields[strings.ToLower(strings.TrimSpace(name))]; ok {
+			if t := structType.Field(f).Type; !csvSupported(t) {
+				return fmt.Errorf("csv field type not supported: %s", t)
+			}
+			columns[i] = f
+		}
+	}
+
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return BadRequest("", fmt.Sprintf("invalid csv: %s", err))
+		}
+
+		item := reflect.New(structType)
+		for i, value := range record {
+			if i >= len(columns) || columns[i] == -1 {
+				continue
+			}
+			if err := setCSVValue(item.Elem().Field(columns[i]), strings.TrimSpace(value)); err != nil {
+				return &AppError{
+					Code:    400,
+					Message: fmt.Sprintf("line %d: %s", line, err),
+					Field:   header[i],
+					Offset:  int64(line),
+				}
+			}
+		}
+
+		if itemType.Kind() == reflect.Ptr {
+			slice.Set(reflect.Append(slice, item))
+		} else {
+			slice.Set(reflect.Append(slice, item.Elem()))
+		}
+	}
+
+	return validate(dest, csvName)
+}
+
+// Returns the contents of the uploaded file or the body
+func (r *Request) csvContent() ([]byte, error) {
+	if strings.HasPrefix(r.Req.Header.Get("Content-Type"), "multipart/form-data") {
+		uploads, err := r.ParseUploads()
+		if err != nil {
+			return nil, err
+		}
+		if len(uploads) == 0 {
+			return nil, BadRequest("", "the csv file is missing")
+		}
+		f, err := uploads[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readCSVContent(f)
+	}
+
+	return readCSVContent(r.Req.Body)
+}
+
+// Reads the whole CSV file, it can't be bigger than MaxUploadMemory
+func readCSVContent(body io.Reader) ([]byte, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(body, MaxUploadMemory+1))
+	if err != nil {
+		return nil, fmt.Errorf("read csv body failed: %s", err)
+	}
+	if int64(len(content)) > MaxUploadMemory {
+		return nil, &AppError{Code: 413, Message: "the csv file is too large"}
+	}
+	return content, nil
+}
+
+// Returns the name of the column of the field in the CSV files
+func csvName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("csv"), ",")[0]; name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// Converts the text of a CSV cell to the type of the field
+func setCSVValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.Ptr {
+		if value == "" {
+			return nil
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	if value == "" {
+		return nil
+	}
+
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		for _, layout := range CSVTimeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				v.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid date: %s", value)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.ToLower(value))
+		if err != nil {
+			return fmt.Errorf("invalid boolean: %s", value)
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer: %s", value)
+		}
+		v.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number: %s", value)
+		}
+		v.SetUint(n)
+
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number: %s", value)
+		}
+		v.SetFloat(n)
+
+	default:
+		return fmt.Errorf("csv field type not supported: %s", v.Type())
+	}
+	return nil
+}
+
+// Returns true if setCSVValue can decode the values of the type
+func csvSupported(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Returns the row with the cells that start like a formula prefixed
+// with ', so the spreadsheets show them as text
+func escapeCSVRow(row []string) []string {
+	escaped := make([]string, len(row))
+	for i, cell := range row {
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cell = "'" + cell
+		}
+		escaped[i] = cell
+	}
+	return escaped
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscapeCSVRow(t *testing.T) {
+	row := []string{"=SUM(A1:A2)", "+1", "-2", "@cmd", "\tx", "plain", "", "a=b", "'quoted"}
+	expected := []string{"'=SUM(A1:A2)", "'+1", "'-2", "'@cmd", "'\tx", "plain", "", "a=b", "'quoted"}
+	if got := escapeCSVRow(row); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestSetCSVValue(t *testing.T) {
+	var item struct {
+		Name    string
+		Count   *int
+		Created time.Time
+		Tags    []string
+	}
+	v := reflect.ValueOf(&item).Elem()
+
+	if err := setCSVValue(v.Field(0), "Jane"); err != nil || item.Name != "Jane" {
+		t.Errorf("string: %v, %q", err, item.Name)
+	}
+	if err := setCSVValue(v.Field(1), "3"); err != nil || item.Count == nil || *item.Count != 3 {
+		t.Errorf("pointer: %v, %v", err, item.Count)
+	}
+	if err := setCSVValue(v.Field(1), "x"); err == nil {
+		t.Errorf("invalid integer accepted")
+	}
+	if err := setCSVValue(v.Field(2), "2014-03-01"); err != nil || item.Created.Day() != 1 {
+		t.Errorf("time: %v, %s", err, item.Created)
+	}
+	if err := setCSVValue(v.Field(3), "a"); err == nil {
+		t.Errorf("unsupported type accepted")
+	}
+
+	for i, supported := range []bool{true, true, true, false} {
+		if got := csvSupported(v.Field(i).Type()); got != supported {
+			t.Errorf("field %d: supported %v, expected %v", i, got, supported)
+		}
+	}
+}
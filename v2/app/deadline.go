@@ -0,0 +1,99 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+)
+
+const defaultStillWorkingPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta http-equiv="refresh" content="{{.Seconds}};url={{.RefreshUrl}}">
+  <title>Still working</title>
+</head>
+<body>
+  <h1>Still working</h1>
+  <p>{{.Message}}</p>
+  <p>This page will refresh automatically.</p>
+</body>
+</html>
+`
+
+var (
+	// Default time a request has to answer. It should be smaller than the
+	// App Engine limit to emit the page before the instance is interrupted.
+	RequestDeadline = 50 * time.Second
+
+	stillWorkingPage = template.Must(template.New("stillworking").Parse(defaultStillWorkingPage))
+)
+
+// Sets the page emitted by StillWorking. It's parsed once, so call it at
+// init(); it panics if the page is not a valid template. The data has
+// the Message, JobId, RefreshUrl and Seconds fields.
+func SetStillWorkingPage(page string) {
+	stillWorkingPage = template.Must(template.New("stillworking").Parse(page))
+}
+
+// Decorates the handler to use a custom deadline for the route.
+// Example: "::/reports": app.Deadline(20*time.Second, reports.Build),
+func Deadline(d time.Duration, h Handler) Handler {
+	return Wrap(h, func(r *Request) error {
+		r.deadline = r.start.Add(d)
+		return h(r)
+	})
+}
+
+// Time remaining before the request deadline
+func (r *Request) TimeLeft() time.Duration {
+	return r.deadline.Sub(time.Now())
+}
+
+// Returns true if the request has less than margin time left
+// before the deadline
+func (r *Request) NearDeadline(margin time.Duration) bool {
+	return r.TimeLeft() < margin
+}
+
+// Emits a "still working" page that refreshes the current URL after some
+// seconds adding the job param, so the handler can check the partial
+// results of the job in the next request.
+// It returns a nil error always for easy of use inside the handlers.
+// Example:
+//    if r.NearDeadline(5 * time.Second) {
+//      return r.StillWorking(jobId, "Your report is being built", 5)
+//    }
+func (r *Request) StillWorking(jobId, message string, seconds int) error {
+	u := *r.Req.URL
+	query := u.Query()
+	query.Set("job", jobId)
+	u.RawQuery = query.Encode()
+
+	data := map[string]interface{}{
+		"Message":    message,
+		"JobId":      jobId,
+		"RefreshUrl": u.String(),
+		"Seconds":    seconds,
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := stillWorkingPage.Execute(buf, data); err != nil {
+		return fmt.Errorf("exec still working page failed: %s", err)
+	}
+
+	if rw, ok := r.W.(*responseWriter); ok {
+		rw.reset()
+	}
+	r.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+	r.W.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+	r.W.WriteHeader(202)
+	buf.WriteTo(r.W)
+
+	return nil
+}
+
+// Returns the job ID added by StillWorking to the refreshed URL
+func (r *Request) JobId() string {
+	return r.Req.URL.Query().Get("job")
+}
@@ -1,10 +1,27 @@
 package app
 
 import (
+	"bytes"
 	"fmt"
+	"html/template"
+	"net/http"
 
 	"appengine"
 	"appengine/taskqueue"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+const defaultFallbackPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Code}} {{.Text}}</title></head>
+<body><h1>{{.Code}} {{.Text}}</h1></body>
+</html>
+`
+
+var (
+	fallbackPages   = map[int]*template.Template{}
+	defaultFallback = template.Must(template.New("fallback").Parse(defaultFallbackPage))
 )
 
 type HttpError int
@@ -13,6 +30,10 @@ func (e HttpError) Error() string {
 	return fmt.Sprintf("http error %d", e)
 }
 
+func Unauthorized() error {
+	return HttpError(401)
+}
+
 func Forbidden() error {
 	return HttpError(403)
 }
@@ -25,8 +46,40 @@ func NotAllowed() error {
 	return HttpError(405)
 }
 
+// Error with a description safe to show to the user, returned in the
+// JSON error envelope with the field & offset of the invalid input
+type AppError struct {
+	Code    int
+	Message string
+	Field   string
+	Offset  int64
+}
+
+func (e *AppError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("http error %d: %s (field %s)", e.Code, e.Message, e.Field)
+	}
+	return fmt.Sprintf("http error %d: %s", e.Code, e.Message)
+}
+
+// Returns a 400 error with a description safe to show to the user
+// Example: return app.BadRequest("email", "the email is not valid")
+func BadRequest(field, message string) error {
+	return &AppError{Code: 400, Message: message, Field: field}
+}
+
+// Transaction that failed all its attempts because other ones modified
+// the same entities. It's answered with a 503 and a Retry-After header.
+type ContentionError struct {
+	Attempts int
+}
+
+func (e *ContentionError) Error() string {
+	return fmt.Sprintf("datastore contention after %d attempts", e.Attempts)
+}
+
 func sendErrorByEmail(c appengine.Context, errorStr string) {
-	if appengine.IsDevAppServer() {
+	if platform.Current.IsDevelopment() {
 		return
 	}
 
@@ -37,3 +90,39 @@ func sendErrorByEmail(c appengine.Context, errorStr string) {
 		c.Errorf("cannot prepare error mail: %s", err.Error())
 	}
 }
+
+// Sets the page emitted when there's no error handler for the code or the
+// handler fails. It's parsed once, so call it at init(); it panics if the
+// page is not a valid template. The data has the Code and Text fields.
+// Use code 0 to replace the default page for all the codes.
+func SetFallbackErrorPage(code int, page string) {
+	t := template.Must(template.New(fmt.Sprintf("fallback%d", code)).Parse(page))
+	if code == 0 {
+		defaultFallback = t
+		return
+	}
+	fallbackPages[code] = t
+}
+
+// Writes the precompiled error page of the code without touching
+// the templates loader or the filesystem
+func writeFallbackError(w http.ResponseWriter, code int) {
+	t, ok := fallbackPages[code]
+	if !ok {
+		t = defaultFallback
+	}
+
+	data := map[string]interface{}{
+		"Code": code,
+		"Text": http.StatusText(code),
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := t.Execute(buf, data); err != nil {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	buf.WriteTo(w)
+}
@@ -0,0 +1,215 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"appengine/datastore"
+)
+
+// Types of the values of the fields
+const (
+	String = iota
+	Int
+	Float
+	Bool
+	Time
+)
+
+// Field allowed in the queries
+type Field struct {
+	Type int
+
+	// Name of the property or search field; the name used in the
+	// queries by default
+	Property string
+}
+
+// Fields allowed in the queries by their name
+// Example:
+//    var userFilters = filter.Schema{
+//      "status":  {Type: filter.String},
+//      "created": {Type: filter.Time, Property: "CreatedAt"},
+//    }
+type Schema map[string]*Field
+
+// Returned when the query is not valid, to show it to the user
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "bad filter: " + e.Message
+}
+
+func errorf(format string, args ...interface{}) error {
+	return &Error{fmt.Sprintf(format, args...)}
+}
+
+// Comparison of a field with a value
+type Term struct {
+	Field    string
+	Property string
+	Op       string
+	Value    interface{}
+}
+
+// Parsed query
+type Query struct {
+	Terms []*Term
+
+	// Free text: single words and quoted phrases
+	Words, Phrases []string
+}
+
+var termRe = regexp.MustCompile(`^([A-Za-z_][\w.]*)(:|>=|<=|>|<|=)(.+)$`)
+
+// Parses a query like: status:active created>2014-01-01 "exact phrase"
+func Parse(text string, schema Schema) (*Query, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	q := new(Query)
+	for _, token := range tokens {
+		if strings.HasPrefix(token, `"`) {
+			q.Phrases = append(q.Phrases, unquote(token))
+			continue
+		}
+
+		m := termRe.FindStringSubmatch(token)
+		if m == nil {
+			q.Words = append(q.Words, token)
+			continue
+		}
+
+		field, ok := schema[m[1]]
+		if !ok {
+			return nil, errorf("unknown field %s", m[1])
+		}
+		value, err := parseValue(field.Type, unquote(m[3]))
+		if err != nil {
+			return nil, errorf("bad value of %s: %s", m[1], m[3])
+		}
+		op := m[2]
+		if op == ":" {
+			op = "="
+		}
+		if field.Type == Bool && op != "=" {
+			return nil, errorf("%s can only be compared with :", m[1])
+		}
+
+		property := field.Property
+		if property == "" {
+			property = m[1]
+		}
+		q.Terms = append(q.Terms, &Term{Field: m[1], Property: property, Op: op, Value: value})
+	}
+
+	return q, nil
+}
+
+// Splits the query by spaces, keeping the quoted texts together
+func tokenize(text string) ([]string, error) {
+	tokens := []string{}
+	current := ""
+	quoted := false
+	for _, c := range text {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			current += string(c)
+		case (c == ' ' || c == '\t') && !quoted:
+			if current != "" {
+				tokens = append(tokens, current)
+			}
+			current = ""
+		default:
+			current += string(c)
+		}
+	}
+	if quoted {
+		return nil, errorf("unclosed quote")
+	}
+	if current != "" {
+		tokens = append(tokens, current)
+	}
+	return tokens, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func parseValue(t int, s string) (interface{}, error) {
+	switch t {
+	case Int:
+		return strconv.ParseInt(s, 10, 64)
+	case Float:
+		return strconv.ParseFloat(s, 64)
+	case Bool:
+		return strconv.ParseBool(s)
+	case Time:
+		if v, err := time.Parse("2006-01-02", s); err == nil {
+			return v, nil
+		}
+		return time.Parse(time.RFC3339, s)
+	}
+	return s, nil
+}
+
+// Applies the terms to the datastore query. The datastore doesn't
+// search free text, and only allows inequalities in one property
+// that should be the first sort order of q.
+func (q *Query) Datastore(dq *datastore.Query) (*datastore.Query, error) {
+	if len(q.Words) > 0 || len(q.Phrases) > 0 {
+		return nil, errorf("free text is not supported, use field:value")
+	}
+
+	inequality := ""
+	for _, t := range q.Terms {
+		if t.Op != "=" {
+			if inequality != "" && inequality != t.Property {
+				return nil, errorf("only one field can be compared with < or >")
+			}
+			inequality = t.Property
+		}
+		dq = dq.Filter(t.Property+" "+t.Op, t.Value)
+	}
+	return dq, nil
+}
+
+// Returns the query string of the Search API
+func (q *Query) Search() string {
+	parts := []string{}
+	for _, t := range q.Terms {
+		parts = append(parts, t.Property+t.Op+searchValue(t.Value))
+	}
+	for _, w := range q.Words {
+		parts = append(parts, searchValue(w))
+	}
+	for _, p := range q.Phrases {
+		parts = append(parts, searchValue(p))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Quotes the values to avoid injecting operators in the query
+func searchValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return `"` + strings.Replace(strings.Replace(v, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+	case time.Time:
+		return v.Format("2006-01-02")
+	case bool:
+		return fmt.Sprintf(`"%t"`, v)
+	}
+	return fmt.Sprintf("%v", v)
+}
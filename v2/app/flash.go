@@ -0,0 +1,50 @@
+package app
+
+import (
+	"encoding/gob"
+	"html/template"
+)
+
+// Message shown to the user in the next rendered page
+type Flash struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+func init() {
+	gob.Register(&Flash{})
+
+	RegisterTemplateFuncs(template.FuncMap{
+		// Pending flash messages, cleared after reading them. It's only
+		// available in the templates executed with Request.Template.
+		// Example: {{range flashes}}<div class="alert alert-{{.Kind}}">{{.Message}}</div>{{end}}
+		"flashes": func() []*Flash {
+			return nil
+		},
+	})
+}
+
+// Stores a flash message in the session (success, error, info, etc.)
+func (r *Request) AddFlash(kind, message string) {
+	r.Session.AddFlash(&Flash{Kind: kind, Message: message})
+}
+
+// Returns the pending flash messages, removing them from the session
+func (r *Request) Flashes() []*Flash {
+	flashes := []*Flash{}
+	for _, f := range r.Session.Flashes() {
+		if flash, ok := f.(*Flash); ok {
+			flashes = append(flashes, flash)
+		}
+	}
+	return flashes
+}
+
+// Emits the data like EmitJson including the pending flash messages:
+//    {"data": ..., "flashes": [{"kind": "success", "message": "..."}]}
+func (r *Request) EmitJsonWithFlashes(data interface{}) error {
+	return r.EmitJson(map[string]interface{}{
+		"data":    data,
+		"flashes": r.Flashes(),
+	})
+}
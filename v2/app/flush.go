@@ -0,0 +1,102 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"appengine"
+)
+
+// Maximum time the buffered data of an instance waits for a flush. The
+// instances with automatic scaling are recycled without notice, so the
+// flushers run too at the end of the first task or cron request the
+// instance receives after the interval; the user requests never pay
+// for them. Schedule FlushHandler to have those requests. Zero disables
+// the periodic flushes.
+var FlushInterval = time.Minute
+
+type flusher struct {
+	name string
+	fn   func(c appengine.Context) error
+}
+
+var (
+	flushMutex = &sync.Mutex{}
+	flushers   []*flusher
+	lastFlush  = time.Now()
+
+	// Serializes the flushes without blocking the checks of the requests
+	flushRunMutex = &sync.Mutex{}
+)
+
+// Registers a function that sends the data buffered in the instance
+// (metrics, analytics events, audit logs...) to its storage. It runs in
+// the stop requests (/_ah/stop) and every FlushInterval (see
+// FlushHandler); an error keeps
+// the data for the next flush. Call it at init().
+// Example: app.RegisterFlusher("metrics", metrics.Flush)
+func RegisterFlusher(name string, fn func(c appengine.Context) error) {
+	flushMutex.Lock()
+	flushers = append(flushers, &flusher{name: name, fn: fn})
+	flushMutex.Unlock()
+
+	stopRoute()
+}
+
+// Runs the flushers if the last flush is older than FlushInterval
+func maybeFlush(c appengine.Context) {
+	if FlushInterval == 0 {
+		return
+	}
+
+	flushMutex.Lock()
+	due := len(flushers) > 0 && time.Since(lastFlush) > FlushInterval
+	if due {
+		// The concurrent requests don't flush again
+		lastFlush = time.Now()
+	}
+	flushMutex.Unlock()
+	if due {
+		flushAll(c, "interval")
+	}
+}
+
+// Runs the flushers of the instance that receives the request. Schedule
+// it in cron.yaml every FlushInterval; the tasks and the cron requests
+// flush their instances too when the interval is due.
+// Example: app.Cron("/tasks/flush", app.FlushHandler)
+func FlushHandler(r *Request) error {
+	flushAll(r.C, "cron")
+	return nil
+}
+
+// Runs all the flushers, one at a time per instance, recovering their
+// panics so the next ones run too
+func flushAll(c appengine.Context, reason string) {
+	flushRunMutex.Lock()
+	defer flushRunMutex.Unlock()
+
+	flushMutex.Lock()
+	lastFlush = time.Now()
+	list := append([]*flusher(nil), flushers...)
+	flushMutex.Unlock()
+
+	for _, f := range list {
+		start := time.Now()
+		if err := runFlusher(c, f); err != nil {
+			c.Errorf("[flush] %s flusher %s failed: %s", reason, f.name, err)
+			continue
+		}
+		c.Infof("[flush] %s flusher %s took %s", reason, f.name, time.Since(start))
+	}
+}
+
+func runFlusher(c appengine.Context, f *flusher) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic recovered error: %s", rec)
+		}
+	}()
+	return f.fn(c)
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/cache"
+)
+
+var (
+	dependenciesMutex sync.RWMutex
+
+	// Fragments keys by the kinds they depend on
+	dependencies = map[string][]string{}
+)
+
+func init() {
+	RegisterRequestTemplateFuncs(func(r *Request) template.FuncMap {
+		return template.FuncMap{
+			// Renders the partial template with the data, caching the
+			// result by key & language for the seconds.
+			// Example: {{cache "sidebar" 300 "partials/sidebar" .}}
+			"cache": func(key string, seconds int, name string, data interface{}) (template.HTML, error) {
+				return r.cachedFragment(key, time.Duration(seconds)*time.Second, name, data)
+			},
+		}
+	})
+}
+
+func (r *Request) cachedFragment(key string, ttl time.Duration, name string, data interface{}) (template.HTML, error) {
+	ckey := cache.Key(r.C, "fragment:"+key, cacheLanguage(r))
+	var content []byte
+	if err := cache.Get(r.C, ckey, &content); err == nil {
+		return template.HTML(bytes.Replace(content, []byte(noncePlaceholder), []byte(r.CSPNonce()), -1)), nil
+	} else if err != cache.ErrMiss {
+		r.C.Warningf("[cache] cannot read fragment %s: %s", key, err)
+	}
+
+	set, err := loadTemplate("templates", []string{name})
+	if err != nil {
+		return "", err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := set.execute(buf, filepath.Base(name)+".html", data, r.templateFuncs()); err != nil {
+		return "", fmt.Errorf("exec fragment %s failed: %s", key, err)
+	}
+
+	content = buf.Bytes()
+	if r.cspNonce != "" {
+		content = bytes.Replace(content, []byte(r.cspNonce), []byte(noncePlaceholder), -1)
+	}
+	if err := cache.Set(r.C, ckey, content, ttl); err != nil {
+		r.C.Warningf("[cache] cannot cache fragment %s: %s", key, err)
+	}
+
+	return template.HTML(buf.String()), nil
+}
+
+// Removes the cached fragment of the key, in all the languages
+func PurgeFragment(c appengine.Context, key string) error {
+	return cache.Invalidate(c, "fragment:"+key)
+}
+
+// Declares that the fragment shows entities of the kinds, so
+// InvalidateKind purges it. Call it at init().
+// Example: app.FragmentDependsOn("popular-posts", "Post", "Comment")
+func FragmentDependsOn(key string, kinds ...string) {
+	dependenciesMutex.Lock()
+	defer dependenciesMutex.Unlock()
+
+	for _, kind := range kinds {
+		dependencies[kind] = append(dependencies[kind], key)
+	}
+}
+
+// Purges the fragments that depend on the kind. Call it after
+// modifying its entities.
+func InvalidateKind(c appengine.Context, kind string) error {
+	dependenciesMutex.RLock()
+	keys := dependencies[kind]
+	dependenciesMutex.RUnlock()
+
+	for _, key := range keys {
+		if err := PurgeFragment(c, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
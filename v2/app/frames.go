@@ -0,0 +1,69 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Name of the query string param with the frame token
+const frameTokenParam = "ft"
+
+type frameToken struct {
+	Origin  string
+	Prefix  string
+	Expires int64
+}
+
+// Returns a signed token that allows the partner origin to embed the
+// routes under the path prefix in an iframe until the ttl expires. Add it
+// to the URL of the iframe with the ft param. It's signed with the
+// secrets loaded by the router, so call it from the handlers.
+// Example: token, err := app.MintFrameToken("https://partner.com", "/widgets/", 24*time.Hour)
+func MintFrameToken(origin, prefix string, ttl time.Duration) (string, error) {
+	t := &frameToken{
+		Origin:  origin,
+		Prefix:  prefix,
+		Expires: time.Now().Add(ttl).Unix(),
+	}
+	keys := currentSecrets()
+	if keys == nil {
+		return "", fmt.Errorf("mint frame token failed: the secrets are not loaded")
+	}
+	return securecookie.EncodeMulti("FRAME-TOKEN", t, keys.frames...)
+}
+
+// Decorates the handler of an embeddable widget. Requests with a valid
+// frame token can be framed by the origin of the token; any other one
+// is protected against clickjacking.
+// Example: "GET::/widgets/calendar": app.Embeddable(widgets.Calendar),
+func Embeddable(h Handler) Handler {
+	return Wrap(h, func(r *Request) error {
+		header := r.W.Header()
+		keys, err := loadSecrets(r.C)
+		if err != nil {
+			return err
+		}
+
+		t := new(frameToken)
+		encoded := r.Req.URL.Query().Get(frameTokenParam)
+		err = securecookie.DecodeMulti("FRAME-TOKEN", encoded, t, keys.frames...)
+		if encoded == "" || err != nil || time.Now().Unix() > t.Expires ||
+			!strings.HasPrefix(r.Req.URL.Path, t.Prefix) {
+			if encoded != "" {
+				r.C.Errorf("[frames] invalid frame token: %v", err)
+			}
+			header.Set("X-Frame-Options", "DENY")
+			setCSPDirective(header, "frame-ancestors", "'none'")
+			return h(r)
+		}
+
+		// X-Frame-Options doesn't support a list of origins in all the
+		// browsers; frame-ancestors replaces it in the modern ones.
+		header.Del("X-Frame-Options")
+		setCSPDirective(header, "frame-ancestors", t.Origin)
+		return h(r)
+	})
+}
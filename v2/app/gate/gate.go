@@ -0,0 +1,319 @@
+package gate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/auth"
+	"github.com/ernestokarim/gaelib/v2/cache"
+)
+
+const (
+	KindAllowed  = "GateAllowed"
+	KindInvite   = "GateInvite"
+	KindInterest = "GateInterest"
+)
+
+var (
+	// Templates of the signup interest page, rendered with Page as data.
+	// Without them a plain builtin page is used.
+	Templates []string
+
+	// Route of InterestHandler, where the page posts the emails
+	InterestPath = "/_/gate/interest"
+
+	// Query param with the invite codes
+	InviteParam = "invite"
+
+	// Time the allowed emails are cached
+	CacheTTL = 10 * time.Minute
+)
+
+const sessionInvite = "gate-invite"
+
+// Email of the allowlist, stored with the lowercase email as its ID
+type Allowed struct {
+	Email   string
+	Created time.Time
+	Invite  string
+}
+
+// Invite code, stored with the code as its ID. A zero MaxUses has no limit.
+type Invite struct {
+	Uses, MaxUses int
+	Created       time.Time
+}
+
+// Email left in the signup interest page, stored with the lowercase
+// email as its ID
+type Interest struct {
+	Email   string
+	Path    string
+	Created time.Time
+}
+
+// Data of the signup interest page
+type Page struct {
+	Path, InterestPath string
+	Nonce              string
+}
+
+// Email captured in the interest page
+type interestForm struct {
+	Email string `json:"email" valid:"required,email,max=200"`
+	Path  string `json:"path" valid:"max=500"`
+}
+
+var builtinPage = template.Must(template.New("gate").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Coming soon</title></head>
+<body>
+<h1>Coming soon</h1>
+<p>We're in private beta. Leave your email and we'll let you know when it's ready.</p>
+<form action="{{.InterestPath}}" data-post data-result="gate-result"
+    data-ok="Thanks! We will write you soon." data-error="Please check the email and try again.">
+  <input type="hidden" name="path" value="{{.Path}}">
+  <input type="email" name="email" required maxlength="200">
+  <button type="submit">Notify me</button>
+</form>
+<p id="gate-result"></p>
+{{.Script}}
+</body>
+</html>
+`))
+
+// Decorates the handler to allow only the admins, the users in the
+// allowlist and the ones with a valid invite code (?invite=CODE, kept in
+// the session and added to the allowlist when they log in). The rest
+// see the signup interest page, with a 401 status for the anonymous
+// users and a 403 for the logged ones.
+// Example: "::/": gate.Gate(pages.Home),
+func Gate(h app.Handler) app.Handler {
+	return app.Wrap(h, func(r *app.Request) error {
+		if user.IsAdmin(r.C) {
+			return h(r)
+		}
+
+		email, err := auth.Identity.Email(r)
+		if err != nil {
+			return fmt.Errorf("get gate user failed: %s", err)
+		}
+
+		if email != "" {
+			if ok, err := IsAllowed(r.C, email); err != nil {
+				return err
+			} else if ok {
+				return h(r)
+			}
+		}
+
+		// The invites of the session were redeemed already
+		if code, _ := r.Session.Values[sessionInvite].(string); code != "" {
+			if email != "" {
+				delete(r.Session.Values, sessionInvite)
+				if err := allow(r.C, email, code); err != nil {
+					return err
+				}
+			}
+			return h(r)
+		}
+
+		if code := r.Req.URL.Query().Get(InviteParam); code != "" {
+			ok, err := redeem(r, code, email)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return h(r)
+			}
+		}
+
+		if email == "" {
+			return interestPage(r, http.StatusUnauthorized)
+		}
+		return interestPage(r, http.StatusForbidden)
+	})
+}
+
+// Handler of the emails posted by the interest page. Register it in
+// InterestPath.
+// Example: "POST::/_/gate/interest": gate.InterestHandler,
+func InterestHandler(r *app.Request) error {
+	form := new(interestForm)
+	if err := r.LoadJsonData(form); err != nil {
+		return err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(form.Email))
+	interest := &Interest{
+		Email:   email,
+		Path:    form.Path,
+		Created: time.Now(),
+	}
+	key := datastore.NewKey(r.C, KindInterest, email, 0, nil)
+	if _, err := datastore.Put(r.C, key, interest); err != nil {
+		return fmt.Errorf("put interest failed: %s", err)
+	}
+
+	return r.EmitJson(map[string]bool{"ok": true})
+}
+
+// Shows the signup interest page with the status. The templates post
+// the emails with the forms of {{post_script}}.
+func interestPage(r *app.Request, status int) error {
+	page := &Page{
+		Path:         r.Path(),
+		InterestPath: InterestPath,
+		Nonce:        r.CSPNonce(),
+	}
+	if Templates != nil {
+		r.W.WriteHeader(status)
+		return r.Template(Templates, page)
+	}
+
+	data := struct {
+		*Page
+		Script template.HTML
+	}{page, r.PostScript()}
+	r.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+	r.W.WriteHeader(status)
+	if err := builtinPage.Execute(r.W, data); err != nil {
+		return fmt.Errorf("exec gate page failed: %s", err)
+	}
+	return nil
+}
+
+// Returns true if the invite is valid, counting its use. The code is
+// kept in the session until the user logs in, when the email is added
+// to the allowlist.
+func redeem(r *app.Request, code, email string) (bool, error) {
+	c := r.C
+	used := false
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		used = false
+		key := datastore.NewKey(c, KindInvite, code, 0, nil)
+		invite := new(Invite)
+		if err := datastore.Get(c, key, invite); err != nil {
+			return err
+		}
+		if invite.MaxUses > 0 && invite.Uses >= invite.MaxUses {
+			return nil
+		}
+		used = true
+
+		invite.Uses++
+		_, err := datastore.Put(c, key, invite)
+		return err
+	}, nil)
+	if err == datastore.ErrNoSuchEntity {
+		c.Warningf("[gate] invite not found: %s", code)
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("redeem invite failed: %s", err)
+	}
+	if !used {
+		c.Warningf("[gate] invite used too many times: %s", code)
+		return false, nil
+	}
+
+	if email == "" {
+		r.Session.Values[sessionInvite] = code
+		return true, nil
+	}
+	if err := allow(c, email, code); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Adds the email to the allowlist
+func Allow(c appengine.Context, email string) error {
+	return allow(c, email, "")
+}
+
+func allow(c appengine.Context, email, invite string) error {
+	email = strings.ToLower(email)
+	allowed := &Allowed{
+		Email:   email,
+		Created: time.Now(),
+		Invite:  invite,
+	}
+	if _, err := datastore.Put(c, datastore.NewKey(c, KindAllowed, email, 0, nil), allowed); err != nil {
+		return fmt.Errorf("put allowed email failed: %s", err)
+	}
+	if err := cache.Delete(c, cacheKey(email)); err != nil {
+		c.Warningf("[gate] delete cached email failed: %s", err)
+	}
+	return nil
+}
+
+// Removes the email from the allowlist
+func Disallow(c appengine.Context, email string) error {
+	email = strings.ToLower(email)
+	if err := datastore.Delete(c, datastore.NewKey(c, KindAllowed, email, 0, nil)); err != nil {
+		return fmt.Errorf("delete allowed email failed: %s", err)
+	}
+	if err := cache.Delete(c, cacheKey(email)); err != nil {
+		c.Warningf("[gate] delete cached email failed: %s", err)
+	}
+	return nil
+}
+
+// Returns true if the email is in the allowlist
+func IsAllowed(c appengine.Context, email string) (bool, error) {
+	email = strings.ToLower(email)
+
+	var allowed bool
+	err := cache.Once(c, cacheKey(email), CacheTTL, &allowed, func() (interface{}, error) {
+		err := datastore.Get(c, datastore.NewKey(c, KindAllowed, email, 0, nil), new(Allowed))
+		if err == datastore.ErrNoSuchEntity {
+			return false, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("get allowed email failed: %s", err)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return allowed, nil
+}
+
+// Creates a new invite code that can be used maxUses times (zero for no
+// limit). Send it to the users as ?invite=CODE in any gated URL.
+func NewInvite(c appengine.Context, maxUses int) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("generate invite failed: %s", err)
+	}
+	code := hex.EncodeToString(random)
+
+	invite := &Invite{MaxUses: maxUses, Created: time.Now()}
+	if _, err := datastore.Put(c, datastore.NewKey(c, KindInvite, code, 0, nil), invite); err != nil {
+		return "", fmt.Errorf("put invite failed: %s", err)
+	}
+	return code, nil
+}
+
+// Returns the emails left in the interest page, newest first
+func Interests(c appengine.Context) ([]*Interest, error) {
+	interests := []*Interest{}
+	if _, err := datastore.NewQuery(KindInterest).Order("-Created").GetAll(c, &interests); err != nil {
+		return nil, fmt.Errorf("query interests failed: %s", err)
+	}
+	return interests, nil
+}
+
+func cacheKey(email string) string {
+	return "gate:" + email
+}
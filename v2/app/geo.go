@@ -0,0 +1,115 @@
+package app
+
+import (
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterRequestTemplateFuncs(func(r *Request) template.FuncMap {
+		return template.FuncMap{
+			"country":   r.Country,
+			"region":    r.Region,
+			"city":      r.City,
+			"languages": r.PreferredLanguages,
+
+			// Example: {{with latlng}}{{.Lat}},{{.Lng}}{{end}}
+			"latlng": func() *LatLng {
+				lat, lng, ok := r.LatLng()
+				if !ok {
+					return nil
+				}
+				return &LatLng{Lat: lat, Lng: lng}
+			},
+		}
+	})
+}
+
+// Position of the city of the client in the templates
+type LatLng struct {
+	Lat, Lng float64
+}
+
+// Returns the ISO 3166-1 alpha-2 code of the country of the client, from
+// the IP of the request, or an empty string if it's unknown.
+// Example: {{if eq country "AR"}}...{{end}}
+func (r *Request) Country() string {
+	return geoHeader(r, "X-AppEngine-Country")
+}
+
+// Returns the region of the client (ISO 3166-2 code, without the
+// country), or an empty string if it's unknown
+func (r *Request) Region() string {
+	return geoHeader(r, "X-AppEngine-Region")
+}
+
+// Returns the city of the client, or an empty string if it's unknown
+func (r *Request) City() string {
+	return geoHeader(r, "X-AppEngine-City")
+}
+
+// Returns the latitude & longitude of the city of the client. ok is
+// false if it's unknown.
+func (r *Request) LatLng() (lat, lng float64, ok bool) {
+	parts := strings.Split(r.Req.Header.Get("X-AppEngine-CityLatLong"), ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	lat, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lng, err = strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+// Returns the languages of the Accept-Language header, lowercase and
+// ordered by their quality
+// Example: "es-AR,es;q=0.8,en;q=0.5" -> ["es-ar", "es", "en"]
+func (r *Request) PreferredLanguages() []string {
+	type entry struct {
+		lang string
+		q    float64
+	}
+	entries := []entry{}
+	for _, part := range strings.Split(r.Req.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.TrimSpace(fields[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		lang = strings.ToLower(strings.Replace(lang, "_", "-", -1))
+		entries = append(entries, entry{lang, q})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].q > entries[j].q })
+
+	langs := make([]string, len(entries))
+	for i, e := range entries {
+		langs[i] = e.lang
+	}
+	return langs
+}
+
+// App Engine uses "ZZ" and "?" for the unknown values
+func geoHeader(r *Request, name string) string {
+	value := r.Req.Header.Get(name)
+	if value == "ZZ" || value == "?" {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGeoTemplateFuncs(t *testing.T) {
+	templatesMutex.RLock()
+	_, err := template.New("t").Funcs(templatesFuncs).Parse(
+		`{{country}} {{region}} {{city}} {{languages}} {{with latlng}}{{.Lat}}{{end}}`)
+	templatesMutex.RUnlock()
+	if err != nil {
+		t.Fatalf("parse with the geo functions failed: %s", err)
+	}
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-AppEngine-Country", "AR")
+	req.Header.Set("X-AppEngine-Region", "?")
+	req.Header.Set("X-AppEngine-CityLatLong", "-34.603722,-58.381592")
+	req.Header.Set("Accept-Language", "en;q=0.5,es-AR,es;q=0.8")
+	r := &Request{Req: req}
+
+	funcs := template.FuncMap{}
+	for _, f := range requestFuncs {
+		for name, fn := range f(r) {
+			funcs[name] = fn
+		}
+	}
+	tmpl, err := template.New("t").Funcs(funcs).Parse(
+		`{{country}}|{{region}}|{{languages}}|{{with latlng}}{{.Lat}},{{.Lng}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := tmpl.Execute(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "AR||[es-ar es en]|-34.603722,-58.381592"; buf.String() != expected {
+		t.Errorf("got %q, expected %q", buf.String(), expected)
+	}
+}
+
+func TestPreferredLanguages(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []string
+	}{
+		{"", []string{}},
+		{"es-AR,es;q=0.8,en;q=0.5", []string{"es-ar", "es", "en"}},
+		{"en;q=0.3, pt_BR, *;q=0.1", []string{"pt-br", "en"}},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Language", test.header)
+		if got := (&Request{Req: req}).PreferredLanguages(); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("header %q: got %v, expected %v", test.header, got, test.expected)
+		}
+	}
+}
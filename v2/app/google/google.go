@@ -0,0 +1,249 @@
+package google
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/cache"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+// Scopes of the common Google APIs
+const (
+	ScopeCalendar = "https://www.googleapis.com/auth/calendar"
+	ScopeDrive    = "https://www.googleapis.com/auth/drive"
+	ScopeSheets   = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+const tokenURL = "https://accounts.google.com/o/oauth2/token"
+
+// Deadline of the requests to the Google APIs
+var Deadline = time.Duration(30) * time.Second
+
+// Mints the OAuth tokens of the server
+type TokenSource interface {
+	// Identifies the source in the cache keys
+	ID() string
+
+	// Returns a new token for the scopes and its expiration
+	Token(c appengine.Context, scopes []string) (string, time.Time, error)
+}
+
+// Service account of the application itself
+var AppIdentity TokenSource = appIdentity{}
+
+type appIdentity struct{}
+
+func (appIdentity) ID() string {
+	return "app"
+}
+
+func (appIdentity) Token(c appengine.Context, scopes []string) (string, time.Time, error) {
+	token, expiry, err := platform.Current.AccessToken(c, scopes...)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("get app identity token failed: %s", err)
+	}
+	return token, expiry, nil
+}
+
+// Service account of a JSON key downloaded from the console, for APIs
+// of other projects or domain-wide delegation.
+type ServiceAccount struct {
+	Email      string
+	PrivateKey *rsa.PrivateKey
+
+	// User impersonated with the domain-wide delegation, if any
+	Subject string
+}
+
+// Loads the service account of the JSON key file
+// Example: sa, err := google.LoadServiceAccount("keys/calendar.json")
+func LoadServiceAccount(filename string) (*ServiceAccount, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read service account failed: %s", err)
+	}
+	return ParseServiceAccount(data)
+}
+
+// Parses the service account of a JSON key
+func ParseServiceAccount(data []byte) (*ServiceAccount, error) {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("decode service account failed: %s", err)
+	}
+
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("service account without private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("parse service account key failed: %s", err)
+		}
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("service account key is not rsa")
+	}
+
+	return &ServiceAccount{Email: key.ClientEmail, PrivateKey: rsaKey}, nil
+}
+
+// Returns a copy of the account that impersonates the user
+func (s *ServiceAccount) As(subject string) *ServiceAccount {
+	return &ServiceAccount{Email: s.Email, PrivateKey: s.PrivateKey, Subject: subject}
+}
+
+func (s *ServiceAccount) ID() string {
+	return s.Email + "/" + s.Subject
+}
+
+// Exchanges a signed JWT assertion for a token
+func (s *ServiceAccount) Token(c appengine.Context, scopes []string) (string, time.Time, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   s.Email,
+		"scope": strings.Join(scopes, " "),
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	if s.Subject != "" {
+		claims["sub"] = s.Subject
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("encode jwt claims failed: %s", err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) +
+		"." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.PrivateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("sign jwt failed: %s", err)
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	client := platform.Current.HTTPClient(c, Deadline)
+	resp, err := client.PostForm(tokenURL, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", time.Time{}, fmt.Errorf("decode token response failed: %s", err)
+	}
+	if result.Error != "" || result.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token error %s: %s", result.Error, result.Description)
+	}
+
+	return result.AccessToken, now.Add(time.Duration(result.ExpiresIn) * time.Second), nil
+}
+
+type cachedToken struct {
+	Token  string
+	Expiry time.Time
+}
+
+// Returns a token of the source for the scopes, cached until a minute
+// before its expiration.
+func Token(c appengine.Context, src TokenSource, scopes ...string) (string, error) {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	key := "google-token:" + src.ID() + ":" + strings.Join(sorted, " ")
+
+	cached := new(cachedToken)
+	if err := cache.Get(c, key, cached); err == nil && time.Now().Before(cached.Expiry) {
+		return cached.Token, nil
+	} else if err != nil && err != cache.ErrMiss {
+		c.Warningf("[google] cannot read cached token: %s", err)
+	}
+
+	token, expiry, err := src.Token(c, scopes)
+	if err != nil {
+		return "", err
+	}
+
+	cached = &cachedToken{Token: token, Expiry: expiry.Add(-time.Minute)}
+	if ttl := cached.Expiry.Sub(time.Now()); ttl > 0 {
+		if err := cache.Set(c, key, cached, ttl); err != nil {
+			c.Warningf("[google] cannot cache token: %s", err)
+		}
+	}
+	return token, nil
+}
+
+// Returns a client that authorizes the requests with the tokens of
+// the source.
+// Example:
+//    client := google.Client(c, google.AppIdentity, google.ScopeCalendar)
+//    resp, err := client.Get("https://www.googleapis.com/calendar/v3/users/me/calendarList")
+func Client(c appengine.Context, src TokenSource, scopes ...string) *http.Client {
+	base := platform.Current.HTTPClient(c, Deadline)
+
+	// The clients of the VMs use the default transport
+	rt := base.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &http.Client{
+		Transport: &transport{c: c, src: src, scopes: scopes, base: rt},
+		Timeout:   base.Timeout,
+	}
+}
+
+type transport struct {
+	c      appengine.Context
+	src    TokenSource
+	scopes []string
+	base   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := Token(t.c, t.src, t.scopes...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The requests shouldn't be modified by the transports
+	authorized := new(http.Request)
+	*authorized = *req
+	authorized.Header = make(http.Header)
+	for k, v := range req.Header {
+		authorized.Header[k] = v
+	}
+	authorized.Header.Set("Authorization", "Bearer "+token)
+
+	return t.base.RoundTrip(authorized)
+}
@@ -0,0 +1,188 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+// Returned when the server answers with an error status
+type HTTPError struct {
+	Method, URL string
+	StatusCode  int
+
+	// Start of the body of the response
+	Body string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s %s failed with status %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// Returned when all the retries fail without a response
+type NetworkError struct {
+	Method, URL string
+	Err         error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%s %s failed: %s", e.Method, e.URL, e.Err)
+}
+
+type Client struct {
+	// Deadline of each attempt
+	Deadline time.Duration
+
+	// Number of retries of the network errors & 5xx responses, waiting
+	// Backoff before the first one and doubling it in the next ones. Only
+	// the GET, HEAD & OPTIONS requests are retried; the rest could
+	// repeat their effects.
+	Retries int
+	Backoff time.Duration
+
+	// Credentials sent in each request, if not empty
+	Username, Password string
+	Token              string
+
+	// Headers added to each request
+	Header http.Header
+}
+
+// Client used by the package functions
+var Default = &Client{
+	Deadline: time.Duration(10) * time.Second,
+	Retries:  3,
+	Backoff:  time.Duration(200) * time.Millisecond,
+}
+
+// Max size of the body kept in the HTTPError
+const errorBodySize = 1024
+
+// Gets the URL and decodes the JSON response into dest
+func Get(c appengine.Context, url string, dest interface{}) error {
+	return Default.Get(c, url, dest)
+}
+
+// Posts the body encoded as JSON and decodes the JSON response into dest
+func PostJSON(c appengine.Context, url string, body, dest interface{}) error {
+	return Default.PostJSON(c, url, body, dest)
+}
+
+// Posts the form values and decodes the JSON response into dest
+func PostForm(c appengine.Context, url string, values neturl.Values, dest interface{}) error {
+	return Default.PostForm(c, url, values, dest)
+}
+
+func (cl *Client) Get(c appengine.Context, url string, dest interface{}) error {
+	return cl.Do(c, "GET", url, nil, dest)
+}
+
+func (cl *Client) PostJSON(c appengine.Context, url string, body, dest interface{}) error {
+	return cl.Do(c, "POST", url, body, dest)
+}
+
+func (cl *Client) PostForm(c appengine.Context, url string, values neturl.Values, dest interface{}) error {
+	return cl.send(c, "POST", url, "application/x-www-form-urlencoded", []byte(values.Encode()), dest)
+}
+
+// Sends the request with the body encoded as JSON (if not nil) and
+// decodes the JSON response into dest (if not nil).
+// Example:
+//    client := &httpclient.Client{Token: token, Retries: 2}
+//    err := client.Do(c, "GET", "https://api.example.com/items/3", nil, item)
+func (cl *Client) Do(c appengine.Context, method, url string, body, dest interface{}) error {
+	if body == nil {
+		return cl.send(c, method, url, "", nil, dest)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encode request body failed: %s", err)
+	}
+	return cl.send(c, method, url, "application/json", payload, dest)
+}
+
+// Sends the payload with the content type, retrying the safe methods
+func (cl *Client) send(c appengine.Context, method, url, contentType string, payload []byte, dest interface{}) error {
+	retries := 0
+	if method == "GET" || method == "HEAD" || method == "OPTIONS" {
+		retries = cl.Retries
+	}
+
+	delay := cl.Backoff
+	for attempt := 0; ; attempt++ {
+		req, err := cl.newRequest(method, url, contentType, payload)
+		if err != nil {
+			return err
+		}
+		resp, err := platform.Current.HTTPClient(c, cl.Deadline).Do(req)
+		if err == nil {
+			if resp.StatusCode < 300 {
+				defer resp.Body.Close()
+				return decode(resp.Body, dest)
+			}
+
+			text, _ := ioutil.ReadAll(io.LimitReader(resp.Body, errorBodySize))
+			resp.Body.Close()
+			err = &HTTPError{Method: method, URL: url, StatusCode: resp.StatusCode, Body: string(text)}
+			if resp.StatusCode < 500 {
+				return err
+			}
+		}
+
+		if attempt >= retries {
+			if _, ok := err.(*HTTPError); ok {
+				return err
+			}
+			return &NetworkError{Method: method, URL: url, Err: err}
+		}
+		c.Warningf("[httpclient] retrying in %s: %s", delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (cl *Client) newRequest(method, url, contentType string, payload []byte) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("prepare request failed: %s", err)
+	}
+
+	for name, values := range cl.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if cl.Username != "" || cl.Password != "" {
+		req.SetBasicAuth(cl.Username, cl.Password)
+	}
+	if cl.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cl.Token)
+	}
+
+	return req, nil
+}
+
+func decode(r io.Reader, dest interface{}) error {
+	if dest == nil {
+		return nil
+	}
+	if err := json.NewDecoder(r).Decode(dest); err != nil {
+		return fmt.Errorf("decode response failed: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+var (
+	// Language used when the client doesn't accept any of the loaded ones,
+	// and for the keys without translation
+	DefaultLanguage = "en"
+
+	// Cookie that overrides the Accept-Language header (see SetLanguage)
+	CookieName = "lang"
+)
+
+var (
+	catalogsMutex = &sync.RWMutex{}
+	catalogs      = map[string]map[string]string{}
+)
+
+func init() {
+	app.SetCacheLanguage(Language)
+	app.RegisterTemplateFuncs(template.FuncMap{
+		// Translated message of the key. Only the templates executed with
+		// Request.Template use the language of the client.
+		// Example: {{T "welcome" .User.Name}}
+		"T": func(key string, args ...interface{}) string {
+			return Translate(DefaultLanguage, key, args...)
+		},
+	})
+	app.RegisterRequestTemplateFuncs(func(r *app.Request) template.FuncMap {
+		// Resolved in the first call; r is nil when they're registered
+		lang := ""
+		language := func() string {
+			if lang == "" {
+				lang = Language(r)
+			}
+			return lang
+		}
+		return template.FuncMap{
+			"T": func(key string, args ...interface{}) string {
+				return Translate(language(), key, args...)
+			},
+			"lang": language,
+		}
+	})
+}
+
+// Loads the translations of the language from a JSON file with
+// a flat object of keys and messages, or a gettext PO file.
+// Call it at init().
+// Example: i18n.LoadFile("es", "translations/es.po")
+func LoadFile(lang, filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("read translations failed: %s", err)
+	}
+
+	messages := map[string]string{}
+	switch filepath.Ext(filename) {
+	case ".json":
+		if err := json.Unmarshal(content, &messages); err != nil {
+			return fmt.Errorf("decode translations %s failed: %s", filename, err)
+		}
+	case ".po":
+		if messages, err = parsePO(string(content)); err != nil {
+			return fmt.Errorf("parse translations %s failed: %s", filename, err)
+		}
+	default:
+		return fmt.Errorf("unknown translations format: %s", filename)
+	}
+
+	Add(lang, messages)
+	return nil
+}
+
+// Loads all the .json and .po files of the directory, using the name
+// of each file as its language. Call it at init().
+// Example: i18n.LoadDir("translations") // en.json, es.po, pt-BR.po...
+func LoadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read translations dir failed: %s", err)
+	}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".json" && ext != ".po") {
+			continue
+		}
+		lang := strings.TrimSuffix(f.Name(), ext)
+		if err := LoadFile(lang, filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds translations to the language, replacing the existing ones
+// with the same key
+func Add(lang string, messages map[string]string) {
+	catalogsMutex.Lock()
+	defer catalogsMutex.Unlock()
+
+	lang = normalize(lang)
+	if catalogs[lang] == nil {
+		catalogs[lang] = map[string]string{}
+	}
+	for key, msg := range messages {
+		catalogs[lang][key] = msg
+	}
+}
+
+// Returns the loaded languages, sorted
+func Languages() []string {
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+
+	langs := []string{}
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Returns the message of the key in the language, falling back to
+// the base language (es for es-AR), the default language and the key
+// itself. The args, if any, are formatted with fmt.Sprintf.
+func Translate(lang, key string, args ...interface{}) string {
+	catalogsMutex.RLock()
+	msg, ok := lookup(normalize(lang), key)
+	if !ok {
+		msg, ok = lookup(normalize(DefaultLanguage), key)
+	}
+	catalogsMutex.RUnlock()
+	if !ok {
+		msg = key
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Translates the key to the language of the client
+func T(r *app.Request, key string, args ...interface{}) string {
+	return Translate(Language(r), key, args...)
+}
+
+// Returns a translation function for the language of the client, to
+// pass it to the forms packages.
+// Example: form.Translator = i18n.Translator(r)
+func Translator(r *app.Request) func(string) string {
+	lang := Language(r)
+	return func(key string) string {
+		return Translate(lang, key)
+	}
+}
+
+// Returns the language of the client: the one stored in the cookie
+// or the preferred one of the Accept-Language header that has been
+// loaded, or DefaultLanguage if none of them.
+func Language(r *app.Request) string {
+	if cookie, err := r.Req.Cookie(CookieName); err == nil {
+		if lang, ok := match(cookie.Value); ok {
+			return lang
+		}
+	}
+
+	for _, lang := range r.PreferredLanguages() {
+		if lang, ok := match(lang); ok {
+			return lang
+		}
+	}
+
+	return normalize(DefaultLanguage)
+}
+
+// Stores the language chosen by the user in the cookie for a year
+func SetLanguage(r *app.Request, lang string) error {
+	lang, ok := match(lang)
+	if !ok {
+		return fmt.Errorf("language not loaded: %s", lang)
+	}
+	http.SetCookie(r.W, &http.Cookie{
+		Name:   CookieName,
+		Value:  lang,
+		Path:   "/",
+		MaxAge: 365 * 24 * 60 * 60,
+	})
+	return nil
+}
+
+// Returns the loaded language that serves the requested one
+func match(lang string) (string, bool) {
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+
+	lang = normalize(lang)
+	if _, ok := catalogs[lang]; ok {
+		return lang, true
+	}
+	if i := strings.Index(lang, "-"); i != -1 {
+		if _, ok := catalogs[lang[:i]]; ok {
+			return lang[:i], true
+		}
+	}
+	return "", false
+}
+
+// Returns the message of the key in the language or its base language.
+// The mutex should be held by the caller.
+func lookup(lang, key string) (string, bool) {
+	if msg, ok := catalogs[lang][key]; ok {
+		return msg, true
+	}
+	if i := strings.Index(lang, "-"); i != -1 {
+		if msg, ok := catalogs[lang[:i]][key]; ok {
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+func normalize(lang string) string {
+	return strings.ToLower(strings.Replace(lang, "_", "-", -1))
+}
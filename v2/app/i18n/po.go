@@ -0,0 +1,65 @@
+package i18n
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parses the msgid/msgstr pairs of a gettext PO file. Comments, contexts
+// and plural forms are ignored, as are the untranslated entries.
+func parsePO(content string) (map[string]string, error) {
+	messages := map[string]string{}
+
+	var id, str, current *string
+	var msgid, msgstr string
+	flush := func() {
+		if id != nil && str != nil && *id != "" && *str != "" {
+			messages[*id] = *str
+		}
+		id, str, current = nil, nil, nil
+	}
+
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			flush()
+
+		case strings.HasPrefix(line, "#"):
+			continue
+
+		case strings.HasPrefix(line, "msgid "):
+			flush()
+			msgid = ""
+			id, current = &msgid, &msgid
+			line = strings.TrimPrefix(line, "msgid ")
+			fallthrough
+
+		case strings.HasPrefix(line, "msgstr "):
+			if strings.HasPrefix(line, "msgstr ") {
+				msgstr = ""
+				str, current = &msgstr, &msgstr
+				line = strings.TrimPrefix(line, "msgstr ")
+			}
+			fallthrough
+
+		case strings.HasPrefix(line, `"`):
+			if current == nil {
+				continue
+			}
+			s, err := strconv.Unquote(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n+1, err)
+			}
+			*current += s
+
+		default:
+			// msgctxt, msgid_plural, msgstr[n]...
+			current = nil
+		}
+	}
+	flush()
+
+	return messages, nil
+}
@@ -0,0 +1,510 @@
+// Invites the users send to their friends. The codes are signed, so they
+// can't be guessed, and each user has a quota of them. Accepting an
+// invite records who invited whom.
+package invites
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/config"
+	"github.com/ernestokarim/gaelib/v2/auth"
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	KindInvite     = "Invite"
+	KindAcceptance = "InviteAcceptance"
+	KindSent       = "InvitesSent"
+	KindKey        = "InvitesKey"
+)
+
+var (
+	// Route of AcceptHandler, used to build the invite URLs
+	AcceptPath = "/invites/accept"
+
+	// Page where the users are sent after accepting an invite
+	WelcomePath = "/"
+
+	// Invites each user can send; the admins have no limit. It can be
+	// changed at runtime with the InviteQuota setting.
+	DefaultQuota int64 = 5
+
+	// Uses of each invite, zero for no limit
+	DefaultMaxUses = 1
+
+	// Time the invites can be accepted
+	DefaultTTL = 30 * 24 * time.Hour
+)
+
+var (
+	codecMutex = &sync.Mutex{}
+	codec      *securecookie.SecureCookie
+)
+
+var onAccept []func(r *app.Request, a *Acceptance) error
+
+func init() {
+	config.Register("InviteQuota", config.TypeInt, "", "Invites each user can send")
+}
+
+// Invite sent by a user, with a numeric ID
+type Invite struct {
+	Inviter       string
+	Uses, MaxUses int
+	Expires       time.Time
+	Created       time.Time
+}
+
+// Accepted invite, stored with the lowercase email of the invitee as
+// its ID. Only the first accepted invite of each user is recorded.
+type Acceptance struct {
+	Invitee  string
+	Inviter  string
+	InviteID int64
+	Created  time.Time
+
+	// The OnAccept hooks didn't finish yet
+	Pending bool `datastore:",noindex"`
+}
+
+// Counter of the invites sent by a user, stored with the lowercase email
+// as its ID. It's updated in the same transaction as the invites.
+type sent struct {
+	Count int64
+}
+
+// Signing key of the codes, generated the first time
+type inviteKey struct {
+	Key []byte `datastore:",noindex"`
+}
+
+// Content of the signed codes
+type inviteToken struct {
+	ID      int64
+	Expires int64
+}
+
+// Runs fn when a user accepts an invite, after it's recorded. Use it to
+// create the account or allow the user in the gate. If a hook fails they
+// all run again when the user opens the invite again, so they should be
+// idempotent. Call it at init().
+// Example:
+//    invites.OnAccept(func(r *app.Request, a *invites.Acceptance) error {
+//      return gate.Allow(r.C, a.Invitee)
+//    })
+func OnAccept(fn func(r *app.Request, a *Acceptance) error) {
+	onAccept = append(onAccept, fn)
+}
+
+// Creates a new invite of the user, returning its signed code. It returns
+// an AppError if the user has no invites left.
+func New(c appengine.Context, inviter string, maxUses int, ttl time.Duration) (string, error) {
+	inviter = strings.ToLower(inviter)
+	admin := isAdmin(c, inviter)
+	quota := config.Int(c, "InviteQuota", DefaultQuota)
+
+	sentKey := datastore.NewKey(c, KindSent, inviter, 0, nil)
+	previous, err := countSent(c, inviter)
+	if err != nil {
+		return "", err
+	}
+	low, _, err := datastore.AllocateIDs(c, KindInvite, nil, 1)
+	if err != nil {
+		return "", fmt.Errorf("allocate invite id failed: %s", err)
+	}
+	key := datastore.NewKey(c, KindInvite, "", low, nil)
+
+	now := time.Now()
+	invite := &Invite{
+		Inviter: inviter,
+		MaxUses: maxUses,
+		Expires: now.Add(ttl),
+		Created: now,
+	}
+	err = datastore.RunInTransaction(c, func(c appengine.Context) error {
+		s := new(sent)
+		if err := datastore.Get(c, sentKey, s); err == datastore.ErrNoSuchEntity {
+			// The invites sent before the counter existed
+			s.Count = previous
+		} else if err != nil {
+			return err
+		}
+		if !admin && s.Count >= quota {
+			return &app.AppError{Code: 403, Message: "no invites left"}
+		}
+
+		s.Count++
+		if _, err := datastore.Put(c, sentKey, s); err != nil {
+			return err
+		}
+		_, err := datastore.Put(c, key, invite)
+		return err
+	}, &datastore.TransactionOptions{XG: true})
+	if _, ok := err.(*app.AppError); ok {
+		return "", err
+	} else if err != nil {
+		return "", fmt.Errorf("put invite failed: %s", err)
+	}
+
+	cc, err := inviteCodec(c)
+	if err != nil {
+		return "", err
+	}
+	t := &inviteToken{ID: key.IntID(), Expires: invite.Expires.Unix()}
+	code, err := cc.Encode("INVITE", t)
+	if err != nil {
+		return "", fmt.Errorf("sign invite failed: %s", err)
+	}
+	return code, nil
+}
+
+// Returns the invites the user can still send, or -1 if there's no limit
+func Remaining(c appengine.Context, inviter string) (int64, error) {
+	if isAdmin(c, inviter) {
+		return -1, nil
+	}
+
+	inviter = strings.ToLower(inviter)
+	s := new(sent)
+	if err := datastore.Get(c, datastore.NewKey(c, KindSent, inviter, 0, nil), s); err == datastore.ErrNoSuchEntity {
+		if s.Count, err = countSent(c, inviter); err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, fmt.Errorf("get sent invites failed: %s", err)
+	}
+
+	quota := config.Int(c, "InviteQuota", DefaultQuota)
+	if left := quota - s.Count; left > 0 {
+		return left, nil
+	}
+	return 0, nil
+}
+
+// Counts the invites of the user, to start the counter of the ones
+// that sent them before it existed
+func countSent(c appengine.Context, inviter string) (int64, error) {
+	n, err := datastore.NewQuery(KindInvite).Filter("Inviter =", inviter).Count(c)
+	if err != nil {
+		return 0, fmt.Errorf("count invites failed: %s", err)
+	}
+	return int64(n), nil
+}
+
+// Returns the codec of the codes, signed with their own key. A random
+// one is generated and stored the first time.
+func inviteCodec(c appengine.Context) (*securecookie.SecureCookie, error) {
+	codecMutex.Lock()
+	defer codecMutex.Unlock()
+	if codec != nil {
+		return codec, nil
+	}
+
+	k := new(inviteKey)
+	key := datastore.NewKey(c, KindKey, "default", 0, nil)
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		if err := datastore.Get(c, key, k); err == nil {
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		k.Key = securecookie.GenerateRandomKey(32)
+		_, err := datastore.Put(c, key, k)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("load invites key failed: %s", err)
+	}
+
+	// The expiration is checked with the Expires of each code, the
+	// default MaxAge would cap the longer TTLs
+	codec = securecookie.New(k.Key, nil).MaxAge(0)
+	return codec, nil
+}
+
+// Returns who invited the user, or nil if they weren't invited
+func InvitedBy(c appengine.Context, email string) (*Acceptance, error) {
+	a := new(Acceptance)
+	key := datastore.NewKey(c, KindAcceptance, strings.ToLower(email), 0, nil)
+	if err := datastore.Get(c, key, a); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("get acceptance failed: %s", err)
+	}
+	return a, nil
+}
+
+// Returns the users that accepted the invites of the inviter, newest first
+func Invitees(c appengine.Context, inviter string) ([]*Acceptance, error) {
+	accepted := []*Acceptance{}
+	q := datastore.NewQuery(KindAcceptance).Filter("Inviter =", strings.ToLower(inviter)).Order("-Created")
+	if _, err := q.GetAll(c, &accepted); err != nil {
+		return nil, fmt.Errorf("query invitees failed: %s", err)
+	}
+	return accepted, nil
+}
+
+// Returns the URL the invitees should open to accept the code
+func AcceptURL(r *app.Request, code string) string {
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     r.Req.Host,
+		Path:     AcceptPath,
+		RawQuery: url.Values{"code": []string{code}}.Encode(),
+	}
+	if r.Req.TLS == nil && r.Req.Header.Get("X-Forwarded-Proto") != "https" {
+		u.Scheme = "http"
+	}
+	return u.String()
+}
+
+// Creates an invite of the logged user, emitting its code & URL.
+// Example: "POST::/invites": invites.CreateHandler,
+func CreateHandler(r *app.Request) error {
+	email, err := auth.Identity.Email(r)
+	if err != nil {
+		return fmt.Errorf("get inviter failed: %s", err)
+	}
+	if email == "" {
+		return app.Unauthorized()
+	}
+
+	code, err := New(r.C, email, DefaultMaxUses, DefaultTTL)
+	if err != nil {
+		return err
+	}
+	left, err := Remaining(r.C, email)
+	if err != nil {
+		return err
+	}
+
+	return r.EmitJson(map[string]interface{}{
+		"code":      code,
+		"url":       AcceptURL(r, code),
+		"remaining": left,
+	})
+}
+
+// Accepts the invite of the code param, sending the anonymous users to
+// the login page first. Register it in AcceptPath.
+// Example: "GET::/invites/accept": invites.AcceptHandler,
+func AcceptHandler(r *app.Request) error {
+	email, err := auth.Identity.Email(r)
+	if err != nil {
+		return fmt.Errorf("get invitee failed: %s", err)
+	}
+	if email == "" {
+		return auth.Identity.Login(r)
+	}
+	email = strings.ToLower(email)
+
+	cc, err := inviteCodec(r.C)
+	if err != nil {
+		return err
+	}
+	code := r.Req.URL.Query().Get("code")
+	t := new(inviteToken)
+	if err := cc.Decode("INVITE", code, t); err != nil {
+		r.C.Warningf("[invites] invalid code: %s", err)
+		return app.NotFound()
+	}
+	if time.Now().Unix() > t.Expires {
+		r.C.Warningf("[invites] expired invite: %d", t.ID)
+		return app.NotFound()
+	}
+
+	a, pending, err := accept(r.C, t.ID, email)
+	if err != nil {
+		return err
+	}
+	if !pending {
+		return r.Redirect(WelcomePath)
+	}
+
+	for _, fn := range onAccept {
+		if err := fn(r, a); err != nil {
+			return fmt.Errorf("accept invite hook failed: %s", err)
+		}
+	}
+	if err := finishAccept(r.C, email); err != nil {
+		return err
+	}
+
+	return r.Redirect(WelcomePath)
+}
+
+// Records the acceptance of the invite. It returns true if the OnAccept
+// hooks should run: the first time, or again if they failed before.
+func accept(c appengine.Context, id int64, email string) (*Acceptance, bool, error) {
+	var a *Acceptance
+	pending := false
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		a, pending = nil, false
+
+		aKey := datastore.NewKey(c, KindAcceptance, email, 0, nil)
+		previous := new(Acceptance)
+		if err := datastore.Get(c, aKey, previous); err == nil {
+			a, pending = previous, previous.Pending
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		key := datastore.NewKey(c, KindInvite, "", id, nil)
+		invite := new(Invite)
+		if err := datastore.Get(c, key, invite); err != nil {
+			return err
+		}
+		if invite.MaxUses > 0 && invite.Uses >= invite.MaxUses {
+			return app.NotFound()
+		}
+		if invite.Inviter == email {
+			return app.BadRequest("code", "the invites can't be accepted by their own sender")
+		}
+
+		invite.Uses++
+		if _, err := datastore.Put(c, key, invite); err != nil {
+			return err
+		}
+
+		a = &Acceptance{
+			Invitee:  email,
+			Inviter:  invite.Inviter,
+			InviteID: id,
+			Created:  time.Now(),
+			Pending:  len(onAccept) > 0,
+		}
+		if _, err := datastore.Put(c, aKey, a); err != nil {
+			return err
+		}
+		pending = a.Pending
+		return nil
+	}, &datastore.TransactionOptions{XG: true})
+	if err == datastore.ErrNoSuchEntity {
+		c.Warningf("[invites] invite not found: %d", id)
+		return nil, false, app.NotFound()
+	} else if _, ok := err.(app.HttpError); ok {
+		return nil, false, err
+	} else if _, ok := err.(*app.AppError); ok {
+		return nil, false, err
+	} else if err != nil {
+		return nil, false, fmt.Errorf("accept invite failed: %s", err)
+	}
+	return a, pending, nil
+}
+
+// Marks the hooks of the acceptance of the user as finished
+func finishAccept(c appengine.Context, email string) error {
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		key := datastore.NewKey(c, KindAcceptance, email, 0, nil)
+		a := new(Acceptance)
+		if err := datastore.Get(c, key, a); err != nil {
+			return err
+		}
+		if !a.Pending {
+			return nil
+		}
+		a.Pending = false
+		_, err := datastore.Put(c, key, a)
+		return err
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("finish invite acceptance failed: %s", err)
+	}
+	return nil
+}
+
+func isAdmin(c appengine.Context, email string) bool {
+	u := user.Current(c)
+	return u != nil && u.Admin && strings.EqualFold(u.Email, email)
+}
+
+// Invites sent & accepted by each user
+type reportRow struct {
+	Inviter              string
+	Sent, Uses, Accepted int
+}
+
+var reportTemplate = template.Must(template.New("invites").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Invites</title></head>
+<body>
+  <h1>Invites</h1>
+  <table>
+    <tr><th>Inviter</th><th>Sent</th><th>Uses</th><th>Accepted</th></tr>
+    {{range .}}
+    <tr><td>{{.Inviter}}</td><td>{{.Sent}}</td><td>{{.Uses}}</td><td>{{.Accepted}}</td></tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
+
+// Admin page with the invites sent & accepted by each user, the most
+// successful inviters first.
+// Example: "::/admin/invites": invites.ReportHandler,
+func ReportHandler(r *app.Request) error {
+	if !user.IsAdmin(r.C) {
+		return app.Forbidden()
+	}
+
+	rows := map[string]*reportRow{}
+	row := func(inviter string) *reportRow {
+		if rows[inviter] == nil {
+			rows[inviter] = &reportRow{Inviter: inviter}
+		}
+		return rows[inviter]
+	}
+
+	invites := []*Invite{}
+	if _, err := datastore.NewQuery(KindInvite).GetAll(r.C, &invites); err != nil {
+		return fmt.Errorf("query invites failed: %s", err)
+	}
+	for _, invite := range invites {
+		row(invite.Inviter).Sent++
+		row(invite.Inviter).Uses += invite.Uses
+	}
+
+	accepted := []*Acceptance{}
+	if _, err := datastore.NewQuery(KindAcceptance).GetAll(r.C, &accepted); err != nil {
+		return fmt.Errorf("query acceptances failed: %s", err)
+	}
+	for _, a := range accepted {
+		row(a.Inviter).Accepted++
+	}
+
+	report := []*reportRow{}
+	for _, row := range rows {
+		report = append(report, row)
+	}
+	sort.Sort(byAccepted(report))
+
+	r.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := reportTemplate.Execute(r.W, report); err != nil {
+		return fmt.Errorf("exec invites report failed: %s", err)
+	}
+	return nil
+}
+
+type byAccepted []*reportRow
+
+func (s byAccepted) Len() int      { return len(s) }
+func (s byAccepted) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byAccepted) Less(i, j int) bool {
+	if s[i].Accepted != s[j].Accepted {
+		return s[i].Accepted > s[j].Accepted
+	}
+	return s[i].Inviter < s[j].Inviter
+}
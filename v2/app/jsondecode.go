@@ -0,0 +1,147 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type JsonDecodeOptions struct {
+	// Reject the bodies with fields not present in the destination
+	DisallowUnknownFields bool
+
+	// Maximum nesting of objects & arrays, zero for no limit
+	MaxDepth int
+
+	// Maximum size of the body in bytes, 32MB by default (the limit of
+	// App Engine)
+	MaxBytes int64
+}
+
+var jsonOptions = JsonDecodeOptions{MaxBytes: 32 << 20}
+
+// Sets the strict-mode options of LoadJsonData. Call it at init().
+// Example:
+//    app.SetJsonDecodeOptions(app.JsonDecodeOptions{
+//      DisallowUnknownFields: true,
+//      MaxDepth:              20,
+//      MaxBytes:              1 << 20,
+//    })
+func SetJsonDecodeOptions(opts JsonDecodeOptions) {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 32 << 20
+	}
+	jsonOptions = opts
+}
+
+// Decodes the body, translating the errors to AppError ones with a
+// description safe to show to the user
+func decodeJsonBody(body []byte, data interface{}) error {
+	if jsonOptions.MaxDepth > 0 && jsonDepth(body) > jsonOptions.MaxDepth {
+		return &AppError{Code: 400, Message: "the JSON is nested too deeply"}
+	}
+
+	err := unmarshalJson(body, data, jsonOptions.DisallowUnknownFields)
+	if err == nil {
+		return nil
+	}
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return &AppError{
+			Code:    400,
+			Message: fmt.Sprintf("invalid JSON syntax at offset %d", e.Offset),
+			Offset:  e.Offset,
+		}
+
+	case *json.UnmarshalTypeError:
+		appErr := &AppError{
+			Code:    400,
+			Message: fmt.Sprintf("invalid %s value, expected %s", e.Value, jsonTypeName(e.Type.Kind().String())),
+			Field:   jsonFieldPath(e.Field),
+		}
+		// The renamed body doesn't keep the offsets of the original one
+		if jsonNaming == nil {
+			appErr.Offset = e.Offset
+		}
+		if appErr.Field != "" {
+			appErr.Message = fmt.Sprintf("invalid %s value in the field %s, expected %s", e.Value,
+				appErr.Field, jsonTypeName(e.Type.Kind().String()))
+		}
+		return appErr
+	}
+
+	// Unknown fields only return a plain error with their name
+	msg := err.Error()
+	if strings.HasPrefix(msg, "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`)
+		field = jsonFieldPath(field)
+		return &AppError{Code: 400, Message: "unknown field " + field, Field: field}
+	}
+	if msg == "unexpected EOF" {
+		return &AppError{Code: 400, Message: "the JSON is incomplete", Offset: int64(len(body))}
+	}
+
+	return fmt.Errorf("decode json body failed: %s", err)
+}
+
+// Returns the field path with the names the client sees
+func jsonFieldPath(path string) string {
+	if jsonNaming == nil || path == "" {
+		return path
+	}
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		// Only the Go names were renamed, the tags start in lowercase usually
+		if part != "" && strings.ToUpper(part[:1]) == part[:1] {
+			parts[i] = jsonNaming(part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"),
+		strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice", kind == "array":
+		return "array"
+	case kind == "struct", kind == "map":
+		return "object"
+	}
+	return kind
+}
+
+// Returns the maximum nesting of objects & arrays of the JSON
+func jsonDepth(data []byte) int {
+	depth, max := 0, 0
+	inString, escaped := false, false
+	for _, b := range data {
+		if inString {
+			if escaped {
+				escaped = false
+			} else if b == '\\' {
+				escaped = true
+			} else if b == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > max {
+				max = depth
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return max
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http"
+	"reflect"
+)
+
+var (
+	requestType = reflect.TypeOf(&Request{})
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Builds a handler from a typed function. fn should be a
+// func(r *app.Request, in *In) (*Out, error): the body (or the query
+// params in the GET requests) is decoded & validated into a new In, and
+// the result encoded with EmitJson. A nil result emits a
+// 204 response. The errors are always returned with the JSON envelope.
+// It panics if the valid tags of In have unknown or malformed rules.
+// Example:
+//    "POST::/_/comments": app.JsonHandler(func(r *app.Request, in *Comment) (*CommentReply, error) {
+//      ....
+//    }),
+func JsonHandler(fn interface{}) Handler {
+	f := reflect.ValueOf(fn)
+	ft := f.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() != 2 || ft.NumOut() != 2 ||
+		ft.In(0) != requestType || ft.In(1).Kind() != reflect.Ptr ||
+		ft.Out(1) != errorType {
+		panic("json handler should be a func(*app.Request, *In) (*Out, error)")
+	}
+	inType := ft.In(1).Elem()
+	if err := checkTags(inType); err != nil {
+		panic("json handler input: " + err.Error())
+	}
+
+	return func(r *Request) error {
+		r.forceJson = true
+
+		in := reflect.New(inType)
+		if r.Req.Method == "GET" {
+			if err := r.LoadData(in.Interface()); err != nil {
+				return err
+			}
+		} else {
+			if err := r.LoadJsonData(in.Interface()); err != nil {
+				return err
+			}
+		}
+
+		results := f.Call([]reflect.Value{reflect.ValueOf(r), in})
+		if !results[1].IsNil() {
+			return results[1].Interface().(error)
+		}
+
+		out := results[0]
+		if (out.Kind() == reflect.Ptr || out.Kind() == reflect.Interface ||
+			out.Kind() == reflect.Map || out.Kind() == reflect.Slice) && out.IsNil() {
+			r.W.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+
+		r.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+		return r.EmitJson(out.Interface())
+	}
+}
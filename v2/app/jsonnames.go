@@ -0,0 +1,392 @@
+package app
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Function that converts the Go name of a field to its JSON name
+type NamingStrategy func(name string) string
+
+var jsonNaming NamingStrategy
+
+// Sets the naming strategy applied by EmitJson, LoadJsonData and the
+// other JSON helpers to the struct fields without a json tag. By default
+// they use the Go names. Call it at init().
+// Example: app.SetJsonNaming(app.CamelCase)
+func SetJsonNaming(strategy NamingStrategy) {
+	jsonNaming = strategy
+}
+
+// Converts a Go name to camelCase: UserID -> userId
+func CamelCase(name string) string {
+	words := splitWords(name)
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if i > 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		words[i] = word
+	}
+	return strings.Join(words, "")
+}
+
+// Converts a Go name to snake_case: UserID -> user_id
+func SnakeCase(name string) string {
+	words := splitWords(name)
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+	return strings.Join(words, "_")
+}
+
+// Splits a Go name in words, keeping the acronyms together:
+// HTTPServerID -> HTTP, Server, ID
+func splitWords(name string) []string {
+	runes := []rune(name)
+	words := []string{}
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		boundary := unicode.IsUpper(cur) &&
+			(!unicode.IsUpper(prev) || unicode.IsLower(next))
+		if cur == '_' || boundary {
+			if word := strings.Trim(string(runes[start:i]), "_"); word != "" {
+				words = append(words, word)
+			}
+			start = i
+		}
+	}
+	if word := strings.Trim(string(runes[start:]), "_"); word != "" {
+		words = append(words, word)
+	}
+	return words
+}
+
+// Encodes the value like json.Marshal, applying the naming strategy
+func MarshalJson(v interface{}) ([]byte, error) {
+	if jsonNaming == nil {
+		return json.Marshal(v)
+	}
+	return json.Marshal(renameFields(reflect.ValueOf(v)))
+}
+
+// Decodes the data like json.Unmarshal, applying the naming strategy
+func UnmarshalJson(data []byte, v interface{}) error {
+	return unmarshalJson(data, v, false)
+}
+
+func unmarshalJson(data []byte, v interface{}, disallowUnknown bool) error {
+	if jsonNaming != nil {
+		var generic interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&generic); err != nil {
+			return err
+		}
+
+		renamed, err := json.Marshal(renameKeys(generic, reflect.TypeOf(v)))
+		if err != nil {
+			return fmt.Errorf("encode renamed json failed: %s", err)
+		}
+		data = renamed
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if disallowUnknown {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(v)
+}
+
+var (
+	marshalerType       = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	interfaceType       = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// JSON object that keeps the order of the struct fields
+type jsonObject struct {
+	keys   []string
+	values []interface{}
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBufferString("{")
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+func (o *jsonObject) has(key string) bool {
+	for _, k := range o.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the value ready to be encoded, with the struct fields
+// renamed. The values that encode themselves are kept untouched.
+func renameFields(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	t := v.Type()
+	if t.Implements(marshalerType) || t.Implements(textMarshalerType) {
+		return v.Interface()
+	}
+	if v.CanAddr() && (reflect.PtrTo(t).Implements(marshalerType) ||
+		reflect.PtrTo(t).Implements(textMarshalerType)) {
+		return v.Addr().Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return renameFields(v.Elem())
+
+	case reflect.Struct:
+		obj := &jsonObject{}
+		addFields(obj, v)
+		return obj
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := reflect.MakeMap(reflect.MapOf(t.Key(), interfaceType))
+		for _, key := range v.MapKeys() {
+			value := reflect.ValueOf(renameFields(v.MapIndex(key)))
+			if !value.IsValid() {
+				value = reflect.Zero(interfaceType)
+			}
+			m.SetMapIndex(key, value)
+		}
+		return m.Interface()
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 || (v.Kind() == reflect.Slice && v.IsNil()) {
+			return v.Interface()
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = renameFields(v.Index(i))
+		}
+		return items
+	}
+
+	return v.Interface()
+}
+
+// Adds the fields of the struct to the object, inlining the embedded ones
+// like encoding/json (the outer fields have precedence)
+func addFields(obj *jsonObject, v reflect.Value) {
+	t := v.Type()
+	embedded := []reflect.Value{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name, opts, tagged := fieldTag(field)
+		if name == "-" && opts == "" {
+			continue
+		}
+		value := v.Field(i)
+
+		if field.Anonymous && !tagged {
+			if value.Kind() == reflect.Ptr {
+				if value.IsNil() {
+					continue
+				}
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct {
+				embedded = append(embedded, value)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if !tagged {
+			name = jsonNaming(field.Name)
+		}
+		if strings.Contains(opts, "omitempty") && isEmptyValue(value) {
+			continue
+		}
+		if obj.has(name) {
+			continue
+		}
+
+		var encoded interface{} = renameFields(value)
+		if strings.Contains(opts, "string") {
+			if data, err := json.Marshal(encoded); err == nil {
+				encoded = string(data)
+			}
+		}
+		obj.keys = append(obj.keys, name)
+		obj.values = append(obj.values, encoded)
+	}
+
+	for _, value := range embedded {
+		addFields(obj, value)
+	}
+}
+
+// Returns the name & options of the json tag of the field, and true if it
+// has an explicit name
+func fieldTag(field reflect.StructField) (string, string, bool) {
+	tag := field.Tag.Get("json")
+	name, opts := tag, ""
+	if i := strings.Index(tag, ","); i != -1 {
+		name, opts = tag[:i], tag[i+1:]
+	}
+	return name, opts, name != ""
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Renames the keys of the decoded JSON to the Go names of the fields of
+// the destination type, so encoding/json can match them
+func renameKeys(value interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		if t.Implements(unmarshalerType) || t.Implements(textUnmarshalerType) {
+			return value
+		}
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return value
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if t.Kind() == reflect.Map {
+			for key, item := range v {
+				v[key] = renameKeys(item, t.Elem())
+			}
+			return v
+		}
+		if t.Kind() != reflect.Struct {
+			return v
+		}
+
+		fields := map[string]reflect.StructField{}
+		collectFields(fields, t)
+		result := map[string]interface{}{}
+		for key, item := range v {
+			field, ok := fields[key]
+			if !ok {
+				result[key] = item
+				continue
+			}
+			if _, _, tagged := fieldTag(field); !tagged {
+				key = field.Name
+			}
+			result[key] = renameKeys(item, field.Type)
+		}
+		return result
+
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return v
+		}
+		for i, item := range v {
+			v[i] = renameKeys(item, t.Elem())
+		}
+		return v
+	}
+
+	return value
+}
+
+// Indexes the fields of the struct by their JSON name, inlining the
+// embedded ones
+func collectFields(fields map[string]reflect.StructField, t reflect.Type) {
+	embedded := []reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, tagged := fieldTag(field)
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && !tagged && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, ft)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if !tagged {
+			name = jsonNaming(field.Name)
+		}
+		fields[name] = field
+	}
+
+	// The outer fields have precedence
+	for _, et := range embedded {
+		inner := map[string]reflect.StructField{}
+		collectFields(inner, et)
+		for name, field := range inner {
+			if _, ok := fields[name]; !ok {
+				fields[name] = field
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type lifecycleHook struct {
+	name string
+	fn   Handler
+}
+
+var (
+	warmupHooks, startHooks, stopHooks []*lifecycleHook
+	lifecycleRoutes                    = map[string]bool{}
+)
+
+// Registers a function that runs in the warmup requests (/_ah/warmup) of
+// the new instances, like template preloading or cache priming. Enable
+// the warmup inbound service in app.yaml. The hooks run in order; the
+// failures are reported like the errors of the handlers, without stopping
+// the rest of them. Call it at init().
+// Example:
+//    app.OnWarmup(func(r *app.Request) error {
+//      return app.PreloadTemplates([]string{"base", "home"})
+//    })
+func OnWarmup(fn Handler) {
+	warmupHooks = append(warmupHooks, newLifecycleHook(fn))
+	lifecycleRoute("/_ah/warmup", &warmupHooks, nil)
+}
+
+// Registers a function that runs in the start requests (/_ah/start) of
+// the instances with manual or basic scaling. Call it at init().
+func OnStart(fn Handler) {
+	startHooks = append(startHooks, newLifecycleHook(fn))
+	lifecycleRoute("/_ah/start", &startHooks, nil)
+}
+
+// Registers a function that runs in the stop requests (/_ah/stop) sent
+// before shutting down the instances with manual or basic scaling and
+// the Managed VMs. The flushers (see RegisterFlusher) run after all the
+// hooks. Call it at init().
+func OnStop(fn Handler) {
+	stopHooks = append(stopHooks, newLifecycleHook(fn))
+	stopRoute()
+}
+
+func stopRoute() {
+	lifecycleRoute("/_ah/stop", &stopHooks, func(r *Request) {
+		flushAll(r.C, "stop")
+	})
+}
+
+func newLifecycleHook(fn Handler) *lifecycleHook {
+	return &lifecycleHook{name: handlerName(fn), fn: fn}
+}
+
+// Returns the name of the function without the import path. The handlers
+// wrapped by a middleware with Wrap are named after the wrapped one.
+func handlerName(fn Handler) string {
+	if name, ok := wrappedName(fn); ok {
+		return name
+	}
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// Registers the route of the path that runs the hooks in order and then
+// calls after (if not nil). The hooks are read when the request arrives,
+// so the ones added later run too; calls after the first one for the
+// same path do nothing.
+func lifecycleRoute(path string, hooks *[]*lifecycleHook, after func(r *Request)) {
+	if lifecycleRoutes[path] {
+		return
+	}
+	lifecycleRoutes[path] = true
+
+	h := func(r *Request) error {
+		start := time.Now()
+		failed := 0
+		for _, hook := range *hooks {
+			hookStart := time.Now()
+			if err := runLifecycleHook(r, hook); err != nil {
+				failed++
+				r.LogError(fmt.Errorf("%s hook %s failed: %s", path, hook.name, err))
+				continue
+			}
+			r.C.Infof("[lifecycle] %s hook %s took %s", path, hook.name, time.Since(hookStart))
+		}
+		r.C.Infof("[lifecycle] %s finished in %s, %d of %d hooks failed", path,
+			time.Since(start), failed, len(*hooks))
+		if after != nil {
+			after(r)
+		}
+		return nil
+	}
+	recordRoute("lifecycle", newRouteGroup(), true, "", path, h)
+	rootRouter().Handle(path, appstatsWrapper(h))
+}
+
+// Runs the hook recovering its panics, so the next ones run too
+func runLifecycleHook(r *Request, hook *lifecycleHook) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic recovered error: %s", rec)
+		}
+	}()
+	return hook.fn(r)
+}
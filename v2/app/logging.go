@@ -0,0 +1,58 @@
+package app
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+)
+
+// Returns the ID of the request, generated the first time. It's emitted
+// in the X-Request-Id header and prefixes all the request log lines.
+func (r *Request) RequestId() string {
+	if r.requestId == "" {
+		r.requestId = hex.EncodeToString(securecookie.GenerateRandomKey(8))
+	}
+	return r.requestId
+}
+
+// Returns the path template of the route that matched the request,
+// or the path if it's unknown
+func (r *Request) RouteName() string {
+	if route := mux.CurrentRoute(r.Req); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.Req.URL.Path
+}
+
+func (r *Request) logPrefix() string {
+	if r.IsCanary() {
+		return fmt.Sprintf("[%s %s canary:%s] ", r.RequestId(), r.RouteName(), r.Version())
+	}
+	return fmt.Sprintf("[%s %s] ", r.RequestId(), r.RouteName())
+}
+
+// Returns the args of the format with the prefix first. The prefix can
+// have % characters of the path, it's never part of the format.
+func (r *Request) logArgs(args []interface{}) []interface{} {
+	return append([]interface{}{r.logPrefix()}, args...)
+}
+
+func (r *Request) Debugf(format string, args ...interface{}) {
+	r.C.Debugf("%s"+format, r.logArgs(args)...)
+}
+
+func (r *Request) Infof(format string, args ...interface{}) {
+	r.C.Infof("%s"+format, r.logArgs(args)...)
+}
+
+func (r *Request) Warnf(format string, args ...interface{}) {
+	r.C.Warningf("%s"+format, r.logArgs(args)...)
+}
+
+func (r *Request) Errorf(format string, args ...interface{}) {
+	r.C.Errorf("%s"+format, r.logArgs(args)...)
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"sort"
+	"strings"
+)
+
+// Router with one handler per method and path. Requests with a method
+// not registered for the path receive a 405 error automatically.
+//
+// Example:
+//    m := app.NewMux()
+//    m.Get("/_/example", example.List)
+//    m.Post("/_/example", example.Save)
+//
+type Mux struct {
+	routes map[string]map[string]Handler
+	group  int
+}
+
+func NewMux() *Mux {
+	return &Mux{routes: map[string]map[string]Handler{}, group: newRouteGroup()}
+}
+
+func (m *Mux) Get(path string, h Handler) {
+	m.Handle("GET", path, h)
+}
+
+func (m *Mux) Post(path string, h Handler) {
+	m.Handle("POST", path, h)
+}
+
+func (m *Mux) Put(path string, h Handler) {
+	m.Handle("PUT", path, h)
+}
+
+func (m *Mux) Delete(path string, h Handler) {
+	m.Handle("DELETE", path, h)
+}
+
+// Register the handler for the method and path. Call it at init().
+func (m *Mux) Handle(method, path string, h Handler) {
+	methods, ok := m.routes[path]
+	if _, ok := methods[method]; ok {
+		panic("route registered twice: " + method + " " + path)
+	}
+	recordRoute("mux", m.group, true, method, path, h)
+	if !ok {
+		methods = map[string]Handler{}
+		m.routes[path] = methods
+		rootRouter().Handle(path, appstatsWrapper(m.dispatcher(path)))
+	}
+	methods[method] = h
+}
+
+func (m *Mux) dispatcher(path string) Handler {
+	return func(r *Request) error {
+		methods := m.routes[path]
+		h, ok := methods[r.Req.Method]
+		if !ok && r.Req.Method == "HEAD" {
+			h, ok = methods["GET"]
+		}
+		if !ok {
+			allow := []string{}
+			for method := range methods {
+				allow = append(allow, method)
+			}
+			sort.Strings(allow)
+			r.W.Header().Set("Allow", strings.Join(allow, ", "))
+			return NotAllowed()
+		}
+
+		return h(r)
+	}
+}
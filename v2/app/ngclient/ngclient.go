@@ -0,0 +1,168 @@
+// Generates an Angular module with the named routes of the application
+// (see app.NameRoute), so the client code doesn't hardcode the paths.
+package ngclient
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+// Name of the generated Angular module
+var ModuleName = "routes"
+
+type route struct {
+	Name, Path string
+	Methods    []string
+}
+
+var moduleTemplate = template.Must(template.New("module").Parse(`// Generated from the named routes of the server, don't edit it.
+angular.module({{.Module}}, [])
+.constant('ROUTES', {{.Paths}})
+.factory('routeUrl', ['ROUTES', function(ROUTES) {
+  // Escapes the segments of the value like app.URLFor, keeping the
+  // slashes of the vars that match several segments
+  function escape(value) {
+    return String(value).split('/').map(function(segment) {
+      return encodeURIComponent(segment).replace(/[!'()*]/g, function(c) {
+        return '%' + c.charCodeAt(0).toString(16).toUpperCase();
+      }).replace(/%(24|26|2B|3A|3D|40)/g, function(m) {
+        return decodeURIComponent(m);
+      });
+    }).join('/');
+  }
+
+  // Builds the URL of the named route replacing its {vars} with the params
+  return function(name, params) {
+    if (!ROUTES.hasOwnProperty(name)) {
+      throw new Error('route not named: ' + name);
+    }
+    return ROUTES[name].replace(/\{([^}:]+)(:[^}]+)?\}/g, function(m, v) {
+      if (!params || params[v] === undefined) {
+        throw new Error('missing var ' + v + ' of route ' + name);
+      }
+      return escape(params[v]);
+    });
+  };
+}])
+.factory('Api', ['$http', 'routeUrl', function($http, routeUrl) {
+  return {
+{{range $i, $r := .Routes}}{{if $i}},
+{{end}}    {{js $r.Name | printf "'%s'"}}: {
+{{range $j, $m := $r.Methods}}{{if $j}},
+{{end}}      '{{$m.Fn}}': function(params{{if $m.Data}}, data{{end}}, config) {
+        return $http['{{$m.Fn}}'](routeUrl({{js $r.Name | printf "'%s'"}}, params){{if $m.Data}}, data{{end}}, config);
+      }{{end}}
+    }{{end}}
+  };
+}]);
+`))
+
+type method struct {
+	Fn   string
+	Data bool
+}
+
+// Writes the Angular module with the ROUTES constant (name -> path), the
+// routeUrl(name, params) function and the Api service, with a wrapper of
+// $http for each method of the named routes.
+// Example: Api.orderShow.get({id: 12}).then(...)
+func Generate(w io.Writer) error {
+	routes := []*route{}
+	for name, path := range app.NamedRoutes() {
+		routes = append(routes, &route{Name: name, Path: path, Methods: app.RouteMethods(path)})
+	}
+	sort.Sort(byName(routes))
+
+	paths := map[string]string{}
+	data := []map[string]interface{}{}
+	for _, r := range routes {
+		paths[r.Name] = r.Path
+
+		methods := []*method{}
+		for _, m := range r.Methods {
+			if m == "" {
+				// The routes without method can be used with all of them
+				methods = append(methods, httpMethods("GET", "POST", "PUT", "DELETE")...)
+				continue
+			}
+			methods = append(methods, httpMethods(m)...)
+		}
+		data = append(data, map[string]interface{}{"Name": r.Name, "Methods": dedup(methods)})
+	}
+
+	encoded, err := json.MarshalIndent(paths, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode routes failed: %s", err)
+	}
+	module, err := json.Marshal(ModuleName)
+	if err != nil {
+		return fmt.Errorf("encode module name failed: %s", err)
+	}
+
+	err = moduleTemplate.Execute(w, map[string]interface{}{
+		"Module": string(module),
+		"Paths":  string(encoded),
+		"Routes": data,
+	})
+	if err != nil {
+		return fmt.Errorf("exec routes module failed: %s", err)
+	}
+	return nil
+}
+
+// Serves the generated module, to load it from the Angular pages.
+// Example: "GET::/scripts/routes.js": ngclient.ScriptHandler,
+func ScriptHandler(r *app.Request) error {
+	buf := bytes.NewBuffer(nil)
+	if err := Generate(buf); err != nil {
+		return err
+	}
+
+	r.W.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	return r.ServeWithETag(fmt.Sprintf("%x", sha1.Sum(buf.Bytes())), func() error {
+		_, err := r.W.Write(buf.Bytes())
+		return err
+	})
+}
+
+// Returns the wrappers of the methods; the ones with a body receive
+// the data to send
+func httpMethods(names ...string) []*method {
+	methods := []*method{}
+	for _, name := range names {
+		name = strings.ToUpper(name)
+		switch name {
+		case "GET", "DELETE", "HEAD":
+			methods = append(methods, &method{Fn: strings.ToLower(name)})
+		case "POST", "PUT", "PATCH":
+			methods = append(methods, &method{Fn: strings.ToLower(name), Data: true})
+		}
+	}
+	return methods
+}
+
+func dedup(methods []*method) []*method {
+	seen := map[string]bool{}
+	result := []*method{}
+	for _, m := range methods {
+		if !seen[m.Fn] {
+			seen[m.Fn] = true
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+type byName []*route
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
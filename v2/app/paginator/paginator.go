@@ -0,0 +1,181 @@
+package paginator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/filter"
+)
+
+var (
+	// Name of the query string param with the page token
+	Param = "page"
+
+	// Name of the query string param with the filter
+	FilterParam = "q"
+
+	// Number of previous pages remembered by the page token, that
+	// stores their start cursors. Going back further returns to the
+	// first page.
+	MaxHistory = 20
+)
+
+type Paginator struct {
+	Query    *datastore.Query
+	PageSize int
+
+	// Fields allowed in the filter param, nil to ignore it. Bad
+	// filters are returned as a *filter.Error.
+	Filters filter.Schema
+}
+
+// Results of a page prepared for the templates:
+//    {{if .Page.HasPrev}}<a href="?page={{.Page.Prev}}">Prev</a>{{end}}
+//    Page {{.Page.Number}}
+//    {{if .Page.HasNext}}<a href="?page={{.Page.Next}}">Next</a>{{end}}
+type Page struct {
+	Keys []*datastore.Key
+
+	// Tokens of the adjacent pages, to use as the page param
+	Next, Prev       string
+	HasNext, HasPrev bool
+
+	// Number of the page, starting at 1
+	Number int
+
+	// Filter applied to the results, as it was typed. The templates
+	// escape it in the links:
+	//    <a href="?q={{.Page.Filter}}&page={{.Page.Next}}">Next</a>
+	Filter string
+}
+
+func New(q *datastore.Query, pageSize int) *Paginator {
+	return &Paginator{Query: q, PageSize: pageSize}
+}
+
+// Loads the page requested in the query string into dst, a pointer
+// to a slice of structs or pointers to structs (nil for keys only queries).
+func (p *Paginator) Load(r *app.Request, dst interface{}) (*Page, error) {
+	// The token stores the start cursors of the last pages, datastore
+	// cursors can only go forward.
+	number, cursors, ok := decodeToken(r.Req.URL.Query().Get(Param))
+	if !ok {
+		r.C.Errorf("[paginator] bad page token")
+		return nil, app.NotFound()
+	}
+
+	query := p.Query
+	filterText := r.Req.URL.Query().Get(FilterParam)
+	if p.Filters != nil && filterText != "" {
+		parsed, err := filter.Parse(filterText, p.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if query, err = parsed.Datastore(query); err != nil {
+			return nil, err
+		}
+	}
+
+	q := query.Limit(p.PageSize)
+	if len(cursors) > 0 {
+		cur, err := datastore.DecodeCursor(cursors[len(cursors)-1])
+		if err != nil {
+			r.C.Errorf("[paginator] bad cursor: %s", err)
+			return nil, app.NotFound()
+		}
+		q = q.Start(cur)
+	}
+
+	page := &Page{Number: number}
+	if p.Filters != nil {
+		page.Filter = filterText
+	}
+
+	var slice reflect.Value
+	if dst != nil {
+		slice = reflect.ValueOf(dst).Elem()
+	}
+	it := q.Run(r.C)
+	for {
+		var elem reflect.Value
+		var target interface{}
+		if dst != nil {
+			elemType := slice.Type().Elem()
+			if elemType.Kind() == reflect.Ptr {
+				elem = reflect.New(elemType.Elem())
+				target = elem.Interface()
+			} else {
+				elem = reflect.New(elemType)
+				target = elem.Interface()
+				elem = elem.Elem()
+			}
+		}
+
+		key, err := it.Next(target)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("query next failed: %s", err)
+		}
+
+		page.Keys = append(page.Keys, key)
+		if dst != nil {
+			slice.Set(reflect.Append(slice, elem))
+		}
+	}
+
+	end, err := it.Cursor()
+	if err != nil {
+		return nil, fmt.Errorf("get cursor failed: %s", err)
+	}
+
+	// Look for more results after the page
+	if len(page.Keys) == p.PageSize {
+		keys, err := query.KeysOnly().Start(end).Limit(1).GetAll(r.C, nil)
+		if err != nil {
+			return nil, fmt.Errorf("check next page failed: %s", err)
+		}
+		if len(keys) > 0 {
+			page.HasNext = true
+			page.Next = encodeToken(number+1, append(cursors, end.String()))
+		}
+	}
+	if number > 1 {
+		page.HasPrev = true
+		page.Prev = encodeToken(number-1, cursors[:len(cursors)-1])
+	}
+
+	return page, nil
+}
+
+// Returns the number of the page and the start cursors of the last
+// pages up to it. The first page has an empty token.
+func decodeToken(token string) (int, []string, bool) {
+	if token == "" {
+		return 1, nil, true
+	}
+	parts := strings.Split(token, ".")
+	number, err := strconv.Atoi(parts[0])
+	if err != nil || number < 2 || len(parts) < 2 || len(parts) > number {
+		return 0, nil, false
+	}
+	return number, parts[1:], true
+}
+
+// Returns the token of the page, keeping only the last MaxHistory
+// cursors. It's empty for the first page and for the pages whose
+// cursor was dropped, that return to the first one.
+func encodeToken(number int, cursors []string) string {
+	if len(cursors) > MaxHistory {
+		cursors = cursors[len(cursors)-MaxHistory:]
+	}
+	if number < 2 || len(cursors) == 0 {
+		return ""
+	}
+	return strconv.Itoa(number) + "." + strings.Join(cursors, ".")
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"html/template"
+)
+
+func init() {
+	RegisterTemplateFuncs(template.FuncMap{
+		// Script tag of PostScript with the nonce of the current request.
+		// It's only available in the templates executed with Request.Template.
+		// Example: {{post_script}}
+		"post_script": func() template.HTML {
+			return ""
+		},
+	})
+}
+
+// Script of the pages that post to the handlers of the router. It defines
+// gaelib.post(path, data, done), that sends the data as JSON with the
+// XSRF token of the cookie in the header checked by the router, calling
+// done with the status and the text of the response. The forms with a
+// data-post attribute are sent with it to their action, with the values
+// of their named fields (true or false for the checkboxes). The data-ok
+// or data-error message is shown in the element of the data-result ID,
+// and data-next is opened after a successful post.
+// Example:
+//    <form action="/_/contact" data-post data-result="contact-result"
+//        data-ok="Sent, thanks!" data-error="Please check the fields">
+//      <input type="email" name="email" required>
+//      <button type="submit">Send</button>
+//    </form>
+//    <p id="contact-result"></p>
+//    {{post_script}}
+const PostScript = `(function() {
+  var gaelib = window.gaelib = window.gaelib || {};
+  gaelib.post = function(path, data, done) {
+    var token = (document.cookie.match(/(?:^|; )XSRF-TOKEN=([^;]*)/) || [])[1] || '';
+    var xhr = new XMLHttpRequest();
+    xhr.open('POST', path);
+    xhr.setRequestHeader('Content-Type', 'application/json');
+    xhr.setRequestHeader('Accept', 'application/json');
+    xhr.setRequestHeader('X-Xsrf-Token', decodeURIComponent(token));
+    xhr.onload = function() {
+      if (done) {
+        done(xhr.status, xhr.responseText);
+      }
+    };
+    xhr.send(JSON.stringify(data));
+  };
+  gaelib.formData = function(form) {
+    var data = {};
+    Array.prototype.forEach.call(form.elements, function(field) {
+      if (!field.name || field.disabled) {
+        return;
+      }
+      if (field.type == 'checkbox') {
+        data[field.name] = field.checked;
+      } else if (field.type != 'radio' || field.checked) {
+        data[field.name] = field.value;
+      }
+    });
+    return data;
+  };
+  if (gaelib.bound) {
+    return;
+  }
+  gaelib.bound = true;
+  document.addEventListener('submit', function(e) {
+    var form = e.target;
+    if (!form.hasAttribute('data-post')) {
+      return;
+    }
+    e.preventDefault();
+    gaelib.post(form.getAttribute('action'), gaelib.formData(form), function(status, text) {
+      var ok = status >= 200 && status < 300;
+      if (ok && form.getAttribute('data-next')) {
+        location.href = form.getAttribute('data-next');
+        return;
+      }
+      var result = document.getElementById(form.getAttribute('data-result'));
+      if (result) {
+        result.textContent = form.getAttribute(ok ? 'data-ok' : 'data-error') || text;
+      }
+    });
+  });
+})();`
+
+// Returns the script tag of PostScript with the nonce of the request
+func (r *Request) PostScript() template.HTML {
+	return template.HTML(`<script nonce="` + template.HTMLEscapeString(r.CSPNonce()) + `">` +
+		PostScript + `</script>`)
+}
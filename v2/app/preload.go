@@ -0,0 +1,62 @@
+package app
+
+import (
+	"fmt"
+	"html/template"
+)
+
+// Adds a Link header to preload the resource (or push it if the frontend
+// supports HTTP/2). asType is the kind of resource: script, style,
+// image, font, etc. Repeated URLs are emitted only once, including the
+// ones declared with {{preload}} in the templates of the request.
+func (r *Request) Preload(url, asType string) {
+	if !r.markPreload(url) {
+		return
+	}
+
+	link := fmt.Sprintf("<%s>; rel=preload; as=%s", url, asType)
+	if asType == "font" {
+		link += "; crossorigin"
+	}
+	r.W.Header().Add("Link", link)
+}
+
+// Records the preload of the URL, returning false if it was already
+// declared in the request
+func (r *Request) markPreload(url string) bool {
+	if r.preloads == nil {
+		r.preloads = map[string]bool{}
+	}
+	if r.preloads[url] {
+		return false
+	}
+	r.preloads[url] = true
+	return true
+}
+
+func init() {
+	// Declares a critical asset inside the templates
+	// Example: {{preload "/styles/main.css" "style"}}
+	RegisterTemplateFuncs(template.FuncMap{
+		"preload": preloadTag,
+	})
+	RegisterRequestTemplateFuncs(func(r *Request) template.FuncMap {
+		return template.FuncMap{
+			"preload": func(url, asType string) template.HTML {
+				if !r.markPreload(url) {
+					return ""
+				}
+				return preloadTag(url, asType)
+			},
+		}
+	})
+}
+
+func preloadTag(url, asType string) template.HTML {
+	crossorigin := ""
+	if asType == "font" {
+		crossorigin = " crossorigin"
+	}
+	return template.HTML(fmt.Sprintf(`<link rel="preload" href="%s" as="%s"%s>`,
+		template.HTMLEscapeString(url), template.HTMLEscapeString(asType), crossorigin))
+}
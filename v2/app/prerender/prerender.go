@@ -0,0 +1,273 @@
+// Snapshots of the Angular pages served to the crawlers, that don't run
+// the JavaScript of the application.
+package prerender
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/cache"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+const KindSnapshot = "PrerenderSnapshot"
+
+var (
+	// URL of the rendering service that runs the JavaScript of the pages.
+	// The escaped URL of the page is appended to it. Empty renders the
+	// snapshots with the server templates of Serve.
+	// Example: prerender.ServiceURL = "https://render.example.com/render?url="
+	ServiceURL = ""
+
+	// Token sent to the rendering service in the X-Prerender-Token header
+	ServiceToken = ""
+
+	// Deadline of the requests to the rendering service
+	ServiceDeadline = 30 * time.Second
+
+	// Time the snapshots are served before rendering them again
+	TTL = 24 * time.Hour
+
+	// Host of the snapshot URLs. Empty uses the default hostname of the
+	// application; the Host header of the requests is never trusted.
+	Host = ""
+
+	// Query params kept in the snapshot URLs, the rest are ignored so
+	// random params can't create new snapshots
+	Params = []string{}
+
+	// Maximum snapshots rendered per minute in all the instances. The
+	// user agents can be spoofed; the next crawlers receive the shell.
+	MaxRendersPerMinute uint64 = 30
+
+	// Lowercase fragments of the user agents of the crawlers that don't
+	// support the _escaped_fragment_ scheme
+	Bots = []string{"googlebot", "bingbot", "yandex", "baiduspider",
+		"facebookexternalhit", "twitterbot", "linkedinbot", "slackbot",
+		"pinterest", "whatsapp", "embedly", "quora link preview"}
+)
+
+const escapedFragmentParam = "_escaped_fragment_"
+
+// Stored snapshot, with the hash of its URL as the ID
+type Snapshot struct {
+	URL     string
+	HTML    []byte `datastore:",noindex"`
+	Created time.Time
+}
+
+// Renders the snapshot of the page with the server templates
+// Example:
+//    func newsSnapshot(r *app.Request, w io.Writer) error {
+//      return app.Template(w, []string{"snapshots/base", "snapshots/news"}, news)
+//    }
+type SnapshotFunc func(r *app.Request, w io.Writer) error
+
+// Returns true if the request comes from a crawler: it has the
+// _escaped_fragment_ param or the user agent of a known bot.
+func IsCrawler(req *http.Request) bool {
+	if _, ok := req.URL.Query()[escapedFragmentParam]; ok {
+		return true
+	}
+	ua := strings.ToLower(req.UserAgent())
+	for _, bot := range Bots {
+		if strings.Contains(ua, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decorates the handler of the JS shell page to serve a snapshot of the
+// page to the crawlers instead. The snapshots come from ServiceURL or,
+// without it, from render (nil serves the shell to the crawlers too).
+// They're cached in memcache and the datastore for TTL.
+// Example: "GET::/news": prerender.Serve(newsSnapshot, pages.App),
+func Serve(render SnapshotFunc, h app.Handler) app.Handler {
+	return app.Wrap(h, func(r *app.Request) error {
+		if r.Req.Method != "GET" || (ServiceURL == "" && render == nil) {
+			return h(r)
+		}
+
+		// The caches shouldn't serve the snapshots to the users or the
+		// shell to the crawlers
+		r.W.Header().Add("Vary", "User-Agent")
+		if !IsCrawler(r.Req) {
+			return h(r)
+		}
+
+		u := pageURL(r.Req, canonicalHost(r.C))
+		html, err := snapshot(r, u, render)
+		if err != nil {
+			// The crawlers receive the shell instead of an error page
+			r.LogError(fmt.Errorf("prerender %s failed: %s", u, err))
+			return h(r)
+		}
+
+		r.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err = r.W.Write(html)
+		return err
+	})
+}
+
+// Removes the cached snapshot of the URL, rendered again the next time
+// a crawler requests it
+func Purge(c appengine.Context, u string) error {
+	if err := cache.Delete(c, cacheKey(u)); err != nil {
+		return err
+	}
+	if err := datastore.Delete(c, snapshotKey(c, u)); err != nil && err != datastore.ErrNoSuchEntity {
+		return fmt.Errorf("delete snapshot failed: %s", err)
+	}
+	return nil
+}
+
+// Returns the HTML of the snapshot of the URL, from the cache, the
+// datastore or rendering it again
+func snapshot(r *app.Request, u string, render SnapshotFunc) ([]byte, error) {
+	c := r.C
+	s := new(Snapshot)
+	if err := cache.Get(c, cacheKey(u), s); err == nil {
+		return s.HTML, nil
+	} else if err != cache.ErrMiss {
+		c.Warningf("[prerender] read cached snapshot failed: %s", err)
+	}
+
+	key := snapshotKey(c, u)
+	if err := datastore.Get(c, key, s); err == nil && time.Since(s.Created) < TTL {
+		setCache(c, u, s)
+		return s.HTML, nil
+	} else if err != nil && err != datastore.ErrNoSuchEntity {
+		c.Warningf("[prerender] read stored snapshot failed: %s", err)
+	}
+
+	if !allowRender(c) {
+		return nil, fmt.Errorf("more than %d renders per minute", MaxRendersPerMinute)
+	}
+
+	var html []byte
+	var err error
+	if ServiceURL != "" {
+		html, err = fetch(c, u)
+	} else {
+		buf := bytes.NewBuffer(nil)
+		err = render(r, buf)
+		html = buf.Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Infof("[prerender] snapshot of %s rendered", u)
+
+	s = &Snapshot{URL: u, HTML: html, Created: time.Now()}
+	if _, err := datastore.Put(c, key, s); err != nil {
+		c.Warningf("[prerender] store snapshot failed: %s", err)
+	}
+	setCache(c, u, s)
+	return html, nil
+}
+
+// Requests the snapshot of the URL to the rendering service
+func fetch(c appengine.Context, u string) ([]byte, error) {
+	req, err := http.NewRequest("GET", ServiceURL+url.QueryEscape(u), nil)
+	if err != nil {
+		return nil, fmt.Errorf("build service request failed: %s", err)
+	}
+	if ServiceToken != "" {
+		req.Header.Set("X-Prerender-Token", ServiceToken)
+	}
+
+	resp, err := platform.Current.HTTPClient(c, ServiceDeadline).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("service request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("service answered with status %d", resp.StatusCode)
+	}
+	html, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read service response failed: %s", err)
+	}
+	return html, nil
+}
+
+// Returns the canonical URL of the page seen by the browsers, with the
+// allowed params and the #! fragment of the _escaped_fragment_ requests
+func pageURL(req *http.Request, host string) string {
+	u := &url.URL{Scheme: "https", Host: host, Path: req.URL.Path}
+
+	query := req.URL.Query()
+	kept := url.Values{}
+	for _, param := range Params {
+		if values, ok := query[param]; ok {
+			kept[param] = values
+		}
+	}
+	u.RawQuery = kept.Encode()
+
+	s := u.String()
+	if fragment := query.Get(escapedFragmentParam); fragment != "" {
+		s += "#!" + fragment
+	}
+	return s
+}
+
+func canonicalHost(c appengine.Context) string {
+	if Host != "" {
+		return Host
+	}
+	return platform.Current.DefaultHostname(c)
+}
+
+// Counts the render in the current minute, returning false if
+// the limit is exceeded. The counter is always kept in memcache, a
+// transactional store would contend under a crawl.
+func allowRender(c appengine.Context) bool {
+	if MaxRendersPerMinute == 0 {
+		return true
+	}
+	key := fmt.Sprintf("prerender-renders:%d", time.Now().Unix()/60)
+	n, err := store.Memcache(c).Increment(key, 1, 0)
+	if err != nil {
+		// The crawlers receive the shell rather than skipping the limit
+		c.Warningf("[prerender] count renders failed: %s", err)
+		return false
+	}
+	return n <= MaxRendersPerMinute
+}
+
+// The URLs can be longer than the memcache keys
+func urlHash(u string) string {
+	hash := sha1.Sum([]byte(u))
+	return hex.EncodeToString(hash[:])
+}
+
+func cacheKey(u string) string {
+	return "prerender:" + urlHash(u)
+}
+
+func snapshotKey(c appengine.Context, u string) *datastore.Key {
+	return datastore.NewKey(c, KindSnapshot, urlHash(u), 0, nil)
+}
+
+func setCache(c appengine.Context, u string, s *Snapshot) {
+	ttl := TTL - time.Since(s.Created)
+	if err := cache.Set(c, cacheKey(u), s, ttl); err != nil {
+		c.Warningf("[prerender] cache snapshot failed: %s", err)
+	}
+}
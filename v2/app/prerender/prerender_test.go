@@ -0,0 +1,50 @@
+package prerender
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPageURL(t *testing.T) {
+	Params = []string{"page"}
+	defer func() { Params = []string{} }()
+
+	tests := []struct {
+		url, expected string
+	}{
+		{"http://evil.com/news", "https://example.com/news"},
+		{"/news?page=2&utm_source=x&rnd=123", "https://example.com/news?page=2"},
+		{"/?_escaped_fragment_=/news/3", "https://example.com/#!/news/3"},
+		{"/?_escaped_fragment_=", "https://example.com/"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pageURL(req, "example.com"); got != test.expected {
+			t.Errorf("url %s: got %s, expected %s", test.url, got, test.expected)
+		}
+	}
+}
+
+func TestIsCrawler(t *testing.T) {
+	tests := []struct {
+		url, ua  string
+		expected bool
+	}{
+		{"/news", "Mozilla/5.0 (compatible; Googlebot/2.1)", true},
+		{"/news", "Mozilla/5.0 (X11; Linux x86_64) Chrome/40.0", false},
+		{"/news?_escaped_fragment_=", "Mozilla/5.0 Chrome/40.0", true},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", test.ua)
+		if got := IsCrawler(req); got != test.expected {
+			t.Errorf("%s %q: got %v, expected %v", test.url, test.ua, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+const KindErrorReport = "ErrorReport"
+
+var numbersRe = regexp.MustCompile(`0x[0-9a-f]+|[0-9]+`)
+
+// Occurrences of the errors with the same fingerprint
+type ErrorReport struct {
+	Message     string `datastore:",noindex"`
+	Count       int64
+	First, Last time.Time
+	Notified    time.Time `datastore:",noindex"`
+
+	// The memcache counter of the error has occurrences not added
+	// to Count yet
+	Pending bool
+}
+
+// Returns the fingerprint of an error (with its stack if present),
+// ignoring the numbers that change between occurrences: IDs,
+// addresses, etc.
+func errorFingerprint(errorStr string) string {
+	normalized := numbersRe.ReplaceAllString(errorStr, "N")
+	return fmt.Sprintf("%x", sha1.Sum([]byte(normalized)))
+}
+
+func errorCounterKey(id string) string {
+	return "error-report:" + id
+}
+
+// Counts the occurrence of the error in memcache and emails the admins
+// the first time it happens. The rest of the occurrences are sent in
+// the digest of ErrorDigestHandler. The request ID is included in the
+// mail to find the logs of the request.
+func reportError(c appengine.Context, requestId, errorStr string) {
+	id := errorFingerprint(errorStr)
+	st := store.Memcache(c)
+	n, err := st.Increment(errorCounterKey(id), 1, 0)
+	if err != nil {
+		c.Errorf("[reports] cannot count the error: %s", err)
+		return
+	}
+	if n > 1 {
+		// The report is already waiting for the next digest
+		return
+	}
+
+	// Only the first occurrence since the last digest touches the
+	// datastore, the transaction doesn't contend under an error flood
+	key := datastore.NewKey(c, KindErrorReport, id, 0, nil)
+	var notify bool
+	err = datastore.RunInTransaction(c, func(c appengine.Context) error {
+		report := new(ErrorReport)
+		if err := datastore.Get(c, key, report); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		now := time.Now()
+		notify = report.First.IsZero()
+		if notify {
+			report.First = now
+			report.Message = errorStr
+			report.Notified = now
+			report.Count = 1
+		}
+		report.Last = now
+		report.Pending = true
+
+		_, err := datastore.Put(c, key, report)
+		return err
+	}, nil)
+	if err != nil {
+		c.Errorf("[reports] cannot store the error report: %s", err)
+		return
+	}
+
+	if notify {
+		// The first occurrence is already counted and notified, leave it
+		// out of the digest
+		if _, err := st.Increment(errorCounterKey(id), -1, 0); err != nil {
+			c.Warningf("[reports] cannot discount the error: %s", err)
+		}
+		sendErrorByEmail(c, fmt.Sprintf("request %s:\n\n%s", requestId, errorStr))
+	}
+}
+
+// Adds the occurrences counted in memcache to the pending reports and
+// emails the admins a digest with them. Schedule it in cron.yaml; the
+// schedule is the minimum time between two notifications of an error.
+// Example: app.Cron("/tasks/error-digest", app.ErrorDigestHandler)
+func ErrorDigestHandler(r *Request) error {
+	q := datastore.NewQuery(KindErrorReport).Filter("Pending =", true).KeysOnly()
+	keys, err := q.GetAll(r.C, nil)
+	if err != nil {
+		return fmt.Errorf("query pending error reports failed: %s", err)
+	}
+
+	digest := []string{}
+	for _, key := range keys {
+		line, err := digestErrorReport(r.C, key)
+		if err != nil {
+			return err
+		}
+		if line != "" {
+			digest = append(digest, line)
+		}
+	}
+
+	if len(digest) > 0 {
+		sendErrorByEmail(r.C, strings.Join(digest, "\n\n----------\n\n"))
+	}
+	return nil
+}
+
+// Moves the occurrences of the memcache counter to the report,
+// returning its line of the digest
+func digestErrorReport(c appengine.Context, key *datastore.Key) (string, error) {
+	st := store.Memcache(c)
+	counter := errorCounterKey(key.StringID())
+
+	// Take the counted occurrences, the new ones stay in the counter
+	var taken int64
+	item, err := st.Get(counter)
+	if err != nil && err != store.ErrNotFound {
+		return "", err
+	}
+	if item != nil {
+		taken, _ = strconv.ParseInt(string(item.Value), 10, 64)
+		if taken > 0 {
+			if _, err := st.Increment(counter, -taken, 0); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	var line string
+	err = datastore.RunInTransaction(c, func(c appengine.Context) error {
+		line = ""
+		report := new(ErrorReport)
+		if err := datastore.Get(c, key, report); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if taken > 0 {
+			line = fmt.Sprintf("%d occurrences since %s:\n\n%s", taken,
+				report.Notified.Format(time.RFC1123), report.Message)
+			report.Count += taken
+			report.Notified = now
+		}
+
+		// Errors reported after reading the counter set the flag again in
+		// their own transaction, after this one
+		pending, err := st.Get(counter)
+		if err != nil && err != store.ErrNotFound {
+			return err
+		}
+		report.Pending = pending != nil && string(pending.Value) != "0"
+
+		_, err = datastore.Put(c, key, report)
+		return err
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("update error report failed: %s", err)
+	}
+
+	return line, nil
+}
+
+// Emails the admins again the error report with the fingerprint id
+func ResendErrorReport(c appengine.Context, id string) error {
+	key := datastore.NewKey(c, KindErrorReport, id, 0, nil)
+
+	report := new(ErrorReport)
+	if err := datastore.Get(c, key, report); err == datastore.ErrNoSuchEntity {
+		return NotFound()
+	} else if err != nil {
+		return fmt.Errorf("resend error report failed: %s", err)
+	}
+
+	sendErrorByEmail(c, fmt.Sprintf("%d occurrences since %s, last one at %s:\n\n%s",
+		report.Count, report.First.Format(time.RFC1123), report.Last.Format(time.RFC1123),
+		report.Message))
+	return nil
+}
+
+var reportsTemplate = template.Must(template.New("reports").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Recent errors</title></head>
+<body>
+  <h1>Recent errors</h1>
+  <table>
+    <tr><th>Last</th><th>First</th><th>Count</th><th>Message</th></tr>
+    {{range .}}
+    <tr>
+      <td>{{.Last.Format "2006-01-02 15:04:05"}}</td>
+      <td>{{.First.Format "2006-01-02 15:04:05"}}</td>
+      <td>{{.Count}}</td>
+      <td><pre>{{.Message}}</pre></td>
+    </tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
+
+// Admin page with the most recent errors of the application.
+// Example: "GET::/admin/errors": app.ErrorReportsHandler,
+func ErrorReportsHandler(r *Request) error {
+	if !user.IsAdmin(r.C) {
+		return Forbidden()
+	}
+
+	var reports []*ErrorReport
+	q := datastore.NewQuery(KindErrorReport).Order("-Last").Limit(50)
+	if _, err := q.GetAll(r.C, &reports); err != nil {
+		return fmt.Errorf("query error reports failed: %s", err)
+	}
+
+	if err := reportsTemplate.Execute(r.W, reports); err != nil {
+		return fmt.Errorf("exec reports template failed: %s", err)
+	}
+
+	return nil
+}
@@ -1,34 +1,216 @@
 package app
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
+	"html/template"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"appengine"
 
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
 	"github.com/gorilla/schema"
 	"github.com/mjibson/goon"
 	"github.com/gorilla/sessions"
 )
 
 var (
-	schemaDecoder = schema.NewDecoder()
-	errorHandlers = map[int]Handler{}
+	schemaDecoder   = schema.NewDecoder()
+	errorHandlers   = map[int]Handler{}
+	errorSerializer = func(r *Request, e *ErrorResponse) error {
+		return r.EmitJson(e)
+	}
+	apiPrefixes = []string{}
 )
 
+// Body of the errors returned to JSON clients
+type ErrorResponse struct {
+	Code      int               `json:"code"`
+	Message   string            `json:"message"`
+	RequestId string            `json:"request_id"`
+	Field     string            `json:"field,omitempty"`
+	Offset    int64             `json:"offset,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
 type Request struct {
 	Req *http.Request
 	W   http.ResponseWriter
 	C   appengine.Context
 	N   *goon.Goon
 	Session *sessions.Session
+
+	start, deadline time.Time
+	preloads        map[string]bool
+	cspNonce        string
+	requestId       string
+	values          map[string]interface{}
+	forceJson       bool
+	templates       []string
+	xsrfToken       string
+	datastoreBudget int
+	budgetErr       error
+
+	// State prepared by NewRequest for Run
+	keys          *secretKeys
+	oldXsrfToken  []uint8
+	setupErr      error
+	preflight     bool
+	trustedOrigin bool
+}
+
+// Builds the request passed to the handlers: it emits the default
+// headers, answers the CORS preflights and loads the session and the XSRF
+// token of the cookies. The router uses it with Run; the tests and other
+// entry points can do the same.
+func NewRequest(c appengine.Context, w http.ResponseWriter, req *http.Request) *Request {
+	// Emit the default headers (you can overwrite them from the
+	// handlers if needed)
+	for name, values := range defaultHeaders {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+
+	rw := newResponseWriter(w)
+	r := &Request{Req: req, W: rw, C: c, N: goon.FromContext(c)}
+	r.start = time.Now()
+	r.deadline = r.start.Add(RequestDeadline)
+
+	// Answer the CORS preflight requests directly
+	r.preflight, r.trustedOrigin = handleCORS(w, req)
+	if r.preflight {
+		return r
+	}
+
+	w.Header().Set("X-Request-Id", r.RequestId())
+	if versionHeader {
+		w.Header().Set("X-Served-Version", r.Version())
+	}
+	if cspPolicy != "" {
+		w.Header().Set("Content-Security-Policy",
+			strings.Replace(cspPolicy, "{nonce}", r.CSPNonce(), -1))
+	}
+
+	// The errors are processed by Run, the handler doesn't run then
+	keys, err := loadSecrets(c)
+	if err != nil {
+		r.Session = sessions.NewSession(nil, SessionName)
+		r.setupErr = err
+		return r
+	}
+	r.keys = keys
+	session, token, encoded, err := getSession(req, rw, keys)
+	if err != nil {
+		r.Session = sessions.NewSession(keys.sessions, SessionName)
+		r.setupErr = fmt.Errorf("build session failed: %s", err)
+		return r
+	}
+	r.Session = session
+	r.oldXsrfToken = token
+	r.xsrfToken = encoded
+
+	return r
+}
+
+// Returns the XSRF token emitted in the cookie of the response, for the
+// clients that can't read it from the cookie
+func (r *Request) XsrfToken() string {
+	return r.xsrfToken
+}
+
+// Runs the handler with a request built by NewRequest like the router:
+// it checks the XSRF token, processes the errors and panics of the
+// handler, and saves the session before writing the output.
+func (r *Request) Run(h Handler) {
+	if r.preflight {
+		return
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			r.processError(fmt.Errorf("panic recovered error: %s", rec))
+		}
+
+		// Save the session & copy the buffered output
+		if err := sessions.Save(r.Req, r.W); err != nil {
+			r.processError(err)
+		}
+		if rw, ok := r.W.(*responseWriter); ok {
+			if err := rw.output(); err != nil {
+				r.LogError(err)
+			}
+		}
+
+		// The buffered data of the instance is flushed periodically by
+		// the background requests (the cron ones have a queue name too)
+		if r.Req.Header.Get("X-AppEngine-QueueName") != "" {
+			maybeFlush(r.C)
+		}
+	}()
+
+	if r.setupErr != nil {
+		r.processError(r.setupErr)
+		return
+	}
+
+	// Check XSRF token. App Engine removes the queue & cron headers from
+	// the external requests, so we can trust them.
+	internal := r.Req.Header.Get("X-AppEngine-QueueName") != "" ||
+		r.Req.Header.Get("X-AppEngine-Cron") != ""
+	if r.Req.Method != "GET" && !internal && !r.trustedOrigin && !isXsrfExempt(r.Req) {
+		if ok, err := checkXsrfToken(r.C, r.Req, r.oldXsrfToken, r.keys); err != nil {
+			r.processError(fmt.Errorf("check xsrf token failed: %s", err))
+			return
+		} else if !ok {
+			r.C.Errorf("xsrf token header check failed")
+			r.processError(Forbidden())
+			return
+		}
+	}
+
+	if err := h(r); err != nil {
+		r.processError(err)
+	} else if err := r.checkDatastoreBudget(); err != nil {
+		// The handler has already written its output, don't replace it
+		r.budgetErr = err
+		r.LogError(err)
+	}
+}
+
+// Returns the names of the templates rendered by the request, in order
+func (r *Request) Templates() []string {
+	return r.templates
+}
+
+// Attaches a value to the request, so the decorators can pass data to
+// the handlers. Use a prefix with the name of the package in the key.
+// Example: r.SetValue("apikeys.key", key)
+func (r *Request) SetValue(key string, value interface{}) {
+	if r.values == nil {
+		r.values = map[string]interface{}{}
+	}
+	r.values[key] = value
+}
+
+// Returns the value attached to the request with SetValue, or nil
+func (r *Request) Value(key string) interface{} {
+	return r.values[key]
 }
 
-// Load the request data using gorilla schema into a struct
+// Load the request data using gorilla schema into a struct, checking
+// its valid tags after that (see Validate). The errors are keyed by the
+// field names of the form.
 func (r *Request) LoadData(data interface{}) error {
+	if err := r.decodeForm(data); err != nil {
+		return err
+	}
+	return validate(data, schemaName)
+}
+
+func (r *Request) decodeForm(data interface{}) error {
 	if err := r.Req.ParseForm(); err != nil {
 		return fmt.Errorf("parse form failed: %s", err)
 	}
@@ -58,16 +240,23 @@ func (r *Request) LoadData(data interface{}) error {
 	return nil
 }
 
+// Decodes the JSON body into data. The invalid bodies return a 400
+// AppError describing the problem (see SetJsonDecodeOptions).
 func (r *Request) LoadJsonData(data interface{}) error {
-	if err := json.NewDecoder(r.Req.Body).Decode(data); err != nil {
-		if err == io.EOF {
-			return nil
+	body, err := ioutil.ReadAll(io.LimitReader(r.Req.Body, jsonOptions.MaxBytes+1))
+	if err != nil {
+		return fmt.Errorf("read json body failed: %s", err)
+	}
+	if int64(len(body)) > jsonOptions.MaxBytes {
+		return &AppError{Code: 413, Message: "the request body is too large"}
+	}
+	// An empty body leaves the zero values, checked like the rest
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := decodeJsonBody(body, data); err != nil {
+			return err
 		}
-
-		return fmt.Errorf("decode json body failed: %s", err)
 	}
-
-	return nil
+	return Validate(data)
 }
 
 func (r *Request) EmitJson(data interface{}) error {
@@ -75,9 +264,11 @@ func (r *Request) EmitJson(data interface{}) error {
 	fmt.Fprintln(r.W, ")]}',")
 
 	// Encode the output
-	if err := json.NewEncoder(r.W).Encode(data); err != nil {
+	output, err := MarshalJson(data)
+	if err != nil {
 		return fmt.Errorf("encode json failed: %s", err)
 	}
+	r.W.Write(append(output, '\n'))
 
 	return nil
 }
@@ -114,7 +305,32 @@ func (r *Request) RedirectPermanently(path string) error {
 }
 
 func (r *Request) Template(names []string, data interface{}) error {
-	return Template(r.W, names, data)
+	r.templates = append(r.templates, names...)
+	return ExecTemplate(&TemplateConfig{
+		Names: names,
+		W:     r.W,
+		Data:  data,
+		Dir:   "templates",
+		Funcs: r.templateFuncs(),
+	})
+}
+
+// Returns the template functions bound to the request
+func (r *Request) templateFuncs() template.FuncMap {
+	funcs := template.FuncMap{
+		"csp_nonce":   r.CSPNonce,
+		"flashes":     r.Flashes,
+		"post_script": r.PostScript,
+	}
+	templatesMutex.RLock()
+	for _, f := range requestFuncs {
+		for name, fn := range f(r) {
+			funcs[name] = fn
+		}
+	}
+	templatesMutex.RUnlock()
+
+	return funcs
 }
 
 func (r *Request) URL() string {
@@ -122,19 +338,69 @@ func (r *Request) URL() string {
 }
 
 func (r *Request) LogError(err error) {
-	r.C.Errorf("%v", err.Error())
-	if !strings.Contains(r.URL(), "/tasks/error-mail") && !appengine.IsDevAppServer() {
-		sendErrorByEmail(r.C, err.Error())
+	r.Errorf("%v", err.Error())
+	if !strings.Contains(r.URL(), "/tasks/error-mail") && !platform.Current.IsDevelopment() {
+		reportError(r.C, r.RequestId(), err.Error())
 	}
 }
 
 func (r *Request) processError(err error) {
 	code := 500
+	logged := err
+	var appErr *AppError
+	var validationErrs ValidationErrors
 	if e, ok := err.(HttpError); ok {
 		code = int(e)
-		r.LogError(fmt.Errorf("http status code %s", e))
+		logged = fmt.Errorf("http status code %s", e)
+	} else if e, ok := err.(*AppError); ok {
+		code = e.Code
+		appErr = e
+	} else if e, ok := err.(ValidationErrors); ok {
+		code = 400
+		validationErrs = e
+	} else if e, ok := err.(*ContentionError); ok {
+		// Transient, the clients and the task queues retry them later
+		code = 503
+		r.W.Header().Set("Retry-After", "1")
+		logged = fmt.Errorf("[contention] %s", e)
+	}
+
+	// The client errors are mistakes of the users and the contention
+	// ones are transient, only the rest are reported to the admins
+	if _, ok := err.(*ContentionError); !ok && code >= 500 {
+		r.LogError(logged)
 	} else {
-		r.LogError(err)
+		r.Errorf("%v", logged.Error())
+	}
+
+	// Discard the partial output of the failed handler. The streamed
+	// responses are already sent, the error can only be logged.
+	if rw, ok := r.W.(*responseWriter); ok {
+		if rw.streaming {
+			return
+		}
+		rw.reset()
+	}
+
+	// JSON clients receive a structured error instead of a page
+	if r.WantsJson() {
+		r.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+		r.W.WriteHeader(code)
+		e := &ErrorResponse{
+			Code:      code,
+			Message:   http.StatusText(code),
+			RequestId: r.RequestId(),
+		}
+		if appErr != nil {
+			e.Message, e.Field, e.Offset = appErr.Message, appErr.Field, appErr.Offset
+		}
+		if validationErrs != nil {
+			e.Errors = validationErrs
+		}
+		if err := errorSerializer(r, e); err != nil {
+			r.Errorf("serialize json error failed: %s", err)
+		}
+		return
 	}
 
 	h, ok := errorHandlers[code]
@@ -142,12 +408,52 @@ func (r *Request) processError(err error) {
 		if err := h(r); err == nil {
 			return
 		}
+		if rw, ok := r.W.(*responseWriter); ok {
+			rw.reset()
+		}
 	}
 
-	http.Error(r.W, "", code)
+	writeFallbackError(r.W, code)
 }
 
-// Sets a new handler function for HTTP errors that returns the code status
+// Sets a new handler function for HTTP errors that returns the code status.
+// A nil handler removes it, emitting the fallback page.
 func SetErrorHandler(code int, f Handler) {
+	if f == nil {
+		delete(errorHandlers, code)
+		return
+	}
 	errorHandlers[code] = f
 }
+
+// Returns the handler of the HTTP errors with the code status, or nil
+func GetErrorHandler(code int) Handler {
+	return errorHandlers[code]
+}
+
+// Sets the function that writes the ErrorResponse to JSON clients.
+// By default it's emitted with EmitJson.
+func SetErrorSerializer(f func(r *Request, e *ErrorResponse) error) {
+	errorSerializer = f
+}
+
+// Marks the routes under the prefix as API ones, returning the errors
+// as JSON even if the client doesn't ask for it. Call it at init().
+// Example: app.SetAPIPrefix("/_/")
+func SetAPIPrefix(prefix string) {
+	apiPrefixes = append(apiPrefixes, prefix)
+}
+
+// Returns true if the client accepts JSON responses or the route
+// is an API one
+func (r *Request) WantsJson() bool {
+	if r.forceJson {
+		return true
+	}
+	for _, prefix := range apiPrefixes {
+		if strings.HasPrefix(r.Req.URL.Path, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(r.Req.Header.Get("Accept"), "application/json")
+}
@@ -8,27 +8,62 @@ import (
 	"io"
 	"bytes"
 
-	"conf"
-	"server/model"
-
 	"appengine"
 
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
 	"github.com/gorilla/mux"
-	gaesessions "code.google.com/p/sadbox/appengine/sessions"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/mjibson/appstats"
-	"github.com/mjibson/goon"
-)
-
-var (
-	dStore = gaesessions.NewDatastoreStore(model.KindSession,
-			[]byte(conf.SessionSecret))
-	xsrfCodecs = securecookie.CodecsFromPairs([]byte(conf.XSRFSecret))
 )
 
 type Handler func(r *Request) error
 
+// Headers emitted in every response. By default some compatibility
+// & anti-cache headers for IE.
+var defaultHeaders = http.Header{
+	"X-Ua-Compatible": {"chrome=1"},
+	"Cache-Control":   {"max-age=0,no-cache,no-store,post-check=0,pre-check=0"},
+	"Expires":         {"Mon, 26 Jul 1997 05:00:00 GMT"},
+}
+
+// Sets a header emitted in every response. Call it at init().
+// Example: app.SetDefaultHeader("X-Content-Type-Options", "nosniff")
+func SetDefaultHeader(name, value string) {
+	defaultHeaders.Set(name, value)
+}
+
+// Removes a header from the default set. Call it at init().
+// Example: app.RemoveDefaultHeader("X-UA-Compatible")
+func RemoveDefaultHeader(name string) {
+	defaultHeaders.Del(name)
+}
+
+var xsrfExempts []func(req *http.Request) bool
+
+// Skips the XSRF check of the requests that match the function, because
+// they're authenticated with something different from the cookies (like
+// an Authorization header). Call it at init().
+func ExemptXsrf(f func(req *http.Request) bool) {
+	xsrfExempts = append(xsrfExempts, f)
+}
+
+// Returns true if the request has the cookie of the session, so it
+// could be authenticated with it
+func HasSessionCookie(req *http.Request) bool {
+	_, err := req.Cookie(SessionName)
+	return err == nil
+}
+
+func isXsrfExempt(req *http.Request) bool {
+	for _, f := range xsrfExempts {
+		if f(req) {
+			return true
+		}
+	}
+	return false
+}
+
 // Build the router table at init().
 //
 // Example routes map:
@@ -40,12 +75,8 @@ type Handler func(r *Request) error
 //    }
 //
 func Router(routes map[string]Handler) {
-	r := mux.NewRouter().StrictSlash(true)
-	r.NotFoundHandler = appstatsWrapper(func(r *Request) error {
-		return NotFound()
-	})
-	http.Handle("/", r)
-
+	r := rootRouter()
+	group := newRouteGroup()
 	for route, handler := range routes {
 		h := appstatsWrapper(handler)
 		parts := strings.Split(route, "::")
@@ -63,6 +94,10 @@ func Router(routes map[string]Handler) {
 			continue
 		} 
 
+		// The map order is random, the conflicts between the routes are
+		// checked in both ways
+		recordRoute("router", group, false, parts[0], parts[1], handler)
+
 		// Generalist handlers (no method specified)
 		if len(parts[0]) == 0 {
 			r.Handle(parts[1], h)
@@ -74,9 +109,40 @@ func Router(routes map[string]Handler) {
 	}
 }
 
+// Starts serving the requests. It's only needed on Managed VMs (build
+// tag appenginevm), call it at the end of main() after building the
+// router; on the classic runtime it does nothing.
+func Main() {
+	platform.Current.Main()
+}
+
+var muxRouter *mux.Router
+
+// Returns the router that serves all the requests, building it the
+// first time
+func rootRouter() *mux.Router {
+	if muxRouter == nil {
+		muxRouter = mux.NewRouter().StrictSlash(true)
+		muxRouter.NotFoundHandler = appstatsWrapper(func(r *Request) error {
+			return NotFound()
+		})
+		http.Handle("/", muxRouter)
+	}
+	return muxRouter
+}
+
+// Buffers the status & the body of the response until the handler
+// finishes, so the errors can still replace them
 type responseWriter struct {
 	w http.ResponseWriter
 	buf *bytes.Buffer
+	status int
+
+	// Set by Stream & SendFile: the writes go directly to the client
+	streaming bool
+
+	// True when the status has been sent to the client
+	wroteHeader bool
 }
 
 func newResponseWriter(w http.ResponseWriter) *responseWriter {
@@ -88,76 +154,54 @@ func (w *responseWriter) Header() http.Header {
 }
 
 func (w *responseWriter) Write(data []byte) (int, error) {
+	if w.streaming {
+		w.wroteHeader = true
+		return w.w.Write(data)
+	}
 	return w.buf.Write(data)
 }
 
 func (w *responseWriter) WriteHeader(code int) {
-	w.w.WriteHeader(code)
+	if w.wroteHeader {
+		return
+	}
+	w.status = code
+	if w.streaming {
+		w.wroteHeader = true
+		w.w.WriteHeader(code)
+	}
+}
+
+// Discards the buffered status & body
+func (w *responseWriter) reset() {
+	w.buf.Reset()
+	w.status = 0
 }
 
+// Sends the buffered status & body to the client
 func (w *responseWriter) output() error {
+	if w.status != 0 && !w.wroteHeader {
+		w.wroteHeader = true
+		w.w.WriteHeader(w.status)
+	}
+	if w.buf.Len() > 0 {
+		w.wroteHeader = true
+	}
 	_, err := io.Copy(w.w, w.buf)
 	return err
 }
 
 func appstatsWrapper(h Handler) http.Handler {
 	f := func(c appengine.Context, w http.ResponseWriter, req *http.Request) {
-		// Emit some compatibility & anti-cache headers for IE (you can overwrite
-		// them from the handlers if needed)
-		w.Header().Set("X-UA-Compatible", "chrome=1")
-		w.Header().Set("Cache-Control", "max-age=0,no-cache,no-store,"+
-			"post-check=0,pre-check=0")
-		w.Header().Set("Expires", "Mon, 26 Jul 1997 05:00:00 GMT")
-
-		// Build the request & session objects
-		rw := newResponseWriter(w)
-		r := &Request{Req: req, W: rw, C: c, N: goon.FromContext(c)}
-		session, token, err := getSession(req, rw)
-		if err != nil {
-			r.processError(fmt.Errorf("build session failed: %s", err))
-			return
-		}
-		r.Session = session
-
-		// Check XSRF token
-		if req.Method != "GET" {
-			if ok, err := checkXsrfToken(req, token); err != nil {
-				r.processError(fmt.Errorf("check xsrf token failed: %s", err))
-				return
-			} else if !ok {
-				c.Errorf("xsrf token header check failed")
-				r.processError(Forbidden())
-				return
-			}
-		}
-
-		// Fatal errors recovery
-		defer func() {
-			if rec := recover(); rec != nil {
-				err := fmt.Errorf("panic recovered error: %s", rec)
-				r.processError(err)
-			}
-		}()
-
-		// Handle the request
-		if err := h(r); err != nil {
-			r.processError(err)
-		}
-
-		// Save the session & copy the buffered output
-		if err := sessions.Save(req, w); err != nil {
-			r.processError(err)
-		}
-		if err := rw.output(); err != nil {
-			r.processError(err)
-		}
+		NewRequest(c, w, req).Run(h)
 	}
 	return appstats.NewHandler(f)
 }
 
-// Return the session, the old XSRF token and an error if needed
-func getSession(req *http.Request, w http.ResponseWriter) (*sessions.Session, []uint8, error) {
-	session, _ := dStore.Get(req, conf.SessionName)
+// Return the session, the old XSRF token, the encoded new one and an
+// error if needed
+func getSession(req *http.Request, w http.ResponseWriter, keys *secretKeys) (*sessions.Session, []uint8, string, error) {
+	session, _ := keys.sessions.Get(req, SessionName)
 	session.Options = &sessions.Options{
 		Path: "/",
 		MaxAge: 7 * 24 * 60 * 60, // 7 days
@@ -170,22 +214,20 @@ func getSession(req *http.Request, w http.ResponseWriter) (*sessions.Session, []
 	token := securecookie.GenerateRandomKey(32)
 	session.Values["xsrf"] = token
 
-	encoded, err := securecookie.EncodeMulti("XSRF-TOKEN", token, xsrfCodecs...)
+	encoded, err := securecookie.EncodeMulti("XSRF-TOKEN", token, keys.xsrf...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("encode token failed: %s", err)
+		return nil, nil, "", fmt.Errorf("encode token failed: %s", err)
 	}
 	http.SetCookie(w, &http.Cookie{
 		Name: "XSRF-TOKEN",
 		Value: encoded,
 		Path: "/",
 	})
-	return session, oldtoken, nil
+	return session, oldtoken, encoded, nil
 }
 
 // Returns true if the XSRF token was correct and an error if needed
-func checkXsrfToken(req *http.Request, token []uint8) (bool, error) {
-	c := appengine.NewContext(req)
-
+func checkXsrfToken(c appengine.Context, req *http.Request, token []uint8, keys *secretKeys) (bool, error) {
 	if token == nil {
 		c.Errorf("[xsrf] token is nil")
 		return false, nil
@@ -212,7 +254,7 @@ func checkXsrfToken(req *http.Request, token []uint8) (bool, error) {
 
 	// Check the token itself
 	var unsafeToken []uint8
-	err := securecookie.DecodeMulti("XSRF-TOKEN", header, &unsafeToken, xsrfCodecs...)
+	err := securecookie.DecodeMulti("XSRF-TOKEN", header, &unsafeToken, keys.xsrf...)
 	if err != nil {
 		return false, fmt.Errorf("decode failed: %s", err)
 	}
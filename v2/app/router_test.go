@@ -0,0 +1,41 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseWriterReplacesTheStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	rw := newResponseWriter(w)
+	rw.WriteHeader(201)
+	rw.Write([]byte("partial"))
+
+	// Like processError after a failed handler
+	rw.reset()
+	rw.WriteHeader(500)
+	rw.Write([]byte("error"))
+	if w.Code != 200 || w.Body.Len() != 0 {
+		t.Fatalf("the response was sent before the output")
+	}
+
+	if err := rw.output(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 500 {
+		t.Errorf("got status %d, expected 500", w.Code)
+	}
+	if got := w.Body.String(); got != "error" {
+		t.Errorf("got body %q, expected the error", got)
+	}
+
+	// A second output, like Run after a stream, doesn't send it again
+	rw.streaming = true
+	rw.WriteHeader(200)
+	if err := rw.output(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 500 {
+		t.Errorf("got status %d after the output, expected 500", w.Code)
+	}
+}
@@ -0,0 +1,293 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// Registered route, in the order the router tries to match them
+type RouteInfo struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Handler string `json:"handler"`
+	Source  string `json:"source"`
+
+	// Routes of the same group belong to the same Mux or are registered
+	// in random order (Router)
+	group   int
+	ordered bool
+	re      *regexp.Regexp
+}
+
+var (
+	routesMutex  = &sync.RWMutex{}
+	routeMethods = map[string]map[string]bool{}
+	routeNames   = map[string]string{}
+	routeList    []*RouteInfo
+	routeGroups  int
+)
+
+// Variables of the path templates, with their optional pattern
+var routeVarRe = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+
+// Returns a new group id for the routes registered together
+func newRouteGroup() int {
+	routesMutex.Lock()
+	defer routesMutex.Unlock()
+
+	routeGroups++
+	return routeGroups
+}
+
+// Records the method of the registered path; an empty method accepts
+// all of them. It panics if the route conflicts with a previous one: the
+// same path with an overlapping method, or a fixed path that a previous
+// pattern matches first, so the wrong handler would serve it.
+func recordRoute(source string, group int, ordered bool, method, path string, h Handler) {
+	routesMutex.Lock()
+	defer routesMutex.Unlock()
+
+	route := &RouteInfo{
+		Method:  method,
+		Path:    path,
+		Handler: handlerName(h),
+		Source:  source,
+		group:   group,
+		ordered: ordered,
+		re:      routePathRe(path),
+	}
+	for _, prev := range routeList {
+		if err := routesConflict(prev, route); err != nil {
+			panic(err.Error())
+		}
+	}
+	routeList = append(routeList, route)
+
+	if routeMethods[path] == nil {
+		routeMethods[path] = map[string]bool{}
+	}
+	routeMethods[path][method] = true
+}
+
+// Returns an error if the new route conflicts with the previous one
+func routesConflict(prev, route *RouteInfo) error {
+	// The Mux dispatches all the methods of the path from one entry,
+	// answering 405 to the ones not registered
+	if prev.group == route.group && prev.Source == "mux" && prev.Path == route.Path {
+		return nil
+	}
+	method := prev.Method
+	if prev.Source == "mux" {
+		method = ""
+	}
+	if method != "" && route.Method != "" && method != route.Method {
+		return nil
+	}
+
+	if routeVarRe.ReplaceAllString(prev.Path, "{}") == routeVarRe.ReplaceAllString(route.Path, "{}") {
+		return fmt.Errorf("route registered twice: %s (%s) and %s (%s)",
+			prev, prev.Handler, route, route.Handler)
+	}
+
+	// Routes registered in random order shadow each other in both ways
+	if shadows(prev, route) || (prev.group == route.group && !prev.ordered && shadows(route, prev)) {
+		return fmt.Errorf("route %s (%s) shadowed by %s (%s)", route, route.Handler,
+			prev, prev.Handler)
+	}
+	return nil
+}
+
+// Returns true if the pattern of a matches the fixed path of b
+func shadows(a, b *RouteInfo) bool {
+	return routeVarRe.MatchString(a.Path) && !routeVarRe.MatchString(b.Path) &&
+		a.re.MatchString(b.Path)
+}
+
+func (route *RouteInfo) String() string {
+	method := route.Method
+	if method == "" {
+		method = "*"
+	}
+	return method + " " + route.Path
+}
+
+// Builds the regexp that matches the same paths as the template
+func routePathRe(path string) *regexp.Regexp {
+	pattern := "^"
+	last := 0
+	for _, m := range routeVarRe.FindAllStringSubmatchIndex(path, -1) {
+		pattern += regexp.QuoteMeta(path[last:m[0]])
+		if m[4] != -1 {
+			pattern += "(?:" + path[m[4]+1:m[5]] + ")"
+		} else {
+			pattern += "[^/]+"
+		}
+		last = m[1]
+	}
+	pattern += regexp.QuoteMeta(path[last:]) + "$"
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("bad route pattern %s: %s", path, err))
+	}
+	return re
+}
+
+// Returns the registered routes in the order the router tries them
+func Routes() []*RouteInfo {
+	routesMutex.RLock()
+	defer routesMutex.RUnlock()
+
+	return append([]*RouteInfo(nil), routeList...)
+}
+
+// Emits the list of registered routes as JSON, to check the handlers
+// and middlewares of each one. Protect it with auth.RequireAdmin.
+// Example: m.Get("/admin/routes", auth.RequireAdmin(app.RoutesHandler))
+func RoutesHandler(r *Request) error {
+	return r.EmitJson(Routes())
+}
+
+// Names of the handlers decorated by the middlewares, by the closure
+// returned by Wrap
+var (
+	wrappedMutex = &sync.Mutex{}
+	wrapped      = map[unsafe.Pointer]string{}
+)
+
+// Returns the handler of a middleware recording that it decorates h, so
+// the routes list shows the name of h instead of the closure of the
+// middleware.
+// Example:
+//    func Logged(h app.Handler) app.Handler {
+//      return app.Wrap(h, func(r *app.Request) error { ... })
+//    }
+func Wrap(h, wrapper Handler) Handler {
+	name := handlerName(h)
+
+	wrappedMutex.Lock()
+	defer wrappedMutex.Unlock()
+	wrapped[funcPointer(wrapper)] = name
+	return wrapper
+}
+
+func wrappedName(fn Handler) (string, bool) {
+	wrappedMutex.Lock()
+	defer wrappedMutex.Unlock()
+	name, ok := wrapped[funcPointer(fn)]
+	return name, ok
+}
+
+// Returns the closure of the func value, different for each call of the
+// middleware even if the code is the same
+func funcPointer(fn Handler) unsafe.Pointer {
+	return *(*unsafe.Pointer)(unsafe.Pointer(&fn))
+}
+
+// Gives a name to the path of a route, used by URLFor and the client
+// code generators instead of hardcoding it. Call it at init().
+// Example: app.NameRoute("orderShow", "/orders/{id:[0-9]+}")
+func NameRoute(name, path string) {
+	routesMutex.Lock()
+	defer routesMutex.Unlock()
+
+	if _, ok := routeNames[name]; ok {
+		panic("route named twice: " + name)
+	}
+	routeNames[name] = path
+}
+
+// Returns the paths of the named routes by name
+func NamedRoutes() map[string]string {
+	routesMutex.RLock()
+	defer routesMutex.RUnlock()
+
+	named := map[string]string{}
+	for name, path := range routeNames {
+		named[name] = path
+	}
+	return named
+}
+
+// Returns the methods registered for the path, sorted. An empty method
+// means the route accepts all of them.
+func RouteMethods(path string) []string {
+	routesMutex.RLock()
+	defer routesMutex.RUnlock()
+
+	methods := []string{}
+	for method := range routeMethods[path] {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Builds the URL of the named route replacing its variables with the
+// pairs of names & values. The values are escaped and should match the
+// pattern of their variable ([^/]+ by default).
+// Example: u, err := app.URLFor("orderShow", "id", "12")
+func URLFor(name string, pairs ...string) (string, error) {
+	routesMutex.RLock()
+	path, ok := routeNames[name]
+	routesMutex.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("route not named: %s", name)
+	}
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("odd number of route vars: %v", pairs)
+	}
+
+	vars := map[string]string{}
+	for i := 0; i < len(pairs); i += 2 {
+		vars[pairs[i]] = pairs[i+1]
+	}
+
+	u := ""
+	last := 0
+	var missing []string
+	for _, m := range routeVarRe.FindAllStringSubmatchIndex(path, -1) {
+		u += path[last:m[0]]
+		last = m[1]
+
+		v := path[m[2]:m[3]]
+		value, ok := vars[v]
+		if !ok {
+			missing = append(missing, v)
+			continue
+		}
+
+		pattern := "[^/]+"
+		if m[4] != -1 {
+			pattern = path[m[4]+1 : m[5]]
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return "", fmt.Errorf("bad pattern of var %s in route %s: %s", v, name, err)
+		}
+		if !re.MatchString(value) {
+			return "", fmt.Errorf("value of var %s doesn't match the route %s: %q", v, name, value)
+		}
+		u += escapeRouteValue(value)
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing vars of route %s: %s", name, strings.Join(missing, ", "))
+	}
+	return u + path[last:], nil
+}
+
+// Escapes the value of a route variable. The slashes are kept, they're
+// only accepted by the patterns of the variables that match several
+// segments.
+func escapeRouteValue(value string) string {
+	segments := strings.Split(value, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
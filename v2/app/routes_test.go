@@ -0,0 +1,133 @@
+package app
+
+import "testing"
+
+func TestRoutePathRe(t *testing.T) {
+	tests := []struct {
+		path, url string
+		matches   bool
+	}{
+		{"/orders", "/orders", true},
+		{"/orders", "/orders/", false},
+		{"/orders/{id}", "/orders/12", true},
+		{"/orders/{id}", "/orders/12/items", false},
+		{"/orders/{id:[0-9]+}", "/orders/12", true},
+		{"/orders/{id:[0-9]+}", "/orders/new", false},
+		{"/files/{path:.+}", "/files/a/b.txt", true},
+		{"/a.b/{id}", "/aXb/1", false},
+	}
+	for _, test := range tests {
+		if got := routePathRe(test.path).MatchString(test.url); got != test.matches {
+			t.Errorf("path %s with %s: got %v, expected %v", test.path, test.url, got, test.matches)
+		}
+	}
+}
+
+func TestRoutesConflict(t *testing.T) {
+	route := func(group int, ordered bool, source, method, path string) *RouteInfo {
+		return &RouteInfo{
+			Method:  method,
+			Path:    path,
+			Source:  source,
+			group:   group,
+			ordered: ordered,
+			re:      routePathRe(path),
+		}
+	}
+
+	tests := []struct {
+		prev, route *RouteInfo
+		conflict    bool
+	}{
+		// Same path and method
+		{route(1, true, "router", "GET", "/a"), route(2, true, "router", "GET", "/a"), true},
+		{route(1, true, "router", "GET", "/a"), route(2, true, "router", "POST", "/a"), false},
+		{route(1, true, "router", "", "/a"), route(2, true, "router", "POST", "/a"), true},
+		{route(1, true, "router", "GET", "/a/{id}"), route(2, true, "router", "GET", "/a/{key:[a-z]+}"), true},
+
+		// The mux dispatches all the methods of a path
+		{route(1, true, "mux", "GET", "/a"), route(1, true, "mux", "POST", "/a"), false},
+		{route(1, true, "mux", "GET", "/a"), route(2, true, "router", "POST", "/a"), true},
+
+		// Patterns registered before fixed paths
+		{route(1, true, "mux", "GET", "/a/{id}"), route(1, true, "mux", "GET", "/a/new"), true},
+		{route(1, true, "mux", "GET", "/a/{id:[0-9]+}"), route(1, true, "mux", "GET", "/a/new"), false},
+		{route(1, true, "mux", "GET", "/a/new"), route(1, true, "mux", "GET", "/a/{id}"), false},
+		{route(1, false, "router", "GET", "/a/new"), route(1, false, "router", "GET", "/a/{id}"), true},
+	}
+	for _, test := range tests {
+		err := routesConflict(test.prev, test.route)
+		if (err != nil) != test.conflict {
+			t.Errorf("%s (%s) then %s (%s): got %v, expected conflict %v", test.prev,
+				test.prev.Source, test.route, test.route.Source, err, test.conflict)
+		}
+	}
+}
+
+func TestURLFor(t *testing.T) {
+	routesMutex.Lock()
+	routeNames["testOrder"] = "/orders/{id:[0-9]+}/items/{item}"
+	routeNames["testFile"] = "/files/{path:.+}"
+	routesMutex.Unlock()
+	defer func() {
+		routesMutex.Lock()
+		delete(routeNames, "testOrder")
+		delete(routeNames, "testFile")
+		routesMutex.Unlock()
+	}()
+
+	tests := []struct {
+		name     string
+		pairs    []string
+		expected string
+		fails    bool
+	}{
+		{"testOrder", []string{"id", "12", "item", "a b"}, "/orders/12/items/a%20b", false},
+		{"testOrder", []string{"id", "12", "item", "a?b#c"}, "/orders/12/items/a%3Fb%23c", false},
+		{"testOrder", []string{"id", "x", "item", "a"}, "", true},
+		{"testOrder", []string{"id", "12", "item", "a/b"}, "", true},
+		{"testOrder", []string{"id", "12"}, "", true},
+		{"testOrder", []string{"id"}, "", true},
+		{"testFile", []string{"path", "a/b c.txt"}, "/files/a/b%20c.txt", false},
+		{"unknown", nil, "", true},
+	}
+	for _, test := range tests {
+		u, err := URLFor(test.name, test.pairs...)
+		if test.fails {
+			if err == nil {
+				t.Errorf("route %s with %v: expected an error, got %s", test.name, test.pairs, u)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("route %s with %v: unexpected error: %s", test.name, test.pairs, err)
+		} else if u != test.expected {
+			t.Errorf("route %s with %v: got %s, expected %s", test.name, test.pairs, u, test.expected)
+		}
+	}
+}
+
+func wrapTestHandler(r *Request) error {
+	return nil
+}
+
+func wrapTestMiddleware(h Handler) Handler {
+	return Wrap(h, func(r *Request) error {
+		return h(r)
+	})
+}
+
+func TestWrapHandlerName(t *testing.T) {
+	expected := "app.wrapTestHandler"
+	if name := handlerName(wrapTestMiddleware(wrapTestHandler)); name != expected {
+		t.Errorf("wrapped handler: got %s, expected %s", name, expected)
+	}
+	if name := handlerName(wrapTestMiddleware(wrapTestMiddleware(wrapTestHandler))); name != expected {
+		t.Errorf("handler wrapped twice: got %s, expected %s", name, expected)
+	}
+
+	other := func(r *Request) error { return nil }
+	if name := handlerName(wrapTestMiddleware(other)); name != "app.TestWrapHandlerName.func1" {
+		t.Errorf("each wrapper should keep its own name, got %s", name)
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/gorilla/securecookie"
+)
+
+const KindSecrets = "AppSecrets"
+
+// Name of the cookie of the sessions
+var SessionName = "session"
+
+// Random secrets of the application, generated the first time
+type secrets struct {
+	Session []byte `datastore:",noindex"`
+	XSRF    []byte `datastore:",noindex"`
+}
+
+// Session store & codecs built with the secrets
+type secretKeys struct {
+	sessions     *sessionStore
+	xsrf, frames []securecookie.Codec
+}
+
+var (
+	secretsMutex = &sync.RWMutex{}
+	loadedKeys   *secretKeys
+)
+
+// Returns the keys of the secrets, loading them from the datastore the
+// first time. Random ones are generated and stored for new applications,
+// so they're never in the code.
+func loadSecrets(c appengine.Context) (*secretKeys, error) {
+	if keys := currentSecrets(); keys != nil {
+		return keys, nil
+	}
+
+	s := new(secrets)
+	key := datastore.NewKey(c, KindSecrets, "default", 0, nil)
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		if err := datastore.Get(c, key, s); err == nil {
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		s.Session = securecookie.GenerateRandomKey(32)
+		s.XSRF = securecookie.GenerateRandomKey(32)
+		_, err := datastore.Put(c, key, s)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("load secrets failed: %s", err)
+	}
+
+	keys := &secretKeys{
+		sessions: newSessionStore(s.Session),
+		xsrf:     securecookie.CodecsFromPairs(s.XSRF),
+		frames:   securecookie.CodecsFromPairs(deriveKey(string(s.XSRF), "frames")),
+	}
+
+	secretsMutex.Lock()
+	loadedKeys = keys
+	secretsMutex.Unlock()
+
+	// The secure cookies use a key derived from them unless they're set
+	cookieMutex.Lock()
+	if cookieCodec == nil {
+		cookieCodec = newCookieCodec(deriveKey(string(s.XSRF), "cookies-hash"), nil)
+	}
+	cookieMutex.Unlock()
+
+	return keys, nil
+}
+
+// Returns the loaded keys, or nil before the first request
+func currentSecrets() *secretKeys {
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+	return loadedKeys
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/securecookie"
+)
+
+var (
+	cspPolicy string
+
+	sriMutex = &sync.Mutex{}
+	sriCache = map[string]string{}
+)
+
+func init() {
+	RegisterTemplateFuncs(template.FuncMap{
+		// Nonce of the inline scripts of the current request. It's only
+		// available in the templates executed with Request.Template.
+		// Example: <script nonce="{{csp_nonce}}">...</script>
+		"csp_nonce": func() string {
+			return ""
+		},
+
+		// Integrity attribute of a static file
+		// Example: <script src="/scripts/app.js" integrity="{{sri "static/scripts/app.js"}}"></script>
+		"sri": func(filename string) (string, error) {
+			return SRIHash(filename)
+		},
+	})
+}
+
+// Sets the Content-Security-Policy header emitted in every response.
+// The {nonce} placeholder is replaced with the nonce of each request.
+// Call it at init().
+// Example: app.SetContentSecurityPolicy("script-src 'self' 'nonce-{nonce}'")
+func SetContentSecurityPolicy(policy string) {
+	cspPolicy = policy
+}
+
+// Sets a directive of the Content-Security-Policy header keeping the
+// rest of them, like the nonce of the scripts. A previous value of
+// the directive is replaced.
+// Example: setCSPDirective(header, "frame-ancestors", "'none'")
+func setCSPDirective(header http.Header, name, value string) {
+	directives := []string{}
+	for _, d := range strings.Split(header.Get("Content-Security-Policy"), ";") {
+		d = strings.TrimSpace(d)
+		if d == "" || d == name || strings.HasPrefix(d, name+" ") {
+			continue
+		}
+		directives = append(directives, d)
+	}
+	directives = append(directives, name+" "+value)
+	header.Set("Content-Security-Policy", strings.Join(directives, "; "))
+}
+
+// Returns the random nonce of the request for the inline scripts
+func (r *Request) CSPNonce() string {
+	if r.cspNonce == "" {
+		r.cspNonce = base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(16))
+	}
+	return r.cspNonce
+}
+
+// Returns the subresource integrity hash of the file (sha384-...).
+// Static files don't change in a deploy, so it's computed only once.
+func SRIHash(filename string) (string, error) {
+	sriMutex.Lock()
+	defer sriMutex.Unlock()
+
+	if hash, ok := sriCache[filename]; ok {
+		return hash, nil
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("read sri file failed: %s", err)
+	}
+	sum := sha512.Sum384(content)
+	hash := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	sriCache[filename] = hash
+
+	return hash, nil
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSetCSPDirective(t *testing.T) {
+	tests := []struct {
+		policy, name, value, expected string
+	}{
+		{"", "frame-ancestors", "'none'", "frame-ancestors 'none'"},
+		{"script-src 'nonce-abc'", "frame-ancestors", "'none'",
+			"script-src 'nonce-abc'; frame-ancestors 'none'"},
+		{"script-src 'nonce-abc'; frame-ancestors 'none'", "frame-ancestors", "https://partner.com",
+			"script-src 'nonce-abc'; frame-ancestors https://partner.com"},
+		{"frame-ancestors-x a; script-src 'self';", "frame-ancestors", "'self'",
+			"frame-ancestors-x a; script-src 'self'; frame-ancestors 'self'"},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.policy != "" {
+			header.Set("Content-Security-Policy", test.policy)
+		}
+		setCSPDirective(header, test.name, test.value)
+		if got := header.Get("Content-Security-Policy"); got != test.expected {
+			t.Errorf("policy %q: got %q, expected %q", test.policy, got, test.expected)
+		}
+	}
+}
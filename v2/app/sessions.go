@@ -0,0 +1,108 @@
+package app
+
+import (
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+	"github.com/ernestokarim/gaelib/v2/store"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Builds the store of the session values. The datastore by default, so
+// the sessions are not lost when memcache evicts them; change it at
+// init() to use another backend.
+// Example: app.SessionStore = store.Memcache
+var SessionStore func(c appengine.Context) store.Store = store.Datastore
+
+// Sessions whose values are kept in the SessionStore, with their ID in
+// a signed cookie
+type sessionStore struct {
+	codecs []securecookie.Codec
+}
+
+func newSessionStore(keyPairs ...[]byte) *sessionStore {
+	return &sessionStore{codecs: securecookie.CodecsFromPairs(keyPairs...)}
+}
+
+func (s *sessionStore) Get(req *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(req).Get(s, name)
+}
+
+// Returns the session of the cookie, or a new one if there's no cookie
+// or its values have expired
+func (s *sessionStore) New(req *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Options = &sessions.Options{Path: "/"}
+	session.IsNew = true
+
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.codecs...); err != nil {
+		return session, fmt.Errorf("decode session cookie failed: %s", err)
+	}
+
+	item, err := s.store(req).Get(sessionKey(session.ID))
+	if err != nil {
+		if err == store.ErrNotFound {
+			return session, nil
+		}
+		return session, fmt.Errorf("load session failed: %s", err)
+	}
+	if err := securecookie.DecodeMulti(name, string(item.Value), &session.Values, s.codecs...); err != nil {
+		return session, fmt.Errorf("decode session failed: %s", err)
+	}
+	session.IsNew = false
+
+	return session, nil
+}
+
+// Stores the values of the session and sets its cookie. A negative
+// MaxAge deletes them.
+func (s *sessionStore) Save(req *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.store(req).Delete(sessionKey(session.ID)); err != nil {
+				return fmt.Errorf("delete session failed: %s", err)
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id := base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+		session.ID = strings.TrimRight(id, "=")
+	}
+	values, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return fmt.Errorf("encode session failed: %s", err)
+	}
+	ttl := time.Duration(session.Options.MaxAge) * time.Second
+	if err := s.store(req).Set(sessionKey(session.ID), []byte(values), ttl); err != nil {
+		return fmt.Errorf("save session failed: %s", err)
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return fmt.Errorf("encode session cookie failed: %s", err)
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *sessionStore) store(req *http.Request) store.Store {
+	return SessionStore(platform.Current.NewContext(req))
+}
+
+func sessionKey(id string) string {
+	return "session:" + id
+}
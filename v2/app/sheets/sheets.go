@@ -0,0 +1,255 @@
+package sheets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/google"
+	"github.com/ernestokarim/gaelib/v2/cache"
+)
+
+const KindSheetContent = "SheetContent"
+
+const (
+	scopeReadOnly = "https://www.googleapis.com/auth/spreadsheets.readonly"
+	valuesURL     = "https://sheets.googleapis.com/v4/spreadsheets/%s/values/%s"
+)
+
+// Account that reads the sheets; share them with its email
+var Source google.TokenSource = google.AppIdentity
+
+// Rows of a sheet decoded in the last refresh, stored with the name
+// of the sheet as its ID
+type SheetContent struct {
+	Data    []byte `datastore:",noindex"`
+	Rows    int
+	Updated time.Time
+}
+
+type sheet struct {
+	spreadsheet, cells string
+	row                reflect.Type
+}
+
+var sheets = map[string]*sheet{}
+
+// Registers a sheet read into structs like row. The first row of the
+// range has the headers, matched with the sheet tag of the fields or
+// their names. Supported fields are strings, ints, floats, bools and
+// times (RFC 3339 or 2006-01-02). Call it at init().
+// Example:
+//    type Product struct {
+//      Name  string
+//      Price float64 `sheet:"Price (USD)"`
+//    }
+//    sheets.Register("products", "1BxiMVs0XRA5nFMd...", "Products!A1:D", Product{})
+func Register(name, spreadsheet, cells string, row interface{}) {
+	t := reflect.TypeOf(row)
+	if t.Kind() != reflect.Struct {
+		panic("sheet row should be a struct: " + name)
+	}
+	sheets[name] = &sheet{spreadsheet: spreadsheet, cells: cells, row: t}
+}
+
+// Refreshes all the registered sheets. Schedule it in cron.yaml.
+// Example: app.Cron("/tasks/sheets", sheets.RefreshHandler)
+func RefreshHandler(r *app.Request) error {
+	failed := 0
+	for name := range sheets {
+		if err := Refresh(r.C, name); err != nil {
+			r.C.Errorf("[sheets] %s", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d sheets failed", failed)
+	}
+	return nil
+}
+
+// Reads the rows of the sheet, storing them for Load
+func Refresh(c appengine.Context, name string) error {
+	s, ok := sheets[name]
+	if !ok {
+		return fmt.Errorf("sheet not registered: %s", name)
+	}
+
+	client := google.Client(c, Source, scopeReadOnly)
+	resp, err := client.Get(fmt.Sprintf(valuesURL, escape(s.spreadsheet), escape(s.cells)))
+	if err != nil {
+		return fmt.Errorf("get sheet %s failed: %s", name, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Values [][]string `json:"values"`
+		Error  struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode sheet %s failed: %s", name, err)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("get sheet %s failed: %s", name, result.Error.Message)
+	}
+
+	rows, err := s.decode(result.Values)
+	if err != nil {
+		return fmt.Errorf("decode rows of %s failed: %s", name, err)
+	}
+	data, err := json.Marshal(rows.Interface())
+	if err != nil {
+		return fmt.Errorf("encode rows of %s failed: %s", name, err)
+	}
+
+	content := &SheetContent{Data: data, Rows: rows.Len(), Updated: time.Now()}
+	if _, err := datastore.Put(c, contentKey(c, name), content); err != nil {
+		return fmt.Errorf("put sheet content failed: %s", err)
+	}
+	if err := cache.Set(c, "sheet:"+name, data, 0); err != nil {
+		c.Warningf("[sheets] cannot cache %s: %s", name, err)
+	}
+	return nil
+}
+
+// Loads the rows of the last refresh of the sheet in dest, a pointer
+// to a slice of the registered struct.
+// Example:
+//    products := []*Product{}
+//    if err := sheets.Load(r.C, "products", &products); err != nil { ... }
+func Load(c appengine.Context, name string, dest interface{}) error {
+	var data []byte
+	if err := cache.Get(c, "sheet:"+name, &data); err != nil {
+		if err != cache.ErrMiss {
+			c.Warningf("[sheets] cannot read cached %s: %s", name, err)
+		}
+
+		content := new(SheetContent)
+		if err := datastore.Get(c, contentKey(c, name), content); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return fmt.Errorf("sheet not refreshed yet: %s", name)
+			}
+			return fmt.Errorf("get sheet content failed: %s", err)
+		}
+		data = content.Data
+		if err := cache.Set(c, "sheet:"+name, data, 0); err != nil {
+			c.Warningf("[sheets] cannot cache %s: %s", name, err)
+		}
+	}
+
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("decode sheet %s failed: %s", name, err)
+	}
+	return nil
+}
+
+// Decodes the values in a slice of the rows; empty rows are skipped
+func (s *sheet) decode(values [][]string) (reflect.Value, error) {
+	rows := reflect.MakeSlice(reflect.SliceOf(s.row), 0, len(values))
+	if len(values) == 0 {
+		return rows, nil
+	}
+
+	// Field of each column
+	columns := make([]int, len(values[0]))
+	for i, header := range values[0] {
+		columns[i] = -1
+		for j := 0; j < s.row.NumField(); j++ {
+			f := s.row.Field(j)
+			name := f.Tag.Get("sheet")
+			if name == "" {
+				name = f.Name
+			}
+			if f.PkgPath == "" && strings.EqualFold(strings.TrimSpace(header), name) {
+				columns[i] = j
+				break
+			}
+		}
+	}
+
+	for n, cells := range values[1:] {
+		if strings.TrimSpace(strings.Join(cells, "")) == "" {
+			continue
+		}
+
+		row := reflect.New(s.row).Elem()
+		for i, cell := range cells {
+			if i >= len(columns) || columns[i] == -1 {
+				continue
+			}
+			if err := setField(row.Field(columns[i]), strings.TrimSpace(cell)); err != nil {
+				return rows, fmt.Errorf("row %d, column %s: %s", n+2, values[0][i], err)
+			}
+		}
+		rows = reflect.Append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func setField(f reflect.Value, cell string) error {
+	if cell == "" {
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(cell)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.Replace(cell, ",", "", -1), 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad integer: %s", cell)
+		}
+		f.SetInt(n)
+
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(strings.Replace(cell, ",", "", -1), 64)
+		if err != nil {
+			return fmt.Errorf("bad number: %s", cell)
+		}
+		f.SetFloat(n)
+
+	case reflect.Bool:
+		switch strings.ToLower(cell) {
+		case "true", "yes", "1", "x":
+			f.SetBool(true)
+		case "false", "no", "0":
+		default:
+			return fmt.Errorf("bad boolean: %s", cell)
+		}
+
+	default:
+		if f.Type() != reflect.TypeOf(time.Time{}) {
+			return fmt.Errorf("unsupported field type: %s", f.Type())
+		}
+		t, err := time.Parse(time.RFC3339, cell)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", cell); err != nil {
+				return fmt.Errorf("bad date: %s", cell)
+			}
+		}
+		f.Set(reflect.ValueOf(t))
+	}
+
+	return nil
+}
+
+// Escapes a segment of the path of the API
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func contentKey(c appengine.Context, name string) *datastore.Key {
+	return datastore.NewKey(c, KindSheetContent, name, 0, nil)
+}
@@ -0,0 +1,399 @@
+package sitemap
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	gaemail "appengine/mail"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/tasks"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+	"github.com/ernestokarim/gaelib/v2/mail"
+)
+
+const (
+	KindBrokenLink = "BrokenLink"
+	KindLinkCheck  = "LinkCheck"
+)
+
+var (
+	// Number of links checked by each task
+	LinkBatch = 50
+
+	// Links of a task requested at the same time
+	LinkConcurrency = 5
+)
+
+// Link that failed in the last check, stored with the hash of the
+// URL as its ID
+type BrokenLink struct {
+	URL, Source string
+	Status      int
+	Error       string `datastore:",noindex"`
+	FirstSeen   time.Time
+	Checked     time.Time
+}
+
+// Progress of the last check, stored with a fixed ID. The tasks of
+// older checks are ignored.
+type LinkCheck struct {
+	Started time.Time
+
+	// Tasks of the check, -1 while they're being enqueued, and the
+	// indexes of the finished ones
+	Batches int
+	Done    []int64 `datastore:",noindex"`
+
+	Finished bool
+}
+
+type checkedLink struct {
+	URL, Source string
+}
+
+type linkBatch struct {
+	// Start of the check, in nanoseconds
+	Check int64
+	Index int64
+	Links []*checkedLink
+}
+
+type linkSource struct {
+	name string
+	urls []*URL
+}
+
+var (
+	linkNames     = []string{}
+	linkProviders = map[string]Provider{}
+)
+
+// Registers a provider of outbound links stored by the app, checked
+// together with the URLs of the sitemap. Call it at init().
+// Example: sitemap.RegisterLinks("post-links", postLinks)
+func RegisterLinks(name string, p Provider) {
+	if _, ok := linkProviders[name]; !ok {
+		linkNames = append(linkNames, name)
+	}
+	linkProviders[name] = p
+}
+
+func init() {
+	tasks.Handle("link-check", checkBatch)
+}
+
+// Checks again all the links in the background. The admins receive the
+// summary when the check finishes, and the broken links that are not
+// in the sitemap anymore are removed. Schedule it in cron.yaml to
+// enable the checks.
+// Example: app.Cron("/tasks/check-links", sitemap.CheckLinksHandler)
+func CheckLinksHandler(r *app.Request) error {
+	sources := []*linkSource{}
+	collect := func(names []string, providers map[string]Provider) error {
+		for _, name := range names {
+			urls, err := providers[name](r.C)
+			if err != nil {
+				return fmt.Errorf("links provider %s failed: %s", name, err)
+			}
+			sources = append(sources, &linkSource{name: name, urls: urls})
+		}
+		return nil
+	}
+	if err := collect(names, providers); err != nil {
+		return err
+	}
+	if err := collect(linkNames, linkProviders); err != nil {
+		return err
+	}
+
+	// The datastore keeps the times with microseconds
+	started := time.Now().Truncate(time.Microsecond)
+	key := linkCheckKey(r.C)
+	if _, err := datastore.Put(r.C, key, &LinkCheck{Started: started, Batches: -1}); err != nil {
+		return fmt.Errorf("put link check failed: %s", err)
+	}
+
+	batches := batchLinks(baseURL(r), sources, LinkBatch)
+	for i, links := range batches {
+		batch := &linkBatch{Check: started.UnixNano(), Index: int64(i), Links: links}
+		if err := tasks.Enqueue(r.C, "link-check", batch); err != nil {
+			return err
+		}
+	}
+
+	// The tasks may have finished already
+	return finishBatch(r.C, started.UnixNano(), -1, len(batches))
+}
+
+// Returns the absolute URLs of the sources without duplicates, in
+// batches of the size
+func batchLinks(base string, sources []*linkSource, size int) [][]*checkedLink {
+	batches := [][]*checkedLink{}
+	var batch []*checkedLink
+	seen := map[string]bool{}
+	for _, source := range sources {
+		for _, u := range source.urls {
+			loc := newEntry(base, u).Loc
+			if seen[loc] {
+				continue
+			}
+			seen[loc] = true
+
+			batch = append(batch, &checkedLink{URL: loc, Source: source.name})
+			if len(batch) == size {
+				batches = append(batches, batch)
+				batch = nil
+			}
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+func checkBatch(r *app.Request, batch *linkBatch) error {
+	var wg sync.WaitGroup
+	slots := make(chan bool, LinkConcurrency)
+	for _, link := range batch.Links {
+		wg.Add(1)
+		slots <- true
+		go func(link *checkedLink) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := checkLink(r.C, link); err != nil {
+				r.C.Errorf("[sitemap] %s", err)
+			}
+		}(link)
+	}
+	wg.Wait()
+
+	return finishBatch(r.C, batch.Check, batch.Index, -1)
+}
+
+// Records the finished batch and the total of batches, -1 if they're
+// unknown, finishing the check after the last one
+func finishBatch(c appengine.Context, check, index int64, batches int) error {
+	key := linkCheckKey(c)
+	var finish bool
+	var started time.Time
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		lc := new(LinkCheck)
+		if err := datastore.Get(c, key, lc); err != nil {
+			return err
+		}
+		finish, started = false, lc.Started
+
+		// A newer check started, the results of the old one are discarded
+		if lc.Started.UnixNano() != check || lc.Finished {
+			return nil
+		}
+
+		if batches >= 0 {
+			lc.Batches = batches
+		}
+		if index >= 0 && !containsIndex(lc.Done, index) {
+			lc.Done = append(lc.Done, index)
+		}
+		finish = lc.Batches >= 0 && len(lc.Done) == lc.Batches
+		_, err := datastore.Put(c, key, lc)
+		return err
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("update link check failed: %s", err)
+	}
+
+	if !finish {
+		return nil
+	}
+	if err := finishCheck(c, started); err != nil {
+		return err
+	}
+
+	lc := new(LinkCheck)
+	if err := datastore.Get(c, key, lc); err != nil {
+		return fmt.Errorf("get link check failed: %s", err)
+	}
+	if lc.Started.Equal(started) {
+		lc.Finished = true
+		if _, err := datastore.Put(c, key, lc); err != nil {
+			return fmt.Errorf("put link check failed: %s", err)
+		}
+	}
+	return nil
+}
+
+func containsIndex(list []int64, index int64) bool {
+	for _, i := range list {
+		if i == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the broken links not checked since the start of the check,
+// that aren't in the sitemap anymore, and emails the summary
+func finishCheck(c appengine.Context, started time.Time) error {
+	for {
+		q := datastore.NewQuery(KindBrokenLink).
+			Filter("Checked <", started).
+			KeysOnly().
+			Limit(500)
+		keys, err := q.GetAll(c, nil)
+		if err != nil {
+			return fmt.Errorf("query stale broken links failed: %s", err)
+		}
+		if err := datastore.DeleteMulti(c, keys); err != nil {
+			return fmt.Errorf("delete stale broken links failed: %s", err)
+		}
+		if len(keys) < 500 {
+			break
+		}
+	}
+
+	return sendLinksSummary(c)
+}
+
+// Requests the link, saving or removing its BrokenLink
+func checkLink(c appengine.Context, link *checkedLink) error {
+	status, reqErr := linkStatus(c, link.URL, "HEAD")
+	if status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented {
+		status, reqErr = linkStatus(c, link.URL, "GET")
+	}
+
+	hash := sha1.Sum([]byte(link.URL))
+	key := datastore.NewKey(c, KindBrokenLink, hex.EncodeToString(hash[:]), 0, nil)
+	if reqErr == nil && status < 400 {
+		if err := datastore.Delete(c, key); err != nil && err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("delete broken link failed: %s", err)
+		}
+		return nil
+	}
+
+	return datastore.RunInTransaction(c, func(c appengine.Context) error {
+		broken := new(BrokenLink)
+		if err := datastore.Get(c, key, broken); err != nil && err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("get broken link failed: %s", err)
+		}
+		if broken.FirstSeen.IsZero() {
+			broken.FirstSeen = time.Now()
+		}
+		broken.URL, broken.Source, broken.Status = link.URL, link.Source, status
+		broken.Error, broken.Checked = "", time.Now()
+		if reqErr != nil {
+			broken.Error = reqErr.Error()
+		}
+		if _, err := datastore.Put(c, key, broken); err != nil {
+			return fmt.Errorf("put broken link failed: %s", err)
+		}
+		return nil
+	}, nil)
+}
+
+func linkStatus(c appengine.Context, u, method string) (int, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "gaelib-linkcheck")
+
+	resp, err := platform.Current.HTTPClient(c, time.Duration(15)*time.Second).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Returns the broken links of the last check
+func BrokenLinks(c appengine.Context) ([]*BrokenLink, error) {
+	links := []*BrokenLink{}
+	if _, err := datastore.NewQuery(KindBrokenLink).Order("URL").GetAll(c, &links); err != nil {
+		return nil, fmt.Errorf("get broken links failed: %s", err)
+	}
+	return links, nil
+}
+
+func sendLinksSummary(c appengine.Context) error {
+	links, err := BrokenLinks(c)
+	if err != nil || len(links) == 0 {
+		return err
+	}
+
+	msg := &gaemail.Message{
+		Sender:  fmt.Sprintf("noreply@%s.appspotmail.com", platform.Current.AppID(c)),
+		Subject: fmt.Sprintf("%d broken links", len(links)),
+		Body:    linksSummary(links),
+	}
+	if err := mail.SendToAdmins(c, msg); err != nil {
+		return fmt.Errorf("send broken links summary failed: %s", err)
+	}
+	return nil
+}
+
+func linksSummary(links []*BrokenLink) string {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "%d broken links found in the last check:\n\n", len(links))
+	for _, link := range links {
+		fmt.Fprintf(buf, "%s (%s): %d %s\n", link.URL, link.Source, link.Status, link.Error)
+	}
+	return buf.String()
+}
+
+func linkCheckKey(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, KindLinkCheck, "last", 0, nil)
+}
+
+var linksTemplate = template.Must(template.New("links").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Broken links</title></head>
+<body>
+  <h1>Broken links</h1>
+  <table>
+    <tr><th>URL</th><th>Source</th><th>Status</th><th>Error</th><th>First seen</th><th>Checked</th></tr>
+    {{range .}}
+    <tr>
+      <td><a href="{{.URL}}" rel="nofollow">{{.URL}}</a></td>
+      <td>{{.Source}}</td>
+      <td>{{if .Status}}{{.Status}}{{end}}</td>
+      <td>{{.Error}}</td>
+      <td>{{.FirstSeen}}</td>
+      <td>{{.Checked}}</td>
+    </tr>
+    {{else}}
+    <tr><td colspan="6">No broken links</td></tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
+
+// Admin page with the broken links of the last check
+// Example: "GET::/admin/broken-links": sitemap.BrokenLinksHandler,
+func BrokenLinksHandler(r *app.Request) error {
+	if !user.IsAdmin(r.C) {
+		return app.Forbidden()
+	}
+
+	links, err := BrokenLinks(r.C)
+	if err != nil {
+		return err
+	}
+	if err := linksTemplate.Execute(r.W, links); err != nil {
+		return fmt.Errorf("exec links template failed: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package sitemap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBatchLinks(t *testing.T) {
+	sources := []*linkSource{
+		{name: "posts", urls: []*URL{{Loc: "/a"}, {Loc: "/b"}, {Loc: "http://other.com/c"}}},
+		{name: "links", urls: []*URL{{Loc: "http://example.com/a"}, {Loc: "/d"}}},
+	}
+	tests := []struct {
+		size     int
+		expected [][]string
+	}{
+		{10, [][]string{{"http://example.com/a", "http://example.com/b", "http://other.com/c", "http://example.com/d"}}},
+		{2, [][]string{{"http://example.com/a", "http://example.com/b"}, {"http://other.com/c", "http://example.com/d"}}},
+		{3, [][]string{{"http://example.com/a", "http://example.com/b", "http://other.com/c"}, {"http://example.com/d"}}},
+	}
+	for _, test := range tests {
+		batches := batchLinks("http://example.com", sources, test.size)
+		if len(batches) != len(test.expected) {
+			t.Errorf("size %d: got %d batches, expected %d", test.size, len(batches), len(test.expected))
+			continue
+		}
+		for i, batch := range batches {
+			urls := []string{}
+			for _, link := range batch {
+				urls = append(urls, link.URL)
+			}
+			if strings.Join(urls, " ") != strings.Join(test.expected[i], " ") {
+				t.Errorf("size %d, batch %d: got %v, expected %v", test.size, i, urls, test.expected[i])
+			}
+		}
+	}
+
+	if batches := batchLinks("http://example.com", nil, 10); len(batches) != 0 {
+		t.Errorf("batches without links: %d", len(batches))
+	}
+}
+
+func TestBatchLinksSource(t *testing.T) {
+	sources := []*linkSource{
+		{name: "posts", urls: []*URL{{Loc: "/a"}}},
+		{name: "links", urls: []*URL{{Loc: "/a"}, {Loc: "/b"}}},
+	}
+	batches := batchLinks("http://example.com", sources, 10)
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("unexpected batches: %v", batches)
+	}
+	if batches[0][0].Source != "posts" || batches[0][1].Source != "links" {
+		t.Errorf("unexpected sources: %s, %s", batches[0][0].Source, batches[0][1].Source)
+	}
+}
+
+func TestLinksSummary(t *testing.T) {
+	links := []*BrokenLink{
+		{URL: "http://example.com/a", Source: "posts", Status: 404},
+		{URL: "http://example.com/b", Source: "links", Error: "timeout"},
+	}
+	expected := "2 broken links found in the last check:\n\n" +
+		"http://example.com/a (posts): 404 \n" +
+		"http://example.com/b (links): 0 timeout\n"
+	if got := linksSummary(links); got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestContainsIndex(t *testing.T) {
+	if !containsIndex([]int64{3, 1}, 1) {
+		t.Errorf("index not found")
+	}
+	if containsIndex([]int64{3, 1}, 2) || containsIndex(nil, 0) {
+		t.Errorf("missing index found")
+	}
+}
@@ -0,0 +1,23 @@
+package sitemap
+
+import (
+	"fmt"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+// Paths disallowed to all the crawlers in robots.txt
+var Disallow = []string{}
+
+// Serves robots.txt pointing to the sitemap
+// Example: "GET::/robots.txt": sitemap.RobotsHandler,
+func RobotsHandler(r *app.Request) error {
+	r.W.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	fmt.Fprintln(r.W, "User-agent: *")
+	for _, path := range Disallow {
+		fmt.Fprintf(r.W, "Disallow: %s\n", path)
+	}
+	fmt.Fprintf(r.W, "\nSitemap: %s/sitemap.xml\n", baseURL(r))
+	return nil
+}
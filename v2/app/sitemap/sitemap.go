@@ -0,0 +1,287 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/cache"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// Scheme & host of the URLs, the one of the request by default
+	BaseURL string
+
+	// Max number of URLs of each sitemap file; the protocol allows 50000
+	ChunkSize = 50000
+
+	// Time the generated sitemaps are cached
+	CacheTTL = time.Duration(6) * time.Hour
+)
+
+// Cache namespace of the generated files
+const namespace = "sitemap"
+
+type URL struct {
+	// Absolute URL or path
+	Loc string
+
+	LastMod    time.Time
+	ChangeFreq string
+	Priority   float64
+}
+
+// Returns the URLs of a part of the site
+type Provider func(c appengine.Context) ([]*URL, error)
+
+var (
+	names     = []string{}
+	providers = map[string]Provider{}
+)
+
+// Registers the provider of URLs. The sitemap lists them in the order
+// of the registrations. Call it at init().
+// Example: sitemap.Register("pages", sitemap.Static("/", "/about", "/contact"))
+func Register(name string, p Provider) {
+	if _, ok := providers[name]; !ok {
+		names = append(names, name)
+	}
+	providers[name] = p
+}
+
+// Provider of a fixed list of paths
+func Static(paths ...string) Provider {
+	return func(c appengine.Context) ([]*URL, error) {
+		urls := []*URL{}
+		for _, p := range paths {
+			urls = append(urls, &URL{Loc: p})
+		}
+		return urls, nil
+	}
+}
+
+// Provider of the entities of the query. loc returns the path of each
+// entity, or an empty string to skip it; the lastmod property (if not
+// empty) fills the LastMod of the URLs.
+// Example:
+//    sitemap.Register("posts", sitemap.Query(datastore.NewQuery("Post"), "Updated",
+//      func(key *datastore.Key) string { return "/posts/" + key.StringID() }))
+func Query(q *datastore.Query, lastmod string, loc func(key *datastore.Key) string) Provider {
+	return func(c appengine.Context) ([]*URL, error) {
+		urls := []*URL{}
+		it := q.Run(c)
+		for {
+			var props datastore.PropertyList
+			key, err := it.Next(&props)
+			if err == datastore.Done {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("query sitemap entities failed: %s", err)
+			}
+
+			u := &URL{Loc: loc(key)}
+			if u.Loc == "" {
+				continue
+			}
+			for _, p := range props {
+				if t, ok := p.Value.(time.Time); ok && lastmod != "" && p.Name == lastmod {
+					u.LastMod = t
+				}
+			}
+			urls = append(urls, u)
+		}
+		return urls, nil
+	}
+}
+
+// Removes the cached sitemaps, to generate them again in the next request
+func Purge(c appengine.Context) error {
+	return cache.Invalidate(c, namespace)
+}
+
+// Serves the sitemap, or the index of the sitemaps if there are more
+// URLs than ChunkSize.
+// Example: "GET::/sitemap.xml": sitemap.Handler,
+func Handler(r *app.Request) error {
+	count, err := chunks(r)
+	if err != nil {
+		return err
+	}
+	if count == 1 {
+		return serveChunk(r, 1)
+	}
+
+	base := baseURL(r)
+	index := &sitemapIndex{Xmlns: xmlns}
+	for i := 1; i <= count; i++ {
+		index.Sitemaps = append(index.Sitemaps, &sitemapEntry{Loc: fmt.Sprintf("%s/sitemap-%d.xml", base, i)})
+	}
+
+	r.W.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	r.W.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(r.W).Encode(index); err != nil {
+		return fmt.Errorf("encode sitemap index failed: %s", err)
+	}
+	return nil
+}
+
+// Serves each one of the sitemaps listed in the index
+// Example: "GET::/sitemap-{n:[0-9]+}.xml": sitemap.ChunkHandler,
+func ChunkHandler(r *app.Request) error {
+	n, err := strconv.Atoi(mux.Vars(r.Req)["n"])
+	if err != nil {
+		return app.NotFound()
+	}
+	return serveChunk(r, n)
+}
+
+func serveChunk(r *app.Request, n int) error {
+	var data []byte
+	key := cache.Key(r.C, namespace, fmt.Sprintf("chunk-%d", n))
+	if err := cache.Get(r.C, key, &data); err != nil {
+		if err != cache.ErrMiss {
+			return fmt.Errorf("get sitemap chunk failed: %s", err)
+		}
+
+		// Evicted before the count; generate all of them again
+		count, err := generate(r)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > count {
+			return app.NotFound()
+		}
+		if err := cache.Get(r.C, key, &data); err != nil {
+			return fmt.Errorf("get sitemap chunk failed: %s", err)
+		}
+	}
+
+	// The chunks are stored compressed to fit in the cache
+	r.W.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	r.W.Header().Add("Vary", "Accept-Encoding")
+	if strings.Contains(r.Req.Header.Get("Accept-Encoding"), "gzip") {
+		r.W.Header().Set("Content-Encoding", "gzip")
+		_, err := r.W.Write(data)
+		return err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decompress sitemap chunk failed: %s", err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("decompress sitemap chunk failed: %s", err)
+	}
+	_, err = r.W.Write(content)
+	return err
+}
+
+// Returns the number of sitemaps, generating them if needed
+func chunks(r *app.Request) (int, error) {
+	var count int
+	err := cache.Once(r.C, cache.Key(r.C, namespace, "count"), CacheTTL, &count, func() (interface{}, error) {
+		return generate(r)
+	})
+	return count, err
+}
+
+// Collects the URLs of the providers, caching the compressed sitemaps
+func generate(r *app.Request) (int, error) {
+	base := baseURL(r)
+	urls := []*urlEntry{}
+	for _, name := range names {
+		provided, err := providers[name](r.C)
+		if err != nil {
+			return 0, fmt.Errorf("sitemap provider %s failed: %s", name, err)
+		}
+		for _, u := range provided {
+			urls = append(urls, newEntry(base, u))
+		}
+	}
+
+	count := 0
+	for start := 0; start == 0 || start < len(urls); start += ChunkSize {
+		end := start + ChunkSize
+		if end > len(urls) {
+			end = len(urls)
+		}
+		count++
+
+		buf := bytes.NewBuffer(nil)
+		gz := gzip.NewWriter(buf)
+		gz.Write([]byte(xml.Header))
+		if err := xml.NewEncoder(gz).Encode(&urlSet{Xmlns: xmlns, URLs: urls[start:end]}); err != nil {
+			return 0, fmt.Errorf("encode sitemap failed: %s", err)
+		}
+		if err := gz.Close(); err != nil {
+			return 0, fmt.Errorf("compress sitemap failed: %s", err)
+		}
+
+		key := cache.Key(r.C, namespace, fmt.Sprintf("chunk-%d", count))
+		if err := cache.Set(r.C, key, buf.Bytes(), CacheTTL); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+func baseURL(r *app.Request) string {
+	if BaseURL != "" {
+		return strings.TrimRight(BaseURL, "/")
+	}
+	scheme := "http"
+	if r.Req.TLS != nil || r.Req.Header.Get("X-AppEngine-Https") == "on" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Req.Host
+}
+
+const xmlns = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type urlSet struct {
+	XMLName xml.Name    `xml:"urlset"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	URLs    []*urlEntry `xml:"url"`
+}
+
+type urlEntry struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+func newEntry(base string, u *URL) *urlEntry {
+	e := &urlEntry{Loc: u.Loc, ChangeFreq: u.ChangeFreq}
+	if strings.HasPrefix(e.Loc, "/") {
+		e.Loc = base + e.Loc
+	}
+	if !u.LastMod.IsZero() {
+		e.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+	}
+	if u.Priority > 0 {
+		e.Priority = strconv.FormatFloat(u.Priority, 'f', 1, 64)
+	}
+	return e
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name        `xml:"sitemapindex"`
+	Xmlns    string          `xml:"xmlns,attr"`
+	Sitemaps []*sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc string `xml:"loc"`
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// Writes the response directly to the client as fn produces it, instead
+// of buffering it, for the large exports. The headers (and the session
+// cookies) are sent before calling fn; if it fails the error is logged
+// but the client only receives the partial output.
+// Example:
+//    return r.Stream("text/plain; charset=utf-8", func(w io.Writer) error {
+//      return exportLines(r.C, w)
+//    })
+func (r *Request) Stream(contentType string, fn func(w io.Writer) error) error {
+	r.W.Header().Set("Content-Type", contentType)
+	if err := r.startStream(); err != nil {
+		return err
+	}
+	return fn(&flushWriter{r.W})
+}
+
+// Serves the content with its length, the Content-Type of the name
+// extension and support for the range & If-Modified-Since requests,
+// without buffering it. It's sent as an attachment with the name unless
+// the handler set the Content-Disposition before.
+// Example: return r.SendFile("report.pdf", report.Created, bytes.NewReader(pdf))
+func (r *Request) SendFile(name string, modtime time.Time, content io.ReadSeeker) error {
+	if r.W.Header().Get("Content-Disposition") == "" {
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name})
+		if disposition == "" {
+			disposition = "attachment"
+		}
+		r.W.Header().Set("Content-Disposition", disposition)
+	}
+
+	if err := r.startStream(); err != nil {
+		return err
+	}
+	http.ServeContent(r.W, r.Req, name, modtime, content)
+	return nil
+}
+
+// Sends the buffered output and switches the writer to the streaming
+// mode. The session is saved now, while the headers can still change.
+func (r *Request) startStream() error {
+	rw, ok := r.W.(*responseWriter)
+	if !ok || rw.streaming {
+		return nil
+	}
+
+	if err := sessions.Save(r.Req, rw.w); err != nil {
+		return fmt.Errorf("save session failed: %s", err)
+	}
+	if err := rw.output(); err != nil {
+		return fmt.Errorf("write buffered output failed: %s", err)
+	}
+	rw.streaming = true
+	return nil
+}
+
+// Flushes each write when the platform supports it, so the client
+// receives the chunks as they're produced
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f *flushWriter) Write(data []byte) (int, error) {
+	n, err := f.w.Write(data)
+	if rw, ok := f.w.(*responseWriter); ok {
+		if flusher, ok := rw.w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	return n, err
+}
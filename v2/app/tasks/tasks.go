@@ -0,0 +1,210 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/taskqueue"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+// Prefix of the paths of the tasks handlers
+const pathPrefix = "/tasks/"
+
+const KindFailure = "TaskFailure"
+
+var (
+	mux    = app.NewMux()
+	queues = map[string]string{}
+)
+
+// Error that shouldn't be retried; the task is discarded after logging it
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Wraps the error returned by a task handler to avoid retrying the task
+func Permanent(err error) error {
+	return &permanentError{err}
+}
+
+// Task discarded after a permanent error, kept to retry it by hand. The
+// tasks that failed and are being retried by the queue are kept too, with
+// Transient set, until they succeed or fail permanently.
+type Failure struct {
+	Name    string
+	Payload []byte `datastore:",noindex"`
+	Error   string `datastore:",noindex"`
+	Created time.Time
+
+	Transient bool
+	Retries   int
+}
+
+// Sets the queue used by the tasks with the name. The default
+// queue is used otherwise. Call it at init().
+func SetQueue(name, queue string) {
+	queues[name] = queue
+}
+
+// Adds a task with the payload encoded as JSON to the queue of the name
+func Enqueue(c appengine.Context, name string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode task payload failed: %s", err)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/json")
+	t := &taskqueue.Task{
+		Path:    pathPrefix + name,
+		Header:  headers,
+		Payload: data,
+	}
+	if _, err := taskqueue.Add(c, t, queues[name]); err != nil {
+		return fmt.Errorf("enqueue task %s failed: %s", name, err)
+	}
+
+	return nil
+}
+
+// Registers the handler of the tasks with the name. fn should be a
+// func(r *app.Request, payload *T) error; the payload is decoded into
+// a new T for each task. Errors retry the task, unless they're
+// wrapped with Permanent. Call it at init().
+// Example:
+//    tasks.Handle("welcome-mail", func(r *app.Request, u *User) error { ... })
+func Handle(name string, fn interface{}) {
+	f := reflect.ValueOf(fn)
+	ft := f.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() != 2 || ft.NumOut() != 1 ||
+		ft.In(0) != reflect.TypeOf(&app.Request{}) || ft.In(1).Kind() != reflect.Ptr ||
+		ft.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		panic("task handler should be a func(*app.Request, *T) error: " + name)
+	}
+	payloadType := ft.In(1).Elem()
+
+	mux.Post(pathPrefix+name, func(r *app.Request) error {
+		// App Engine removes this header from the external requests
+		if r.Req.Header.Get("X-AppEngine-QueueName") == "" {
+			r.C.Errorf("[tasks] request without queue name header")
+			return app.Forbidden()
+		}
+
+		data, err := ioutil.ReadAll(r.Req.Body)
+		if err != nil {
+			return fmt.Errorf("read task %s payload failed: %s", name, err)
+		}
+
+		// Retrying a malformed payload won't fix it; the task is
+		// reported & kept in the failures with the raw contents.
+		payload := reflect.New(payloadType)
+		if err := json.Unmarshal(data, payload.Interface()); err != nil {
+			err = fmt.Errorf("decode task %s payload failed, it won't be retried: %s", name, err)
+			r.LogError(err)
+			clearTransient(r)
+			recordFailure(r.C, name, data, err)
+			return nil
+		}
+
+		result := f.Call([]reflect.Value{reflect.ValueOf(r), payload})[0]
+		if result.IsNil() {
+			clearTransient(r)
+			return nil
+		}
+		err = result.Interface().(error)
+		if _, ok := err.(*permanentError); ok {
+			r.C.Errorf("[tasks] task %s failed, it won't be retried: %s", name, err)
+			clearTransient(r)
+			recordFailure(r.C, name, data, err)
+			return nil
+		}
+		recordTransient(r, name, data, err)
+		return fmt.Errorf("task %s failed: %s", name, err)
+	})
+}
+
+func recordFailure(c appengine.Context, name string, data []byte, err error) {
+	f := &Failure{
+		Name:    name,
+		Payload: data,
+		Error:   err.Error(),
+		Created: time.Now(),
+	}
+	if _, err := datastore.Put(c, datastore.NewIncompleteKey(c, KindFailure, nil), f); err != nil {
+		c.Errorf("[tasks] cannot store the failure of %s: %s", name, err)
+	}
+}
+
+// Stores the failure of a task that will be retried, replacing the one
+// of its previous attempt
+func recordTransient(r *app.Request, name string, data []byte, err error) {
+	retries, _ := strconv.Atoi(r.Req.Header.Get("X-AppEngine-TaskRetryCount"))
+	f := &Failure{
+		Name:      name,
+		Payload:   data,
+		Error:     err.Error(),
+		Created:   time.Now(),
+		Transient: true,
+		Retries:   retries + 1,
+	}
+	if _, err := datastore.Put(r.C, transientKey(r), f); err != nil {
+		r.C.Errorf("[tasks] cannot store the failure of %s: %s", name, err)
+	}
+}
+
+// Removes the failure of the previous attempt of the task, if any
+func clearTransient(r *app.Request) {
+	if r.Req.Header.Get("X-AppEngine-TaskRetryCount") == "0" {
+		return
+	}
+	if err := datastore.Delete(r.C, transientKey(r)); err != nil && err != datastore.ErrNoSuchEntity {
+		r.C.Errorf("[tasks] cannot remove the failure of the task: %s", err)
+	}
+}
+
+func transientKey(r *app.Request) *datastore.Key {
+	id := r.Req.Header.Get("X-AppEngine-QueueName") + "/" + r.Req.Header.Get("X-AppEngine-TaskName")
+	return datastore.NewKey(r.C, KindFailure, id, 0, nil)
+}
+
+// Returns the most recent failed tasks, newest first
+func Failures(c appengine.Context, limit int) ([]*datastore.Key, []*Failure, error) {
+	failures := []*Failure{}
+	keys, err := datastore.NewQuery(KindFailure).Order("-Created").Limit(limit).GetAll(c, &failures)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query task failures failed: %s", err)
+	}
+	return keys, failures, nil
+}
+
+// Enqueues again the failed task, removing its failure
+func Retry(c appengine.Context, id int64) error {
+	key := datastore.NewKey(c, KindFailure, "", id, nil)
+	f := new(Failure)
+	if err := datastore.Get(c, key, f); err == datastore.ErrNoSuchEntity {
+		return app.NotFound()
+	} else if err != nil {
+		return fmt.Errorf("get task failure failed: %s", err)
+	}
+
+	if err := Enqueue(c, f.Name, json.RawMessage(f.Payload)); err != nil {
+		return err
+	}
+	if err := datastore.Delete(c, key); err != nil {
+		return fmt.Errorf("delete task failure failed: %s", err)
+	}
+	return nil
+}
@@ -2,17 +2,49 @@ package app
 
 import (
 	"fmt"
+	"html"
 	"html/template"
 	"io"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
-	"appengine"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
 )
 
 var (
-	templatesMutex = &sync.Mutex{}
-	templatesCache = map[string]*template.Template{}
+	templatesMutex = &sync.RWMutex{}
+	templatesCache = map[string]*templateSet{}
+	templatesFuncs = template.FuncMap{
+		// Compare two items to see if they're equal
+		"equals": func(a, b interface{}) bool {
+			return a == b
+		},
+
+		// Returns true if the current iteration is the last one of the loop
+		"last": func(max, i int) bool {
+			return i == max-1
+		},
+
+		// Quick format for a date & time
+		"datetime": func(t time.Time) string {
+			return t.Format("02/01/2006 15:04:05")
+		},
+
+		// Converts new lines to their equivalent in HTML
+		"nl2br": func(s string) template.HTML {
+			s = html.EscapeString(s)
+			s = strings.Replace(s, "\n", "<br>", -1)
+			return template.HTML(s)
+		},
+	}
+)
+
+var (
+	requestFuncs   []func(r *Request) template.FuncMap
+	templateThemes []string
 )
 
 type TemplateConfig struct {
@@ -20,6 +52,81 @@ type TemplateConfig struct {
 	W                     io.Writer
 	Data                  interface{}
 	Dir                   string
+
+	// Functions that replace the global ones only in this execution
+	Funcs template.FuncMap
+}
+
+// Adds functions available to all the templates. Call it at init(),
+// before parsing any template.
+func RegisterTemplateFuncs(funcs template.FuncMap) {
+	templatesMutex.Lock()
+	defer templatesMutex.Unlock()
+
+	for name, f := range funcs {
+		templatesFuncs[name] = f
+	}
+}
+
+// Adds functions bound to the request to the templates executed with
+// Request.Template. f is called once with a nil request to know the names
+// of the functions, so it shouldn't use r until they run; the names without
+// a global function get a placeholder that lets the templates be parsed
+// and fails outside Request.Template. Call it at init().
+func RegisterRequestTemplateFuncs(f func(r *Request) template.FuncMap) {
+	templatesMutex.Lock()
+	defer templatesMutex.Unlock()
+
+	requestFuncs = append(requestFuncs, f)
+	for name := range f(nil) {
+		if _, ok := templatesFuncs[name]; !ok {
+			templatesFuncs[name] = requestOnlyFunc(name)
+		}
+	}
+}
+
+// Global placeholder of a function that needs the request
+func requestOnlyFunc(name string) func(args ...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("template function %s needs the request, use Request.Template", name)
+	}
+}
+
+// Sets the list of theme directories, relative to the templates one, where
+// each template is searched before the templates directory itself. The
+// first theme with the file wins, so a deployment can override only some
+// of them. Call it at init().
+// Example: app.SetTemplateThemes("themes/custom", "themes/default")
+func SetTemplateThemes(dirs ...string) {
+	templatesMutex.Lock()
+	defer templatesMutex.Unlock()
+
+	templateThemes = dirs
+	templatesCache = map[string]*templateSet{}
+}
+
+// Returns the path of the template file in the first theme that has it,
+// or in the directory itself if no theme overrides it
+func resolveTemplate(dir, name string) string {
+	for _, theme := range templateThemes {
+		file := filepath.Join(dir, theme, name+".html")
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	return filepath.Join(dir, name+".html")
+}
+
+// Parse the templates sets ahead of the first request, to detect errors
+// early and warm up the instance. Each set is a list of names, like
+// the one passed to Template.
+func PreloadTemplates(sets ...[]string) error {
+	for _, names := range sets {
+		if _, err := loadTemplate("templates", names); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func Template(w io.Writer, names []string, data interface{}) error {
@@ -32,28 +139,62 @@ func Template(w io.Writer, names []string, data interface{}) error {
 }
 
 func ExecTemplate(c *TemplateConfig) error {
-	cname := ""
-	for i, name := range c.Names {
-		c.Names[i] = filepath.Join(c.Dir, name+".html")
-		cname += name
+	set, err := loadTemplate(c.Dir, c.Names)
+	if err != nil {
+		return err
+	}
+	if err := set.execute(c.W, "base", c.Data, c.Funcs); err != nil {
+		return fmt.Errorf("exec templates failed: %s", err)
+	}
+
+	return nil
+}
+
+// Parsed templates of a list of names. The parsed set is never executed,
+// each execution runs a clone bound to its own functions.
+type templateSet struct {
+	base *template.Template
+}
+
+// Executes the named template with the functions replacing the global
+// ones during this execution only
+func (set *templateSet) execute(w io.Writer, name string, data interface{}, funcs template.FuncMap) error {
+	t, err := set.base.Clone()
+	if err != nil {
+		return fmt.Errorf("clone templates failed: %s", err)
+	}
+	if len(funcs) > 0 {
+		t.Funcs(funcs)
+	}
+	return t.ExecuteTemplate(w, name, data)
+}
+
+// Returns the parsed templates from the cache, parsing them only the first
+// time (or every time in the dev server to see the changes)
+func loadTemplate(dir string, names []string) (*templateSet, error) {
+	cname := dir + ":" + strings.Join(names, ",")
+
+	templatesMutex.RLock()
+	set, ok := templatesCache[cname]
+	templatesMutex.RUnlock()
+	if ok && !platform.Current.IsDevelopment() {
+		return set, nil
 	}
 
 	templatesMutex.Lock()
 	defer templatesMutex.Unlock()
 
-	t, ok := templatesCache[cname]
-	if !ok || appengine.IsDevAppServer() {
-		var err error
-		t, err = template.New(cname).ParseFiles(c.Names...)
-		if err != nil {
-			return fmt.Errorf("templates parsing failed: %s", err)
-		}
-		templatesCache[cname] = t
+	files := make([]string, len(names))
+	for i, name := range names {
+		files[i] = resolveTemplate(dir, name)
 	}
 
-	if err := t.ExecuteTemplate(c.W, "base", c.Data); err != nil {
-		return fmt.Errorf("exec templates failed: %s", err)
+	t, err := template.New(cname).Funcs(templatesFuncs).ParseFiles(files...)
+	if err != nil {
+		return nil, fmt.Errorf("templates parsing failed: %s", err)
 	}
+	set = &templateSet{base: t}
+	templatesCache[cname] = set
 
-	return nil
+	return set, nil
 }
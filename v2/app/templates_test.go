@@ -0,0 +1,28 @@
+package app
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestRequestTemplateFuncsPlaceholders(t *testing.T) {
+	RegisterRequestTemplateFuncs(func(r *Request) template.FuncMap {
+		return template.FuncMap{
+			"test_host": func() string { return r.Req.Host },
+		}
+	})
+
+	templatesMutex.RLock()
+	tmpl, err := template.New("t").Funcs(templatesFuncs).Parse(`{{test_host}}`)
+	templatesMutex.RUnlock()
+	if err != nil {
+		t.Fatalf("parse with a request function failed: %s", err)
+	}
+
+	err = tmpl.Execute(bytes.NewBuffer(nil), nil)
+	if err == nil || !strings.Contains(err.Error(), "needs the request") {
+		t.Errorf("got error %v executing without the request", err)
+	}
+}
@@ -0,0 +1,259 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+
+	"appengine"
+	"appengine/blobstore"
+	"appengine/datastore"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/tasks"
+)
+
+const KindBlobMigration = "BlobMigration"
+
+// Number of blobs copied by each task of a migration
+var MigrationBatch = 20
+
+// Called after copying each blob to update the entities that reference
+// the old key. It's called again if the task is retried, so it should
+// be idempotent.
+type RewriteFunc func(c appengine.Context, old, new appengine.BlobKey, filename string) error
+
+// Progress of a migration, stored with the name as its ID
+type BlobMigration struct {
+	Bucket            string
+	Started, Finished time.Time
+	Migrated, Failed  int64
+	Bytes             int64
+	LastError         string `datastore:",noindex"`
+	Cursor            string `datastore:",noindex"`
+}
+
+type migrationTask struct {
+	Name   string
+	Cursor string
+}
+
+var migrations = map[string]*migration{}
+
+type migration struct {
+	bucket  string
+	rewrite RewriteFunc
+}
+
+// Registers a migration that copies all the Blobstore blobs to the
+// bucket of Cloud Storage, calling rewrite with each one of them. The
+// objects are named after the old keys. Call it at init(); the tasks
+// run in the blob-migration queue if it's configured with tasks.SetQueue.
+// Example:
+//    upload.RegisterMigration("photos", "my-bucket", rewritePhotos)
+func RegisterMigration(name, bucket string, rewrite RewriteFunc) {
+	migrations[name] = &migration{bucket: bucket, rewrite: rewrite}
+}
+
+func init() {
+	tasks.Handle("blob-migration", migrateBatch)
+}
+
+// Starts (or restarts from the beginning) the migration with the name
+func StartMigration(c appengine.Context, name string) error {
+	m, ok := migrations[name]
+	if !ok {
+		return fmt.Errorf("migration not registered: %s", name)
+	}
+
+	status := &BlobMigration{Bucket: m.bucket, Started: time.Now()}
+	if _, err := datastore.Put(c, migrationKey(c, name), status); err != nil {
+		return fmt.Errorf("put migration status failed: %s", err)
+	}
+
+	return tasks.Enqueue(c, "blob-migration", &migrationTask{Name: name})
+}
+
+// Returns the progress of the migration with the name
+func MigrationStatus(c appengine.Context, name string) (*BlobMigration, error) {
+	status := new(BlobMigration)
+	if err := datastore.Get(c, migrationKey(c, name), status); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, err
+		}
+		return nil, fmt.Errorf("get migration status failed: %s", err)
+	}
+	return status, nil
+}
+
+func migrateBatch(r *app.Request, t *migrationTask) error {
+	m, ok := migrations[t.Name]
+	if !ok {
+		return tasks.Permanent(fmt.Errorf("migration not registered: %s", t.Name))
+	}
+
+	q := datastore.NewQuery("__BlobInfo__").Limit(MigrationBatch)
+	if t.Cursor != "" {
+		cur, err := datastore.DecodeCursor(t.Cursor)
+		if err != nil {
+			return tasks.Permanent(fmt.Errorf("decode cursor failed: %s", err))
+		}
+		q = q.Start(cur)
+	}
+
+	progress := new(BlobMigration)
+	count := 0
+	it := q.Run(r.C)
+	for {
+		info := new(blobstore.BlobInfo)
+		key, err := it.Next(info)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return fmt.Errorf("query blobs failed: %s", err)
+		}
+		count++
+
+		old := appengine.BlobKey(key.StringID())
+		if err := migrateBlob(r.C, m, old, info); err != nil {
+			r.C.Errorf("[upload] migrate blob %s failed: %s", old, err)
+			progress.Failed++
+			progress.LastError = fmt.Sprintf("%s: %s", old, err)
+			continue
+		}
+		progress.Migrated++
+		progress.Bytes += info.Size
+	}
+
+	next := ""
+	if count == MigrationBatch {
+		cur, err := it.Cursor()
+		if err != nil {
+			return fmt.Errorf("get cursor failed: %s", err)
+		}
+		next = cur.String()
+	}
+
+	if err := updateMigration(r.C, t.Name, progress, t.Cursor, next); err != nil {
+		return err
+	}
+	if next != "" {
+		return tasks.Enqueue(r.C, "blob-migration", &migrationTask{Name: t.Name, Cursor: next})
+	}
+	return nil
+}
+
+// Copies the blob to the bucket, checking the copy before calling the
+// rewrite function
+func migrateBlob(c appengine.Context, m *migration, old appengine.BlobKey, info *blobstore.BlobInfo) error {
+	// The checksum is computed while the blob is sent
+	sum := md5.New()
+	content := io.TeeReader(blobstore.NewReader(c, old), sum)
+	filename, err := StoreGCS(c, m.bucket, string(old), info.ContentType, content)
+	if err != nil {
+		return err
+	}
+
+	key, err := BlobKey(c, filename)
+	if err != nil {
+		return err
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, blobstore.NewReader(c, key)); err != nil {
+		return fmt.Errorf("read copy failed: %s", err)
+	}
+	if !bytes.Equal(h.Sum(nil), sum.Sum(nil)) {
+		return fmt.Errorf("checksum of the copy %s doesn't match", filename)
+	}
+
+	if err := m.rewrite(c, old, key, filename); err != nil {
+		return fmt.Errorf("rewrite references failed: %s", err)
+	}
+	return nil
+}
+
+// Adds the progress of the batch that started in the cursor to the
+// migration status, finishing it if there's no next cursor. The batches
+// already added, whose tasks are being retried, are ignored.
+func updateMigration(c appengine.Context, name string, progress *BlobMigration, cursor, next string) error {
+	key := migrationKey(c, name)
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		status := new(BlobMigration)
+		if err := datastore.Get(c, key, status); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if status.Cursor != cursor || !status.Finished.IsZero() {
+			return nil
+		}
+		status.Migrated += progress.Migrated
+		status.Failed += progress.Failed
+		status.Bytes += progress.Bytes
+		if progress.LastError != "" {
+			status.LastError = progress.LastError
+		}
+		status.Cursor = next
+		if next == "" {
+			status.Finished = time.Now()
+		}
+		_, err := datastore.Put(c, key, status)
+		return err
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("update migration status failed: %s", err)
+	}
+	return nil
+}
+
+func migrationKey(c appengine.Context, name string) *datastore.Key {
+	return datastore.NewKey(c, KindBlobMigration, name, 0, nil)
+}
+
+var migrationTemplate = template.Must(template.New("migration").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Blob migration {{.Name}}</title></head>
+<body>
+  <h1>Blob migration {{.Name}}</h1>
+  {{with .Status}}
+  <table>
+    <tr><th>Bucket</th><td>{{.Bucket}}</td></tr>
+    <tr><th>Started</th><td>{{.Started}}</td></tr>
+    <tr><th>Finished</th><td>{{if .Finished.IsZero}}running{{else}}{{.Finished}}{{end}}</td></tr>
+    <tr><th>Migrated</th><td>{{.Migrated}} ({{.Bytes}} bytes)</td></tr>
+    <tr><th>Failed</th><td>{{.Failed}}</td></tr>
+    <tr><th>Last error</th><td>{{.LastError}}</td></tr>
+  </table>
+  {{else}}
+  <p>Not started</p>
+  {{end}}
+</body>
+</html>
+`))
+
+// Admin page with the progress of the migration in the name param of
+// the query string. Start it with StartMigration.
+// Example: "GET::/admin/blob-migration": upload.MigrationHandler,
+func MigrationHandler(r *app.Request) error {
+	if !user.IsAdmin(r.C) {
+		return app.Forbidden()
+	}
+
+	name := r.Req.FormValue("name")
+	if _, ok := migrations[name]; !ok {
+		return app.NotFound()
+	}
+
+	status, err := MigrationStatus(r.C, name)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	data := map[string]interface{}{"Name": name, "Status": status}
+	if err := migrationTemplate.Execute(r.W, data); err != nil {
+		return fmt.Errorf("exec migration template failed: %s", err)
+	}
+	return nil
+}
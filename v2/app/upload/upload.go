@@ -0,0 +1,103 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"appengine"
+	"appengine/blobstore"
+	"appengine/image"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+const storageScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// Returns the URL where the form should be posted to upload the files
+// directly to the bucket of Cloud Storage (the default Blobstore if it's
+// empty). The request is sent to successPath after that; use
+// r.ParseUploads there to get the files.
+func UploadURL(c appengine.Context, successPath, bucket string, maxSize int64) (string, error) {
+	opts := &blobstore.UploadURLOptions{
+		MaxUploadBytes: maxSize,
+		StorageBucket:  bucket,
+	}
+	u, err := blobstore.UploadURL(c, successPath, opts)
+	if err != nil {
+		return "", fmt.Errorf("get upload url failed: %s", err)
+	}
+	return u.String(), nil
+}
+
+// Stores the contents of the file in the bucket with the name, streaming
+// them from the reader. It returns the filename of the object
+// (/gs/bucket/name).
+// Example:
+//     f, err := u.Open()
+//     ...
+//     defer f.Close()
+//     filename, err := upload.StoreGCS(r.C, bucket, name, u.ContentType, f)
+func StoreGCS(c appengine.Context, bucket, name, contentType string, content io.Reader) (string, error) {
+	token, _, err := platform.Current.AccessToken(c, storageScope)
+	if err != nil {
+		return "", fmt.Errorf("get access token failed: %s", err)
+	}
+
+	params := url.Values{"uploadType": {"media"}, "name": {name}}
+	endpoint := fmt.Sprintf("https://www.googleapis.com/upload/storage/v1/b/%s/o?%s",
+		url.QueryEscape(bucket), params.Encode())
+	req, err := http.NewRequest("POST", endpoint, content)
+	if err != nil {
+		return "", fmt.Errorf("prepare storage request failed: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := platform.Current.HTTPClient(c, time.Duration(60)*time.Second).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("store file failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		var result struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return "", fmt.Errorf("store file failed: status %d: %s", resp.StatusCode,
+			result.Error.Message)
+	}
+
+	return fmt.Sprintf("/gs/%s/%s", bucket, name), nil
+}
+
+// Returns the blob key of a file stored in Cloud Storage (/gs/bucket/name)
+func BlobKey(c appengine.Context, filename string) (appengine.BlobKey, error) {
+	key, err := blobstore.BlobKeyForFile(c, filename)
+	if err != nil {
+		return "", fmt.Errorf("get blob key failed: %s", err)
+	}
+	return key, nil
+}
+
+// Returns the URL to serve an image of the Blobstore or Cloud Storage
+// with the Images API. Size resizes the longest side (0 to keep the
+// original one) and crop makes it square.
+func ServingURL(c appengine.Context, key appengine.BlobKey, size int, crop bool) (string, error) {
+	opts := &image.ServingURLOptions{
+		Secure: true,
+		Size:   size,
+		Crop:   crop,
+	}
+	u, err := image.ServingURL(c, key, opts)
+	if err != nil {
+		return "", fmt.Errorf("get serving url failed: %s", err)
+	}
+	return u.String(), nil
+}
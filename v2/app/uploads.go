@@ -0,0 +1,149 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"appengine"
+	"appengine/blobstore"
+)
+
+// Memory used to parse the multipart forms; bigger files are stored
+// in temporary files.
+var MaxUploadMemory int64 = 10 << 20
+
+// File received in a request
+type Upload struct {
+	// Name of the form field
+	Field string
+
+	Filename string
+	Size     int64
+
+	// Type detected from the first bytes of the file; the one sent by the
+	// client is in DeclaredType.
+	ContentType, DeclaredType string
+
+	// Key of the file if it was uploaded to the Blobstore (or GCS
+	// through the Blobstore API)
+	BlobKey appengine.BlobKey
+
+	open func() (io.ReadCloser, error)
+}
+
+// Opens the contents of the file, without reading them in memory. The
+// caller should close it.
+func (u *Upload) Open() (io.ReadCloser, error) {
+	f, err := u.open()
+	if err != nil {
+		return nil, fmt.Errorf("open uploaded file failed: %s", err)
+	}
+	return f, nil
+}
+
+// Checks the size (0 to skip it) and the detected content type of the
+// file (prefixes like "image/" are allowed).
+func (u *Upload) Validate(maxSize int64, types ...string) error {
+	if maxSize > 0 && u.Size > maxSize {
+		return fmt.Errorf("file %s too big: %d bytes", u.Filename, u.Size)
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	contentType := strings.TrimSpace(strings.Split(u.ContentType, ";")[0])
+	for _, t := range types {
+		if contentType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("file %s type not allowed: %s", u.Filename, u.ContentType)
+}
+
+// Returns the files of the request: the blobs of a Blobstore upload
+// callback or the parts of a multipart form.
+func (r *Request) ParseUploads() ([]*Upload, error) {
+	uploads := []*Upload{}
+
+	// Blobstore callback
+	if r.Req.Header.Get("X-AppEngine-BlobUpload") != "" {
+		blobs, _, err := blobstore.ParseUpload(r.Req)
+		if err != nil {
+			return nil, fmt.Errorf("parse blobstore upload failed: %s", err)
+		}
+		for field, infos := range blobs {
+			for _, info := range infos {
+				key := info.BlobKey
+				u := &Upload{
+					Field:        field,
+					Filename:     info.Filename,
+					DeclaredType: info.ContentType,
+					Size:         info.Size,
+					BlobKey:      key,
+					open: func() (io.ReadCloser, error) {
+						return ioutil.NopCloser(blobstore.NewReader(r.C, key)), nil
+					},
+				}
+				if err := u.sniff(); err != nil {
+					return nil, err
+				}
+				uploads = append(uploads, u)
+			}
+		}
+		return uploads, nil
+	}
+
+	if err := r.Req.ParseMultipartForm(MaxUploadMemory); err != nil {
+		return nil, fmt.Errorf("parse multipart form failed: %s", err)
+	}
+	for field, headers := range r.Req.MultipartForm.File {
+		for _, header := range headers {
+			f, err := header.Open()
+			if err != nil {
+				return nil, fmt.Errorf("open uploaded file failed: %s", err)
+			}
+			size, err := f.Seek(0, 2)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("read uploaded file size failed: %s", err)
+			}
+
+			h := header
+			u := &Upload{
+				Field:        field,
+				Filename:     header.Filename,
+				DeclaredType: header.Header.Get("Content-Type"),
+				Size:         size,
+				open: func() (io.ReadCloser, error) {
+					return h.Open()
+				},
+			}
+			if err := u.sniff(); err != nil {
+				return nil, err
+			}
+			uploads = append(uploads, u)
+		}
+	}
+
+	return uploads, nil
+}
+
+// Detects the content type from the first bytes of the file, the client
+// can send any type it wants
+func (u *Upload) sniff() error {
+	f, err := u.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("read uploaded file failed: %s", err)
+	}
+	u.ContentType = http.DetectContentType(buf[:n])
+	return nil
+}
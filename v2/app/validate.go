@@ -0,0 +1,327 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ernestokarim/gaelib/v2/validators"
+)
+
+// Errors of the validation keyed by the field names. They're emitted in
+// the JSON error envelope, or can be shown in a form with SetErrors.
+type ValidationErrors map[string]string
+
+func (e ValidationErrors) Error() string {
+	fields := []string{}
+	for field, msg := range e {
+		fields = append(fields, field+": "+msg)
+	}
+	sort.Strings(fields)
+	return "validation failed: " + strings.Join(fields, "; ")
+}
+
+// Types that check themselves after the tags validation
+type Validator interface {
+	Validate() error
+}
+
+// Checks the valid tags of the struct fields, returning ValidationErrors
+// with all the invalid ones, keyed by their JSON names. Nested structs are
+// checked too, and the ones that implement Validator are called at the end.
+// The fields of the embedded structs are keyed as the fields of the parent.
+// Unknown or malformed rules return an error.
+// LoadData and LoadJsonData call it after decoding.
+// Supported rules: required, min=N, max=N (length of strings & slices or
+// numeric value), email, oneof=a b c.
+// Example:
+//    type Comment struct {
+//      Author string `valid:"required,max=50"`
+//      Email  string `valid:"email"`
+//    }
+func Validate(v interface{}) error {
+	return validate(v, jsonName)
+}
+
+func validate(v interface{}, naming func(field reflect.StructField) string) error {
+	errs := ValidationErrors{}
+	if err := validateValue(reflect.ValueOf(v), "", naming, errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, path string, naming func(field reflect.StructField) string,
+	errs ValidationErrors) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if unexported(field) {
+				continue
+			}
+
+			// The fields of the embedded structs are checked as if they
+			// were fields of this one, like the decoders see them
+			name := path
+			if !inlined(field, naming) {
+				name = naming(field)
+				if path != "" {
+					name = path + "." + name
+				}
+			}
+			if tag := field.Tag.Get("valid"); tag != "" {
+				rules, err := parseRules(tag)
+				if err != nil {
+					return fmt.Errorf("valid tag of %s failed: %s", t.Name()+"."+field.Name, err)
+				}
+				if msg := checkRules(v.Field(i), name, rules); msg != "" {
+					errs[name] = msg
+					continue
+				}
+			}
+			if err := validateValue(v.Field(i), name, naming, errs); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), naming, errs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The custom checks only run if the fields are correct. The ones of
+	// the unexported embedded structs are promoted to the parent.
+	if len(errs) > 0 || !v.CanInterface() {
+		return nil
+	}
+	validator, ok := v.Interface().(Validator)
+	if !ok && v.CanAddr() {
+		validator, ok = v.Addr().Interface().(Validator)
+	}
+	if !ok {
+		return nil
+	}
+	if err := validator.Validate(); err != nil {
+		if e, ok := err.(*AppError); ok && e.Field != "" {
+			errs[e.Field] = e.Message
+			return nil
+		}
+		if e, ok := err.(ValidationErrors); ok {
+			for field, msg := range e {
+				errs[field] = msg
+			}
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Rule of a valid tag, with the argument after the = sign
+type rule struct {
+	name, arg string
+	limit     float64
+}
+
+// Parses the rules of a valid tag, returning an error for the unknown
+// ones and the ones without the right argument
+func parseRules(tag string) ([]rule, error) {
+	if tag == "" {
+		return nil, nil
+	}
+	rules := []rule{}
+	for _, text := range strings.Split(tag, ",") {
+		r := rule{name: text}
+		if i := strings.Index(text, "="); i != -1 {
+			r.name, r.arg = text[:i], text[i+1:]
+		}
+
+		switch r.name {
+		case "required", "email":
+			if r.arg != "" {
+				return nil, fmt.Errorf("rule %s doesn't take an argument", text)
+			}
+
+		case "min", "max":
+			limit, err := strconv.ParseFloat(r.arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s needs a number", text)
+			}
+			r.limit = limit
+
+		case "oneof":
+			if len(strings.Fields(r.arg)) == 0 {
+				return nil, fmt.Errorf("rule %s needs the allowed values", text)
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown rule %s", text)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Checks the valid tags of the struct type and the types of its fields.
+// JsonHandler calls it to fail when the handler is registered instead
+// of when it receives a request.
+func checkTags(t reflect.Type) error {
+	return checkTypeTags(t, map[reflect.Type]bool{})
+}
+
+func checkTypeTags(t reflect.Type, seen map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if unexported(field) {
+			continue
+		}
+		if _, err := parseRules(field.Tag.Get("valid")); err != nil {
+			return fmt.Errorf("valid tag of %s failed: %s", t.Name()+"."+field.Name, err)
+		}
+		if err := checkTypeTags(field.Type, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the message of the first rule that fails, or an empty string
+func checkRules(v reflect.Value, field string, rules []rule) string {
+	for _, r := range rules {
+		switch r.name {
+		case "required":
+			if isEmptyValue(v) {
+				return fmt.Sprintf("the field %s is required", field)
+			}
+
+		case "min", "max":
+			if !checkLimit(v, r.name, r.limit) {
+				what := "value"
+				if isLength(v) {
+					what = "length"
+				}
+				return fmt.Sprintf("the %s of the field %s should be %s %s",
+					what, field, map[string]string{"min": "at least", "max": "at most"}[r.name], r.arg)
+			}
+
+		case "email":
+			if v.Kind() == reflect.String && v.Len() > 0 && !validators.Email(v.String()) {
+				return fmt.Sprintf("the field %s is not a valid email", field)
+			}
+
+		case "oneof":
+			value := fmt.Sprintf("%v", v)
+			if !isEmptyValue(v) && !validators.OneOf(value, strings.Fields(r.arg)) {
+				return fmt.Sprintf("the field %s should be one of: %s", field, r.arg)
+			}
+		}
+	}
+	return ""
+}
+
+// Returns true if the value satisfies the limit of the min or max rule:
+// the characters of the strings, the length of the slices & maps or
+// the numeric value of the rest
+func checkLimit(v reflect.Value, rule string, limit float64) bool {
+	if v.Kind() == reflect.String {
+		if rule == "min" {
+			return validators.MinLength(v.String(), int(limit))
+		}
+		return validators.MaxLength(v.String(), int(limit))
+	}
+
+	var n float64
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n = float64(v.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	}
+	if rule == "min" {
+		return n >= limit
+	}
+	return n <= limit
+}
+
+// Returns true if the min & max rules check the length of the value
+func isLength(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+	return false
+}
+
+// Returns true if the decoders can't set the field: the unexported ones,
+// except the embedded structs, whose fields are promoted
+func unexported(field reflect.StructField) bool {
+	return field.PkgPath != "" && !(field.Anonymous && field.Type.Kind() == reflect.Struct)
+}
+
+// Returns true if the field embeds a struct without a name of its own in
+// the naming, so its fields are promoted to the parent
+func inlined(field reflect.StructField, naming func(field reflect.StructField) string) bool {
+	if !field.Anonymous {
+		return false
+	}
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	untagged := field
+	untagged.Tag = ""
+	return naming(field) == naming(untagged)
+}
+
+// Returns the name of the field in the JSON payloads
+func jsonName(field reflect.StructField) string {
+	name, _, tagged := fieldTag(field)
+	if tagged && name != "-" {
+		return name
+	}
+	if jsonNaming != nil {
+		return jsonNaming(field.Name)
+	}
+	return field.Name
+}
+
+// Returns the name of the field in the form values, like gorilla schema
+func schemaName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("schema"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return field.Name
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+type validateBase struct {
+	Email string `json:"email" valid:"required,email"`
+}
+
+type validateComment struct {
+	validateBase
+	Author *validateAuthor `json:"author"`
+	Text   string          `json:"text" valid:"max=5"`
+}
+
+type validateAuthor struct {
+	Name string `json:"name" valid:"required"`
+}
+
+func TestValidateNamesEmbeddedFields(t *testing.T) {
+	err := Validate(&validateComment{Author: &validateAuthor{}, Text: "too long"})
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("got error %v, expected validation errors", err)
+	}
+	for _, field := range []string{"email", "author.name", "text"} {
+		if errs[field] == "" {
+			t.Errorf("no error for the field %s: %v", field, errs)
+		}
+	}
+	if len(errs) != 3 {
+		t.Errorf("got %d errors, expected 3: %v", len(errs), errs)
+	}
+}
+
+func TestCheckTags(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		valid bool
+	}{
+		{validateComment{}, true},
+		{struct {
+			Name string `valid:"required,min=2,max=10,oneof=a b"`
+		}{}, true},
+		{struct {
+			Name string `valid:"requried"`
+		}{}, false},
+		{struct {
+			Name string `valid:"max=ten"`
+		}{}, false},
+		{struct {
+			Name string `valid:"oneof="`
+		}{}, false},
+		{struct {
+			Items []struct {
+				Name string `valid:"email=yes"`
+			}
+		}{}, false},
+	}
+	for i, test := range tests {
+		err := checkTags(reflect.TypeOf(test.value))
+		if (err == nil) != test.valid {
+			t.Errorf("test %d: got error %v, expected valid %v", i, err, test.valid)
+		}
+	}
+
+	if err := Validate(&struct {
+		Name string `valid:"unknown"`
+	}{}); err == nil {
+		t.Errorf("unknown rule accepted by Validate")
+	}
+}
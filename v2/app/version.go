@@ -0,0 +1,62 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+// Major version receiving most of the traffic. Requests served by other
+// versions during a traffic split are considered canaries: their log
+// lines are tagged with the version. Call SetStableVersion at init().
+var stableVersion string
+
+// Example: app.SetStableVersion("3")
+func SetStableVersion(version string) {
+	stableVersion = version
+}
+
+// True to send the X-Served-Version header
+var versionHeader bool
+
+// Sends the version serving each request in the X-Served-Version header,
+// to compare the versions of a traffic split from the clients. The public
+// responses don't reveal the deployments by default. Call it at init().
+func EnableVersionHeader() {
+	versionHeader = true
+}
+
+// Returns the major version serving the request (without the
+// deployment ID appended by App Engine)
+func (r *Request) Version() string {
+	return strings.SplitN(platform.Current.VersionID(r.C), ".", 2)[0]
+}
+
+// Returns the module serving the request
+func (r *Request) Module() string {
+	return platform.Current.ModuleName(r.C)
+}
+
+// Returns true if the request is served by a version that's not the
+// stable one during a traffic split
+func (r *Request) IsCanary() bool {
+	return stableVersion != "" && r.Version() != stableVersion
+}
+
+// Appends the version to the key, so the caches of different versions
+// don't mix data with incompatible formats during a traffic split.
+// Example: memcache.Get(r.C, r.VersionedKey("home-page"))
+func (r *Request) VersionedKey(key string) string {
+	return key + "@" + r.Module() + "." + r.Version()
+}
+
+// Returns the value of the version serving the request, or def if
+// it's not in the map. Use it to assign experiments to the versions
+// of a traffic split.
+// Example: layout := r.ByVersion(map[string]string{"4": "new"}, "old")
+func (r *Request) ByVersion(values map[string]string, def string) string {
+	if v, ok := values[r.Version()]; ok {
+		return v
+	}
+	return def
+}
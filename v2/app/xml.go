@@ -0,0 +1,165 @@
+package app
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Decodes the XML body into data and checks its valid tags like
+// LoadJsonData, naming the errors with the XML names of the fields.
+func (r *Request) LoadXmlData(data interface{}) error {
+	var body io.Reader = r.Req.Body
+	decodeCharset := charsetReader
+
+	// The charset of the header takes precedence over the one of the
+	// XML declaration, that is ignored then
+	if _, params, err := mime.ParseMediaType(r.Req.Header.Get("Content-Type")); err == nil && params["charset"] != "" {
+		reader, err := charsetReader(params["charset"], body)
+		if err != nil {
+			return err
+		}
+		body = reader
+		decodeCharset = func(charset string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+	}
+
+	decoder := xml.NewDecoder(body)
+	decoder.CharsetReader = decodeCharset
+	if err := decoder.Decode(data); err != nil && err != io.EOF {
+		return fmt.Errorf("decode xml body failed: %s", err)
+	}
+
+	return validate(data, xmlName)
+}
+
+// Returns the name of the field in the XML documents
+func xmlName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("xml"), ",")[0]
+	if name != "" && name != "-" {
+		// Remove the parents of the a>b>c paths
+		parts := strings.Split(name, ">")
+		return parts[len(parts)-1]
+	}
+	return field.Name
+}
+
+func (r *Request) EmitXml(data interface{}) error {
+	r.W.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	if _, err := io.WriteString(r.W, xml.Header); err != nil {
+		return fmt.Errorf("write xml header failed: %s", err)
+	}
+
+	if err := xml.NewEncoder(r.W).Encode(data); err != nil {
+		return fmt.Errorf("encode xml failed: %s", err)
+	}
+
+	return nil
+}
+
+// Emits the data as XML or JSON, the one preferred in the Accept header
+// of the client. JSON is used if there's no preference.
+func (r *Request) Emit(data interface{}) error {
+	// The response changes with the header, the caches should store
+	// each version apart
+	r.W.Header().Add("Vary", "Accept")
+	if prefersXml(r.Req.Header.Get("Accept")) {
+		return r.EmitXml(data)
+	}
+
+	r.W.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return r.EmitJson(data)
+}
+
+func prefersXml(accept string) bool {
+	xmlQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		switch strings.TrimSpace(fields[0]) {
+		case "application/xml", "text/xml":
+			if q > xmlQ {
+				xmlQ = q
+			}
+		case "application/json":
+			if q > jsonQ {
+				jsonQ = q
+			}
+		}
+	}
+	return xmlQ > 0 && xmlQ > jsonQ
+}
+
+// Converts the charsets used by the legacy clients to UTF-8. Only the
+// single byte Latin ones are supported besides UTF-8.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "latin1", "latin-1":
+		return &singleByteReader{r: bufio.NewReader(input)}, nil
+	case "windows-1252", "cp1252":
+		return &singleByteReader{r: bufio.NewReader(input), high: cp1252}, nil
+	}
+	return nil, fmt.Errorf("unsupported charset: %s", charset)
+}
+
+// Characters of the 0x80-0x9F range of windows-1252, that Latin-1 uses
+// for control codes. The unassigned bytes keep the Latin-1 ones.
+var cp1252 = [32]rune{
+	'\u20AC', '\u0081', '\u201A', '\u0192', '\u201E', '\u2026', '\u2020', '\u2021',
+	'\u02C6', '\u2030', '\u0160', '\u2039', '\u0152', '\u008D', '\u017D', '\u008F',
+	'\u0090', '\u2018', '\u2019', '\u201C', '\u201D', '\u2022', '\u2013', '\u2014',
+	'\u02DC', '\u2122', '\u0161', '\u203A', '\u0153', '\u009D', '\u017E', '\u0178',
+}
+
+// Decodes Latin-1 bytes as UTF-8, replacing the 0x80-0x9F range with the
+// high table if present
+type singleByteReader struct {
+	r       *bufio.Reader
+	high    [32]rune
+	pending []byte
+}
+
+func (l *singleByteReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(l.pending) > 0 {
+			c := copy(p[n:], l.pending)
+			l.pending = l.pending[c:]
+			n += c
+			continue
+		}
+
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		ch := rune(b)
+		if b >= 0x80 && b <= 0x9F && l.high[b-0x80] != 0 {
+			ch = l.high[b-0x80]
+		}
+		buf := make([]byte, utf8.UTFMax)
+		l.pending = buf[:utf8.EncodeRune(buf, ch)]
+	}
+	return n, nil
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCharsetReader(t *testing.T) {
+	tests := []struct {
+		charset  string
+		input    []byte
+		expected string
+	}{
+		{"utf-8", []byte("caf\xc3\xa9"), "café"},
+		{"ISO-8859-1", []byte("caf\xe9"), "café"},
+		{"latin1", []byte("\x80"), "\u0080"},
+		{"windows-1252", []byte("caf\xe9 \x80 \x93x\x94"), "café € “x”"},
+		{"cp1252", []byte("\x81\x9f"), "\u0081Ÿ"},
+	}
+	for _, test := range tests {
+		reader, err := charsetReader(test.charset, bytes.NewReader(test.input))
+		if err != nil {
+			t.Errorf("charset %s: %s", test.charset, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Errorf("charset %s: %s", test.charset, err)
+			continue
+		}
+		if string(got) != test.expected {
+			t.Errorf("charset %s: got %q, expected %q", test.charset, got, test.expected)
+		}
+	}
+
+	if _, err := charsetReader("shift_jis", bytes.NewReader(nil)); err == nil {
+		t.Errorf("unsupported charset accepted")
+	}
+}
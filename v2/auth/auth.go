@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"fmt"
+
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+// Returns the logged user of the Google Accounts, or nil if there's no one
+func Current(r *app.Request) *user.User {
+	return user.Current(r.C)
+}
+
+// Decorates the handler to allow only logged users. Anonymous users
+// are sent to the login page, or receive a 401 error in the
+// JSON requests.
+// Example: "::/profile": auth.RequireLogin(profile.Edit),
+func RequireLogin(h app.Handler) app.Handler {
+	return app.Wrap(h, func(r *app.Request) error {
+		if Current(r) == nil {
+			return login(r)
+		}
+		return h(r)
+	})
+}
+
+// Decorates the handler to allow only the admins of the application.
+// Anonymous users are treated like in RequireLogin and the rest of
+// users receive a 403 error (themed with the ERROR::403 handler).
+// Example: "::/admin": auth.RequireAdmin(admin.Home),
+func RequireAdmin(h app.Handler) app.Handler {
+	return app.Wrap(h, func(r *app.Request) error {
+		u := Current(r)
+		if u == nil {
+			return login(r)
+		}
+		if !u.Admin {
+			r.C.Errorf("[auth] user is not an admin: %s", u.Email)
+			return app.Forbidden()
+		}
+		return h(r)
+	})
+}
+
+func login(r *app.Request) error {
+	if r.WantsJson() {
+		return app.Unauthorized()
+	}
+
+	u, err := user.LoginURL(r.C, r.Path())
+	if err != nil {
+		return fmt.Errorf("get login url failed: %s", err)
+	}
+	return r.Redirect(u)
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+// Source of the authenticated identity of the requests. The default one
+// uses the Google Accounts; implement it to verify SAML assertions or
+// other kind of enterprise logins.
+type IdentityProvider interface {
+	// Returns the email of the logged user, or an empty string if
+	// there's no one.
+	Email(r *app.Request) (string, error)
+
+	// Returns the organization domain verified by the provider for the
+	// logged user (the Google Apps domain, the "hd" claim of OpenID
+	// Connect, etc.), or an empty string if the account has none. The
+	// domain of the email can't be used: anyone can register an account
+	// with any address.
+	Domain(r *app.Request) (string, error)
+
+	// Sends the user to the login page, returning to the current URL after it
+	Login(r *app.Request) error
+}
+
+type googleAccounts struct{}
+
+func (g googleAccounts) Email(r *app.Request) (string, error) {
+	u := user.Current(r.C)
+	if u == nil {
+		return "", nil
+	}
+	return u.Email, nil
+}
+
+func (g googleAccounts) Domain(r *app.Request) (string, error) {
+	u := user.Current(r.C)
+	if u == nil {
+		return "", nil
+	}
+	return u.AuthDomain, nil
+}
+
+func (g googleAccounts) Login(r *app.Request) error {
+	return login(r)
+}
+
+// Identity provider used by RestrictDomains
+var Identity IdentityProvider = googleAccounts{}
+
+func init() {
+	// Requests of different users are not coalesced
+	app.SetCoalesceUser(func(r *app.Request) string {
+		email, err := Identity.Email(r)
+		if err != nil {
+			// Don't share the response if the user is unknown
+			r.C.Warningf("[auth] cannot get the user to coalesce: %s", err)
+			return "error:" + r.RequestId()
+		}
+		return email
+	})
+}
+
+// Decorates the handler to allow only the users of the domains, as
+// verified by the identity provider. Anonymous users are sent to the login page and outsiders
+// receive a 403 error (themed with the ERROR::403 handler).
+// Example: "::/admin": auth.RestrictDomains([]string{"example.com"}, admin.Home),
+func RestrictDomains(domains []string, h app.Handler) app.Handler {
+	return app.Wrap(h, func(r *app.Request) error {
+		email, err := Identity.Email(r)
+		if err != nil {
+			return fmt.Errorf("get identity failed: %s", err)
+		}
+		if email == "" {
+			return Identity.Login(r)
+		}
+
+		domain, err := Identity.Domain(r)
+		if err != nil {
+			return fmt.Errorf("get identity domain failed: %s", err)
+		}
+		if !inDomains(domain, domains) {
+			r.C.Errorf("[auth] user outside the allowed domains: %s (%s)", email, domain)
+			return app.Forbidden()
+		}
+
+		return h(r)
+	})
+}
+
+func inDomains(domain string, domains []string) bool {
+	if domain == "" {
+		return false
+	}
+
+	for _, d := range domains {
+		if strings.EqualFold(domain, d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+var (
+	// Returned by Get when the key is not cached
+	ErrMiss = errors.New("cache: miss")
+
+	// Codec used to encode the values stored by Set & Once. The values
+	// remember their codec, so changing it doesn't break the old ones.
+	DefaultCodec Codec = Gob
+
+	// Expiration of the fill locks of Once, and maximum time waited for
+	// the value filled by another instance
+	LockTimeout = time.Duration(10) * time.Second
+
+	// Interval between the checks of the value while waiting
+	PollInterval = time.Duration(100) * time.Millisecond
+)
+
+// Encodes the cached values
+type Codec interface {
+	// Identifies the codec in the stored values
+	ID() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	Gob  Codec = gobCodec{}
+	JSON Codec = jsonCodec{}
+
+	codecs = map[byte]Codec{'g': Gob, 'j': JSON}
+)
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte { return 'g' }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte { return 'j' }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Registers an additional codec. Call it at init().
+func RegisterCodec(codec Codec) {
+	codecs[codec.ID()] = codec
+}
+
+// Loads the cached value of the key into dest, returning ErrMiss if
+// it's not found.
+func Get(c appengine.Context, key string, dest interface{}) error {
+	item, err := store.New(c).Get(Prefix(c) + key)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return ErrMiss
+		}
+		return fmt.Errorf("get cache item failed: %s", err)
+	}
+	return decode(item.Value, dest)
+}
+
+// Caches the value; a zero ttl never expires
+func Set(c appengine.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+	if err := store.New(c).Set(Prefix(c)+key, data, ttl); err != nil {
+		return fmt.Errorf("set cache item failed: %s", err)
+	}
+	return nil
+}
+
+// Removes the cached value of the key
+func Delete(c appengine.Context, key string) error {
+	if err := store.New(c).Delete(Prefix(c) + key); err != nil {
+		return fmt.Errorf("delete cache item failed: %s", err)
+	}
+	return nil
+}
+
+// Computes the value of a key not cached
+type FillFunc func() (interface{}, error)
+
+// In-process fills, to share the value between concurrent requests
+var (
+	fillsMutex sync.Mutex
+	fills      = map[string]*fill{}
+)
+
+type fill struct {
+	wg   sync.WaitGroup
+	data []byte
+	err  error
+}
+
+// Loads the cached value of the key into dest, calling fn to compute
+// and cache it if it's not found. Only one request fills each key at a
+// time, across all instances; the rest wait for its value.
+// Example:
+//    var posts []*Post
+//    err := cache.Once(c, "posts", time.Hour, &posts, func() (interface{}, error) {
+//      return loadPosts(c)
+//    })
+func Once(c appengine.Context, key string, ttl time.Duration, dest interface{}, fn FillFunc) error {
+	if err := Get(c, key, dest); err != ErrMiss {
+		return err
+	}
+
+	// The tenants of the namespaces don't share the fills
+	fkey := namespaceOf(c) + ":" + key
+
+	fillsMutex.Lock()
+	f, ok := fills[fkey]
+	if !ok {
+		f = new(fill)
+		f.wg.Add(1)
+		fills[fkey] = f
+	}
+	fillsMutex.Unlock()
+
+	if ok {
+		f.wg.Wait()
+	} else {
+		f.run(c, fkey, key, ttl, fn)
+	}
+
+	if f.err != nil {
+		return f.err
+	}
+	return decode(f.data, dest)
+}
+
+// Fills the key and releases the waiters, even if fn panics; the panic
+// is returned to all of them as an error
+func (f *fill) run(c appengine.Context, fkey, key string, ttl time.Duration, fn FillFunc) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			f.data, f.err = nil, fmt.Errorf("cache fill of %s panicked: %v", key, rec)
+		}
+
+		fillsMutex.Lock()
+		delete(fills, fkey)
+		fillsMutex.Unlock()
+		f.wg.Done()
+	}()
+
+	f.data, f.err = fillKey(c, key, ttl, fn)
+}
+
+// Returns the namespace of the context, empty for the default one
+func namespaceOf(c appengine.Context) string {
+	return datastore.NewKey(c, "CacheFill", "key", 0, nil).Namespace()
+}
+
+// Fills the key holding the lock, or waits for the instance that holds
+// it. If the wait times out the value is computed anyway.
+func fillKey(c appengine.Context, key string, ttl time.Duration, fn FillFunc) ([]byte, error) {
+	st := store.New(c)
+	key = Prefix(c) + key
+	lock := "cache-lock:" + key
+	err := st.Add(lock, []byte{1}, LockTimeout)
+	if err == nil {
+		defer st.Delete(lock)
+	} else if err == store.ErrNotStored {
+		deadline := time.Now().Add(LockTimeout)
+		for time.Now().Before(deadline) {
+			time.Sleep(PollInterval)
+			item, err := st.Get(key)
+			if err == nil {
+				return item.Value, nil
+			} else if err != store.ErrNotFound {
+				return nil, fmt.Errorf("get cache item failed: %s", err)
+			}
+		}
+		c.Warningf("[cache] timeout waiting the fill of %s", key)
+	} else {
+		c.Warningf("[cache] cannot lock the fill of %s: %s", key, err)
+	}
+
+	value, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	data, err := encode(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.Set(key, data, ttl); err != nil {
+		c.Warningf("[cache] cannot set %s: %s", key, err)
+	}
+	return data, nil
+}
+
+func encode(value interface{}) ([]byte, error) {
+	data, err := DefaultCodec.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("encode cache value failed: %s", err)
+	}
+	return append([]byte{DefaultCodec.ID()}, data...), nil
+}
+
+func decode(data []byte, dest interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty cache value")
+	}
+	codec, ok := codecs[data[0]]
+	if !ok {
+		return fmt.Errorf("unknown cache codec: %c", data[0])
+	}
+	if err := codec.Unmarshal(data[1:], dest); err != nil {
+		return fmt.Errorf("decode cache value failed: %s", err)
+	}
+	return nil
+}
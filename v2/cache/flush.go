@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+// Time each instance keeps the generation of the cache before loading
+// it again; Flush takes effect in the other instances after it.
+var GenerationTTL = time.Duration(10) * time.Second
+
+type generation struct {
+	value  uint64
+	loaded time.Time
+}
+
+var (
+	generationsMutex sync.Mutex
+	generations      = map[string]*generation{}
+)
+
+// Discards all the cached values, including the entities cached by the
+// db package. The rest of the data of the store, like the sessions or
+// the rate limit counters, is kept.
+func Flush(c appengine.Context) error {
+	value, err := generationValue(c, 1)
+	if err != nil {
+		return err
+	}
+	setGeneration(c, value)
+	return nil
+}
+
+// Returns the prefix of the keys of the current generation of the cache.
+// Get, Set, Delete & Once add it to the keys.
+func Prefix(c appengine.Context) string {
+	ns := namespaceOf(c)
+	generationsMutex.Lock()
+	g := generations[ns]
+	generationsMutex.Unlock()
+
+	if g == nil || time.Since(g.loaded) > GenerationTTL {
+		value, err := generationValue(c, 0)
+		if err != nil {
+			// Without the generation the keys are simply not shared
+			c.Warningf("[cache] %s", err)
+			value = uint64(time.Now().UnixNano())
+		} else {
+			setGeneration(c, value)
+		}
+		return fmt.Sprintf("g%d:", value)
+	}
+	return fmt.Sprintf("g%d:", g.value)
+}
+
+func generationValue(c appengine.Context, delta int64) (uint64, error) {
+	// Start from the current time, so an evicted generation doesn't
+	// return to the keys of a previous one
+	initial := uint64(time.Now().UnixNano())
+	value, err := store.New(c).Increment("cache-generation", delta, initial)
+	if err != nil {
+		return 0, fmt.Errorf("get cache generation failed: %s", err)
+	}
+	return value, nil
+}
+
+func setGeneration(c appengine.Context, value uint64) {
+	generationsMutex.Lock()
+	defer generationsMutex.Unlock()
+	generations[namespaceOf(c)] = &generation{value: value, loaded: time.Now()}
+}
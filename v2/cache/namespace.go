@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+// Returns the key inside the namespace. The keys include the version
+// of the namespace, so Invalidate discards all of them at once.
+// Example: cache.Set(c, cache.Key(c, "user:"+id, "profile"), profile, time.Hour)
+func Key(c appengine.Context, namespace, key string) string {
+	version, err := namespaceVersion(c, namespace, 0)
+	if err != nil {
+		// Without the version the keys are simply not shared
+		c.Warningf("[cache] %s", err)
+		version = uint64(time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s:%d:%s", namespace, version, key)
+}
+
+// Discards all the keys of the namespace
+func Invalidate(c appengine.Context, namespace string) error {
+	_, err := namespaceVersion(c, namespace, 1)
+	return err
+}
+
+func namespaceVersion(c appengine.Context, namespace string, delta int64) (uint64, error) {
+	// Start from the current time, so an evicted version doesn't return
+	// to the keys of a previous one
+	initial := uint64(time.Now().UnixNano())
+	version, err := store.New(c).Increment("cache-ns:"+namespace, delta, initial)
+	if err != nil {
+		return 0, fmt.Errorf("get cache namespace version failed: %s", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,318 @@
+package db
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+
+	"github.com/ernestokarim/gaelib/v2/cache"
+)
+
+// Time the entities are kept in memcache
+var CacheExpiration = time.Hour
+
+// Stores the entity with the ID, or a new allocated one if it's zero,
+// updating the cached copy and enqueueing its fan-out rules. It returns
+// the key of the entity, even if the fan-out fails. Inside RunInTransaction
+// the cached copy is removed after the commit instead, so a rolled back
+// entity is never cached.
+func Put(c appengine.Context, kind string, id int64, entity interface{}) (*datastore.Key, error) {
+	key := datastore.NewKey(c, kind, "", id, nil)
+	key, err := datastore.Put(c, key, entity)
+	if err != nil {
+		return nil, fmt.Errorf("put entity failed: %s", err)
+	}
+
+	if !invalidateAfterCommit(c, []*datastore.Key{key}) {
+		setCache(c, key, entity)
+	}
+
+	if err := fanOut(c, kind, []*datastore.Key{key}); err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+// Loads the entity with the ID into dst, trying memcache first.
+// It returns datastore.ErrNoSuchEntity if it doesn't exist. Stored
+// properties without a field in dst are ignored (see Tolerant).
+// Inside RunInTransaction the cache is skipped, so the entity is part
+// of the transaction and the concurrent changes are detected.
+func GetByID(c appengine.Context, kind string, id int64, dst interface{}) error {
+	key := datastore.NewKey(c, kind, "", id, nil)
+	tx := inTransaction(c)
+	if !tx {
+		if _, err := memcache.Gob.Get(c, cacheKey(c, key), dst); err == nil {
+			return nil
+		} else if err != memcache.ErrCacheMiss {
+			c.Warningf("[db] get cache failed: %s", err)
+		}
+	}
+
+	if err := datastore.Get(c, key, tolerant(c, kind, dst)); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return err
+		}
+		return fmt.Errorf("get entity failed: %s", err)
+	}
+
+	if !tx {
+		setCache(c, key, dst)
+	}
+
+	return nil
+}
+
+// Removes the entity with the ID and its cached copy
+func DeleteByID(c appengine.Context, kind string, id int64) error {
+	key := datastore.NewKey(c, kind, "", id, nil)
+	if err := memcache.Delete(c, cacheKey(c, key)); err != nil && err != memcache.ErrCacheMiss {
+		c.Warningf("[db] delete cache failed: %s", err)
+	}
+	if err := datastore.Delete(c, key); err != nil {
+		return fmt.Errorf("delete entity failed: %s", err)
+	}
+	invalidateAfterCommit(c, []*datastore.Key{key})
+
+	return nil
+}
+
+// Stores a slice of entities in a batch. It should have the same length
+// as the IDs one (use zeros to allocate new IDs). It returns the keys.
+func PutMulti(c appengine.Context, kind string, ids []int64, entities interface{}) ([]*datastore.Key, error) {
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = datastore.NewKey(c, kind, "", id, nil)
+	}
+
+	keys, err := datastore.PutMulti(c, keys, entities)
+	if err != nil {
+		return nil, fmt.Errorf("put entities failed: %s", err)
+	}
+
+	// The new contents are loaded again from the datastore the next time
+	if !invalidateAfterCommit(c, keys) {
+		deleteCacheMulti(c, keys)
+	}
+
+	if err := fanOut(c, kind, keys); err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+// Loads a slice of entities in a batch, trying memcache first. dst
+// should be a slice with the same length as the IDs one. Missing entities
+// return a appengine.MultiError with datastore.ErrNoSuchEntity in their
+// positions. Inside RunInTransaction the cache is skipped like in GetByID.
+func GetMulti(c appengine.Context, kind string, ids []int64, dst interface{}) error {
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = datastore.NewKey(c, kind, "", id, nil)
+	}
+
+	if inTransaction(c) {
+		if err := datastore.GetMulti(c, keys, tolerantMulti(c, kind, dst)); err != nil {
+			if _, ok := err.(appengine.MultiError); ok {
+				return err
+			}
+			return fmt.Errorf("get entities failed: %s", err)
+		}
+		return nil
+	}
+
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Slice || v.Len() != len(keys) {
+		return fmt.Errorf("get entities failed: dst should be a slice of len %d", len(keys))
+	}
+
+	// Positions not found in the cache
+	missing := getCacheMulti(c, keys, v)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	missingKeys := make([]*datastore.Key, len(missing))
+	missingDst := reflect.MakeSlice(v.Type(), len(missing), len(missing))
+	for i, pos := range missing {
+		missingKeys[i] = keys[pos]
+		elemPointer(v.Index(pos))
+		missingDst.Index(i).Set(v.Index(pos))
+	}
+
+	err := datastore.GetMulti(c, missingKeys, tolerantMulti(c, kind, missingDst.Interface()))
+	merr, isMulti := err.(appengine.MultiError)
+	if err != nil && !isMulti {
+		return fmt.Errorf("get entities failed: %s", err)
+	}
+
+	items := []*memcache.Item{}
+	var errs appengine.MultiError
+	for i, pos := range missing {
+		v.Index(pos).Set(missingDst.Index(i))
+		if merr != nil && merr[i] != nil {
+			if errs == nil {
+				errs = make(appengine.MultiError, len(keys))
+			}
+			errs[pos] = merr[i]
+			continue
+		}
+		items = append(items, &memcache.Item{
+			Key:        cacheKey(c, keys[pos]),
+			Object:     elemPointer(v.Index(pos)),
+			Expiration: CacheExpiration,
+		})
+	}
+
+	if len(items) > 0 {
+		if err := memcache.Gob.SetMulti(c, items); err != nil {
+			c.Warningf("[db] set cache failed: %s", err)
+		}
+	}
+
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// Removes a batch of entities and their cached copies
+func DeleteMulti(c appengine.Context, kind string, ids []int64) error {
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = datastore.NewKey(c, kind, "", id, nil)
+	}
+
+	deleteCacheMulti(c, keys)
+	if err := datastore.DeleteMulti(c, keys); err != nil {
+		return fmt.Errorf("delete entities failed: %s", err)
+	}
+	invalidateAfterCommit(c, keys)
+
+	return nil
+}
+
+// Runs the query calling fn with each result, starting at the encoded
+// cursor (empty to start at the beginning). newEntity returns the
+// destination of each result (nil for keys only queries). It returns the
+// cursor after the last result, to continue the iteration in another
+// request; fn can return datastore.Done to stop it early.
+// Example:
+//    next, err := db.Iterate(c, q, cursor, func() interface{} {
+//      return new(Item)
+//    }, func(key *datastore.Key, entity interface{}) error {
+//      item := entity.(*Item)
+//      ....
+//    })
+func Iterate(c appengine.Context, q *datastore.Query, cursor string,
+	newEntity func() interface{},
+	fn func(key *datastore.Key, entity interface{}) error) (string, error) {
+	if cursor != "" {
+		cur, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return "", fmt.Errorf("decode cursor failed: %s", err)
+		}
+		q = q.Start(cur)
+	}
+
+	it := q.Run(c)
+	for {
+		entity := newEntity()
+		key, err := it.Next(entity)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("query next failed: %s", err)
+		}
+
+		if err := fn(key, entity); err != nil {
+			if err == datastore.Done {
+				break
+			}
+			return "", err
+		}
+	}
+
+	cur, err := it.Cursor()
+	if err != nil {
+		return "", fmt.Errorf("get cursor failed: %s", err)
+	}
+	return cur.String(), nil
+}
+
+// Returns the key of the cached copy, in the generation of the cache
+// package so cache.Flush discards it too
+func cacheKey(c appengine.Context, key *datastore.Key) string {
+	return fmt.Sprintf("%sdb:%s:%d", cache.Prefix(c), key.Kind(), key.IntID())
+}
+
+func setCache(c appengine.Context, key *datastore.Key, entity interface{}) {
+	item := &memcache.Item{
+		Key:        cacheKey(c, key),
+		Object:     entity,
+		Expiration: CacheExpiration,
+	}
+	if err := memcache.Gob.Set(c, item); err != nil {
+		c.Warningf("[db] set cache failed: %s", err)
+	}
+}
+
+// Loads the cached copies of the keys into the elements of the slice,
+// returning the positions not found
+func getCacheMulti(c appengine.Context, keys []*datastore.Key, v reflect.Value) []int {
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = cacheKey(c, key)
+	}
+	items, err := memcache.GetMulti(c, cacheKeys)
+	if err != nil {
+		c.Warningf("[db] get cache failed: %s", err)
+		items = map[string]*memcache.Item{}
+	}
+
+	missing := []int{}
+	for i, key := range cacheKeys {
+		item, ok := items[key]
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		dec := gob.NewDecoder(bytes.NewReader(item.Value))
+		if err := dec.Decode(elemPointer(v.Index(i))); err != nil {
+			c.Warningf("[db] decode cache failed: %s", err)
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Returns a pointer to the element of a slice of structs or pointers
+func elemPointer(elem reflect.Value) interface{} {
+	if elem.Kind() == reflect.Ptr {
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		return elem.Interface()
+	}
+	return elem.Addr().Interface()
+}
+
+func deleteCacheMulti(c appengine.Context, keys []*datastore.Key) {
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = cacheKey(c, key)
+	}
+	if err := memcache.DeleteMulti(c, cacheKeys); err != nil {
+		if _, ok := err.(appengine.MultiError); !ok {
+			c.Warningf("[db] delete cache failed: %s", err)
+		}
+	}
+}
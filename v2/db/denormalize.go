@@ -0,0 +1,197 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/tasks"
+)
+
+// Number of related entities updated by each task, of one or several
+// of the changed IDs
+var FanOutBatch = 100
+
+// Rule that copies fields of the entities of a kind to the related
+// entities of another kind when they're stored with Put or PutMulti.
+type FanOut struct {
+	// Kind of the related entities and their property with the ID of
+	// the changed entity
+	Target, Property string
+
+	// func(src *Source, dst *Target) bool that copies the fields to dst,
+	// returning true if something changed
+	Update interface{}
+
+	update            reflect.Value
+	source, targetTyp reflect.Type
+}
+
+type fanOutTask struct {
+	Source string
+	Rule   int
+	IDs    []int64
+	Cursor string
+}
+
+var fanOuts = map[string][]*FanOut{}
+
+func init() {
+	tasks.Handle("db-fanout", runFanOut)
+}
+
+// Registers a fan-out rule of the source kind. The updates run in the
+// db-fanout tasks, retried if they fail, with a transaction for each
+// related entity; the updated entities run their own rules too, avoid
+// cycles between them. Call it at init().
+// Example:
+//    db.RegisterFanOut("User", &db.FanOut{
+//      Target:   "Post",
+//      Property: "AuthorID",
+//      Update: func(u *User, p *Post) bool {
+//        changed := p.AuthorName != u.Name
+//        p.AuthorName = u.Name
+//        return changed
+//      },
+//    })
+func RegisterFanOut(source string, rule *FanOut) {
+	f := reflect.ValueOf(rule.Update)
+	ft := f.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() != 2 || ft.NumOut() != 1 ||
+		ft.In(0).Kind() != reflect.Ptr || ft.In(1).Kind() != reflect.Ptr ||
+		ft.Out(0).Kind() != reflect.Bool {
+		panic("fan-out update should be a func(*Source, *Target) bool: " + source)
+	}
+
+	rule.update, rule.source, rule.targetTyp = f, ft.In(0).Elem(), ft.In(1).Elem()
+	fanOuts[source] = append(fanOuts[source], rule)
+}
+
+// Enqueues the fan-out tasks of the stored entities
+func fanOut(c appengine.Context, kind string, keys []*datastore.Key) error {
+	ids := make([]int64, len(keys))
+	for i, key := range keys {
+		ids[i] = key.IntID()
+	}
+
+	for i := range fanOuts[kind] {
+		if err := enqueueFanOut(c, &fanOutTask{Source: kind, Rule: i, IDs: ids}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Updates a batch of the entities related to the IDs of the task,
+// enqueueing the rest of them
+func runFanOut(r *app.Request, t *fanOutTask) error {
+	c := r.C
+	if t.Rule >= len(fanOuts[t.Source]) || len(t.IDs) == 0 {
+		return tasks.Permanent(fmt.Errorf("fan-out rule not registered: %s %d", t.Source, t.Rule))
+	}
+	rule := fanOuts[t.Source][t.Rule]
+
+	budget := FanOutBatch
+	cursor := t.Cursor
+	for i, id := range t.IDs {
+		read, end, err := fanOutID(c, t.Source, rule, id, cursor, budget)
+		if err != nil {
+			return err
+		}
+
+		// Continue with the next page of this ID or the next IDs
+		if end != "" {
+			return enqueueFanOut(c, &fanOutTask{Source: t.Source, Rule: t.Rule, IDs: t.IDs[i:], Cursor: end})
+		}
+		budget -= read
+		if budget <= 0 && i+1 < len(t.IDs) {
+			return enqueueFanOut(c, &fanOutTask{Source: t.Source, Rule: t.Rule, IDs: t.IDs[i+1:]})
+		}
+		cursor = ""
+	}
+	return nil
+}
+
+// Updates the entities related to the ID, up to limit of them from the
+// cursor. It returns the number of them read and the cursor to continue
+// if the limit was reached.
+func fanOutID(c appengine.Context, source string, rule *FanOut, id int64, cursor string,
+	limit int) (int, string, error) {
+	src := reflect.New(rule.source)
+	if err := GetByID(c, source, id, src.Interface()); err != nil {
+		if err != datastore.ErrNoSuchEntity {
+			return 0, "", err
+		}
+		return 0, "", nil
+	}
+
+	q := datastore.NewQuery(rule.Target).Filter(rule.Property+" =", id).KeysOnly().Limit(limit)
+	if cursor != "" {
+		start, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return 0, "", tasks.Permanent(fmt.Errorf("decode fan-out cursor failed: %s", err))
+		}
+		q = q.Start(start)
+	}
+
+	read := 0
+	it := q.Run(c)
+	for {
+		key, err := it.Next(nil)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return 0, "", fmt.Errorf("query fan-out targets failed: %s", err)
+		}
+		read++
+
+		if err := updateTarget(c, rule, src, key); err != nil {
+			return 0, "", err
+		}
+	}
+	if read < limit {
+		return read, "", nil
+	}
+
+	end, err := it.Cursor()
+	if err != nil {
+		return 0, "", fmt.Errorf("get fan-out cursor failed: %s", err)
+	}
+	return read, end.String(), nil
+}
+
+// Copies the fields to the target in its own transaction, so the
+// concurrent changes of the rest of them are not overwritten. Its fan-out
+// is enqueued in the same transaction: a retry finds the target already
+// updated, but its fan-out was not lost.
+func updateTarget(c appengine.Context, rule *FanOut, src reflect.Value, key *datastore.Key) error {
+	return RunInTransaction(c, nil, func(c appengine.Context) error {
+		dst := reflect.New(rule.targetTyp)
+		if err := datastore.Get(c, key, tolerant(c, rule.Target, dst.Interface())); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return nil
+			}
+			return fmt.Errorf("get fan-out target failed: %s", err)
+		}
+
+		if !rule.update.Call([]reflect.Value{src, dst})[0].Bool() {
+			return nil
+		}
+		if _, err := datastore.Put(c, key, dst.Interface()); err != nil {
+			return fmt.Errorf("put fan-out target failed: %s", err)
+		}
+		invalidateAfterCommit(c, []*datastore.Key{key})
+
+		return fanOut(c, rule.Target, []*datastore.Key{key})
+	})
+}
+
+func enqueueFanOut(c appengine.Context, t *fanOutTask) error {
+	if err := tasks.Enqueue(c, "db-fanout", t); err != nil {
+		return fmt.Errorf("enqueue fan-out failed: %s", err)
+	}
+	return nil
+}
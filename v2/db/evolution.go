@@ -0,0 +1,249 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// Kinds of schema issues found loading an entity
+const (
+	// Stored property without a field in the struct (removed or renamed)
+	IssueUnknown = "unknown"
+
+	// Field of the struct without a stored property (added later)
+	IssueMissing = "missing"
+
+	// Stored property with a type that can't be assigned to the field
+	IssueMismatch = "mismatch"
+)
+
+// Difference between the stored properties of an entity and its struct
+type Issue struct {
+	Kind   string
+	Field  string
+	Type   string
+	Reason string
+}
+
+func (i *Issue) String() string {
+	if i.Reason != "" {
+		return fmt.Sprintf("%s %s.%s: %s", i.Type, i.Kind, i.Field, i.Reason)
+	}
+	return fmt.Sprintf("%s %s.%s", i.Type, i.Kind, i.Field)
+}
+
+var (
+	issuesMutex = &sync.Mutex{}
+	issues      = map[string]map[string]*Issue{}
+)
+
+// Wrapper of an entity that ignores the stored properties that don't
+// exist anymore in the struct instead of failing with a
+// datastore.ErrFieldMismatch. The differences are recorded in Issues
+// and in the registry of the kind (see SchemaIssues), logging them the
+// first time they're found. Saving it drops the unknown properties.
+// Example:
+//    item := new(Item)
+//    err := datastore.Get(c, key, db.Tolerant(c, "Item", item))
+type TolerantEntity struct {
+	Issues []*Issue
+
+	c      appengine.Context
+	kind   string
+	entity interface{}
+}
+
+func Tolerant(c appengine.Context, kind string, entity interface{}) *TolerantEntity {
+	return &TolerantEntity{c: c, kind: kind, entity: entity}
+}
+
+func (e *TolerantEntity) Load(props <-chan datastore.Property) error {
+	fields := structFields(reflect.TypeOf(e.entity))
+
+	// Filter the unknown properties before loading the rest
+	known := make(chan datastore.Property, 32)
+	seen := map[string]bool{}
+	unknown := map[string]bool{}
+	go func() {
+		defer close(known)
+		for p := range props {
+			if _, ok := fields[p.Name]; !ok {
+				unknown[p.Name] = true
+				continue
+			}
+			seen[p.Name] = true
+			known <- p
+		}
+	}()
+	err := datastore.LoadStruct(e.entity, known)
+
+	// Drain the channel if LoadStruct stopped early
+	for _ = range known {
+	}
+
+	e.Issues = nil
+	if err != nil {
+		fmerr, ok := err.(*datastore.ErrFieldMismatch)
+		if !ok {
+			return err
+		}
+		e.addIssue(fmerr.FieldName, IssueMismatch, fmerr.Reason)
+	}
+	for name := range unknown {
+		e.addIssue(name, IssueUnknown, "")
+	}
+	for name, multiple := range fields {
+		// Empty slices are not stored, they can't be detected
+		if !seen[name] && !multiple {
+			e.addIssue(name, IssueMissing, "")
+		}
+	}
+
+	return nil
+}
+
+func (e *TolerantEntity) Save(props chan<- datastore.Property) error {
+	return datastore.SaveStruct(e.entity, props)
+}
+
+func (e *TolerantEntity) addIssue(field, kind, reason string) {
+	issue := &Issue{Kind: e.kind, Field: field, Type: kind, Reason: reason}
+	e.Issues = append(e.Issues, issue)
+
+	issuesMutex.Lock()
+	defer issuesMutex.Unlock()
+
+	if issues[e.kind] == nil {
+		issues[e.kind] = map[string]*Issue{}
+	}
+	id := kind + ":" + field
+	if _, ok := issues[e.kind][id]; !ok {
+		issues[e.kind][id] = issue
+		if e.c != nil {
+			e.c.Warningf("[db] schema issue: %s", issue)
+		}
+	}
+}
+
+// Wraps dst with Tolerant, unless it's not a struct pointer or
+// it already loads itself
+func tolerant(c appengine.Context, kind string, dst interface{}) interface{} {
+	if _, ok := dst.(datastore.PropertyLoadSaver); ok {
+		return dst
+	}
+	t := reflect.TypeOf(dst)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return dst
+	}
+	return Tolerant(c, kind, dst)
+}
+
+// Wraps each element of the dst slice with Tolerant
+func tolerantMulti(c appengine.Context, kind string, dst interface{}) interface{} {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Slice {
+		return dst
+	}
+
+	wrapped := make([]interface{}, v.Len())
+	for i := range wrapped {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Struct {
+			elem = elem.Addr()
+		}
+		if elem.Kind() != reflect.Ptr || elem.IsNil() {
+			return dst
+		}
+		wrapped[i] = tolerant(c, kind, elem.Interface())
+	}
+	return wrapped
+}
+
+// Returns the schema issues found in this instance for the kind, so
+// a migration can fix the stored entities.
+func SchemaIssues(kind string) []*Issue {
+	issuesMutex.Lock()
+	defer issuesMutex.Unlock()
+
+	list := []*Issue{}
+	for _, issue := range issues[kind] {
+		list = append(list, issue)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].String() < list[j].String() })
+	return list
+}
+
+// Forgets the issues found for the kind, after migrating it
+func ResetSchemaIssues(kind string) {
+	issuesMutex.Lock()
+	defer issuesMutex.Unlock()
+
+	delete(issues, kind)
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	geoPointType = reflect.TypeOf(appengine.GeoPoint{})
+	keyType      = reflect.TypeOf(&datastore.Key{})
+)
+
+var (
+	fieldsMutex = &sync.RWMutex{}
+	fieldsCache = map[reflect.Type]map[string]bool{}
+)
+
+// Returns the property names of a struct pointer, and if each one of
+// them can have multiple values (slices)
+func structFields(t reflect.Type) map[string]bool {
+	fieldsMutex.RLock()
+	fields, ok := fieldsCache[t]
+	fieldsMutex.RUnlock()
+	if ok {
+		return fields
+	}
+
+	fields = map[string]bool{}
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+		addStructFields(t.Elem(), "", false, fields)
+	}
+
+	fieldsMutex.Lock()
+	fieldsCache[t] = fields
+	fieldsMutex.Unlock()
+
+	return fields
+}
+
+func addStructFields(t reflect.Type, prefix string, multiple bool, fields map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("datastore"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = f.Name
+		}
+
+		ft, slice := f.Type, false
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+			ft, slice = ft.Elem(), true
+		}
+
+		if ft.Kind() == reflect.Struct && ft != timeType && ft != geoPointType && ft != keyType {
+			addStructFields(ft, prefix+name+".", multiple || slice, fields)
+			continue
+		}
+		fields[prefix+name] = multiple || slice
+	}
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+// Properties of the publishing window of the scheduled entities. A zero
+// UnpublishAt never expires.
+const (
+	PublishAtProperty   = "PublishAt"
+	UnpublishAtProperty = "UnpublishAt"
+)
+
+// Outputs cleared when the entities of a kind cross their window
+type scheduledKind struct {
+	paths    []string
+	onChange func(c appengine.Context) error
+}
+
+var scheduledKinds = map[string]*scheduledKind{}
+
+const publishCronKey = "db-publish-cron"
+
+// Registers a kind with the PublishAt & UnpublishAt properties, so
+// PublishCronHandler clears its cached pages, fragments (see
+// app.FragmentDependsOn) and paths when an entity is published or
+// unpublished. Call it at init().
+// Example: db.RegisterScheduled("News", "/", "/news")
+func RegisterScheduled(kind string, paths ...string) {
+	scheduledKinds[kind] = &scheduledKind{paths: paths}
+}
+
+// Runs fn too when the entities of the scheduled kind cross their
+// window, for caches not managed by the library. Call it at init().
+func OnScheduleChange(kind string, fn func(c appengine.Context) error) {
+	s, ok := scheduledKinds[kind]
+	if !ok {
+		panic("kind not scheduled: " + kind)
+	}
+	s.onChange = fn
+}
+
+// Returns true if the window includes now
+func IsPublished(publishAt, unpublishAt, now time.Time) bool {
+	return !publishAt.After(now) && (unpublishAt.IsZero() || unpublishAt.After(now))
+}
+
+// Filters the query to the entities published before now. The datastore
+// only allows one inequality, so the unpublished ones are removed by
+// GetAllPublished; sort the query by PublishAt first if needed.
+func Published(q *datastore.Query, now time.Time) *datastore.Query {
+	return q.Filter(PublishAtProperty+" <=", now)
+}
+
+// Runs the query into the dst slice pointer like GetAll, returning up to
+// limit entities (0 for all of them) whose window includes now. Don't set
+// the limit in the query: the unpublished entities are skipped while it
+// runs, so the pages are not short.
+// Example:
+//    q := datastore.NewQuery("News").Order("-PublishAt")
+//    keys, err := db.GetAllPublished(c, q, &news, time.Now(), 20)
+func GetAllPublished(c appengine.Context, q *datastore.Query, dst interface{}, now time.Time,
+	limit int) ([]*datastore.Key, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("published destination should be a slice pointer: %T", dst)
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	published := []*datastore.Key{}
+	it := Published(q, now).Run(c)
+	for limit == 0 || len(published) < limit {
+		entity := reflect.New(elemType)
+		key, err := it.Next(entity.Interface())
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("query published entities failed: %s", err)
+		}
+
+		if elemType.Kind() == reflect.Struct {
+			if field := entity.Elem().FieldByName(UnpublishAtProperty); field.IsValid() {
+				unpublishAt, ok := field.Interface().(time.Time)
+				if ok && !unpublishAt.IsZero() && !unpublishAt.After(now) {
+					continue
+				}
+			}
+		}
+		if !isPtr {
+			entity = entity.Elem()
+		}
+		slice.Set(reflect.Append(slice, entity))
+		published = append(published, key)
+	}
+
+	return published, nil
+}
+
+// Clears the outputs of the scheduled kinds with entities published or
+// unpublished since the last run. Schedule it in cron.yaml every few
+// minutes to enable it.
+// Example: app.Cron("/tasks/publish", db.PublishCronHandler)
+func PublishCronHandler(r *app.Request) error {
+	c := r.C
+	st := store.Datastore(c)
+	now := time.Now()
+
+	last := now.Add(-time.Hour)
+	if item, err := st.Get(publishCronKey); err == nil {
+		nanos, err := strconv.ParseInt(string(item.Value), 10, 64)
+		if err != nil {
+			c.Warningf("[db] invalid last publish cron run: %s", err)
+		} else {
+			last = time.Unix(0, nanos)
+		}
+	} else if err != store.ErrNotFound {
+		return fmt.Errorf("get last publish cron run failed: %s", err)
+	}
+
+	for kind, s := range scheduledKinds {
+		crossed, err := crossedWindow(c, kind, last, now)
+		if err != nil {
+			return err
+		}
+		if !crossed {
+			continue
+		}
+		c.Infof("[db] scheduled %s entities changed, clearing outputs", kind)
+
+		if err := app.InvalidateKind(c, kind); err != nil {
+			return fmt.Errorf("invalidate kind %s failed: %s", kind, err)
+		}
+		for _, path := range s.paths {
+			if err := app.PurgeCached(c, path); err != nil {
+				return fmt.Errorf("purge cached %s failed: %s", path, err)
+			}
+		}
+		if s.onChange != nil {
+			if err := s.onChange(c); err != nil {
+				return fmt.Errorf("schedule change of %s failed: %s", kind, err)
+			}
+		}
+	}
+
+	value := []byte(strconv.FormatInt(now.UnixNano(), 10))
+	if err := st.Set(publishCronKey, value, 0); err != nil {
+		return fmt.Errorf("save last publish cron run failed: %s", err)
+	}
+
+	return nil
+}
+
+// Returns true if an entity of the kind was published or unpublished
+// in the interval (from, to]
+func crossedWindow(c appengine.Context, kind string, from, to time.Time) (bool, error) {
+	for _, prop := range []string{PublishAtProperty, UnpublishAtProperty} {
+		q := datastore.NewQuery(kind).
+			Filter(prop+" >", from).
+			Filter(prop+" <=", to).
+			KeysOnly().
+			Limit(1)
+		keys, err := q.GetAll(c, nil)
+		if err != nil {
+			return false, fmt.Errorf("query %s window failed: %s", kind, err)
+		}
+		if len(keys) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+)
+
+// Time the recent writes of a user are merged into the queries; it should
+// be longer than the usual lag of the indexes
+var RecentWindow = 30 * time.Second
+
+// Maximum number of recent writes remembered for each user & kind
+var RecentLimit = 20
+
+type recentWrite struct {
+	ID      int64
+	Deleted bool
+	Time    time.Time
+}
+
+// Stores the entity like Put, remembering it so the next list queries of
+// the user see it even if the indexes are not updated yet (see
+// MergeRecent). user can be any string that identifies the user.
+// Example:
+//    key, err := db.PutRecent(c, email, "Post", 0, post)
+func PutRecent(c appengine.Context, user, kind string, id int64, entity interface{}) (*datastore.Key, error) {
+	key, err := Put(c, kind, id, entity)
+	if err != nil {
+		return key, err
+	}
+	Remember(c, user, kind, key.IntID(), false)
+	return key, nil
+}
+
+// Removes the entity like DeleteByID, remembering it so the next list
+// queries of the user don't return it anymore (see MergeRecent).
+func DeleteRecent(c appengine.Context, user, kind string, id int64) error {
+	if err := DeleteByID(c, kind, id); err != nil {
+		return err
+	}
+	Remember(c, user, kind, id, true)
+	return nil
+}
+
+// Records a write of the user during RecentWindow. Use it directly
+// after transactions or batches; the errors are only logged, the write
+// itself is already done.
+func Remember(c appengine.Context, user, kind string, id int64, deleted bool) {
+	key := recentKey(user, kind)
+	w := recentWrite{ID: id, Deleted: deleted, Time: time.Now()}
+
+	for i := 0; i < 3; i++ {
+		writes := []recentWrite{}
+		item, err := memcache.Gob.Get(c, key, &writes)
+		if err == memcache.ErrCacheMiss {
+			item = &memcache.Item{Key: key, Object: []recentWrite{w}, Expiration: RecentWindow}
+			err = memcache.Gob.Add(c, item)
+			if err == memcache.ErrNotStored {
+				continue
+			}
+		} else if err == nil {
+			item.Object = addRecentWrite(writes, w)
+			item.Expiration = RecentWindow
+			err = memcache.Gob.CompareAndSwap(c, item)
+			if err == memcache.ErrCASConflict || err == memcache.ErrNotStored {
+				continue
+			}
+		}
+		if err != nil {
+			c.Warningf("[db] remember recent write failed: %s", err)
+		}
+		return
+	}
+	c.Warningf("[db] remember recent write failed: too many conflicts: %s %d", kind, id)
+}
+
+// Page of a list query where MergeRecent adds the recent writes
+type MergeOptions struct {
+	// Checks that the new entities belong to the list, nil to add all
+	// of them
+	Match func(entity interface{}) bool
+
+	// Order of the query: true if a goes before b. Without it the new
+	// entities are added at the beginning of the first page, like in
+	// the lists of the newest entities first.
+	Less func(a, b interface{}) bool
+
+	// Limit of the query, 0 if it has none. The entities that go after
+	// the last result of a full page belong to the next pages.
+	Limit int
+
+	// True if the query started at a cursor; the entities that go
+	// before its first result belong to the previous pages
+	Cursor bool
+}
+
+// Result of the query or recent entity, with its value in the slice
+// and the pointer to the entity passed to the options functions
+type mergedResult struct {
+	key    *datastore.Key
+	value  reflect.Value
+	entity interface{}
+}
+
+// Merges the recent writes of the user into the page of a list query of
+// the kind: the stored entities missing in it are inserted in the order
+// of the query and the deleted ones are removed. dst is the pointer to
+// the slice of structs or struct pointers filled by the query. It returns
+// the updated keys.
+// The page keeps the limit; the results pushed out of it by the new
+// entities are missing in the list until RecentWindow ends.
+// Example:
+//    keys, err := q.GetAll(c, &posts)
+//    ....
+//    keys, err = db.MergeRecent(c, email, "Post", keys, &posts, &db.MergeOptions{
+//      Match: func(e interface{}) bool { return e.(*Post).Blog == blog },
+//      Less:  func(a, b interface{}) bool { return a.(*Post).Date.After(b.(*Post).Date) },
+//      Limit: 20,
+//    })
+func MergeRecent(c appengine.Context, user, kind string, keys []*datastore.Key, dst interface{},
+	opts *MergeOptions) ([]*datastore.Key, error) {
+	writes := []recentWrite{}
+	if _, err := memcache.Gob.Get(c, recentKey(user, kind), &writes); err != nil {
+		if err != memcache.ErrCacheMiss {
+			c.Warningf("[db] get recent writes failed: %s", err)
+		}
+		return keys, nil
+	}
+	if opts == nil {
+		opts = new(MergeOptions)
+	}
+
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return nil, fmt.Errorf("merge recent destination should be a slice pointer: %T", dst)
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	// Filter the results deleted recently, indexing the rest of them
+	limit := time.Now().Add(-RecentWindow)
+	deleted := map[int64]bool{}
+	for _, w := range writes {
+		if w.Deleted && w.Time.After(limit) {
+			deleted[w.ID] = true
+		}
+	}
+	found := map[int64]bool{}
+	results := []*mergedResult{}
+	var first, last interface{}
+	for i, key := range keys {
+		value := slice.Index(i)
+		entity := value.Interface()
+		if !isPtr {
+			entity = value.Addr().Interface()
+		}
+		if i == 0 {
+			first = entity
+		}
+		last = entity
+
+		if deleted[key.IntID()] {
+			continue
+		}
+		found[key.IntID()] = true
+		results = append(results, &mergedResult{key, value, entity})
+	}
+	full := opts.Limit > 0 && len(keys) >= opts.Limit
+
+	// Insert the entities stored recently that are not in the results
+	// yet and belong to this page
+	added := 0
+	for _, w := range writes {
+		if w.Deleted || found[w.ID] || w.Time.Before(limit) {
+			continue
+		}
+		found[w.ID] = true
+
+		entity := reflect.New(elemType)
+		if err := GetByID(c, kind, w.ID, entity.Interface()); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				continue
+			}
+			return nil, err
+		}
+		if opts.Match != nil && !opts.Match(entity.Interface()) {
+			continue
+		}
+
+		r := &mergedResult{
+			key:    datastore.NewKey(c, kind, "", w.ID, nil),
+			value:  entity,
+			entity: entity.Interface(),
+		}
+		if !isPtr {
+			r.value = entity.Elem()
+		}
+
+		if opts.Less == nil {
+			if opts.Cursor {
+				continue
+			}
+			results = append(results[:added], append([]*mergedResult{r}, results[added:]...)...)
+			added++
+			continue
+		}
+		if opts.Cursor && first != nil && opts.Less(r.entity, first) {
+			continue
+		}
+		if full && last != nil && !opts.Less(r.entity, last) {
+			continue
+		}
+		pos := len(results)
+		for i, result := range results {
+			if opts.Less(r.entity, result.entity) {
+				pos = i
+				break
+			}
+		}
+		results = append(results[:pos], append([]*mergedResult{r}, results[pos:]...)...)
+	}
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+
+	newKeys := make([]*datastore.Key, len(results))
+	newSlice := reflect.MakeSlice(slice.Type(), 0, len(results))
+	for i, r := range results {
+		newKeys[i] = r.key
+		newSlice = reflect.Append(newSlice, r.value)
+	}
+	slice.Set(newSlice)
+
+	return newKeys, nil
+}
+
+func recentKey(user, kind string) string {
+	return fmt.Sprintf("db-recent:%s:%s", kind, user)
+}
+
+// Adds the write to the list, newest first, dropping the old ones
+func addRecentWrite(writes []recentWrite, w recentWrite) []recentWrite {
+	result := []recentWrite{w}
+	limit := time.Now().Add(-RecentWindow)
+	for _, old := range writes {
+		if old.ID == w.ID || old.Time.Before(limit) {
+			continue
+		}
+		if len(result) >= RecentLimit {
+			break
+		}
+		result = append(result, old)
+	}
+	return result
+}
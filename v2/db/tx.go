@@ -0,0 +1,188 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+const KindIdempotencyMark = "IdempotencyMark"
+
+// Attempts of the transactions before failing with a contention error
+var TransactionAttempts = 5
+
+// Time the applied idempotency keys are kept; the retries of the
+// operations should arrive before it
+var IdempotencyMarkTTL = 30 * 24 * time.Hour
+
+// Marks removed by each call of CleanIdempotencyMarksHandler
+var IdempotencyCleanBatch = 500
+
+var (
+	txMutex sync.Mutex
+
+	// Keys of the cached entities modified in the running transactions,
+	// by their context. Their copies are removed after the commit.
+	txKeys = map[appengine.Context][]*datastore.Key{}
+)
+
+// Applied idempotency key, stored in the same transaction as the changes
+type idempotencyMark struct {
+	Created time.Time
+}
+
+// Runs fn in a transaction, retrying it TransactionAttempts times if
+// another one modifies the same entity groups (opts.Attempts overrides
+// it). If all of them fail by contention it returns an *app.ContentionError,
+// answered with a 503 so the clients and the task queues retry later.
+func RunInTransaction(c appengine.Context, opts *datastore.TransactionOptions,
+	fn func(c appengine.Context) error) error {
+	o := &datastore.TransactionOptions{Attempts: TransactionAttempts}
+	if opts != nil {
+		o.XG = opts.XG
+		if opts.Attempts > 0 {
+			o.Attempts = opts.Attempts
+		}
+	}
+
+	var modified []*datastore.Key
+	err := datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		txMutex.Lock()
+		txKeys[tc] = []*datastore.Key{}
+		txMutex.Unlock()
+
+		defer func() {
+			txMutex.Lock()
+			modified = txKeys[tc]
+			delete(txKeys, tc)
+			txMutex.Unlock()
+		}()
+
+		return fn(tc)
+	}, o)
+	if err == datastore.ErrConcurrentTransaction {
+		c.Warningf("[db] transaction contention after %d attempts", o.Attempts)
+		return &app.ContentionError{Attempts: o.Attempts}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Also removes the copies cached by other requests meanwhile
+	if len(modified) > 0 {
+		deleteCacheMulti(c, modified)
+	}
+	return nil
+}
+
+// Returns true if c is the context of a transaction started by
+// RunInTransaction
+func inTransaction(c appengine.Context) bool {
+	txMutex.Lock()
+	defer txMutex.Unlock()
+
+	_, ok := txKeys[c]
+	return ok
+}
+
+// Queues the removal of the cached copies of the keys until the commit
+// if c is the context of a transaction started by RunInTransaction.
+// It returns false outside them.
+func invalidateAfterCommit(c appengine.Context, keys []*datastore.Key) bool {
+	txMutex.Lock()
+	defer txMutex.Unlock()
+
+	pending, ok := txKeys[c]
+	if !ok {
+		return false
+	}
+	txKeys[c] = append(pending, keys...)
+	return true
+}
+
+// Runs fn in a cross-group transaction, that can use up to 25 entity
+// groups. See RunInTransaction.
+func RunInXGTransaction(c appengine.Context, fn func(c appengine.Context) error) error {
+	return RunInTransaction(c, &datastore.TransactionOptions{XG: true}, fn)
+}
+
+// Runs fn in a cross-group transaction only if the idempotency key
+// was not applied before, storing it with the changes of fn. It returns
+// false if fn was skipped. The entities of fn can use 24 entity groups.
+// The keys are kept IdempotencyMarkTTL if CleanIdempotencyMarksHandler
+// is scheduled.
+// Example:
+//    applied, err := db.RunOnce(c, "charge:"+orderID, func(c appengine.Context) error {
+//      ....
+//    })
+func RunOnce(c appengine.Context, key string, fn func(c appengine.Context) error) (bool, error) {
+	markKey := datastore.NewKey(c, KindIdempotencyMark, key, 0, nil)
+
+	var applied bool
+	err := RunInXGTransaction(c, func(c appengine.Context) error {
+		applied = false
+		if err := datastore.Get(c, markKey, new(idempotencyMark)); err == nil {
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("get idempotency mark failed: %s", err)
+		}
+
+		if err := fn(c); err != nil {
+			return err
+		}
+
+		mark := &idempotencyMark{Created: time.Now()}
+		if _, err := datastore.Put(c, markKey, mark); err != nil {
+			return fmt.Errorf("put idempotency mark failed: %s", err)
+		}
+		applied = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if !applied {
+		c.Infof("[db] idempotency key %s already applied", key)
+	}
+	return applied, nil
+}
+
+// Runs fn with RunOnce using the name of the task as the idempotency key,
+// so the retries of the task don't apply its changes twice. Outside
+// the task queues fn runs in a normal cross-group transaction.
+func RunTaskOnce(r *app.Request, fn func(c appengine.Context) error) error {
+	name := r.Req.Header.Get("X-AppEngine-TaskName")
+	if name == "" {
+		return RunInXGTransaction(r.C, fn)
+	}
+	queue := r.Req.Header.Get("X-AppEngine-QueueName")
+	_, err := RunOnce(r.C, "task:"+queue+":"+name, fn)
+	return err
+}
+
+// Removes the idempotency keys older than IdempotencyMarkTTL. Schedule
+// it in cron.yaml; each run removes IdempotencyCleanBatch of them.
+// Example: app.Cron("/tasks/clean-idempotency", db.CleanIdempotencyMarksHandler)
+func CleanIdempotencyMarksHandler(r *app.Request) error {
+	q := datastore.NewQuery(KindIdempotencyMark).
+		Filter("Created <", time.Now().Add(-IdempotencyMarkTTL)).
+		KeysOnly().
+		Limit(IdempotencyCleanBatch)
+	keys, err := q.GetAll(r.C, nil)
+	if err != nil {
+		return fmt.Errorf("query expired idempotency marks failed: %s", err)
+	}
+	if err := datastore.DeleteMulti(r.C, keys); err != nil {
+		return fmt.Errorf("delete expired idempotency marks failed: %s", err)
+	}
+
+	if len(keys) > 0 {
+		r.C.Infof("[db] removed %d idempotency marks", len(keys))
+	}
+	return nil
+}
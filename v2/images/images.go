@@ -0,0 +1,179 @@
+package images
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/blobstore"
+	"appengine/image"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/google"
+	"github.com/ernestokarim/gaelib/v2/cache"
+	"github.com/gorilla/mux"
+)
+
+// Formats that can be negotiated, in order of preference
+var negotiable = []string{"avif", "webp"}
+
+// Time the existence of the variants of a file is cached
+var VariantsCacheTTL = time.Hour
+
+const storageReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// Returns the preferred image format the client accepts over the
+// traditional JPEG/PNG ones: "avif", "webp" or an empty string.
+func PreferredFormat(req *http.Request) string {
+	accept := req.Header.Get("Accept")
+	for _, format := range negotiable {
+		if strings.Contains(accept, "image/"+format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// Returns a serving URL of the images service for the blob, resized to
+// size pixels (0 for the original size). If the client supports it the
+// URL returns the WebP version of the image.
+func ServingURL(r *app.Request, key appengine.BlobKey, size int) (string, error) {
+	return CroppedServingURL(r, key, size, false)
+}
+
+// Like ServingURL, but the image is cropped to a square of size
+// pixels if crop is true.
+func CroppedServingURL(r *app.Request, key appengine.BlobKey, size int, crop bool) (string, error) {
+	u, err := image.ServingURL(r.C, key, &image.ServingURLOptions{Secure: true})
+	if err != nil {
+		return "", fmt.Errorf("get serving url failed: %s", err)
+	}
+
+	opts := []string{}
+	if size > 0 {
+		opts = append(opts, fmt.Sprintf("s%d", size))
+	}
+	if crop {
+		opts = append(opts, "c")
+	}
+	// The images service only converts to WebP
+	if PreferredFormat(r.Req) == "webp" {
+		opts = append(opts, "rw")
+	}
+
+	result := u.String()
+	if len(opts) > 0 {
+		result += "=" + strings.Join(opts, "-")
+	}
+	r.W.Header().Add("Vary", "Accept")
+
+	return result, nil
+}
+
+// Serves a file of Cloud Storage (/gs/bucket/name.jpg). If the client
+// supports one of the formats listed in variants, the file with the same
+// name and that extension is sent instead (it should be stored
+// alongside the original one). The original file is sent if the variant
+// doesn't exist.
+// Example: images.Serve(r, "/gs/bucket/photo.jpg", "webp")
+func Serve(r *app.Request, filename string, variants ...string) error {
+	if format := PreferredFormat(r.Req); format != "" {
+		for _, v := range variants {
+			if v != format {
+				continue
+			}
+			variant := strings.TrimSuffix(filename, path.Ext(filename)) + "." + format
+			if variantExists(r.C, variant) {
+				filename = variant
+				r.W.Header().Set("Content-Type", "image/"+format)
+			}
+			break
+		}
+	}
+
+	key, err := blobstore.BlobKeyForFile(r.C, filename)
+	if err != nil {
+		return fmt.Errorf("get blob key failed: %s", err)
+	}
+	r.W.Header().Add("Vary", "Accept")
+	blobstore.Send(r.W, key)
+
+	return nil
+}
+
+// Stops serving the blob through the URLs of ServingURL
+func DeleteServingURL(c appengine.Context, key appengine.BlobKey) error {
+	if err := image.DeleteServingURL(c, key); err != nil {
+		return fmt.Errorf("delete serving url failed: %s", err)
+	}
+	return nil
+}
+
+// Handler that streams the images of the bucket, allowing the clients
+// and proxies to cache them for maxAge. The route should have a name
+// variable with the path of the image inside the bucket.
+// Example: "GET::/images/{name:.+}": images.Handler("my-bucket", 24*time.Hour, "webp"),
+func Handler(bucket string, maxAge time.Duration, variants ...string) app.Handler {
+	return func(r *app.Request) error {
+		name := mux.Vars(r.Req)["name"]
+		if name == "" || strings.Contains(name, "..") {
+			return app.NotFound()
+		}
+
+		h := r.W.Header()
+		h.Del("Expires")
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+		if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+			h.Set("Content-Type", ct)
+		}
+
+		return Serve(r, fmt.Sprintf("/gs/%s/%s", bucket, name), variants...)
+	}
+}
+
+// Returns true if the Cloud Storage file (/gs/bucket/name) exists. The
+// errors are logged and return false, so the original file is served.
+func variantExists(c appengine.Context, filename string) bool {
+	key := "images-variant:" + filename
+	var exists bool
+	if err := cache.Get(c, key, &exists); err == nil {
+		return exists
+	} else if err != cache.ErrMiss {
+		c.Warningf("[images] read cached variant failed: %s", err)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(filename, "/gs/"), "/", 2)
+	if len(parts) != 2 {
+		c.Errorf("[images] not a cloud storage file: %s", filename)
+		return false
+	}
+	// The slashes of the object name are escaped too
+	u := fmt.Sprintf("https://www.googleapis.com/storage/v1/b/%s/o/%s?fields=name",
+		url.QueryEscape(parts[0]), strings.Replace(url.QueryEscape(parts[1]), "+", "%20", -1))
+	resp, err := google.Client(c, google.AppIdentity, storageReadScope).Get(u)
+	if err != nil {
+		c.Warningf("[images] stat variant %s failed: %s", filename, err)
+		return false
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		exists = true
+	case http.StatusNotFound:
+		exists = false
+	default:
+		c.Warningf("[images] stat variant %s failed: status %d", filename, resp.StatusCode)
+		return false
+	}
+
+	if err := cache.Set(c, key, exists, VariantsCacheTTL); err != nil {
+		c.Warningf("[images] cache variant failed: %s", err)
+	}
+	return exists
+}
@@ -0,0 +1,39 @@
+// +build !appenginevm
+
+package platform
+
+import (
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/urlfetch"
+)
+
+// Runtime the app is built for
+var Current Runtime = classic{}
+
+type classic struct {
+	appengineRuntime
+}
+
+func (classic) Name() string {
+	return "classic"
+}
+
+func (classic) IsDevelopment() bool {
+	return appengine.IsDevAppServer()
+}
+
+// The classic runtime only allows the outgoing requests through urlfetch
+func (classic) HTTPClient(c appengine.Context, deadline time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &urlfetch.Transport{
+			Context:  c,
+			Deadline: deadline,
+		},
+	}
+}
+
+// The classic runtime starts the server itself
+func (classic) Main() {}
@@ -0,0 +1,86 @@
+// Package platform isolates the calls that differ between the classic
+// App Engine runtime and Managed VMs. The implementation of Current is
+// selected with the appenginevm build tag, so the handlers don't change.
+//
+// The rest of the library shouldn't call the runtime functions of the
+// appengine package (contexts, tokens, versions, urlfetch, etc.)
+// directly, only the datastore, memcache & taskqueue APIs that work
+// the same in both of them.
+// Example: client := platform.Current.HTTPClient(c, time.Duration(10)*time.Second)
+package platform
+
+import (
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/mail"
+)
+
+// Calls of the runtime that serves the app
+type Runtime interface {
+	// Name of the runtime, emitted in the logs & error reports
+	Name() string
+
+	// Returns true when running in the development server
+	IsDevelopment() bool
+
+	// Returns the context of the incoming request
+	NewContext(req *http.Request) appengine.Context
+
+	// Returns the ID of the application
+	AppID(c appengine.Context) string
+
+	// Returns the full version ID of the request ("v1.123456789")
+	VersionID(c appengine.Context) string
+
+	// Returns the name of the module that serves the request
+	ModuleName(c appengine.Context) string
+
+	// Returns the hostname of the default version of the app
+	DefaultHostname(c appengine.Context) string
+
+	// Returns an OAuth2 token of the service account of the app
+	AccessToken(c appengine.Context, scopes ...string) (string, time.Time, error)
+
+	// Returns a client for the outgoing requests
+	HTTPClient(c appengine.Context, deadline time.Duration) *http.Client
+
+	// Sends the message to the admins of the app with the Mail API
+	SendToAdmins(c appengine.Context, msg *mail.Message) error
+
+	// Starts the server if the runtime doesn't do it by itself
+	Main()
+}
+
+// Calls that are the same in both runtimes. Their implementations
+// embed it and override the rest.
+type appengineRuntime struct{}
+
+func (appengineRuntime) NewContext(req *http.Request) appengine.Context {
+	return appengine.NewContext(req)
+}
+
+func (appengineRuntime) AppID(c appengine.Context) string {
+	return appengine.AppID(c)
+}
+
+func (appengineRuntime) VersionID(c appengine.Context) string {
+	return appengine.VersionID(c)
+}
+
+func (appengineRuntime) ModuleName(c appengine.Context) string {
+	return appengine.ModuleName(c)
+}
+
+func (appengineRuntime) DefaultHostname(c appengine.Context) string {
+	return appengine.DefaultVersionHostname(c)
+}
+
+func (appengineRuntime) AccessToken(c appengine.Context, scopes ...string) (string, time.Time, error) {
+	return appengine.AccessToken(c, scopes...)
+}
+
+func (appengineRuntime) SendToAdmins(c appengine.Context, msg *mail.Message) error {
+	return mail.SendToAdmins(c, msg)
+}
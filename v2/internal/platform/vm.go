@@ -0,0 +1,52 @@
+// +build appenginevm
+
+package platform
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"appengine"
+)
+
+// Runtime the app is built for
+var Current Runtime = vm{}
+
+type vm struct {
+	appengineRuntime
+}
+
+func (vm) Name() string {
+	return "vm"
+}
+
+func (vm) IsDevelopment() bool {
+	return os.Getenv("RUN_WITH_DEVAPPSERVER") != ""
+}
+
+// The VMs receive the version in the environment instead of the
+// request headers
+func (r vm) VersionID(c appengine.Context) string {
+	if v := os.Getenv("GAE_MODULE_VERSION"); v != "" {
+		return v
+	}
+	return r.appengineRuntime.VersionID(c)
+}
+
+func (r vm) ModuleName(c appengine.Context) string {
+	if m := os.Getenv("GAE_MODULE_NAME"); m != "" {
+		return m
+	}
+	return r.appengineRuntime.ModuleName(c)
+}
+
+// Managed VMs can open sockets directly, without the urlfetch limits
+func (vm) HTTPClient(c appengine.Context, deadline time.Duration) *http.Client {
+	return &http.Client{Timeout: deadline}
+}
+
+// Starts the server of the VM; it never returns
+func (vm) Main() {
+	appengine.Main()
+}
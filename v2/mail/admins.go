@@ -0,0 +1,39 @@
+package mail
+
+import (
+	"fmt"
+
+	"appengine"
+	gaemail "appengine/mail"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/config"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+// Sends the message to the emails of the AdminEmails setting, or to the
+// admins of the application while it's empty
+func SendToAdmins(c appengine.Context, msg *gaemail.Message) error {
+	admins := config.AdminEmails(c)
+	if len(admins) == 0 {
+		return platform.Current.SendToAdmins(c, msg)
+	}
+
+	msg.To = admins
+	if err := gaemail.Send(c, msg); err != nil {
+		return fmt.Errorf("send admins mail failed: %s", err)
+	}
+	return nil
+}
+
+// Handler of the error mails enqueued by the router in the admin-mails
+// queue, sent with SendToAdmins.
+// Example: "POST::/tasks/error-mail": mail.ErrorMailHandler,
+func ErrorMailHandler(r *app.Request) error {
+	msg := &gaemail.Message{
+		Sender:  fmt.Sprintf("errors@%s.appspotmail.com", platform.Current.AppID(r.C)),
+		Subject: fmt.Sprintf("Error in %s", platform.Current.AppID(r.C)),
+		Body:    r.Req.FormValue("Error"),
+	}
+	return SendToAdmins(r.C, msg)
+}
@@ -0,0 +1,204 @@
+package mail
+
+import (
+	"fmt"
+	"html/template"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"appengine"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/httpclient"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+var (
+	// Additional senders checked by the preflight together with DefaultFrom
+	Senders []string
+
+	// Domains that should be included in the SPF record of the senders,
+	// the ones of the mail provider (e.g. "sendgrid.net"). Nothing is
+	// checked if it's empty. Set it at init().
+	SPFIncludes []string
+
+	// Selectors of the DKIM records published for the senders, given by
+	// the mail provider (e.g. "s1", "s2"). Nothing is checked if it's
+	// empty. Set it at init().
+	DKIMSelectors []string
+
+	// DNS over HTTPS endpoint used to read the records
+	DNSResolver = "https://dns.google.com/resolve"
+)
+
+// Result of the preflight of a sender address
+type SenderCheck struct {
+	Address  string
+	SPF      string
+	DKIM     []string
+	Problems []string
+}
+
+func (s *SenderCheck) OK() bool {
+	return len(s.Problems) == 0
+}
+
+func (s *SenderCheck) problem(format string, args ...interface{}) {
+	s.Problems = append(s.Problems, fmt.Sprintf(format, args...))
+}
+
+// Checks the format of the address and the SPF & DKIM records of its
+// domain. The senders authorized in the console can't be read, so they're
+// not checked.
+func CheckSender(c appengine.Context, address string) *SenderCheck {
+	check := &SenderCheck{Address: address}
+
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		check.problem("invalid address: %s", err)
+		return check
+	}
+	parts := strings.Split(addr.Address, "@")
+	domain := strings.ToLower(parts[len(parts)-1])
+
+	// The appspotmail addresses are always allowed and don't need records
+	if domain == platform.Current.AppID(c)+".appspotmail.com" {
+		return check
+	}
+	records, err := lookupTXT(c, domain)
+	if err != nil {
+		check.problem("lookup spf failed: %s", err)
+	}
+	for _, record := range records {
+		if strings.HasPrefix(record, "v=spf1") {
+			check.SPF = record
+		}
+	}
+	if check.SPF == "" && err == nil {
+		check.problem("no spf record in %s", domain)
+	}
+	for _, include := range SPFIncludes {
+		if check.SPF != "" && !strings.Contains(check.SPF, "include:"+include) {
+			check.problem("the spf record doesn't include %s", include)
+		}
+	}
+
+	for _, selector := range DKIMSelectors {
+		name := selector + "._domainkey." + domain
+		records, err := lookupTXT(c, name)
+		if err != nil {
+			check.problem("lookup dkim %s failed: %s", name, err)
+			continue
+		}
+		found := false
+		for _, record := range records {
+			if strings.Contains(record, "p=") {
+				check.DKIM = append(check.DKIM, name)
+				found = true
+				break
+			}
+		}
+		if !found {
+			check.problem("no dkim key in %s", name)
+		}
+	}
+
+	return check
+}
+
+// Checks DefaultFrom and the rest of the Senders
+func CheckSenders(c appengine.Context) []*SenderCheck {
+	checks := []*SenderCheck{}
+	for _, address := range allSenders() {
+		checks = append(checks, CheckSender(c, address))
+	}
+	return checks
+}
+
+// Logs a warning for each problem found in the senders. Call it from
+// the warmup request to detect the problems before sending any mail.
+// Example: app.OnWarmup(mail.WarmupHandler)
+func WarmupHandler(r *app.Request) error {
+	for _, check := range CheckSenders(r.C) {
+		for _, problem := range check.Problems {
+			r.Warnf("[mail] sender %s: %s", check.Address, problem)
+		}
+	}
+	return nil
+}
+
+var preflightTemplate = template.Must(template.New("preflight").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Mail senders</title></head>
+<body>
+  <h1>Mail senders</h1>
+  <table>
+    <tr><th>Sender</th><th>SPF</th><th>DKIM</th><th>Problems</th></tr>
+    {{range .}}
+    <tr>
+      <td>{{.Address}}</td>
+      <td>{{.SPF}}</td>
+      <td>{{range .DKIM}}{{.}}<br>{{end}}</td>
+      <td>{{if .OK}}OK{{else}}{{range .Problems}}{{.}}<br>{{end}}{{end}}</td>
+    </tr>
+    {{end}}
+  </table>
+</body>
+</html>
+`))
+
+// Admin page with the preflight of the senders
+// Example: "GET::/admin/mail-senders": mail.PreflightHandler,
+func PreflightHandler(r *app.Request) error {
+	if !user.IsAdmin(r.C) {
+		return app.Forbidden()
+	}
+
+	if err := preflightTemplate.Execute(r.W, CheckSenders(r.C)); err != nil {
+		return fmt.Errorf("exec preflight template failed: %s", err)
+	}
+	return nil
+}
+
+func allSenders() []string {
+	senders := []string{}
+	if DefaultFrom != "" {
+		senders = append(senders, DefaultFrom)
+	}
+	for _, s := range Senders {
+		if s != DefaultFrom {
+			senders = append(senders, s)
+		}
+	}
+	return senders
+}
+
+// Returns the TXT records of the name
+func lookupTXT(c appengine.Context, name string) ([]string, error) {
+	var result struct {
+		Status int `json:"Status"`
+		Answer []struct {
+			Type int    `json:"type"`
+			Data string `json:"data"`
+		} `json:"Answer"`
+	}
+	u := DNSResolver + "?" + url.Values{"name": {name}, "type": {"TXT"}}.Encode()
+	if err := httpclient.Get(c, u, &result); err != nil {
+		return nil, fmt.Errorf("dns request failed: %s", err)
+	}
+
+	// NXDOMAIN is not an error, the name simply has no records
+	if result.Status != 0 && result.Status != 3 {
+		return nil, fmt.Errorf("dns status %d", result.Status)
+	}
+
+	records := []string{}
+	for _, answer := range result.Answer {
+		if answer.Type == 16 {
+			records = append(records, strings.Trim(strings.Replace(answer.Data, `" "`, "", -1), `"`))
+		}
+	}
+	return records, nil
+}
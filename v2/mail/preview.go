@@ -0,0 +1,143 @@
+package mail
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+// Mail template shown in the preview pages with sample data
+type Preview struct {
+	Name      string
+	Subject   string
+	Templates []string
+
+	// Returns the data of the template, like the Data of a Mail
+	Sample func() interface{}
+}
+
+var previews = map[string]*Preview{}
+
+// Registers a mail template for the preview pages. Call it at init().
+// Example:
+//    mail.RegisterPreview(&mail.Preview{
+//      Name:      "welcome",
+//      Subject:   "Welcome!",
+//      Templates: []string{"mails/base", "mails/welcome"},
+//      Sample:    func() interface{} { return &Welcome{Name: "Jane"} },
+//    })
+func RegisterPreview(p *Preview) {
+	if _, ok := previews[p.Name]; ok {
+		panic("mail preview registered twice: " + p.Name)
+	}
+	previews[p.Name] = p
+}
+
+// Rendered preview of a template
+type previewPage struct {
+	*Preview
+	From, Text string
+	HTMLURL    string
+	Widths     []int
+	Previews   []*Preview
+}
+
+// Widths of the frames of the HTML body: desktop & mobile
+var previewWidths = []int{800, 375}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Mail previews</title></head>
+<body>
+  <h1>Mail previews</h1>
+  <ul>
+    {{range .Previews}}
+    <li><a href="?name={{.Name}}">{{.Name}}</a></li>
+    {{end}}
+  </ul>
+
+  {{if .Preview}}
+  <h2>{{.Name}}</h2>
+  <p>From: {{.From}}<br>Subject: {{.Subject}}<br>Templates: {{range .Templates}}{{.}} {{end}}</p>
+  {{range .Widths}}
+  <h3>{{.}}px</h3>
+  <iframe src="{{$.HTMLURL}}" width="{{.}}" height="600"></iframe>
+  {{end}}
+  <h3>Plain text</h3>
+  <pre>{{.Text}}</pre>
+  {{end}}
+</body>
+</html>
+`))
+
+// Admin page that lists the registered mail templates and renders
+// the selected one with its sample data, in desktop & mobile widths
+// and as plain text, without sending any mail. admin.Mount registers
+// it; protect it with auth.RequireAdmin to use it elsewhere.
+// Example: m.Get("/admin/mail-previews", auth.RequireAdmin(mail.PreviewHandler))
+func PreviewHandler(r *app.Request) error {
+	page := &previewPage{Widths: previewWidths}
+	for _, p := range previews {
+		page.Previews = append(page.Previews, p)
+	}
+	sort.Sort(byName(page.Previews))
+
+	if name := r.Req.FormValue("name"); name != "" {
+		p, ok := previews[name]
+		if !ok {
+			return app.NotFound()
+		}
+		m := p.mail(r)
+		html, err := render(r.C, m)
+		if err != nil {
+			return err
+		}
+
+		// The frames load the body alone, without the styles of this page
+		if r.Req.FormValue("part") == "html" {
+			r.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, err := r.W.Write([]byte(html))
+			return err
+		}
+
+		// The text that GenerateText would send, if there's none
+		page.Preview = p
+		page.Text = m.Text
+		if page.Text == "" {
+			page.Text = TextFromHTML(html)
+		}
+		page.From = m.From
+		if m.FromName != "" {
+			page.From = fmt.Sprintf("%s <%s>", m.FromName, m.From)
+		}
+		page.HTMLURL = "?" + url.Values{"name": {name}, "part": {"html"}}.Encode()
+	}
+
+	r.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := previewTemplate.Execute(r.W, page); err != nil {
+		return fmt.Errorf("exec preview template failed: %s", err)
+	}
+	return nil
+}
+
+// Builds the mail of the preview with the brand of the request
+func (p *Preview) mail(r *app.Request) *Mail {
+	m := &Mail{
+		Subject:   p.Subject,
+		Templates: p.Templates,
+		Brand:     r.Brand(),
+	}
+	if p.Sample != nil {
+		m.Data = p.Sample()
+	}
+	return m
+}
+
+type byName []*Preview
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
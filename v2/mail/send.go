@@ -4,49 +4,196 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"html/template"
+	"io"
+	"mime/multipart"
 	"net/url"
+	"strings"
 	"time"
 
-	"conf"
-
 	"appengine"
+	"appengine/datastore"
 	"appengine/taskqueue"
-	"appengine/urlfetch"
 
 	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/config"
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
 )
 
+// Sender of the mails built with SendTemplate
+var DefaultFrom, DefaultFromName string
+
+const KindMailAttachment = "MailAttachment"
+
+func init() {
+	config.Register("SendGridUser", config.TypeString, "", "SendGrid API user")
+	config.Register("SendGridKey", config.TypeSecret, "", "SendGrid API key")
+	config.Register("SendGridAPI", config.TypeString, "https://api.sendgrid.com/api/mail.send.json",
+		"SendGrid API endpoint")
+}
+
 type Mail struct {
 	// Message info
 	To, ToName,
 	From, FromName,
 	Subject string
 
+	// Additional recipients
+	ToList, Cc, Bcc []string
+
 	// Message body construction
 	Templates []string
 	Data      interface{}
 
+	// Plain text alternative of the HTML body
+	Text string
+
+	// Generates Text from the HTML body if it's empty
+	GenerateText bool
+
+	Attachments []*Attachment
+
 	// Additional info for templates
 	AppId string
+
+	// Brand of the domain that sends the mail, the default one if it's nil.
+	// It fills From & FromName if they're empty.
+	Brand *app.Brand
 }
 
+type Attachment struct {
+	Name    string `datastore:",noindex"`
+	Content []byte `datastore:",noindex"`
+}
+
+// Enqueues the mail to send it in the background, with the brand of
+// the domain of the request
 func (m *Mail) Send(r *app.Request) error {
+	if m.Brand == nil {
+		m.Brand = r.Brand()
+	}
+	return SendLater(r.C, m)
+}
+
+// Enqueues the mail in the mails queue, so the latency of the request is
+// not affected. The /tasks/mail route should be handled by TaskHandler.
+// The attachments don't fit in the task, they're stored in the datastore
+// until the mail is sent.
+func SendLater(c appengine.Context, m *Mail) error {
+	keys, err := storeAttachments(c, m.Attachments)
+	if err != nil {
+		return err
+	}
+
+	queued := *m
+	queued.Attachments = nil
 	buf := bytes.NewBuffer(nil)
-	if err := json.NewEncoder(buf).Encode(m); err != nil {
+	if err := json.NewEncoder(buf).Encode(&queued); err != nil {
 		return fmt.Errorf("encode mail failed: %s", err)
 	}
 
 	t := app.NewTask("/tasks/mail", map[string]string{
-		"Mail": buf.String(),
+		"Mail":        buf.String(),
+		"Attachments": encodeKeys(keys),
 	})
-	if _, err := taskqueue.Add(r.C, t, "mails"); err != nil {
+	if _, err := taskqueue.Add(c, t, "mails"); err != nil {
+		if len(keys) > 0 {
+			if err := datastore.DeleteMulti(c, keys); err != nil {
+				c.Warningf("[mail] delete attachments failed: %s", err)
+			}
+		}
 		return fmt.Errorf("enqueue mail failed: %s", err)
 	}
 
 	return nil
 }
 
+// Handler of the mails enqueued by SendLater.
+// Example: "POST::/tasks/mail": mail.TaskHandler,
+func TaskHandler(r *app.Request) error {
+	m := new(Mail)
+	if err := json.NewDecoder(bytes.NewBufferString(r.Req.FormValue("Mail"))).Decode(m); err != nil {
+		return fmt.Errorf("decode mail failed: %s", err)
+	}
+
+	keys, err := decodeKeys(r.Req.FormValue("Attachments"))
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		m.Attachments = make([]*Attachment, len(keys))
+		for i := range m.Attachments {
+			m.Attachments[i] = new(Attachment)
+		}
+		if err := datastore.GetMulti(r.C, keys, m.Attachments); err != nil {
+			return fmt.Errorf("load attachments failed: %s", err)
+		}
+	}
+
+	if err := SendGrid(r, m); err != nil {
+		return err
+	}
+
+	// The retries of the task need them until the mail is sent
+	if len(keys) > 0 {
+		if err := datastore.DeleteMulti(r.C, keys); err != nil {
+			r.C.Warningf("[mail] delete attachments failed: %s", err)
+		}
+	}
+	return nil
+}
+
+// Stores the attachments of a queued mail, returning their keys
+func storeAttachments(c appengine.Context, attachments []*Attachment) ([]*datastore.Key, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	keys := make([]*datastore.Key, len(attachments))
+	for i := range keys {
+		keys[i] = datastore.NewIncompleteKey(c, KindMailAttachment, nil)
+	}
+	keys, err := datastore.PutMulti(c, keys, attachments)
+	if err != nil {
+		return nil, fmt.Errorf("store attachments failed: %s", err)
+	}
+	return keys, nil
+}
+
+func encodeKeys(keys []*datastore.Key) string {
+	encoded := make([]string, len(keys))
+	for i, key := range keys {
+		encoded[i] = key.Encode()
+	}
+	return strings.Join(encoded, ",")
+}
+
+func decodeKeys(value string) ([]*datastore.Key, error) {
+	if value == "" {
+		return nil, nil
+	}
+	keys := []*datastore.Key{}
+	for _, encoded := range strings.Split(value, ",") {
+		key, err := datastore.DecodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decode attachment key failed: %s", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Renders the template with the data and sends the mail directly
+// from DefaultFrom, or the sender of the default brand.
+func SendTemplate(c appengine.Context, to, subject, templateName string, data interface{}) error {
+	m := &Mail{
+		To:        to,
+		Subject:   subject,
+		Templates: []string{templateName},
+		Data:      data,
+	}
+	return sendGrid(c, m)
+}
+
 // Response from the SendGrid API
 type mailAPI struct {
 	Message string   `json:"message"`
@@ -55,29 +202,40 @@ type mailAPI struct {
 
 // Send a mail using the SendGrid API
 func SendGrid(r *app.Request, m *Mail) error {
-	m.AppId = appengine.AppID(r.C)
-	html := bytes.NewBuffer(nil)
-	if err := app.Template(html, m.Templates, m); err != nil {
-		return fmt.Errorf("prepare mail template failed: %s", err)
+	return sendGrid(r.C, m)
+}
+
+func sendGrid(c appengine.Context, m *Mail) error {
+	html, err := render(c, m)
+	if err != nil {
+		return err
 	}
 
 	data := url.Values{
-		"api_user": []string{conf.SendGridUser},
-		"api_key":  []string{conf.SendGridKey},
-		"to":       []string{m.To},
-		"toname":   []string{m.ToName},
+		"api_user": []string{config.String(c, "SendGridUser", "")},
+		"api_key":  []string{config.Secret(c, "SendGridKey", "")},
+		"to[]":     append([]string{m.To}, m.ToList...),
+		"toname[]": toNames(m),
 		"subject":  []string{m.Subject},
-		"html":     []string{html.String()},
+		"html":     []string{html},
 		"from":     []string{m.From},
 		"fromname": []string{m.FromName},
 	}
-	client := &http.Client{
-		Transport: &urlfetch.Transport{
-			Context:  r.C,
-			Deadline: time.Duration(40) * time.Second,
-		},
+	if len(m.Cc) > 0 {
+		data["cc[]"] = m.Cc
+	}
+	if len(m.Bcc) > 0 {
+		data["bcc[]"] = m.Bcc
+	}
+	data.Set("text", m.Text)
+
+	body, contentType, err := encodeMail(data, m.Attachments)
+	if err != nil {
+		return err
 	}
-	resp, err := client.PostForm(conf.SendGridAPI, data)
+
+	client := platform.Current.HTTPClient(c, time.Duration(40)*time.Second)
+	resp, err := client.Post(config.String(c, "SendGridAPI", ""), contentType, body)
 	if err != nil {
 		return fmt.Errorf("post mail failed: %s", err)
 	}
@@ -94,3 +252,78 @@ func SendGrid(r *app.Request, m *Mail) error {
 
 	return nil
 }
+
+// Returns one name for each address of to[]; the API pairs them by
+// position, so the additional recipients have an empty one.
+func toNames(m *Mail) []string {
+	names := make([]string, len(m.ToList)+1)
+	names[0] = m.ToName
+	return names
+}
+
+// Encodes the fields of the API call. The files are sent as the parts
+// of a multipart form, with their names & binary contents untouched.
+func encodeMail(data url.Values, attachments []*Attachment) (io.Reader, string, error) {
+	if len(attachments) == 0 {
+		return bytes.NewBufferString(data.Encode()), "application/x-www-form-urlencoded", nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := multipart.NewWriter(buf)
+	for name, values := range data {
+		for _, value := range values {
+			if err := w.WriteField(name, value); err != nil {
+				return nil, "", fmt.Errorf("write mail field failed: %s", err)
+			}
+		}
+	}
+	for _, a := range attachments {
+		part, err := w.CreateFormFile(fmt.Sprintf("files[%s]", a.Name), a.Name)
+		if err != nil {
+			return nil, "", fmt.Errorf("create attachment part failed: %s", err)
+		}
+		if _, err := part.Write(a.Content); err != nil {
+			return nil, "", fmt.Errorf("write attachment failed: %s", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", fmt.Errorf("close mail body failed: %s", err)
+	}
+
+	return buf, w.FormDataContentType(), nil
+}
+
+// Fills the sender & brand defaults of the mail and renders its HTML
+// body, generating the plain text alternative if it's requested
+func render(c appengine.Context, m *Mail) (string, error) {
+	m.AppId = platform.Current.AppID(c)
+	if m.Brand == nil {
+		m.Brand = app.DefaultBrand()
+	}
+	if m.From == "" {
+		m.From, m.FromName = m.Brand.MailFrom, m.Brand.MailFromName
+	}
+	if m.From == "" {
+		m.From, m.FromName = DefaultFrom, DefaultFromName
+	}
+	// The templates see the brand of the mail in {{brand}} too, not the
+	// default one of the executions without a request
+	html := bytes.NewBuffer(nil)
+	brand := m.Brand
+	err := app.ExecTemplate(&app.TemplateConfig{
+		Names: m.Templates,
+		W:     html,
+		Data:  m,
+		Dir:   "templates",
+		Funcs: template.FuncMap{
+			"brand": func() *app.Brand { return brand },
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("prepare mail template failed: %s", err)
+	}
+	if m.Text == "" && m.GenerateText {
+		m.Text = TextFromHTML(html.String())
+	}
+	return html.String(), nil
+}
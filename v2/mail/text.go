@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	hiddenBlocksRe = regexp.MustCompile(`(?is)<(head|style|script)[^>]*>.*?</(head|style|script)>`)
+	linksRe        = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	breaksRe       = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|h[1-6]|li|table|ul|ol)>`)
+	itemsRe        = regexp.MustCompile(`(?i)<li[^>]*>`)
+	tagsRe         = regexp.MustCompile(`(?s)<[^>]*>`)
+	spacesRe       = regexp.MustCompile(`[ \t\r\f]+`)
+	blankLinesRe   = regexp.MustCompile(`\n{3,}`)
+)
+
+// Returns the plain text alternative of the HTML body of a mail: the
+// links are written with their URL and the blocks in separate lines.
+func TextFromHTML(body string) string {
+	text := hiddenBlocksRe.ReplaceAllString(body, "")
+	text = linksRe.ReplaceAllStringFunc(text, func(m string) string {
+		parts := linksRe.FindStringSubmatch(m)
+		u, label := html.UnescapeString(parts[1]), strings.TrimSpace(tagsRe.ReplaceAllString(parts[2], ""))
+		if label == "" || label == u {
+			return u
+		}
+		return label + " (" + u + ")"
+	})
+	text = breaksRe.ReplaceAllString(text, "\n")
+	text = itemsRe.ReplaceAllString(text, "- ")
+	text = tagsRe.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spacesRe.ReplaceAllString(line, " "))
+	}
+	text = blankLinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text) + "\n"
+}
@@ -0,0 +1,27 @@
+package mail
+
+import "testing"
+
+func TestTextFromHTML(t *testing.T) {
+	tests := []struct {
+		html, text string
+	}{
+		{"<p>Hello</p>", "Hello\n"},
+		{"<head><title>Mail</title><style>p { color: red; }</style></head><p>Hi</p>", "Hi\n"},
+		{"<p>One</p><p>Two</p>", "One\nTwo\n"},
+		{"Line<br>break<br/>here", "Line\nbreak\nhere\n"},
+		{`<a href="https://example.com/a?b=1&amp;c=2">Open it</a>`, "Open it (https://example.com/a?b=1&c=2)\n"},
+		{`<a href="https://example.com"><b>https://example.com</b></a>`, "https://example.com\n"},
+		{`<a href="https://example.com"><img src="logo.png"></a>`, "https://example.com\n"},
+		{"<ul><li>One</li><li>Two</li></ul>", "- One\n- Two\n"},
+		{"<p>  Too    many \t spaces  </p>", "Too many spaces\n"},
+		{"<p>A</p>\n\n\n\n<p>B</p>", "A\n\nB\n"},
+		{"Tom &amp; Jerry &lt;3", "Tom & Jerry <3\n"},
+		{"<script>alert(1)</script>Safe", "Safe\n"},
+	}
+	for _, test := range tests {
+		if got := TextFromHTML(test.html); got != test.text {
+			t.Errorf("html %q: got %q, expected %q", test.html, got, test.text)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package oauth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/httpclient"
+	"github.com/gorilla/securecookie"
+)
+
+const KindOAuthUser = "OAuthUser"
+
+// Normalized profile of the user returned by all the providers
+type Profile struct {
+	Provider string
+	ID       string
+	Email    string
+	Name     string
+	Picture  string `datastore:",noindex"`
+}
+
+type Provider struct {
+	// Name of the provider, used in the profiles and the keys
+	Name string
+
+	AuthURL, TokenURL, ProfileURL string
+	ClientID, ClientSecret        string
+	Scopes                        []string
+
+	// Absolute URL of the route that calls Callback
+	RedirectURL string
+
+	// Converts the profile response of the provider
+	parse func(data map[string]interface{}) *Profile
+}
+
+// Called after a successful login with the profile of the user, to
+// store it in the session or the datastore (see SaveProfile).
+// Returning an error aborts the login.
+var OnLogin = func(r *app.Request, p *Profile) error {
+	return fmt.Errorf("oauth.OnLogin is not configured")
+}
+
+func Google(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         "google",
+		AuthURL:      "https://accounts.google.com/o/oauth2/auth",
+		TokenURL:     "https://accounts.google.com/o/oauth2/token",
+		ProfileURL:   "https://www.googleapis.com/oauth2/v2/userinfo",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"email", "profile"},
+		RedirectURL:  redirectURL,
+		parse: func(d map[string]interface{}) *Profile {
+			return &Profile{ID: str(d["id"]), Email: str(d["email"]),
+				Name: str(d["name"]), Picture: str(d["picture"])}
+		},
+	}
+}
+
+func Facebook(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         "facebook",
+		AuthURL:      "https://www.facebook.com/dialog/oauth",
+		TokenURL:     "https://graph.facebook.com/oauth/access_token",
+		ProfileURL:   "https://graph.facebook.com/me?fields=id,name,email,picture",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"email"},
+		RedirectURL:  redirectURL,
+		parse: func(d map[string]interface{}) *Profile {
+			p := &Profile{ID: str(d["id"]), Email: str(d["email"]), Name: str(d["name"])}
+			if pic, ok := d["picture"].(map[string]interface{}); ok {
+				if data, ok := pic["data"].(map[string]interface{}); ok {
+					p.Picture = str(data["url"])
+				}
+			}
+			return p
+		},
+	}
+}
+
+func GitHub(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Name:         "github",
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		ProfileURL:   "https://api.github.com/user",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"user:email"},
+		RedirectURL:  redirectURL,
+		parse: func(d map[string]interface{}) *Profile {
+			name := str(d["name"])
+			if name == "" {
+				name = str(d["login"])
+			}
+			return &Profile{ID: str(d["id"]), Email: str(d["email"]),
+				Name: name, Picture: str(d["avatar_url"])}
+		},
+	}
+}
+
+// Redirects the user to the authorization page of the provider.
+// Example: return provider.Login(r, "/dashboard")
+func (p *Provider) Login(r *app.Request, next string) error {
+	state := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	r.Session.Values["oauth-state"] = state
+	r.Session.Values["oauth-next"] = next
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {p.RedirectURL},
+		"scope":         {strings.Join(p.Scopes, " ")},
+		"state":         {state},
+	}
+	return r.Redirect(p.AuthURL + "?" + params.Encode())
+}
+
+// Handler of the RedirectURL. It checks the state param against the
+// session to avoid CSRF attacks, loads the profile and calls OnLogin.
+func (p *Provider) Callback(r *app.Request) error {
+	state, _ := r.Session.Values["oauth-state"].(string)
+	next, _ := r.Session.Values["oauth-next"].(string)
+	delete(r.Session.Values, "oauth-state")
+	delete(r.Session.Values, "oauth-next")
+
+	query := r.Req.URL.Query()
+	if state == "" || query.Get("state") != state {
+		r.C.Errorf("[oauth] state mismatch")
+		return app.Forbidden()
+	}
+	if e := query.Get("error"); e != "" {
+		r.C.Errorf("[oauth] provider error: %s", e)
+		return app.Forbidden()
+	}
+
+	token, err := p.exchange(r, query.Get("code"))
+	if err != nil {
+		return err
+	}
+	profile, err := p.profile(r, token)
+	if err != nil {
+		return err
+	}
+	if err := OnLogin(r, profile); err != nil {
+		return err
+	}
+
+	if next == "" {
+		next = "/"
+	}
+	return r.Redirect(next)
+}
+
+func (p *Provider) exchange(r *app.Request, code string) (string, error) {
+	data := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := client("").PostForm(r.C, p.TokenURL, data, &result); err != nil {
+		return "", fmt.Errorf("exchange code failed: %s", err)
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("no access token in the response: %s", result.Error)
+	}
+
+	return result.AccessToken, nil
+}
+
+func (p *Provider) profile(r *app.Request, token string) (*Profile, error) {
+	data := map[string]interface{}{}
+	if err := client(token).Get(r.C, p.ProfileURL, &data); err != nil {
+		return nil, fmt.Errorf("get profile failed: %s", err)
+	}
+
+	profile := p.parse(data)
+	profile.Provider = p.Name
+	if profile.ID == "" {
+		return nil, fmt.Errorf("profile without id")
+	}
+
+	return profile, nil
+}
+
+// Stores the profile in the datastore, keyed by the provider and the ID.
+// It can be called from OnLogin.
+func SaveProfile(c appengine.Context, p *Profile) (*datastore.Key, error) {
+	key := datastore.NewKey(c, KindOAuthUser, p.Provider+":"+p.ID, 0, nil)
+	if _, err := datastore.Put(c, key, p); err != nil {
+		return nil, fmt.Errorf("put profile failed: %s", err)
+	}
+	return key, nil
+}
+
+// Returns the client of the provider requests, with the access token
+// if it's not empty
+func client(token string) *httpclient.Client {
+	return &httpclient.Client{
+		Deadline: time.Duration(20) * time.Second,
+		Retries:  2,
+		Backoff:  time.Duration(200) * time.Millisecond,
+		Token:    token,
+	}
+}
+
+// Returns the string version of a JSON value; numeric IDs are
+// converted without decimals
+func str(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return fmt.Sprintf("%.0f", t)
+	}
+	return ""
+}
@@ -0,0 +1,330 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/httpclient"
+	"github.com/gorilla/securecookie"
+)
+
+// Relying party configuration for an OpenID Connect provider
+type Provider struct {
+	// Base URL of the provider, the discovery document is
+	// loaded from Issuer + "/.well-known/openid-configuration"
+	Issuer string
+
+	ClientID, ClientSecret string
+
+	// Absolute URL of the route that calls Callback
+	RedirectURL string
+
+	// Additional scopes to the "openid" one
+	Scopes []string
+
+	// Called after verifying the ID token to store the user in the
+	// session; returning an error aborts the login. It's required.
+	MapUser func(r *app.Request, claims *Claims) error
+
+	mutex     sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// Verified claims of the ID token
+type Claims struct {
+	Issuer   string      `json:"iss"`
+	Subject  string      `json:"sub"`
+	Audience interface{} `json:"aud"`
+	Expires  int64       `json:"exp"`
+	IssuedAt int64       `json:"iat"`
+	Nonce    string      `json:"nonce"`
+	Email    string      `json:"email"`
+	Name     string      `json:"name"`
+
+	// All the claims of the token
+	Raw map[string]interface{} `json:"-"`
+}
+
+var client = &httpclient.Client{
+	Deadline: time.Duration(20) * time.Second,
+	Retries:  2,
+	Backoff:  time.Duration(200) * time.Millisecond,
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Redirects the user to the login page of the provider.
+// Example: return provider.Login(r, "/dashboard")
+func (p *Provider) Login(r *app.Request, next string) error {
+	if p.MapUser == nil {
+		return fmt.Errorf("oidc provider without MapUser: %s", p.Issuer)
+	}
+	d, err := p.loadDiscovery(r)
+	if err != nil {
+		return err
+	}
+
+	state := encodeRandom()
+	nonce := encodeRandom()
+	r.Session.Values["oidc-state"] = state
+	r.Session.Values["oidc-nonce"] = nonce
+	r.Session.Values["oidc-next"] = next
+
+	scopes := append([]string{"openid"}, p.Scopes...)
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {p.RedirectURL},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	return r.Redirect(d.AuthorizationEndpoint + "?" + params.Encode())
+}
+
+// Handler of the RedirectURL. It checks the state, exchanges the code
+// for the ID token and verifies it before calling MapUser.
+func (p *Provider) Callback(r *app.Request) error {
+	if p.MapUser == nil {
+		return fmt.Errorf("oidc provider without MapUser: %s", p.Issuer)
+	}
+
+	state, _ := r.Session.Values["oidc-state"].(string)
+	nonce, _ := r.Session.Values["oidc-nonce"].(string)
+	next, _ := r.Session.Values["oidc-next"].(string)
+	delete(r.Session.Values, "oidc-state")
+	delete(r.Session.Values, "oidc-nonce")
+	delete(r.Session.Values, "oidc-next")
+
+	query := r.Req.URL.Query()
+	if state == "" || query.Get("state") != state {
+		r.C.Errorf("[oidc] state mismatch")
+		return app.Forbidden()
+	}
+	if e := query.Get("error"); e != "" {
+		r.C.Errorf("[oidc] provider error: %s", e)
+		return app.Forbidden()
+	}
+
+	token, err := p.exchange(r, query.Get("code"))
+	if err != nil {
+		return err
+	}
+	claims, err := p.verify(r, token, nonce)
+	if err != nil {
+		r.C.Errorf("[oidc] invalid id token: %s", err)
+		return app.Forbidden()
+	}
+
+	if err := p.MapUser(r, claims); err != nil {
+		return err
+	}
+
+	if next == "" {
+		next = "/"
+	}
+	return r.Redirect(next)
+}
+
+func (p *Provider) loadDiscovery(r *app.Request) (*discovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := new(discovery)
+	u := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := client.Get(r.C, u, d); err != nil {
+		return nil, fmt.Errorf("load discovery failed: %s", err)
+	}
+	// The document should describe the configured provider
+	if !sameIssuer(d.Issuer, p.Issuer) {
+		return nil, fmt.Errorf("discovery issuer mismatch: %s", d.Issuer)
+	}
+	p.discovery = d
+
+	return d, nil
+}
+
+// Exchange the authorization code for the ID token
+func (p *Provider) exchange(r *app.Request, code string) (string, error) {
+	d, err := p.loadDiscovery(r)
+	if err != nil {
+		return "", err
+	}
+
+	data := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+	}
+	var result struct {
+		IdToken string `json:"id_token"`
+	}
+	if err := client.PostForm(r.C, d.TokenEndpoint, data, &result); err != nil {
+		return "", fmt.Errorf("exchange code failed: %s", err)
+	}
+	if result.IdToken == "" {
+		return "", fmt.Errorf("no id token in the response")
+	}
+
+	return result.IdToken, nil
+}
+
+// Verify the signature and the claims of the ID token
+func (p *Provider) verify(r *app.Request, token, nonce string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("decode header failed: %s", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("algorithm not supported: %s", header.Alg)
+	}
+
+	key, err := p.key(r, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode signature failed: %s", err)
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature); err != nil {
+		return nil, fmt.Errorf("verify signature failed: %s", err)
+	}
+
+	claims := new(Claims)
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("decode claims failed: %s", err)
+	}
+	if err := decodeSegment(parts[1], &claims.Raw); err != nil {
+		return nil, fmt.Errorf("decode raw claims failed: %s", err)
+	}
+
+	if !sameIssuer(claims.Issuer, p.Issuer) {
+		return nil, fmt.Errorf("issuer mismatch: %s", claims.Issuer)
+	}
+	if !hasAudience(claims.Audience, p.ClientID) {
+		return nil, fmt.Errorf("audience mismatch: %v", claims.Audience)
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, fmt.Errorf("token expired")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// Returns the public key with the ID, reloading the JWKS if it's unknown
+func (p *Provider) key(r *app.Request, kid string) (*rsa.PublicKey, error) {
+	d, err := p.loadDiscovery(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := client.Get(r.C, d.JwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("load jwks failed: %s", err)
+	}
+
+	p.keys = map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decode key modulus failed: %s", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decode key exponent failed: %s", err)
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key: %s", kid)
+	}
+	return key, nil
+}
+
+// Returns true if the issuers are the same, ignoring the final slash
+func sameIssuer(iss, expected string) bool {
+	return iss != "" && strings.TrimSuffix(iss, "/") == strings.TrimSuffix(expected, "/")
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, item := range v {
+			if item == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func encodeRandom() string {
+	return base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+}
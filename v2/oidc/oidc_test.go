@@ -0,0 +1,65 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Provider{
+		Issuer:    "https://accounts.example.com/",
+		ClientID:  "client",
+		discovery: &discovery{Issuer: "https://accounts.example.com"},
+		keys:      map[string]*rsa.PublicKey{"k1": &key.PublicKey},
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		claims map[string]interface{}
+		valid  bool
+	}{
+		{map[string]interface{}{"iss": "https://accounts.example.com", "aud": "client", "exp": exp, "nonce": "n"}, true},
+		{map[string]interface{}{"iss": "https://accounts.example.com/", "aud": []string{"other", "client"}, "exp": exp, "nonce": "n"}, true},
+		{map[string]interface{}{"iss": "https://evil.example.com", "aud": "client", "exp": exp, "nonce": "n"}, false},
+		{map[string]interface{}{"aud": "client", "exp": exp, "nonce": "n"}, false},
+		{map[string]interface{}{"iss": "https://accounts.example.com", "aud": "other", "exp": exp, "nonce": "n"}, false},
+		{map[string]interface{}{"iss": "https://accounts.example.com", "aud": "client", "exp": 1, "nonce": "n"}, false},
+		{map[string]interface{}{"iss": "https://accounts.example.com", "aud": "client", "exp": exp, "nonce": "x"}, false},
+	}
+	for _, test := range tests {
+		_, err := p.verify(nil, signToken(t, key, test.claims), "n")
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("claims %v: got valid %v (%v), expected %v", test.claims, valid, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package push
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+// Returns the inline script that asks the user for permission and
+// sends the subscription to the subscribe path (see SubscribeHandler)
+// with the post script of the app, included before it.
+// The service worker should be served by ServiceWorkerHandler at the
+// worker path. Call window.gaelibPush.subscribe() from a user action.
+// Example: {{push "/push/subscribe" "/push-worker.js"}}
+func Script(r *app.Request, subscribePath, workerPath string) template.HTML {
+	conf, err := json.Marshal(map[string]string{
+		"key":       vapid.public,
+		"subscribe": subscribePath,
+		"worker":    workerPath,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("encode push script config failed: %s", err))
+	}
+
+	return r.PostScript() + template.HTML(fmt.Sprintf(`<script nonce="%s">window.gaelibPush = (%s)(%s);</script>`,
+		template.HTMLEscapeString(r.CSPNonce()), subscribeJS, conf))
+}
+
+func init() {
+	app.RegisterRequestTemplateFuncs(func(r *app.Request) template.FuncMap {
+		return template.FuncMap{
+			"push": func(subscribePath, workerPath string) template.HTML {
+				return Script(r, subscribePath, workerPath)
+			},
+		}
+	})
+}
+
+const subscribeJS = `function(conf) {
+  function key(s) {
+    s = (s + '===='.slice(s.length % 4)).replace(/-/g, '+').replace(/_/g, '/');
+    var raw = atob(s), out = new Uint8Array(raw.length);
+    for (var i = 0; i < raw.length; i++) { out[i] = raw.charCodeAt(i); }
+    return out;
+  }
+  function post(path, sub) {
+    return new Promise(function(resolve, reject) {
+      gaelib.post(path, sub, function(status, text) {
+        if (status >= 200 && status < 300) { resolve(); } else { reject(new Error(text)); }
+      });
+    });
+  }
+  var supported = 'serviceWorker' in navigator && 'PushManager' in window;
+  return {
+    supported: supported,
+    subscribe: function() {
+      if (!supported) { return Promise.reject(new Error('push not supported')); }
+      return navigator.serviceWorker.register(conf.worker).then(function(reg) {
+        return reg.pushManager.subscribe({userVisibleOnly: true, applicationServerKey: key(conf.key)});
+      }).then(function(sub) { return post(conf.subscribe, sub); });
+    },
+    unsubscribe: function(path) {
+      if (!supported) { return Promise.resolve(); }
+      return navigator.serviceWorker.getRegistration(conf.worker).then(function(reg) {
+        return reg && reg.pushManager.getSubscription();
+      }).then(function(sub) {
+        if (!sub) { return; }
+        return post(path, sub).then(function() { return sub.unsubscribe(); });
+      });
+    }
+  };
+}`
+
+// Serves the service worker that shows the notifications. Its path
+// limits the pages it controls, serve it from the root.
+// Example: http.HandleFunc("/push-worker.js", push.ServiceWorkerHandler)
+func ServiceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, ServiceWorkerJS)
+}
+
+// Service worker that shows the messages sent with SendToUser
+const ServiceWorkerJS = `
+self.addEventListener('push', function(event) {
+  var m = event.data ? event.data.json() : {};
+  event.waitUntil(self.registration.showNotification(m.title || '', {
+    body: m.body, icon: m.icon, tag: m.tag, data: {url: m.url}
+  }));
+});
+self.addEventListener('notificationclick', function(event) {
+  event.notification.close();
+  var url = event.notification.data && event.notification.data.url;
+  if (url) { event.waitUntil(clients.openWindow(url)); }
+});
+`
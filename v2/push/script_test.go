@@ -0,0 +1,34 @@
+package push
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+)
+
+func TestPushTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := `{{define "base"}}{{if .}}{{push "/push/subscribe" "/push-worker.js"}}{{end}}{{end}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "base.html"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The template is parsed without the request
+	err = app.ExecTemplate(&app.TemplateConfig{
+		Names: []string{"base"},
+		W:     bytes.NewBuffer(nil),
+		Dir:   dir,
+	})
+	if err != nil {
+		t.Errorf("exec template with the push functions failed: %s", err)
+	}
+}
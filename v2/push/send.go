@@ -0,0 +1,188 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/tasks"
+)
+
+var (
+	// Number of subscriptions notified by each task
+	BatchSize = 100
+
+	// Time the push services keep the messages of offline browsers
+	DefaultTTL = time.Duration(24) * time.Hour
+)
+
+// Notification shown by the service worker of ServiceWorkerHandler
+type Message struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+
+	// Page opened when the user clicks the notification
+	URL  string `json:"url,omitempty"`
+	Icon string `json:"icon,omitempty"`
+
+	// Notifications with the same tag replace each other
+	Tag string `json:"tag,omitempty"`
+
+	// Name of a template registered with RegisterTemplate, executed
+	// with the data to fill the title & body
+	Template string      `json:"-"`
+	Data     interface{} `json:"-"`
+
+	TTL time.Duration `json:"-"`
+}
+
+type pushTemplate struct {
+	title, body *template.Template
+}
+
+var templates = map[string]*pushTemplate{}
+
+// Registers the title & body of a message template, with the
+// text/template syntax. Call it at init().
+// Example: push.RegisterTemplate("comment", "New comment", "{{.Author}} replied to your post")
+func RegisterTemplate(name, title, body string) {
+	templates[name] = &pushTemplate{
+		title: template.Must(template.New(name + "-title").Parse(title)),
+		body:  template.Must(template.New(name + "-body").Parse(body)),
+	}
+}
+
+type pushTask struct {
+	User    string
+	Payload []byte
+	TTL     time.Duration
+	Cursor  string
+}
+
+func init() {
+	tasks.Handle("push", sendBatch)
+}
+
+// Sends the message to all the browsers of the user in the background
+func SendToUser(c appengine.Context, user string, m *Message) error {
+	payload, err := preparePayload(m)
+	if err != nil {
+		return err
+	}
+	return tasks.Enqueue(c, "push", &pushTask{User: user, Payload: payload, TTL: m.ttl()})
+}
+
+// Sends the message to all the subscriptions in the background
+func Broadcast(c appengine.Context, m *Message) error {
+	return SendToUser(c, "", m)
+}
+
+func preparePayload(m *Message) ([]byte, error) {
+	if m.Template != "" {
+		t, ok := templates[m.Template]
+		if !ok {
+			return nil, fmt.Errorf("push template not registered: %s", m.Template)
+		}
+		title, body := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+		if err := t.title.Execute(title, m.Data); err != nil {
+			return nil, fmt.Errorf("exec push title template failed: %s", err)
+		}
+		if err := t.body.Execute(body, m.Data); err != nil {
+			return nil, fmt.Errorf("exec push body template failed: %s", err)
+		}
+		m.Title, m.Body = title.String(), body.String()
+	}
+
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("encode push message failed: %s", err)
+	}
+	return payload, nil
+}
+
+func (m *Message) ttl() time.Duration {
+	if m.TTL == 0 {
+		return DefaultTTL
+	}
+	return m.TTL
+}
+
+// Notifies a batch of subscriptions, removing the expired ones, and
+// enqueues the next one
+func sendBatch(r *app.Request, t *pushTask) error {
+	q := datastore.NewQuery(KindSubscription)
+	if t.User != "" {
+		q = q.Filter("User =", t.User)
+	}
+	if t.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(t.Cursor)
+		if err != nil {
+			return tasks.Permanent(fmt.Errorf("decode push cursor failed: %s", err))
+		}
+		q = q.Start(cursor)
+	}
+
+	keys := []*datastore.Key{}
+	subs := []*Subscription{}
+	it := q.Run(r.C)
+	for len(subs) < BatchSize {
+		s := new(Subscription)
+		key, err := it.Next(s)
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return fmt.Errorf("get push subscriptions failed: %s", err)
+		}
+		keys = append(keys, key)
+		subs = append(subs, s)
+	}
+
+	// Enqueue the next batch before sending, so a failure here doesn't
+	// repeat the whole list
+	if len(subs) == BatchSize {
+		cursor, err := it.Cursor()
+		if err != nil {
+			return fmt.Errorf("get push cursor failed: %s", err)
+		}
+		next := &pushTask{User: t.User, Payload: t.Payload, TTL: t.TTL, Cursor: cursor.String()}
+		if err := tasks.Enqueue(r.C, "push", next); err != nil {
+			return err
+		}
+	}
+
+	var mutex sync.Mutex
+	expired := []*datastore.Key{}
+	var wg sync.WaitGroup
+	for i, s := range subs {
+		wg.Add(1)
+		go func(key *datastore.Key, s *Subscription) {
+			defer wg.Done()
+			if err := send(r.C, s, t.Payload, t.TTL); err != nil {
+				if err == errExpired {
+					mutex.Lock()
+					expired = append(expired, key)
+					mutex.Unlock()
+					return
+				}
+				r.C.Warningf("[push] send to %s failed: %s", s.User, err)
+			}
+		}(keys[i], s)
+	}
+	wg.Wait()
+
+	if len(expired) > 0 {
+		r.C.Infof("[push] removing %d expired subscriptions", len(expired))
+		if err := datastore.DeleteMulti(r.C, expired); err != nil {
+			r.C.Errorf("[push] delete expired subscriptions failed: %s", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package push
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/auth"
+)
+
+const KindSubscription = "PushSubscription"
+
+// Push subscription of a browser, stored with the hash of the endpoint
+// as its ID. The keys are the ones sent by PushSubscription.toJSON().
+type Subscription struct {
+	User     string
+	Endpoint string `datastore:",noindex"`
+	P256dh   string `datastore:",noindex"`
+	Auth     string `datastore:",noindex"`
+	Created  time.Time
+}
+
+// Body sent by the subscription script
+type subscriptionData struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// Stores the subscription of the user, replacing the previous one of
+// the same endpoint.
+func Subscribe(c appengine.Context, user string, s *Subscription) error {
+	s.User = user
+	if s.Created.IsZero() {
+		s.Created = time.Now()
+	}
+	if _, err := datastore.Put(c, subscriptionKey(c, s.Endpoint), s); err != nil {
+		return fmt.Errorf("put push subscription failed: %s", err)
+	}
+	return nil
+}
+
+// Removes the subscription of the endpoint
+func Unsubscribe(c appengine.Context, endpoint string) error {
+	if err := datastore.Delete(c, subscriptionKey(c, endpoint)); err != nil && err != datastore.ErrNoSuchEntity {
+		return fmt.Errorf("delete push subscription failed: %s", err)
+	}
+	return nil
+}
+
+// Returns the subscriptions of all the browsers of the user
+func Subscriptions(c appengine.Context, user string) ([]*Subscription, error) {
+	subs := []*Subscription{}
+	q := datastore.NewQuery(KindSubscription).Filter("User =", user)
+	if _, err := q.GetAll(c, &subs); err != nil {
+		return nil, fmt.Errorf("get push subscriptions failed: %s", err)
+	}
+	return subs, nil
+}
+
+// Stores the subscription sent by the script of Script for the logged
+// user (see auth.Identity).
+// Example: "POST::/push/subscribe": push.SubscribeHandler,
+func SubscribeHandler(r *app.Request) error {
+	email, err := auth.Identity.Email(r)
+	if err != nil {
+		return fmt.Errorf("get push user failed: %s", err)
+	}
+	if email == "" {
+		return app.Unauthorized()
+	}
+
+	data := new(subscriptionData)
+	if err := r.LoadJsonData(data); err != nil {
+		return err
+	}
+	if data.Endpoint == "" || data.Keys.P256dh == "" || data.Keys.Auth == "" {
+		return app.Forbidden()
+	}
+
+	return Subscribe(r.C, email, &Subscription{
+		Endpoint: data.Endpoint,
+		P256dh:   data.Keys.P256dh,
+		Auth:     data.Keys.Auth,
+	})
+}
+
+// Removes the subscription of the endpoint sent by the script when the
+// user disables the notifications.
+// Example: "POST::/push/unsubscribe": push.UnsubscribeHandler,
+func UnsubscribeHandler(r *app.Request) error {
+	data := new(subscriptionData)
+	if err := r.LoadJsonData(data); err != nil {
+		return err
+	}
+
+	// Only the owner can remove it
+	email, err := auth.Identity.Email(r)
+	if err != nil {
+		return fmt.Errorf("get push user failed: %s", err)
+	}
+	s := new(Subscription)
+	if err := datastore.Get(r.C, subscriptionKey(r.C, data.Endpoint), s); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		return fmt.Errorf("get push subscription failed: %s", err)
+	}
+	if email == "" || s.User != email {
+		return app.Forbidden()
+	}
+
+	return Unsubscribe(r.C, data.Endpoint)
+}
+
+// The endpoints are too long for the key names
+func subscriptionKey(c appengine.Context, endpoint string) *datastore.Key {
+	hash := sha256.Sum256([]byte(endpoint))
+	return datastore.NewKey(c, KindSubscription, hex.EncodeToString(hash[:]), 0, nil)
+}
@@ -0,0 +1,213 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+// Returned by send when the subscription has expired or was removed
+// by the user in the browser
+var errExpired = fmt.Errorf("push subscription expired")
+
+var vapid struct {
+	key     *ecdsa.PrivateKey
+	public  string
+	subject string
+}
+
+// Sets the VAPID keys that identify the application in the push
+// services, encoded as base64url like the ones of GenerateVAPIDKeys.
+// The subject is a mailto: or https: contact URL. Call it at init().
+// Example: push.SetVAPIDKeys(conf.VAPIDPublic, conf.VAPIDPrivate, "mailto:admin@example.com")
+func SetVAPIDKeys(public, private, subject string) {
+	d, err := base64.RawURLEncoding.DecodeString(private)
+	if err != nil {
+		panic(fmt.Sprintf("decode vapid private key failed: %s", err))
+	}
+
+	key := new(ecdsa.PrivateKey)
+	key.Curve = elliptic.P256()
+	key.D = new(big.Int).SetBytes(d)
+	key.X, key.Y = key.Curve.ScalarBaseMult(d)
+	if base64.RawURLEncoding.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y)) != public {
+		panic("the vapid public key doesn't match the private one")
+	}
+
+	vapid.key, vapid.public, vapid.subject = key, public, subject
+}
+
+// Returns a new pair of VAPID keys, encoded as base64url
+func GenerateVAPIDKeys() (public, private string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("generate vapid keys failed: %s", err)
+	}
+	public = base64.RawURLEncoding.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y))
+	private = base64.RawURLEncoding.EncodeToString(pad(key.D.Bytes(), 32))
+	return public, private, nil
+}
+
+// Sends the payload to the subscription with the Web Push protocol: the
+// payload is encrypted as aes128gcm (RFC 8291) and the request signed
+// with VAPID (RFC 8292).
+func send(c appengine.Context, s *Subscription, payload []byte, ttl time.Duration) error {
+	if vapid.key == nil {
+		return fmt.Errorf("vapid keys not configured")
+	}
+
+	body, err := encrypt(s, payload)
+	if err != nil {
+		return err
+	}
+	auth, err := vapidAuthorization(s.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("prepare push request failed: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", fmt.Sprintf("%d", int64(ttl.Seconds())))
+	req.Header.Set("Authorization", auth)
+
+	client := platform.Current.HTTPClient(c, time.Duration(10)*time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("push request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errExpired
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push service error: %s", resp.Status)
+	}
+	return nil
+}
+
+// Encrypts the payload for the keys of the subscription
+func encrypt(s *Subscription, payload []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	uaPublic, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s.P256dh, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode subscription key failed: %s", err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s.Auth, "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode subscription auth failed: %s", err)
+	}
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, fmt.Errorf("bad subscription key")
+	}
+
+	// Ephemeral key of the application server
+	asPrivate, asX, asY, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate push key failed: %s", err)
+	}
+	asPublic := elliptic.Marshal(curve, asX, asY)
+	sx, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	ecdhSecret := pad(sx.Bytes(), 32)
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate push salt failed: %s", err)
+	}
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, fmt.Errorf("prepare push cipher failed: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("prepare push cipher failed: %s", err)
+	}
+
+	// One record with the final padding delimiter
+	ciphertext := gcm.Seal(nil, nonce, append(payload, 2), nil)
+
+	header := bytes.NewBuffer(salt)
+	binary.Write(header, binary.BigEndian, uint32(4096))
+	header.WriteByte(byte(len(asPublic)))
+	header.Write(asPublic)
+	return append(header.Bytes(), ciphertext...), nil
+}
+
+// HKDF with SHA-256 for outputs up to one hash long
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+// Returns the Authorization header with the VAPID token for the origin
+// of the endpoint
+func vapidAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("parse push endpoint failed: %s", err)
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(time.Duration(12) * time.Hour).Unix(),
+		"sub": vapid.subject,
+	})
+	if err != nil {
+		return "", fmt.Errorf("encode vapid claims failed: %s", err)
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, vapid.key, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("sign vapid token failed: %s", err)
+	}
+	signature := append(pad(r.Bytes(), 32), pad(s.Bytes(), 32)...)
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	return fmt.Sprintf("vapid t=%s, k=%s", token, vapid.public), nil
+}
+
+// Left pads the big endian number to the size
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
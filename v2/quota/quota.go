@@ -0,0 +1,203 @@
+package quota
+
+import (
+	"fmt"
+	"html/template"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/user"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/store"
+)
+
+const KindQuotaShard = "QuotaShard"
+
+// Number of shards of each counter. More shards allow more
+// concurrent requests of the same key.
+var Shards = 20
+
+type shard struct {
+	Key   string
+	Day   string
+	Count int64 `datastore:",noindex"`
+}
+
+// Returns the identifier the quota is accounted to: an API key,
+// the user ID, etc. An empty string skips the accounting.
+type KeyFunc func(r *app.Request) string
+
+// Decorates the handler to allow only perDay requests per key and day
+// (in UTC). When the quota is exhausted a 429 error is returned.
+// The X-RateLimit-Limit and X-RateLimit-Remaining headers are emitted
+// in every response.
+func Limit(perDay int64, keyFunc KeyFunc, h app.Handler) app.Handler {
+	return app.Wrap(h, func(r *app.Request) error {
+		key := keyFunc(r)
+		if key == "" {
+			return h(r)
+		}
+
+		count, err := Increment(r.C, key)
+		if err != nil {
+			return err
+		}
+
+		remaining := perDay - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		r.W.Header().Set("X-RateLimit-Limit", strconv.FormatInt(perDay, 10))
+		r.W.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+
+		if count > perDay {
+			r.W.Header().Set("Retry-After", strconv.Itoa(secondsToMidnight()))
+			return app.HttpError(429)
+		}
+
+		return h(r)
+	})
+}
+
+// Adds one request to the counter of the key for today, returning
+// the total count.
+func Increment(c appengine.Context, key string) (int64, error) {
+	day := today()
+	id := fmt.Sprintf("%s|%s|%d", key, day, rand.Intn(Shards))
+	dkey := datastore.NewKey(c, KindQuotaShard, id, 0, nil)
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		s := &shard{Key: key, Day: day}
+		if err := datastore.Get(c, dkey, s); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		s.Count++
+		_, err := datastore.Put(c, dkey, s)
+		return err
+	}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("increment quota shard failed: %s", err)
+	}
+
+	// The total is cached to avoid reading all the shards each time
+	st := store.New(c)
+	total, err := st.Increment(cacheKey(key, day), 1, 0)
+	if err == nil && total > 1 {
+		return int64(total), nil
+	}
+
+	count, err := Count(c, key, day)
+	if err != nil {
+		return 0, err
+	}
+	value := []byte(strconv.FormatInt(count, 10))
+	if err := st.Set(cacheKey(key, day), value, 25*time.Hour); err != nil {
+		c.Warningf("[quota] set cache failed: %s", err)
+	}
+
+	return count, nil
+}
+
+// Returns the count of the key in the day (formatted as 2006-01-02)
+func Count(c appengine.Context, key, day string) (int64, error) {
+	keys := make([]*datastore.Key, Shards)
+	for i := range keys {
+		id := fmt.Sprintf("%s|%s|%d", key, day, i)
+		keys[i] = datastore.NewKey(c, KindQuotaShard, id, 0, nil)
+	}
+
+	shards := make([]*shard, Shards)
+	for i := range shards {
+		shards[i] = new(shard)
+	}
+	if err := datastore.GetMulti(c, keys, shards); err != nil {
+		merr, ok := err.(appengine.MultiError)
+		if !ok {
+			return 0, fmt.Errorf("get quota shards failed: %s", err)
+		}
+		for _, e := range merr {
+			if e != nil && e != datastore.ErrNoSuchEntity {
+				return 0, fmt.Errorf("get quota shards failed: %s", e)
+			}
+		}
+	}
+
+	var total int64
+	for _, s := range shards {
+		total += s.Count
+	}
+	return total, nil
+}
+
+var adminTemplate = template.Must(template.New("quota").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Quota consumption {{.Day}}</title></head>
+<body>
+  <h1>Quota consumption {{.Day}}</h1>
+  <table>
+    <tr><th>Key</th><th>Requests</th></tr>
+    {{range .Rows}}<tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>{{end}}
+  </table>
+</body>
+</html>
+`))
+
+// Admin page with the consumption per key of a day (the day param
+// of the query string, today by default).
+// Example: "GET::/admin/quota": quota.AdminHandler,
+func AdminHandler(r *app.Request) error {
+	if !user.IsAdmin(r.C) {
+		return app.Forbidden()
+	}
+
+	day := r.Req.FormValue("day")
+	if day == "" {
+		day = today()
+	}
+
+	var shards []*shard
+	q := datastore.NewQuery(KindQuotaShard).Filter("Day =", day)
+	if _, err := q.GetAll(r.C, &shards); err != nil {
+		return fmt.Errorf("query quota shards failed: %s", err)
+	}
+
+	totals := map[string]int64{}
+	for _, s := range shards {
+		totals[s.Key] += s.Count
+	}
+
+	type row struct {
+		Key   string
+		Count int64
+	}
+	rows := []*row{}
+	for key, count := range totals {
+		rows = append(rows, &row{key, count})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Count > rows[j].Count })
+
+	data := map[string]interface{}{"Day": day, "Rows": rows}
+	if err := adminTemplate.Execute(r.W, data); err != nil {
+		return fmt.Errorf("exec quota template failed: %s", err)
+	}
+
+	return nil
+}
+
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+func cacheKey(key, day string) string {
+	return "quota:" + key + ":" + day
+}
+
+func secondsToMidnight() int {
+	now := time.Now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int(midnight.Sub(now).Seconds())
+}
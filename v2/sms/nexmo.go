@@ -0,0 +1,126 @@
+package sms
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/app/httpclient"
+)
+
+const nexmoAPI = "https://rest.nexmo.com/sms/json"
+
+// Transport of the Nexmo API
+type Nexmo struct {
+	APIKey, APISecret string
+
+	// Public URL of StatusHandler
+	StatusURL string
+
+	// Nexmo doesn't sign the webhooks; this token is added to the
+	// StatusURL and should be included as the token param of the
+	// inbound URL configured in Nexmo too. It's required, the webhooks
+	// are rejected without it.
+	WebhookToken string
+}
+
+// Response of the Nexmo API
+type nexmoAPIResponse struct {
+	Messages []struct {
+		Status    string `json:"status"`
+		MessageID string `json:"message-id"`
+		ErrorText string `json:"error-text"`
+	} `json:"messages"`
+}
+
+func (n *Nexmo) Send(c appengine.Context, m *Message) (string, error) {
+	data := url.Values{
+		"api_key":    []string{n.APIKey},
+		"api_secret": []string{n.APISecret},
+		"from":       []string{strings.TrimPrefix(m.From, "+")},
+		"to":         []string{strings.TrimPrefix(m.To, "+")},
+		"text":       []string{m.Body},
+		"type":       []string{"unicode"},
+	}
+	if n.StatusURL != "" {
+		if n.WebhookToken == "" {
+			return "", fmt.Errorf("nexmo status url without webhook token")
+		}
+		sep := "?"
+		if strings.Contains(n.StatusURL, "?") {
+			sep = "&"
+		}
+		callback := n.StatusURL + sep + url.Values{"token": {n.WebhookToken}}.Encode()
+		data.Set("status-report-req", "1")
+		data.Set("callback", callback)
+	}
+
+	result := new(nexmoAPIResponse)
+	if err := httpclient.PostForm(c, nexmoAPI, data, result); err != nil {
+		return "", fmt.Errorf("nexmo request failed: %s", err)
+	}
+	if len(result.Messages) == 0 {
+		return "", fmt.Errorf("nexmo response without messages")
+	}
+
+	// Long messages are split in parts; the first one identifies it
+	msg := result.Messages[0]
+	if msg.Status != "0" {
+		return "", fmt.Errorf("nexmo error %s: %s", msg.Status, msg.ErrorText)
+	}
+	return msg.MessageID, nil
+}
+
+func (n *Nexmo) ParseStatus(c appengine.Context, req *http.Request) (*Status, error) {
+	if err := n.checkToken(req); err != nil {
+		return nil, err
+	}
+
+	status := &Status{ID: req.Form.Get("messageId")}
+	switch req.Form.Get("status") {
+	case "delivered":
+		status.Status = StatusDelivered
+	case "failed", "rejected", "expired":
+		status.Status = StatusFailed
+		status.Error = req.Form.Get("err-code")
+	default:
+		status.Status = StatusSent
+	}
+	if status.ID == "" {
+		return nil, fmt.Errorf("nexmo status without message id")
+	}
+
+	return status, nil
+}
+
+func (n *Nexmo) ParseInbound(c appengine.Context, req *http.Request) (*Inbound, error) {
+	if err := n.checkToken(req); err != nil {
+		return nil, err
+	}
+
+	return &Inbound{
+		From: NormalizePhone("+" + req.Form.Get("msisdn")),
+		To:   NormalizePhone("+" + req.Form.Get("to")),
+		Body: req.Form.Get("text"),
+	}, nil
+}
+
+// Nexmo sends the webhooks as GET or POST, both end in req.Form
+func (n *Nexmo) checkToken(req *http.Request) error {
+	if err := req.ParseForm(); err != nil {
+		return fmt.Errorf("parse nexmo webhook failed: %s", err)
+	}
+	if n.WebhookToken == "" {
+		return fmt.Errorf("nexmo webhook token not configured")
+	}
+
+	token := req.Form.Get("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(n.WebhookToken)) != 1 {
+		return fmt.Errorf("bad nexmo webhook token")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package sms
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+const KindOptOut = "SMSOptOut"
+
+// Keywords of the inbound messages that opt out & in again
+var (
+	StopKeywords  = []string{"STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT"}
+	StartKeywords = []string{"START", "YES", "UNSTOP"}
+)
+
+// Inbound message received in a webhook
+type Inbound struct {
+	From, To, Body string
+}
+
+// Recipient that doesn't want more messages, stored with the
+// normalized phone as its ID
+type OptOut struct {
+	Date    time.Time
+	Keyword string
+}
+
+// Stops sending messages to the phone
+func SetOptOut(c appengine.Context, phone, keyword string) error {
+	o := &OptOut{Date: time.Now(), Keyword: keyword}
+	if _, err := datastore.Put(c, optOutKey(c, phone), o); err != nil {
+		return fmt.Errorf("put sms opt out failed: %s", err)
+	}
+	return nil
+}
+
+// Sends messages to the phone again
+func SetOptIn(c appengine.Context, phone string) error {
+	if err := datastore.Delete(c, optOutKey(c, phone)); err != nil && err != datastore.ErrNoSuchEntity {
+		return fmt.Errorf("delete sms opt out failed: %s", err)
+	}
+	return nil
+}
+
+// Returns true if the recipient of the phone opted out
+func IsOptedOut(c appengine.Context, phone string) (bool, error) {
+	if err := datastore.Get(c, optOutKey(c, phone), new(OptOut)); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return false, nil
+		}
+		return false, fmt.Errorf("get sms opt out failed: %s", err)
+	}
+	return true, nil
+}
+
+// Function called with the inbound messages that are not opt out or
+// opt in keywords. Set it at init().
+var InboundFunc func(c appengine.Context, m *Inbound) error
+
+// Receives the inbound messages of the provider, tracking the opt out
+// keywords. Register it directly in net/http like StatusHandler.
+// Example: http.HandleFunc("/sms/inbound", sms.InboundHandler)
+func InboundHandler(w http.ResponseWriter, req *http.Request) {
+	c := platform.Current.NewContext(req)
+	if DefaultTransport == nil {
+		c.Errorf("[sms] inbound message received without transport")
+		http.Error(w, "not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	m, err := DefaultTransport.ParseInbound(c, req)
+	if err != nil {
+		c.Warningf("[sms] bad inbound webhook: %s", err)
+		http.Error(w, "bad request", http.StatusForbidden)
+		return
+	}
+
+	keyword := strings.ToUpper(strings.TrimSpace(m.Body))
+	switch {
+	case hasKeyword(StopKeywords, keyword):
+		err = SetOptOut(c, m.From, keyword)
+	case hasKeyword(StartKeywords, keyword):
+		err = SetOptIn(c, m.From)
+	case InboundFunc != nil:
+		err = InboundFunc(c, m)
+	}
+	if err != nil {
+		c.Errorf("[sms] process inbound message failed: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func hasKeyword(keywords []string, keyword string) bool {
+	for _, k := range keywords {
+		if k == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+func optOutKey(c appengine.Context, phone string) *datastore.Key {
+	return datastore.NewKey(c, KindOptOut, NormalizePhone(phone), 0, nil)
+}
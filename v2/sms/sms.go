@@ -0,0 +1,138 @@
+package sms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/app"
+	"github.com/ernestokarim/gaelib/v2/app/tasks"
+)
+
+var (
+	// Provider used to send the messages. Set it at init().
+	// Example: sms.DefaultTransport = &sms.Twilio{AccountSID: "...", AuthToken: "..."}
+	DefaultTransport Transport
+
+	// Sender of the messages without From
+	DefaultFrom string
+
+	// Returned when the recipient asked not to receive more messages
+	ErrOptedOut = errors.New("recipient opted out")
+)
+
+type Message struct {
+	To, From string
+
+	// Text of the message, or the name of a template registered with
+	// RegisterTemplate executed with the data
+	Body     string
+	Template string
+	Data     interface{}
+
+	// Sent even if the recipient opted out. Use it only for messages
+	// requested by the user, like the OTP codes.
+	Transactional bool
+}
+
+// Provider that sends the messages and parses its webhooks
+type Transport interface {
+	// Sends the message and returns the ID assigned by the provider
+	Send(c appengine.Context, m *Message) (string, error)
+
+	// Extracts the delivery report of a status webhook
+	ParseStatus(c appengine.Context, req *http.Request) (*Status, error)
+
+	// Extracts the message of an inbound webhook
+	ParseInbound(c appengine.Context, req *http.Request) (*Inbound, error)
+}
+
+var templates = template.New("sms")
+
+// Registers a message template. The text/template syntax is used, the
+// messages are not HTML. Call it at init().
+// Example: sms.RegisterTemplate("otp", "Your code is {{.Code}}")
+func RegisterTemplate(name, text string) {
+	template.Must(templates.New(name).Parse(text))
+}
+
+func init() {
+	tasks.Handle("sms", sendTask)
+}
+
+// Sends the message directly, recording its delivery status
+func Send(c appengine.Context, m *Message) error {
+	if DefaultTransport == nil {
+		return fmt.Errorf("sms transport not configured")
+	}
+
+	m.To = NormalizePhone(m.To)
+	if m.From == "" {
+		m.From = DefaultFrom
+	}
+	if m.Template != "" {
+		buf := bytes.NewBuffer(nil)
+		if err := templates.ExecuteTemplate(buf, m.Template, m.Data); err != nil {
+			return fmt.Errorf("exec sms template failed: %s", err)
+		}
+		m.Body = buf.String()
+	}
+
+	if !m.Transactional {
+		opted, err := IsOptedOut(c, m.To)
+		if err != nil {
+			return err
+		}
+		if opted {
+			return ErrOptedOut
+		}
+	}
+
+	id, err := DefaultTransport.Send(c, m)
+	if err != nil {
+		return fmt.Errorf("send sms failed: %s", err)
+	}
+
+	return saveDelivery(c, id, &Delivery{
+		To:      m.To,
+		Status:  StatusQueued,
+		Sent:    time.Now(),
+		Updated: time.Now(),
+	})
+}
+
+// Enqueues the message in the sms task, so the latency of the request
+// is not affected. The templates are executed when sending it.
+func SendLater(c appengine.Context, m *Message) error {
+	return tasks.Enqueue(c, "sms", m)
+}
+
+func sendTask(r *app.Request, m *Message) error {
+	if err := Send(r.C, m); err != nil {
+		if err == ErrOptedOut {
+			r.C.Infof("[sms] not sent to %s, recipient opted out", m.To)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Removes the formatting of a phone number, leaving the digits and
+// the + of the international prefix.
+func NormalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	out := ""
+	for i, c := range phone {
+		if (c >= '0' && c <= '9') || (c == '+' && i == 0) {
+			out += string(c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+package sms
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+
+	"github.com/ernestokarim/gaelib/v2/internal/platform"
+)
+
+const KindDelivery = "SMSDelivery"
+
+// Normalized delivery statuses of the providers
+const (
+	StatusQueued    = "queued"
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Delivery report of a status webhook
+type Status struct {
+	ID     string
+	Status string
+	Error  string
+}
+
+// Delivery status of a message, stored with the ID of the provider
+type Delivery struct {
+	To            string
+	Status        string
+	Error         string `datastore:",noindex"`
+	Sent, Updated time.Time
+}
+
+// Returns the delivery status of the message with the provider ID
+func GetDelivery(c appengine.Context, id string) (*Delivery, error) {
+	d := new(Delivery)
+	if err := datastore.Get(c, deliveryKey(c, id), d); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get sms delivery failed: %s", err)
+	}
+	return d, nil
+}
+
+// Receives the delivery reports of the provider. Register it directly
+// in net/http, the providers can't send the XSRF token of the app
+// router; use the same path in the StatusURL of the transport.
+// Example: http.HandleFunc("/sms/status", sms.StatusHandler)
+func StatusHandler(w http.ResponseWriter, req *http.Request) {
+	c := platform.Current.NewContext(req)
+	if DefaultTransport == nil {
+		c.Errorf("[sms] status received without transport")
+		http.Error(w, "not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	status, err := DefaultTransport.ParseStatus(c, req)
+	if err != nil {
+		c.Warningf("[sms] bad status webhook: %s", err)
+		http.Error(w, "bad request", http.StatusForbidden)
+		return
+	}
+
+	d := &Delivery{Status: status.Status, Error: status.Error, Updated: time.Now()}
+	if err := saveDelivery(c, status.ID, d); err != nil {
+		c.Errorf("[sms] update delivery %s failed: %s", status.ID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Order of the statuses; the final ones have the same
+var statusOrder = map[string]int{
+	StatusQueued:    0,
+	StatusSent:      1,
+	StatusDelivered: 2,
+	StatusFailed:    2,
+}
+
+// Stores the delivery, only moving its status forward: the reports can
+// arrive out of order, even before Send records the queued message.
+func saveDelivery(c appengine.Context, id string, update *Delivery) error {
+	key := deliveryKey(c, id)
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		d := new(Delivery)
+		if err := datastore.Get(c, key, d); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		if update.To != "" {
+			d.To = update.To
+		}
+		if !update.Sent.IsZero() {
+			d.Sent = update.Sent
+		}
+		if d.Status == "" || statusOrder[update.Status] > statusOrder[d.Status] {
+			d.Status, d.Error, d.Updated = update.Status, update.Error, update.Updated
+		}
+
+		_, err := datastore.Put(c, key, d)
+		return err
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("put sms delivery failed: %s", err)
+	}
+	return nil
+}
+
+func deliveryKey(c appengine.Context, id string) *datastore.Key {
+	return datastore.NewKey(c, KindDelivery, id, 0, nil)
+}
@@ -0,0 +1,134 @@
+package sms
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"appengine"
+
+	"github.com/ernestokarim/gaelib/v2/app/httpclient"
+)
+
+const twilioAPI = "https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json"
+
+// Transport of the Twilio API
+type Twilio struct {
+	AccountSID, AuthToken string
+
+	// Public URLs of StatusHandler & InboundHandler, exactly as they're
+	// configured in Twilio. They're used to check the signatures of the
+	// webhooks.
+	StatusURL, InboundURL string
+}
+
+// Response of the Twilio API
+type twilioAPIResponse struct {
+	Sid     string `json:"sid"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (t *Twilio) Send(c appengine.Context, m *Message) (string, error) {
+	data := url.Values{
+		"To":   []string{m.To},
+		"From": []string{m.From},
+		"Body": []string{m.Body},
+	}
+	if t.StatusURL != "" {
+		data.Set("StatusCallback", t.StatusURL)
+	}
+
+	client := &httpclient.Client{
+		Deadline: time.Duration(10) * time.Second,
+		Username: t.AccountSID,
+		Password: t.AuthToken,
+	}
+	result := new(twilioAPIResponse)
+	if err := client.PostForm(c, fmt.Sprintf(twilioAPI, t.AccountSID), data, result); err != nil {
+		// The errors of the API have a code & a message
+		if herr, ok := err.(*httpclient.HTTPError); ok && json.Unmarshal([]byte(herr.Body), result) == nil {
+			return "", fmt.Errorf("twilio error %d: %s", result.Code, result.Message)
+		}
+		return "", fmt.Errorf("twilio request failed: %s", err)
+	}
+
+	return result.Sid, nil
+}
+
+func (t *Twilio) ParseStatus(c appengine.Context, req *http.Request) (*Status, error) {
+	if err := t.checkSignature(req, t.StatusURL); err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		ID:    req.PostForm.Get("MessageSid"),
+		Error: req.PostForm.Get("ErrorCode"),
+	}
+	switch req.PostForm.Get("MessageStatus") {
+	case "sent":
+		status.Status = StatusSent
+	case "delivered":
+		status.Status = StatusDelivered
+	case "undelivered", "failed":
+		status.Status = StatusFailed
+	default:
+		status.Status = StatusQueued
+	}
+	if status.ID == "" {
+		return nil, fmt.Errorf("twilio status without message sid")
+	}
+
+	return status, nil
+}
+
+func (t *Twilio) ParseInbound(c appengine.Context, req *http.Request) (*Inbound, error) {
+	if err := t.checkSignature(req, t.InboundURL); err != nil {
+		return nil, err
+	}
+
+	return &Inbound{
+		From: NormalizePhone(req.PostForm.Get("From")),
+		To:   NormalizePhone(req.PostForm.Get("To")),
+		Body: req.PostForm.Get("Body"),
+	}, nil
+}
+
+// Checks the X-Twilio-Signature header: the HMAC-SHA1 of the URL
+// followed by the sorted POST params, keyed with the auth token.
+func (t *Twilio) checkSignature(req *http.Request, u string) error {
+	if err := req.ParseForm(); err != nil {
+		return fmt.Errorf("parse twilio webhook failed: %s", err)
+	}
+	if u == "" {
+		return fmt.Errorf("twilio webhook url not configured")
+	}
+
+	keys := []string{}
+	for k := range req.PostForm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	signed := u
+	for _, k := range keys {
+		for _, v := range req.PostForm[k] {
+			signed += k + v
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(t.AuthToken))
+	mac.Write([]byte(signed))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(req.Header.Get("X-Twilio-Signature"))) {
+		return fmt.Errorf("bad twilio signature")
+	}
+
+	return nil
+}
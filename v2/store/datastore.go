@@ -0,0 +1,187 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+const KindStoreItem = "StoreItem"
+
+type entity struct {
+	Value   []byte    `datastore:",noindex"`
+	Expires time.Time `datastore:",noindex"`
+	Version int64     `datastore:",noindex"`
+}
+
+func (e *entity) expired() bool {
+	return !e.Expires.IsZero() && time.Now().After(e.Expires)
+}
+
+type datastoreStore struct {
+	c appengine.Context
+}
+
+// Store backed by the datastore. Slower than memcache but the values
+// are never evicted. Expired entities are not deleted automatically.
+func Datastore(c appengine.Context) Store {
+	return &datastoreStore{c}
+}
+
+func (s *datastoreStore) key(key string) *datastore.Key {
+	return datastore.NewKey(s.c, KindStoreItem, key, 0, nil)
+}
+
+// Returns the entity of the key, or nil if it doesn't exist or has expired
+func (s *datastoreStore) get(c appengine.Context, key string) (*entity, error) {
+	e := new(entity)
+	if err := datastore.Get(c, s.key(key), e); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if e.expired() {
+		return nil, nil
+	}
+	return e, nil
+}
+
+func (s *datastoreStore) put(c appengine.Context, key string, e *entity, ttl time.Duration) error {
+	e.Expires = time.Time{}
+	if ttl > 0 {
+		e.Expires = time.Now().Add(ttl)
+	}
+	e.Version++
+	_, err := datastore.Put(c, s.key(key), e)
+	return err
+}
+
+func (s *datastoreStore) Get(key string) (*Item, error) {
+	e, err := s.get(s.c, key)
+	if err != nil {
+		return nil, fmt.Errorf("datastore get failed: %s", err)
+	}
+	if e == nil {
+		return nil, ErrNotFound
+	}
+	return &Item{Key: key, Value: e.Value, token: e.Version}, nil
+}
+
+func (s *datastoreStore) Set(key string, value []byte, ttl time.Duration) error {
+	err := datastore.RunInTransaction(s.c, func(c appengine.Context) error {
+		e, err := s.get(c, key)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			e = new(entity)
+		}
+		e.Value = value
+		return s.put(c, key, e, ttl)
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("datastore set failed: %s", err)
+	}
+	return nil
+}
+
+func (s *datastoreStore) Add(key string, value []byte, ttl time.Duration) error {
+	var stored bool
+	err := datastore.RunInTransaction(s.c, func(c appengine.Context) error {
+		e, err := s.get(c, key)
+		if err != nil {
+			return err
+		}
+		if stored = e == nil; !stored {
+			return nil
+		}
+		return s.put(c, key, &entity{Value: value}, ttl)
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("datastore add failed: %s", err)
+	}
+	if !stored {
+		return ErrNotStored
+	}
+	return nil
+}
+
+func (s *datastoreStore) Delete(key string) error {
+	if err := datastore.Delete(s.c, s.key(key)); err != nil && err != datastore.ErrNoSuchEntity {
+		return fmt.Errorf("datastore delete failed: %s", err)
+	}
+	return nil
+}
+
+func (s *datastoreStore) Increment(key string, delta int64, initial uint64) (uint64, error) {
+	var n uint64
+	err := datastore.RunInTransaction(s.c, func(c appengine.Context) error {
+		e, err := s.get(c, key)
+		if err != nil {
+			return err
+		}
+
+		var ttl time.Duration
+		n = initial
+		if e == nil {
+			e = new(entity)
+		} else {
+			if n, err = strconv.ParseUint(string(e.Value), 10, 64); err != nil {
+				return fmt.Errorf("value is not a number: %s", err)
+			}
+			if !e.Expires.IsZero() {
+				ttl = e.Expires.Sub(time.Now())
+			}
+		}
+
+		// Same semantics as memcache: decrements stop at zero
+		if delta < 0 && uint64(-delta) > n {
+			n = 0
+		} else {
+			n = uint64(int64(n) + delta)
+		}
+		e.Value = []byte(strconv.FormatUint(n, 10))
+		return s.put(c, key, e, ttl)
+	}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("datastore increment failed: %s", err)
+	}
+	return n, nil
+}
+
+func (s *datastoreStore) CompareAndSwap(item *Item) error {
+	version, ok := item.token.(int64)
+	if !ok {
+		return fmt.Errorf("datastore cas failed: item not returned by Get")
+	}
+
+	var result error
+	err := datastore.RunInTransaction(s.c, func(c appengine.Context) error {
+		e, err := s.get(c, item.Key)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			result = ErrNotStored
+			return nil
+		}
+		if e.Version != version {
+			result = ErrConflict
+			return nil
+		}
+		e.Value = item.Value
+		if err := s.put(c, item.Key, e, item.TTL); err != nil {
+			return err
+		}
+		item.token = e.Version
+		return nil
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("datastore cas failed: %s", err)
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"appengine"
+	"appengine/memcache"
+)
+
+type memcacheStore struct {
+	c appengine.Context
+}
+
+// Store backed by memcache. Values can be evicted before they expire,
+// use it for data that can be lost.
+func Memcache(c appengine.Context) Store {
+	return &memcacheStore{c}
+}
+
+func (s *memcacheStore) Get(key string) (*Item, error) {
+	item, err := memcache.Get(s.c, key)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("memcache get failed: %s", err)
+	}
+	return &Item{Key: key, Value: item.Value, token: item}, nil
+}
+
+func (s *memcacheStore) Set(key string, value []byte, ttl time.Duration) error {
+	item := &memcache.Item{Key: key, Value: value, Expiration: ttl}
+	if err := memcache.Set(s.c, item); err != nil {
+		return fmt.Errorf("memcache set failed: %s", err)
+	}
+	return nil
+}
+
+func (s *memcacheStore) Add(key string, value []byte, ttl time.Duration) error {
+	item := &memcache.Item{Key: key, Value: value, Expiration: ttl}
+	if err := memcache.Add(s.c, item); err != nil {
+		if err == memcache.ErrNotStored {
+			return ErrNotStored
+		}
+		return fmt.Errorf("memcache add failed: %s", err)
+	}
+	return nil
+}
+
+func (s *memcacheStore) Delete(key string) error {
+	if err := memcache.Delete(s.c, key); err != nil && err != memcache.ErrCacheMiss {
+		return fmt.Errorf("memcache delete failed: %s", err)
+	}
+	return nil
+}
+
+func (s *memcacheStore) Increment(key string, delta int64, initial uint64) (uint64, error) {
+	n, err := memcache.Increment(s.c, key, delta, initial)
+	if err != nil {
+		return 0, fmt.Errorf("memcache increment failed: %s", err)
+	}
+	return n, nil
+}
+
+func (s *memcacheStore) CompareAndSwap(item *Item) error {
+	mitem, ok := item.token.(*memcache.Item)
+	if !ok {
+		return fmt.Errorf("memcache cas failed: item not returned by Get")
+	}
+	mitem.Value = item.Value
+	mitem.Expiration = item.TTL
+	if err := memcache.CompareAndSwap(s.c, mitem); err != nil {
+		switch err {
+		case memcache.ErrCASConflict:
+			return ErrConflict
+		case memcache.ErrNotStored:
+			return ErrNotStored
+		}
+		return fmt.Errorf("memcache cas failed: %s", err)
+	}
+	return nil
+}
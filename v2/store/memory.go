@@ -0,0 +1,153 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"appengine"
+)
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+	version int64
+}
+
+var (
+	memoryMu      sync.Mutex
+	memoryEntries = map[string]*memoryEntry{}
+)
+
+type memoryStore struct{}
+
+// Store that keeps the values in the memory of the instance. It's not
+// shared between instances; use it in tests or local development.
+// Example: store.Default = store.Memory
+func Memory(c appengine.Context) Store {
+	return memoryStore{}
+}
+
+// Removes all the values of the memory stores
+func ResetMemory() {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	memoryEntries = map[string]*memoryEntry{}
+}
+
+// Returns the entry of the key, or nil if it doesn't exist or has expired.
+// The mutex should be held by the caller.
+func (s memoryStore) get(key string) *memoryEntry {
+	e := memoryEntries[key]
+	if e == nil {
+		return nil
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(memoryEntries, key)
+		return nil
+	}
+	return e
+}
+
+func (s memoryStore) put(key string, value []byte, ttl time.Duration, version int64) *memoryEntry {
+	e := &memoryEntry{value: append([]byte(nil), value...), version: version + 1}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	memoryEntries[key] = e
+	return e
+}
+
+func (s memoryStore) Get(key string) (*Item, error) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	e := s.get(key)
+	if e == nil {
+		return nil, ErrNotFound
+	}
+	value := append([]byte(nil), e.value...)
+	return &Item{Key: key, Value: value, token: e.version}, nil
+}
+
+func (s memoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	var version int64
+	if e := s.get(key); e != nil {
+		version = e.version
+	}
+	s.put(key, value, ttl, version)
+	return nil
+}
+
+func (s memoryStore) Add(key string, value []byte, ttl time.Duration) error {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	if s.get(key) != nil {
+		return ErrNotStored
+	}
+	s.put(key, value, ttl, 0)
+	return nil
+}
+
+func (s memoryStore) Delete(key string) error {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	delete(memoryEntries, key)
+	return nil
+}
+
+func (s memoryStore) Increment(key string, delta int64, initial uint64) (uint64, error) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	n := initial
+	e := s.get(key)
+	if e != nil {
+		var err error
+		if n, err = strconv.ParseUint(string(e.value), 10, 64); err != nil {
+			return 0, fmt.Errorf("memory increment failed: value is not a number: %s", err)
+		}
+	}
+
+	if delta < 0 && uint64(-delta) > n {
+		n = 0
+	} else {
+		n = uint64(int64(n) + delta)
+	}
+
+	value := []byte(strconv.FormatUint(n, 10))
+	if e == nil {
+		s.put(key, value, 0, 0)
+	} else {
+		e.value = value
+		e.version++
+	}
+	return n, nil
+}
+
+func (s memoryStore) CompareAndSwap(item *Item) error {
+	version, ok := item.token.(int64)
+	if !ok {
+		return fmt.Errorf("memory cas failed: item not returned by Get")
+	}
+
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	e := s.get(item.Key)
+	if e == nil {
+		return ErrNotStored
+	}
+	if e.version != version {
+		return ErrConflict
+	}
+	e = s.put(item.Key, item.Value, item.TTL, e.version)
+	item.token = e.version
+	return nil
+}
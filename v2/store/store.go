@@ -0,0 +1,67 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"appengine"
+)
+
+var (
+	// Returned by Get when the key doesn't exist or has expired
+	ErrNotFound = errors.New("store: key not found")
+
+	// Returned by Add when the key already exists and by CompareAndSwap
+	// when the key has been removed since the Get
+	ErrNotStored = errors.New("store: item not stored")
+
+	// Returned by CompareAndSwap when the value has been modified since
+	// the Get
+	ErrConflict = errors.New("store: compare and swap conflict")
+)
+
+// Key/value storage with expiration used by the counters, caches and
+// rate limiters of the library.
+type Store interface {
+	// Returns the item of the key or ErrNotFound
+	Get(key string) (*Item, error)
+
+	// Stores the value; a zero ttl never expires
+	Set(key string, value []byte, ttl time.Duration) error
+
+	// Stores the value only if the key doesn't exist, returning
+	// ErrNotStored otherwise
+	Add(key string, value []byte, ttl time.Duration) error
+
+	// Removes the key. Missing keys are not an error.
+	Delete(key string) error
+
+	// Atomically adds delta to the decimal value of the key, starting
+	// from initial if it doesn't exist. It returns the new value.
+	Increment(key string, delta int64, initial uint64) (uint64, error)
+
+	// Stores the item only if it hasn't changed since it was returned
+	// by Get. It returns ErrConflict or ErrNotStored otherwise.
+	CompareAndSwap(item *Item) error
+}
+
+type Item struct {
+	Key   string
+	Value []byte
+
+	// Expiration of the new value in CompareAndSwap
+	TTL time.Duration
+
+	// Backend specific version of the value
+	token interface{}
+}
+
+// Builds the store used by the library. Memcache by default; change it
+// at init() to use another backend.
+// Example: store.Default = store.Datastore
+var Default func(c appengine.Context) Store = Memcache
+
+// Returns the default store for the context
+func New(c appengine.Context) Store {
+	return Default(c)
+}
@@ -0,0 +1,51 @@
+// Checks shared by the struct tags validation of the app package and the
+// v0 & v1 forms packages. The forms keep their original semantics: they
+// count bytes (MinBytes & MaxBytes) and check the emails by themselves.
+package validators
+
+import (
+	"regexp"
+	"unicode/utf8"
+)
+
+var emailRe = regexp.MustCompile(`^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$`)
+
+// Returns true if the value is not empty
+func Required(v string) bool {
+	return v != ""
+}
+
+// Returns true if the value looks like an email address
+func Email(v string) bool {
+	return emailRe.MatchString(v)
+}
+
+// Returns true if the value has at least n characters
+func MinLength(v string, n int) bool {
+	return utf8.RuneCountInString(v) >= n
+}
+
+// Returns true if the value has n characters at most
+func MaxLength(v string, n int) bool {
+	return utf8.RuneCountInString(v) <= n
+}
+
+// Returns true if the value has at least n bytes
+func MinBytes(v string, n int) bool {
+	return len(v) >= n
+}
+
+// Returns true if the value has n bytes at most
+func MaxBytes(v string, n int) bool {
+	return len(v) <= n
+}
+
+// Returns true if the value is one of the options
+func OneOf(v string, options []string) bool {
+	for _, option := range options {
+		if v == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package validators
+
+import "testing"
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"user@example.com", true},
+		{"first.last+tag@sub.example.museum", true},
+		{"user@example", false},
+		{"user.example.com", false},
+		{"user@@example.com", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := Email(test.value); got != test.expected {
+			t.Errorf("email %q: got %v, expected %v", test.value, got, test.expected)
+		}
+	}
+}
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		value    string
+		n        int
+		min, max bool
+	}{
+		{"abc", 3, true, true},
+		{"ab", 3, false, true},
+		{"abcd", 3, true, false},
+		{"ñandú", 5, true, true},
+	}
+	for _, test := range tests {
+		if got := MinLength(test.value, test.n); got != test.min {
+			t.Errorf("min length %q %d: got %v, expected %v", test.value, test.n, got, test.min)
+		}
+		if got := MaxLength(test.value, test.n); got != test.max {
+			t.Errorf("max length %q %d: got %v, expected %v", test.value, test.n, got, test.max)
+		}
+	}
+}
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		value    string
+		n        int
+		min, max bool
+	}{
+		{"abc", 3, true, true},
+		{"ab", 3, false, true},
+		{"ñandú", 5, true, false},
+		{"ñandú", 7, true, true},
+	}
+	for _, test := range tests {
+		if got := MinBytes(test.value, test.n); got != test.min {
+			t.Errorf("min bytes %q %d: got %v, expected %v", test.value, test.n, got, test.min)
+		}
+		if got := MaxBytes(test.value, test.n); got != test.max {
+			t.Errorf("max bytes %q %d: got %v, expected %v", test.value, test.n, got, test.max)
+		}
+	}
+}
+
+func TestOneOf(t *testing.T) {
+	options := []string{"a", "b"}
+	if !OneOf("a", options) || OneOf("c", options) || OneOf("", options) {
+		t.Errorf("one of %v failed", options)
+	}
+}